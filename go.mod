@@ -1,6 +1,7 @@
 module github.com/yanzongzhen/nats-server
 
 require (
+	github.com/klauspost/compress v1.11.4
 	github.com/minio/highwayhash v1.0.0
 	github.com/nats-io/jwt/v2 v2.0.0-20200820224411-1e751ff168ab
 	github.com/nats-io/nkeys v0.2.0
@@ -50,6 +50,9 @@ Logging Options:
 JetStream Options:
     -js, --jetstream                 Enable JetStream functionality.
     -sd, --store_dir <dir>           Set the storage directory.
+        --js_check                   Verify the storage directory and exit.
+        --js_repair                  Verify and repair the storage directory and exit.
+                                     The server must not be running when checking or repairing.
 
 Authorization Options:
         --user <user>                User required for connections
@@ -100,6 +103,11 @@ func main() {
 	} else if opts.CheckConfig {
 		fmt.Fprintf(os.Stderr, "%s: configuration file %s is valid\n", exe, opts.ConfigFile)
 		os.Exit(0)
+	} else if opts.JetStreamCheck {
+		if err := server.CheckJetStreamStore(opts, os.Stdout); err != nil {
+			server.PrintAndDie(fmt.Sprintf("%s: %s", exe, err))
+		}
+		os.Exit(0)
 	}
 
 	// Create the server with appropriate options.
@@ -29,6 +29,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/nats-io/jwt/v2"
@@ -79,6 +80,8 @@ type Account struct {
 	eventIds     *nuid.NUID
 	eventIdsMu   sync.Mutex
 	defaultPerms *Permissions
+	mappings     []*mapping
+	hasMapped    int32
 }
 
 // Account based limits.
@@ -256,10 +259,285 @@ func (a *Account) shallowCopy() *Account {
 		}
 	}
 	na.jsLimits = a.jsLimits
+	if len(a.mappings) > 0 {
+		// Mappings are not modified once created, so they can be shared.
+		na.mappings = append([]*mapping(nil), a.mappings...)
+		na.hasMapped = 1
+	}
 
 	return na
 }
 
+// MapDest is a destination of a subject mapping along with its weight in percent.
+// When Cluster is set, the destination only applies to messages published in
+// the cluster with that name.
+type MapDest struct {
+	Subject string `json:"subject"`
+	Weight  uint8  `json:"weight"`
+	Cluster string `json:"cluster,omitempty"`
+}
+
+// NewMapDest returns a new mapping destination.
+func NewMapDest(subject string, weight uint8) *MapDest {
+	return &MapDest{Subject: subject, Weight: weight}
+}
+
+// Subject mapping and its weighted destinations. Destinations
+// for a given cluster take precedence over the default ones.
+type mapping struct {
+	src    string
+	wc     bool
+	dests  []*destination
+	cdests map[string][]*destination
+}
+
+type destination struct {
+	tr     *transform
+	weight uint8
+}
+
+// AddMapping adds a simple subject mapping, all messages published to src
+// will be delivered to dest instead.
+func (a *Account) AddMapping(src, dest string) error {
+	return a.AddWeightedMappings(src, NewMapDest(dest, 100))
+}
+
+// AddWeightedMappings adds a subject mapping with weighted destinations.
+// Destinations can reference the wildcard tokens of src with $1 or
+// {{wildcard(1)}}. If the weights add up to less than 100, the remainder
+// of the messages are delivered to src unchanged.
+func (a *Account) AddWeightedMappings(src string, dests ...*MapDest) error {
+	if !IsValidSubject(src) {
+		return ErrInvalidSubject
+	}
+	var mdests []*MapDest
+	cmdests := make(map[string][]*MapDest)
+	for _, d := range dests {
+		if d.Cluster == _EMPTY_ {
+			mdests = append(mdests, d)
+		} else {
+			cmdests[d.Cluster] = append(cmdests[d.Cluster], d)
+		}
+	}
+	m := &mapping{src: src, wc: !subjectIsLiteral(src)}
+	var err error
+	if m.dests, err = newDestinations(src, mdests); err != nil {
+		return err
+	}
+	if len(cmdests) > 0 {
+		m.cdests = make(map[string][]*destination, len(cmdests))
+		for cluster, cdests := range cmdests {
+			if m.cdests[cluster], err = newDestinations(src, cdests); err != nil {
+				return err
+			}
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i, em := range a.mappings {
+		if em.src == src {
+			a.mappings[i] = m
+			return nil
+		}
+	}
+	a.mappings = append(a.mappings, m)
+	atomic.StoreInt32(&a.hasMapped, 1)
+	return nil
+}
+
+// Creates the weighted destinations of a mapping, if the weights add up
+// to less than 100 the remainder goes to the original subject.
+func newDestinations(src string, dests []*MapDest) ([]*destination, error) {
+	ds := make([]*destination, 0, len(dests)+1)
+	var total uint8
+	for _, d := range dests {
+		if d.Weight > 100 || total+d.Weight > 100 {
+			return nil, ErrMappingWeightsExceeded
+		}
+		tr, err := newTransform(src, d.Subject)
+		if err != nil {
+			return nil, err
+		}
+		total += d.Weight
+		ds = append(ds, &destination{tr, d.Weight})
+	}
+	if total < 100 {
+		tr, _ := newTransform(src, src)
+		ds = append(ds, &destination{tr, 100 - total})
+	}
+	return ds, nil
+}
+
+// RemoveMapping removes the subject mapping for src, returning true if
+// one was found.
+func (a *Account) RemoveMapping(src string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i, m := range a.mappings {
+		if m.src == src {
+			a.mappings = append(a.mappings[:i], a.mappings[i+1:]...)
+			if len(a.mappings) == 0 {
+				atomic.StoreInt32(&a.hasMapped, 0)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// Fast check for the publish path.
+func (a *Account) hasMappings() bool {
+	return a != nil && atomic.LoadInt32(&a.hasMapped) == 1
+}
+
+// Returns the subject a published message should be delivered to and
+// whether or not it was changed by a mapping. Literal mappings take
+// precedence over wildcard ones.
+func (a *Account) selectMappedSubject(subject string) (string, bool) {
+	a.mu.RLock()
+	srv := a.srv
+	var m *mapping
+	for _, em := range a.mappings {
+		if !em.wc && em.src == subject {
+			m = em
+			break
+		}
+	}
+	if m == nil {
+		for _, em := range a.mappings {
+			if em.wc && matchLiteral(subject, em.src) {
+				m = em
+				break
+			}
+		}
+	}
+	a.mu.RUnlock()
+
+	if m == nil {
+		return subject, false
+	}
+
+	dests := m.dests
+	if len(m.cdests) > 0 && srv != nil {
+		if cdests, ok := m.cdests[srv.cachedClusterName()]; ok {
+			dests = cdests
+		}
+	}
+	d := dests[0]
+	if len(dests) > 1 {
+		r, total := uint8(rand.Int31n(100)), uint8(0)
+		for _, d = range dests {
+			if total += d.weight; r < total {
+				break
+			}
+		}
+	}
+	ns, err := d.tr.transformSubject(subject)
+	if err != nil || ns == subject {
+		return subject, false
+	}
+	return ns, true
+}
+
+// Transforms a subject matching src into dest, where dest can
+// reference the wildcard tokens of src by position.
+type transform struct {
+	src   string
+	stoks []string
+	dtoks []string
+	// For each destination token, the index of the source token it is
+	// taken from, or -1 for literals.
+	dtpi []int
+}
+
+func newTransform(src, dest string) (*transform, error) {
+	if !IsValidSubject(src) || !IsValidSubject(dest) {
+		return nil, ErrBadSubjectMappingDestination
+	}
+	stoks := strings.Split(src, tsep)
+	var pwcs []int
+	sfwc := -1
+	for i, tok := range stoks {
+		switch tok {
+		case "*":
+			pwcs = append(pwcs, i)
+		case ">":
+			sfwc = i
+		}
+	}
+
+	dtoks := strings.Split(dest, tsep)
+	dtpi := make([]int, len(dtoks))
+	for i, tok := range dtoks {
+		dtpi[i] = -1
+		switch {
+		case tok == "*":
+			return nil, ErrBadSubjectMappingDestination
+		case tok == ">":
+			if sfwc < 0 {
+				return nil, ErrBadSubjectMappingDestination
+			}
+			dtpi[i] = sfwc
+		default:
+			n, ok := wildcardReference(tok)
+			if !ok {
+				continue
+			}
+			if n < 1 || n > len(pwcs) {
+				return nil, ErrBadSubjectMappingDestination
+			}
+			dtpi[i] = pwcs[n-1]
+		}
+	}
+	return &transform{src: src, stoks: stoks, dtoks: dtoks, dtpi: dtpi}, nil
+}
+
+// Parses $N or {{wildcard(N)}} destination tokens.
+func wildcardReference(tok string) (int, bool) {
+	var ns string
+	if strings.HasPrefix(tok, "$") {
+		ns = tok[1:]
+	} else if strings.HasPrefix(tok, "{{") && strings.HasSuffix(tok, "}}") {
+		fn := strings.Replace(tok[2:len(tok)-2], " ", "", -1)
+		if !strings.HasPrefix(fn, "wildcard(") || !strings.HasSuffix(fn, ")") {
+			return 0, false
+		}
+		ns = fn[len("wildcard(") : len(fn)-1]
+	} else {
+		return 0, false
+	}
+	n, err := strconv.Atoi(ns)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// Returns the transformed subject, the subject has to match the
+// source of the transform.
+func (tr *transform) transformSubject(subject string) (string, error) {
+	if !matchLiteral(subject, tr.src) {
+		return _EMPTY_, ErrInvalidSubject
+	}
+	toks := strings.Split(subject, tsep)
+	var b strings.Builder
+	for i, tok := range tr.dtoks {
+		if i > 0 {
+			b.WriteString(tsep)
+		}
+		switch si := tr.dtpi[i]; {
+		case si < 0:
+			b.WriteString(tok)
+		case tr.stoks[si] == ">":
+			b.WriteString(strings.Join(toks[si:], tsep))
+		default:
+			b.WriteString(toks[si])
+		}
+	}
+	return b.String(), nil
+}
+
 // nextEventID uses its own lock for better concurrency.
 func (a *Account) nextEventID() string {
 	a.eventIdsMu.Lock()
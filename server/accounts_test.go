@@ -2403,3 +2403,310 @@ func TestSamplingHeader(t *testing.T) {
 	test(true, http.Header{"traceparent": []string{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}})
 	test(false, http.Header{"traceparent": []string{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"}})
 }
+
+func TestAccountSubjectMapping(t *testing.T) {
+	opts := DefaultOptions()
+	s := RunServer(opts)
+	defer s.Shutdown()
+
+	acc := s.GlobalAccount()
+	if err := acc.AddMapping("foo", "bar"); err != nil {
+		t.Fatalf("Error adding mapping: %v", err)
+	}
+	if err := acc.AddMapping("orders.*.*", "orders.{{wildcard(2)}}.$1"); err != nil {
+		t.Fatalf("Error adding mapping: %v", err)
+	}
+	if err := acc.AddMapping("events.*.>", "archive.$1.>"); err != nil {
+		t.Fatalf("Error adding mapping: %v", err)
+	}
+
+	nc, err := nats.Connect(fmt.Sprintf("nats://%s:%d", opts.Host, opts.Port))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer nc.Close()
+
+	sub, _ := nc.SubscribeSync(">")
+	nc.Flush()
+
+	for _, test := range []struct {
+		pub, expected string
+	}{
+		{"foo", "bar"},
+		{"foo.baz", "foo.baz"},
+		{"orders.us.123", "orders.123.us"},
+		{"events.a.b.c", "archive.a.b.c"},
+	} {
+		nc.Publish(test.pub, []byte("ok"))
+		msg, err := sub.NextMsg(time.Second)
+		if err != nil {
+			t.Fatalf("Error receiving message for %q: %v", test.pub, err)
+		}
+		if msg.Subject != test.expected {
+			t.Fatalf("Expected %q to be mapped to %q, got %q", test.pub, test.expected, msg.Subject)
+		}
+	}
+
+	if !acc.RemoveMapping("foo") {
+		t.Fatal("Expected mapping to be removed")
+	}
+	nc.Publish("foo", []byte("ok"))
+	if msg, err := sub.NextMsg(time.Second); err != nil || msg.Subject != "foo" {
+		t.Fatalf("Expected message on %q after removing mapping, got %v %v", "foo", msg, err)
+	}
+
+	for _, test := range []struct {
+		src, dest string
+		err       error
+	}{
+		{"foo..bar", "bar", ErrInvalidSubject},
+		{"foo.*", "bar.$2", ErrBadSubjectMappingDestination},
+		{"foo.*", "bar.*", ErrBadSubjectMappingDestination},
+		{"foo.*", "bar.>", ErrBadSubjectMappingDestination},
+	} {
+		if err := acc.AddMapping(test.src, test.dest); err != test.err {
+			t.Fatalf("Expected error %v mapping %q to %q, got %v", test.err, test.src, test.dest, err)
+		}
+	}
+	if err := acc.AddWeightedMappings("foo", NewMapDest("bar", 60), NewMapDest("baz", 50)); err != ErrMappingWeightsExceeded {
+		t.Fatalf("Expected error %v, got %v", ErrMappingWeightsExceeded, err)
+	}
+}
+
+func TestAccountWeightedSubjectMapping(t *testing.T) {
+	opts := DefaultOptions()
+	s := RunServer(opts)
+	defer s.Shutdown()
+
+	// The remaining 20 percent stay on the original subject.
+	acc := s.GlobalAccount()
+	if err := acc.AddWeightedMappings("svc", NewMapDest("svc.v1", 50), NewMapDest("svc.v2", 30)); err != nil {
+		t.Fatalf("Error adding mapping: %v", err)
+	}
+
+	nc, err := nats.Connect(fmt.Sprintf("nats://%s:%d", opts.Host, opts.Port))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer nc.Close()
+
+	sub, _ := nc.SubscribeSync("svc.>")
+	orig, _ := nc.SubscribeSync("svc")
+	nc.Flush()
+
+	total := 1000
+	for i := 0; i < total; i++ {
+		nc.Publish("svc", nil)
+	}
+	nc.Flush()
+
+	counts := map[string]int{}
+	for {
+		msg, err := sub.NextMsg(250 * time.Millisecond)
+		if err != nil {
+			break
+		}
+		counts[msg.Subject]++
+	}
+	n, _, _ := orig.Pending()
+	counts["svc"] = n
+
+	if got := counts["svc.v1"] + counts["svc.v2"] + counts["svc"]; got != total {
+		t.Fatalf("Expected %d messages, got %d: %v", total, got, counts)
+	}
+	for subj, weight := range map[string]int{"svc.v1": 50, "svc.v2": 30, "svc": 20} {
+		expected := total * weight / 100
+		if delta := counts[subj] - expected; delta < -total/10 || delta > total/10 {
+			t.Fatalf("Expected about %d messages on %q, got %d", expected, subj, counts[subj])
+		}
+	}
+}
+
+func TestAccountSubjectMappingConfigDefaultWeight(t *testing.T) {
+	conf := createConfFile(t, []byte(`
+		listen: "127.0.0.1:-1"
+		accounts {
+			A {
+				users: [{user: a, password: pwd}]
+				mappings: {
+					foo: [
+						{destination: "bar"}
+					]
+				}
+			}
+		}
+	`))
+	defer os.Remove(conf)
+	s, opts := RunServerWithConfig(conf)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(fmt.Sprintf("nats://a:pwd@%s:%d", opts.Host, opts.Port))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer nc.Close()
+
+	// Without a weight, the destination gets all messages.
+	sub, _ := nc.SubscribeSync(">")
+	nc.Flush()
+	for i := 0; i < 10; i++ {
+		nc.Publish("foo", nil)
+	}
+	for i := 0; i < 10; i++ {
+		if msg, err := sub.NextMsg(time.Second); err != nil || msg.Subject != "bar" {
+			t.Fatalf("Expected mapped message on %q, got %v %v", "bar", msg, err)
+		}
+	}
+}
+
+func TestAccountSubjectMappingConfig(t *testing.T) {
+	conf := createConfFile(t, []byte(`
+		listen: "127.0.0.1:-1"
+		accounts {
+			A {
+				users: [{user: a, password: pwd}]
+				mappings: {
+					foo: bar
+					"orders.*.*": "orders.$2.$1"
+					canary: [
+						{destination: "canary.v1", weight: "80%"}
+						{destination: "canary.v2", weight: 20}
+					]
+					"svc.orders": [
+						{destination: "svc.orders.east", cluster: "east"}
+						{destination: "svc.orders.west", cluster: "west"}
+					]
+				}
+			}
+		}
+	`))
+	defer os.Remove(conf)
+	s, opts := RunServerWithConfig(conf)
+	defer s.Shutdown()
+
+	acc, err := s.LookupAccount("A")
+	if err != nil {
+		t.Fatalf("Error looking up account: %v", err)
+	}
+	acc.mu.RLock()
+	nm := len(acc.mappings)
+	acc.mu.RUnlock()
+	if nm != 4 {
+		t.Fatalf("Expected 4 mappings, got %d", nm)
+	}
+	acc.mu.RLock()
+	for _, m := range acc.mappings {
+		if m.src != "svc.orders" {
+			continue
+		}
+		for _, cluster := range []string{"east", "west"} {
+			if ds := m.cdests[cluster]; len(ds) != 1 || ds[0].weight != 100 {
+				t.Fatalf("Expected a single destination for cluster %q with weight 100, got %+v", cluster, ds)
+			}
+		}
+	}
+	acc.mu.RUnlock()
+
+	nc, err := nats.Connect(fmt.Sprintf("nats://a:pwd@%s:%d", opts.Host, opts.Port))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer nc.Close()
+
+	sub, _ := nc.SubscribeSync("orders.>")
+	nc.Flush()
+	nc.Publish("orders.us.123", nil)
+	if msg, err := sub.NextMsg(time.Second); err != nil || msg.Subject != "orders.123.us" {
+		t.Fatalf("Expected mapped message on %q, got %v %v", "orders.123.us", msg, err)
+	}
+
+	conf = createConfFile(t, []byte(`
+		accounts {
+			A {
+				mappings: {
+					canary: [
+						{destination: "canary.v1", weight: 80}
+						{destination: "canary.v2", weight: 30}
+					]
+				}
+			}
+		}
+	`))
+	defer os.Remove(conf)
+	if _, err := ProcessConfigFile(conf); err == nil || !strings.Contains(err.Error(), ErrMappingWeightsExceeded.Error()) {
+		t.Fatalf("Expected error about weights, got %v", err)
+	}
+}
+
+func TestAccountClusterSubjectMapping(t *testing.T) {
+	addMappings := func(s *Server) {
+		t.Helper()
+		err := s.GlobalAccount().AddWeightedMappings("svc.orders",
+			&MapDest{Subject: "svc.orders.east", Weight: 100, Cluster: "east"},
+			&MapDest{Subject: "svc.orders.west", Weight: 100, Cluster: "west"},
+			NewMapDest("svc.orders.default", 100))
+		if err != nil {
+			t.Fatalf("Error adding mapping: %v", err)
+		}
+	}
+
+	ob := testDefaultOptionsForGateway("west")
+	sb := runGatewayServer(ob)
+	defer sb.Shutdown()
+	addMappings(sb)
+
+	oa := testGatewayOptionsFromToWithServers(t, "east", "west", sb)
+	sa := runGatewayServer(oa)
+	defer sa.Shutdown()
+	addMappings(sa)
+
+	waitForOutboundGateways(t, sa, 1, 2*time.Second)
+	waitForOutboundGateways(t, sb, 1, 2*time.Second)
+
+	// Subscribe in west so that messages published in east have to
+	// cross the gateway.
+	ncb, err := nats.Connect(fmt.Sprintf("nats://%s:%d", ob.Host, ob.Port))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer ncb.Close()
+	sub, _ := ncb.SubscribeSync("svc.orders.*")
+	ncb.Flush()
+
+	nca, err := nats.Connect(fmt.Sprintf("nats://%s:%d", oa.Host, oa.Port))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer nca.Close()
+
+	for _, test := range []struct {
+		nc       *nats.Conn
+		expected string
+	}{
+		{nca, "svc.orders.east"},
+		{ncb, "svc.orders.west"},
+	} {
+		test.nc.Publish("svc.orders", []byte("ok"))
+		test.nc.Flush()
+		msg, err := sub.NextMsg(time.Second)
+		if err != nil {
+			t.Fatalf("Error receiving message: %v", err)
+		}
+		if msg.Subject != test.expected {
+			t.Fatalf("Expected message on %q, got %q", test.expected, msg.Subject)
+		}
+	}
+
+	// A server in a cluster without its own destinations uses the default ones.
+	s := RunServer(DefaultOptions())
+	defer s.Shutdown()
+	addMappings(s)
+	if subj, _ := s.GlobalAccount().selectMappedSubject("svc.orders"); subj != "svc.orders.default" {
+		t.Fatalf("Expected default destination, got %q", subj)
+	}
+	// A change of the cluster name, e.g. a dynamic one, is picked up.
+	s.setClusterName("west")
+	if subj, _ := s.GlobalAccount().selectMappedSubject("svc.orders"); subj != "svc.orders.west" {
+		t.Fatalf("Expected west destination, got %q", subj)
+	}
+}
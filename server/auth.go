@@ -246,9 +246,17 @@ func (s *Server) configureAuthorization() {
 		s.nkeys = nil
 		s.info.AuthRequired = false
 	}
+	// Clients that are not auth users are authenticated by the auth service.
+	if opts.AuthCallout != nil {
+		s.info.AuthRequired = true
+	}
 
 	// Do similar for websocket config
 	s.wsConfigAuth(&opts.Websocket)
+	// And for MQTT config
+	s.mqttConfigAuth(&opts.MQTT)
+	// And for the additional client listeners
+	s.listenersConfigAuth(opts)
 }
 
 // Takes the given slices of NkeyUser and User options and build
@@ -344,6 +352,12 @@ func (s *Server) processClientOrLeafAuthentication(c *client, opts *Options) boo
 		// If no auth required for regular clients, then check if
 		// we have an override for websocket clients.
 		authRequired = s.websocket.authOverride
+	} else if !authRequired && c.mqtt != nil {
+		// Same for MQTT clients.
+		authRequired = s.mqtt.authOverride
+	} else if !authRequired && c.listener != nil {
+		// And for clients of an additional listener.
+		authRequired = c.listener.authOverride
 	}
 	if !authRequired {
 		// TODO(dlc) - If they send us credentials should we fail?
@@ -374,6 +388,36 @@ func (s *Server) processClientOrLeafAuthentication(c *client, opts *Options) boo
 			nkusers = s.websocket.nkeys
 			ao = true
 		}
+	} else if c.mqtt != nil {
+		mo := &opts.MQTT
+		// Always override TLSMap.
+		tlsMap = mo.TLSMap
+		// The rest depends on if there was any auth override in
+		// the MQTT's config.
+		if s.mqtt.authOverride {
+			noAuthUser = mo.NoAuthUser
+			username = mo.Username
+			password = mo.Password
+			token = mo.Token
+			users = s.mqtt.users
+			nkusers = nil
+			ao = true
+		}
+	} else if c.listener != nil {
+		lo := c.listener.opts(opts)
+		// Always override TLSMap.
+		tlsMap = lo.TLSMap
+		// The rest depends on if there was any auth override in
+		// the listener's config.
+		if c.listener.authOverride {
+			noAuthUser = lo.NoAuthUser
+			username = lo.Username
+			password = lo.Password
+			token = lo.Token
+			users = c.listener.users
+			nkusers = c.listener.nkeys
+			ao = true
+		}
 	} else if c.kind == LEAF {
 		tlsMap = opts.LeafNode.TLSMap
 	}
@@ -386,6 +430,12 @@ func (s *Server) processClientOrLeafAuthentication(c *client, opts *Options) boo
 		nkusers = s.nkeys
 	}
 
+	// Delegate to the auth service unless this is one of its users.
+	if c.kind == CLIENT && !ao && opts.AuthCallout != nil && !isAuthCalloutUser(opts.AuthCallout, c) {
+		s.mu.Unlock()
+		return s.processClientAuthCallout(c, opts.AuthCallout)
+	}
+
 	// Check if we have trustedKeys defined in the server. If so we require a user jwt.
 	if s.trustedKeys != nil {
 		if c.opts.JWT == "" {
@@ -850,6 +900,9 @@ func comparePasswords(serverPassword, clientPassword string) bool {
 }
 
 func validateAuth(o *Options) error {
+	if err := validateAuthCallout(o); err != nil {
+		return err
+	}
 	if o.NoAuthUser == "" {
 		return nil
 	}
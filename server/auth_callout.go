@@ -0,0 +1,341 @@
+// Copyright 2020 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"github.com/nats-io/nuid"
+)
+
+const (
+	// AuthCalloutSubject is the subject authorization requests are sent on,
+	// in the account of the auth service.
+	AuthCalloutSubject = "$SYS.REQ.USER.AUTH"
+
+	// AuthRequestAudience is the audience of the signed authorization requests.
+	AuthRequestAudience = "nats-authorization-request"
+
+	// DefaultAuthCalloutTimeout is how long we wait for the auth service by default.
+	DefaultAuthCalloutTimeout = 2 * time.Second
+
+	// Reply subject for authorization requests.
+	authCalloutReplySubj = "_INBOX.AUTH.%s"
+)
+
+// AuthCallout delegates the authentication of clients to an external
+// auth service. The service receives an AuthorizationRequest and
+// responds with a user JWT that decides the account, permissions and
+// expiration of the client.
+type AuthCallout struct {
+	// Issuer is the public account nkey that signs the user JWTs
+	// returned by the auth service.
+	Issuer string
+	// Account is the name of the account the auth service runs in.
+	Account string
+	// AuthUsers are authenticated normally and bypass the callout,
+	// such as the users of the auth service itself.
+	AuthUsers []string
+	// Timeout is how long to wait for the auth service to respond.
+	Timeout time.Duration
+}
+
+// AuthorizationRequest is sent to the auth service as the data of a
+// generic JWT signed by the server.
+type AuthorizationRequest struct {
+	ServerID       string             `json:"server_id"`
+	ServerName     string             `json:"server_name"`
+	UserNkey       string             `json:"user_nkey"`
+	ClientInfo     AuthClientInfo     `json:"client_info"`
+	ConnectOptions AuthConnectOptions `json:"connect_opts"`
+	TLS            *AuthClientTLS     `json:"client_tls,omitempty"`
+	Nonce          string             `json:"client_nonce,omitempty"`
+}
+
+// AuthClientInfo is information about the connection being authorized.
+type AuthClientInfo struct {
+	ID   uint64 `json:"id"`
+	Host string `json:"host"`
+	Port int    `json:"port"`
+	Kind string `json:"kind"`
+}
+
+// AuthConnectOptions are the options the client sent in its CONNECT.
+type AuthConnectOptions struct {
+	JWT      string `json:"jwt,omitempty"`
+	Nkey     string `json:"nkey,omitempty"`
+	Sig      string `json:"sig,omitempty"`
+	Token    string `json:"auth_token,omitempty"`
+	Username string `json:"user,omitempty"`
+	Password string `json:"pass,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Lang     string `json:"lang,omitempty"`
+	Version  string `json:"version,omitempty"`
+	Protocol int    `json:"protocol"`
+}
+
+// AuthClientTLS is the TLS state of the connection being authorized.
+type AuthClientTLS struct {
+	Version string   `json:"version"`
+	Cipher  string   `json:"cipher"`
+	Certs   []string `json:"certs,omitempty"`
+}
+
+// AuthorizationResponse is the response of the auth service. JWT is a user
+// JWT issued by the callout issuer for the user nkey of the request, with
+// the name of the account to bind the client to as its audience.
+type AuthorizationResponse struct {
+	JWT   string `json:"jwt,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// Check if the client is one of the users that bypass the callout.
+func isAuthCalloutUser(ac *AuthCallout, c *client) bool {
+	for _, u := range ac.AuthUsers {
+		if (c.opts.Username != _EMPTY_ && u == c.opts.Username) || (c.opts.Nkey != _EMPTY_ && u == c.opts.Nkey) {
+			return true
+		}
+	}
+	return false
+}
+
+// Builds the signed authorization request for the client.
+func (s *Server) authorizationRequest(c *client, userNkey string) (string, error) {
+	s.mu.Lock()
+	kp := s.kp
+	req := &AuthorizationRequest{ServerID: s.info.ID, ServerName: s.info.Name}
+	s.mu.Unlock()
+
+	req.UserNkey = userNkey
+	req.ClientInfo = AuthClientInfo{ID: c.cid, Host: c.host, Port: int(c.port), Kind: "Client"}
+	if c.ws != nil {
+		req.ClientInfo.Kind = "WebSocket"
+	} else if c.mqtt != nil {
+		req.ClientInfo.Kind = "MQTT"
+	}
+	req.ConnectOptions = AuthConnectOptions{
+		JWT:      c.opts.JWT,
+		Nkey:     c.opts.Nkey,
+		Sig:      c.opts.Sig,
+		Token:    c.opts.Token,
+		Username: c.opts.Username,
+		Password: c.opts.Password,
+		Name:     c.opts.Name,
+		Lang:     c.opts.Lang,
+		Version:  c.opts.Version,
+		Protocol: c.opts.Protocol,
+	}
+	if cs := c.GetTLSConnectionState(); cs != nil {
+		req.TLS = &AuthClientTLS{Version: tlsVersion(cs.Version), Cipher: tlsCipher(cs.CipherSuite)}
+		for _, cert := range cs.PeerCertificates {
+			req.TLS.Certs = append(req.TLS.Certs, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})))
+		}
+	}
+	if len(c.nonce) > 0 {
+		req.Nonce = string(c.nonce)
+	}
+
+	b, err := json.Marshal(req)
+	if err != nil {
+		return _EMPTY_, err
+	}
+	claim := jwt.NewGenericClaims(userNkey)
+	claim.Audience = AuthRequestAudience
+	if err := json.Unmarshal(b, &claim.Data); err != nil {
+		return _EMPTY_, err
+	}
+	return claim.Encode(kp)
+}
+
+// Sends the authorization request for the client to the auth service and
+// waits for its response.
+func (s *Server) requestAuthorization(acc *Account, req string, timeout time.Duration) (*AuthorizationResponse, error) {
+	respCh := make(chan []byte, 1)
+	reply := fmt.Sprintf(authCalloutReplySubj, nuid.Next())
+	sub, err := acc.subscribeInternal(reply, func(_ *subscription, c *client, _, _ string, msg []byte) {
+		// Account client messages have \r\n on end, skip any headers.
+		if c.pa.hdr > 0 && c.pa.hdr <= len(msg) {
+			msg = msg[c.pa.hdr:]
+		}
+		select {
+		case respCh <- bytes.TrimSpace(append([]byte(nil), msg...)):
+		default:
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer sub.client.processUnsub(sub.sid)
+
+	if err := s.sendInternalAccountMsgWithReply(acc, AuthCalloutSubject, reply, req); err != nil {
+		return nil, err
+	}
+
+	select {
+	case msg := <-respCh:
+		var resp AuthorizationResponse
+		if err := json.Unmarshal(msg, &resp); err != nil {
+			return nil, fmt.Errorf("invalid response: %v", err)
+		}
+		return &resp, nil
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("timeout waiting for response")
+	case <-s.quitCh:
+		return nil, ErrServerNotRunning
+	}
+}
+
+// Authenticates the client with the auth service.
+func (s *Server) processClientAuthCallout(c *client, ac *AuthCallout) bool {
+	acc, err := s.LookupAccount(ac.Account)
+	if err != nil {
+		c.Errorf("Auth callout account %q lookup error: %v", ac.Account, err)
+		return false
+	}
+	// The user JWT returned has to be issued for this user nkey,
+	// this ties the response to this request.
+	ukp, err := nkeys.CreateUser()
+	if err != nil {
+		c.Errorf("Auth callout error creating user nkey: %v", err)
+		return false
+	}
+	userNkey, _ := ukp.PublicKey()
+	req, err := s.authorizationRequest(c, userNkey)
+	if err != nil {
+		c.Errorf("Auth callout error creating request: %v", err)
+		return false
+	}
+	timeout := ac.Timeout
+	if timeout <= 0 {
+		timeout = DefaultAuthCalloutTimeout
+	}
+	resp, err := s.requestAuthorization(acc, req, timeout)
+	if err != nil {
+		c.Errorf("Auth callout request error: %v", err)
+		return false
+	}
+	if resp.Error != _EMPTY_ {
+		c.Debugf("Auth callout denied authorization: %s", resp.Error)
+		return false
+	}
+
+	juc, err := jwt.DecodeUserClaims(resp.JWT)
+	if err != nil {
+		c.Debugf("Auth callout user JWT not valid: %v", err)
+		return false
+	}
+	vr := jwt.CreateValidationResults()
+	juc.Validate(vr)
+	if vr.IsBlocking(true) {
+		c.Debugf("Auth callout user JWT no longer valid: %+v", vr)
+		return false
+	}
+	if juc.Issuer != ac.Issuer {
+		c.Debugf("Auth callout user JWT not issued by %q", ac.Issuer)
+		return false
+	}
+	if juc.Subject != userNkey {
+		c.Debugf("Auth callout user JWT not issued for the requested user nkey")
+		return false
+	}
+	if juc.Audience == _EMPTY_ {
+		c.Debugf("Auth callout user JWT has no account")
+		return false
+	}
+	uacc, err := s.LookupAccount(juc.Audience)
+	if err != nil {
+		c.Debugf("Auth callout account %q lookup error: %v", juc.Audience, err)
+		return false
+	}
+	if !validateSrc(juc, c.host) {
+		c.Errorf("Bad src Ip %s", c.host)
+		return false
+	}
+	allowNow, validFor := validateTimes(juc)
+	if !allowNow {
+		c.Errorf("Outside connect times")
+		return false
+	}
+
+	nkey := buildInternalNkeyUser(juc, uacc)
+	if err := c.RegisterNkeyUser(nkey); err != nil {
+		return false
+	}
+	// Hold onto the user's public key.
+	c.pubKey = juc.Subject
+
+	// Generate an event if we have a system account.
+	s.accountConnectEvent(c)
+
+	// Check if we need to set an auth timer if the user jwt expires.
+	c.setExpiration(juc.Claims(), validFor)
+	return true
+}
+
+// Validates the auth callout configuration.
+func validateAuthCallout(o *Options) error {
+	ac := o.AuthCallout
+	if ac == nil {
+		return nil
+	}
+	if len(o.TrustedOperators) > 0 {
+		return fmt.Errorf("auth callout not compatible with Trusted Operator")
+	}
+	if !nkeys.IsValidPublicAccountKey(ac.Issuer) {
+		return fmt.Errorf("auth callout issuer %q is not a valid public account nkey", ac.Issuer)
+	}
+	if ac.Account == _EMPTY_ {
+		return fmt.Errorf("auth callout requires an account")
+	}
+	if ac.Account != globalAccountName {
+		found := false
+		for _, acc := range o.Accounts {
+			if acc.Name == ac.Account {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("auth callout account %q not defined", ac.Account)
+		}
+	}
+	if len(ac.AuthUsers) == 0 {
+		return fmt.Errorf("auth callout requires at least one auth user")
+	}
+	for _, au := range ac.AuthUsers {
+		found := false
+		for _, u := range o.Users {
+			if u.Username == au {
+				found = true
+				break
+			}
+		}
+		for _, u := range o.Nkeys {
+			if u.Nkey == au {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("auth callout user %q not defined", au)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+// Copyright 2020 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"github.com/yanzongzhen/nats.go"
+)
+
+func runAuthCalloutServer(t *testing.T, akp nkeys.KeyPair, extra string) (*Server, *Options) {
+	t.Helper()
+	apub, _ := akp.PublicKey()
+	conf := createConfFile(t, []byte(fmt.Sprintf(`
+		listen: "127.0.0.1:-1"
+		accounts {
+			AUTH { users: [{user: auth, password: pwd}] }
+			APP {}
+		}
+		authorization {
+			auth_callout {
+				issuer: %q
+				account: AUTH
+				auth_users: [auth]
+				%s
+			}
+		}
+	`, apub, extra)))
+	defer os.Remove(conf)
+	return RunServerWithConfig(conf)
+}
+
+// Runs an auth service that accepts user bob with password secret, the
+// response is signed with the given key pair.
+func runAuthService(t *testing.T, s *Server, opts *Options, skp nkeys.KeyPair) *nats.Conn {
+	t.Helper()
+	nc, err := nats.Connect(fmt.Sprintf("nats://auth:pwd@%s:%d", opts.Host, opts.Port))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	nc.Subscribe(AuthCalloutSubject, func(m *nats.Msg) {
+		respond := func(resp *AuthorizationResponse) {
+			b, _ := json.Marshal(resp)
+			m.Respond(b)
+		}
+		gc, err := jwt.DecodeGeneric(string(m.Data))
+		if err != nil {
+			respond(&AuthorizationResponse{Error: err.Error()})
+			return
+		}
+		if gc.Issuer != s.ID() || gc.Audience != AuthRequestAudience {
+			respond(&AuthorizationResponse{Error: "bad request"})
+			return
+		}
+		var req AuthorizationRequest
+		b, _ := json.Marshal(gc.Data)
+		json.Unmarshal(b, &req)
+		if req.ConnectOptions.Username != "bob" || req.ConnectOptions.Password != "secret" {
+			respond(&AuthorizationResponse{Error: "not authorized"})
+			return
+		}
+		uc := jwt.NewUserClaims(req.UserNkey)
+		uc.Audience = "APP"
+		uc.Name = req.ConnectOptions.Username
+		uc.Pub.Allow.Add("app.>")
+		uc.Sub.Allow.Add("app.>")
+		ujwt, err := uc.Encode(skp)
+		if err != nil {
+			respond(&AuthorizationResponse{Error: err.Error()})
+			return
+		}
+		respond(&AuthorizationResponse{JWT: ujwt})
+	})
+	nc.Flush()
+	return nc
+}
+
+func TestAuthCallout(t *testing.T) {
+	akp, _ := nkeys.CreateAccount()
+	s, opts := runAuthCalloutServer(t, akp, "")
+	defer s.Shutdown()
+
+	ncs := runAuthService(t, s, opts, akp)
+	defer ncs.Close()
+
+	errCh := make(chan error, 1)
+	nc, err := nats.Connect(fmt.Sprintf("nats://bob:secret@%s:%d", opts.Host, opts.Port),
+		nats.ErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, err error) {
+			errCh <- err
+		}))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer nc.Close()
+
+	// Check the client was bound to the account of the user JWT.
+	app, _ := s.LookupAccount("APP")
+	if n := app.NumLocalConnections(); n != 1 {
+		t.Fatalf("Expected 1 connection in account APP, got %d", n)
+	}
+
+	sub, _ := nc.SubscribeSync("app.>")
+	nc.Publish("app.foo", []byte("ok"))
+	if _, err := sub.NextMsg(time.Second); err != nil {
+		t.Fatalf("Error receiving message: %v", err)
+	}
+
+	// And that it has the permissions of the user JWT.
+	nc.SubscribeSync("other")
+	select {
+	case err := <-errCh:
+		if !strings.Contains(err.Error(), "Permissions Violation") {
+			t.Fatalf("Expected a permissions violation, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected a permissions violation")
+	}
+
+	// Denied by the auth service.
+	if _, err := nats.Connect(fmt.Sprintf("nats://bob:wrong@%s:%d", opts.Host, opts.Port)); err == nil {
+		t.Fatal("Expected connect to fail")
+	}
+}
+
+func TestAuthCalloutWrongIssuer(t *testing.T) {
+	akp, _ := nkeys.CreateAccount()
+	s, opts := runAuthCalloutServer(t, akp, "")
+	defer s.Shutdown()
+
+	// The auth service signs with a key that is not the callout issuer.
+	okp, _ := nkeys.CreateAccount()
+	ncs := runAuthService(t, s, opts, okp)
+	defer ncs.Close()
+
+	if _, err := nats.Connect(fmt.Sprintf("nats://bob:secret@%s:%d", opts.Host, opts.Port)); err == nil {
+		t.Fatal("Expected connect to fail")
+	}
+}
+
+func TestAuthCalloutTimeout(t *testing.T) {
+	akp, _ := nkeys.CreateAccount()
+	s, opts := runAuthCalloutServer(t, akp, `timeout: "100ms"`)
+	defer s.Shutdown()
+
+	if to := s.getOpts().AuthCallout.Timeout; to != 100*time.Millisecond {
+		t.Fatalf("Expected timeout of 100ms, got %v", to)
+	}
+
+	// No auth service running.
+	start := time.Now()
+	if _, err := nats.Connect(fmt.Sprintf("nats://bob:secret@%s:%d", opts.Host, opts.Port)); err == nil {
+		t.Fatal("Expected connect to fail")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Expected callout to time out quickly, took %v", elapsed)
+	}
+
+	// The auth users are not subject to the callout.
+	nc, err := nats.Connect(fmt.Sprintf("nats://auth:pwd@%s:%d", opts.Host, opts.Port))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	nc.Close()
+}
+
+func TestAuthCalloutValidation(t *testing.T) {
+	akp, _ := nkeys.CreateAccount()
+	apub, _ := akp.PublicKey()
+	authAcc := NewAccount("AUTH")
+	authUser := &User{Username: "auth", Password: "pwd", Account: authAcc}
+
+	for _, test := range []struct {
+		name string
+		ac   *AuthCallout
+		err  string
+	}{
+		{"bad issuer", &AuthCallout{Issuer: "bad", Account: "AUTH", AuthUsers: []string{"auth"}}, "not a valid public account nkey"},
+		{"no account", &AuthCallout{Issuer: apub, AuthUsers: []string{"auth"}}, "requires an account"},
+		{"unknown account", &AuthCallout{Issuer: apub, Account: "FOO", AuthUsers: []string{"auth"}}, "account \"FOO\" not defined"},
+		{"no auth users", &AuthCallout{Issuer: apub, Account: "AUTH"}, "at least one auth user"},
+		{"unknown auth user", &AuthCallout{Issuer: apub, Account: "AUTH", AuthUsers: []string{"bob"}}, "user \"bob\" not defined"},
+		{"valid", &AuthCallout{Issuer: apub, Account: "AUTH", AuthUsers: []string{"auth"}}, ""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			o := DefaultOptions()
+			o.Accounts = []*Account{authAcc}
+			o.Users = []*User{authUser}
+			o.AuthCallout = test.ac
+			err := validateOptions(o)
+			if test.err == "" {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("Expected error containing %q, got %v", test.err, err)
+			}
+		})
+	}
+}
@@ -178,6 +178,7 @@ const (
 	MsgHeaderViolation
 	NoRespondersRequiresHeaders
 	ClusterNameConflict
+	DuplicateClientID
 )
 
 // Some flags passed to processMsgResultsEx
@@ -236,6 +237,10 @@ type client struct {
 	gw    *gateway
 	leaf  *leaf
 	ws    *websocket
+	mqtt  *mqtt
+
+	// The additional client listener this client connected to, if any.
+	listener *clientListener
 
 	// To keep track of gateway replies mapping
 	gwrm map[string]*gwReplyMap
@@ -276,6 +281,9 @@ type outbound struct {
 	mp  int64         // Snapshot of max pending for client.
 	lft time.Duration // Last flush time for Write.
 	stc chan struct{} // Stall chan we create to slow down producers on overrun, e.g. fan-in.
+
+	// Compression of outbound data, set once compression has started.
+	cmp *outCompression
 }
 
 type perm struct {
@@ -345,6 +353,10 @@ type readCache struct {
 
 	rsz int32 // Read buffer size
 	srs int32 // Short reads, used for dynamic buffer resizing.
+
+	// Data that followed the INFO protocol signaling that the remote
+	// started to compress, to be decompressed by the readLoop.
+	cpend []byte
 }
 
 const (
@@ -394,6 +406,28 @@ func (c *client) GetTLSConnectionState() *tls.ConnectionState {
 	return &state
 }
 
+// Returns true if the public key of the certificate presented by the peer
+// is in the given set, or if the set is empty.
+// Lock should not be held.
+func (c *client) matchesPinnedCert(tlsPinnedCerts PinnedCertSet) bool {
+	if len(tlsPinnedCerts) == 0 {
+		return true
+	}
+	c.mu.Lock()
+	cs := c.GetTLSConnectionState()
+	c.mu.Unlock()
+	if cs == nil || len(cs.PeerCertificates) == 0 {
+		c.Errorf("Peer did not present a certificate, expected one of the pinned certificates")
+		return false
+	}
+	fp := pinnedCertFingerprint(cs.PeerCertificates[0])
+	if _, ok := tlsPinnedCerts[fp]; !ok {
+		c.Errorf("Peer certificate %q with public key %s is not pinned", cs.PeerCertificates[0].Subject, fp)
+		return false
+	}
+	return true
+}
+
 // This is the main subscription struct that indicates
 // interest in published messages.
 // FIXME(dlc) - This is getting bloated for normal subs, need
@@ -509,6 +543,8 @@ func (c *client) initClient() {
 		name := "cid"
 		if c.ws != nil {
 			name = "wid"
+		} else if c.mqtt != nil {
+			name = "mid"
 		}
 		c.ncs = fmt.Sprintf("%s - %s:%d", conn, name, c.cid)
 	case ROUTER:
@@ -933,6 +969,7 @@ func (c *client) readLoop(pre []byte) {
 	}
 	nc := c.nc
 	ws := c.ws != nil
+	mqtt := c.mqtt != nil
 	c.in.rsz = startBufSize
 	// Snapshot max control line since currently can not be changed on reload and we
 	// were checking it on each call to parse. If this changes and we allow MaxControlLine
@@ -967,7 +1004,8 @@ func (c *client) readLoop(pre []byte) {
 
 	var wsr *wsReadInfo
 	if ws {
-		wsr = &wsReadInfo{}
+		// The client side of a websocket connection reads unmasked frames.
+		wsr = &wsReadInfo{nomask: c.ws.maskwrite}
 		wsr.init()
 	}
 
@@ -976,8 +1014,11 @@ func (c *client) readLoop(pre []byte) {
 		c.parse(pre)
 	}
 
+	// Replaced by a decompressing reader if the remote starts compression.
+	var rd io.Reader = nc
+
 	for {
-		n, err := nc.Read(b)
+		n, err := rd.Read(b)
 		//log.Printf("Server Buf : %s", b)
 		// If we have any data we will try to parse and exit at the end.
 		if n == 0 && err != nil {
@@ -1007,7 +1048,17 @@ func (c *client) readLoop(pre []byte) {
 		// Main call into parser for inbound data. This will generate callouts
 		// to process messages, etc.
 		for i := 0; i < len(bufs); i++ {
-			if err := c.parse(bufs[i]); err != nil {
+			var err error
+			if mqtt {
+				err = c.mqttParse(bufs[i])
+			} else {
+				err = c.parse(bufs[i])
+			}
+			if err == errCompressionStart {
+				rd = c.newDecompressionReader(nc)
+				continue
+			}
+			if err != nil {
 				if dur := time.Since(start); dur >= readLoopReportThreshold {
 					c.Warnf("Readloop processing time: %v", dur)
 				}
@@ -1105,6 +1156,9 @@ func (c *client) collapsePtoNB() (net.Buffers, int64) {
 	if c.ws != nil {
 		return c.wsCollapsePtoNB()
 	}
+	if c.out.cmp != nil {
+		return c.compCollapsePtoNB()
+	}
 	if c.out.p != nil {
 		p := c.out.p
 		c.out.p = nil
@@ -1120,6 +1174,12 @@ func (c *client) handlePartialWrite(pnb net.Buffers) {
 		c.ws.frames = append(pnb, c.ws.frames...)
 		return
 	}
+	// The partial is either already compressed or was queued before
+	// compression started, so it must not be compressed.
+	if c.out.cmp != nil {
+		c.out.cmp.nb = append(pnb, c.out.cmp.nb...)
+		return
+	}
 	nb, _ := c.collapsePtoNB()
 	// The partial needs to be first, so append nb to pnb
 	c.out.nb = append(pnb, nb...)
@@ -1417,6 +1477,9 @@ func (c *client) processInfo(arg []byte) error {
 	if err := json.Unmarshal(arg, &info); err != nil {
 		return err
 	}
+	if info.CompressionStart && c.kind != CLIENT && c.ws == nil {
+		return errCompressionStart
+	}
 	switch c.kind {
 	case ROUTER:
 		c.processRouteInfo(&info)
@@ -1824,6 +1887,10 @@ func (c *client) enqueueProtoAndFlush(proto []byte, doFlush bool) {
 		log.Print("Client has shutdown!!")
 		return
 	}
+	// MQTT clients do not understand NATS protocols (such as -ERR).
+	if c.mqtt != nil {
+		return
+	}
 	c.queueOutbound(proto)
 	if !(doFlush && c.flushOutbound()) {
 		c.flushSignal()
@@ -1985,6 +2052,7 @@ func (c *client) processPong() {
 	c.mu.Lock()
 	c.ping.out = 0
 	c.rtt = computeRTT(c.rttStart)
+	c.updateCompressionLevel()
 	srv := c.srv
 	reorderGWs := c.kind == GATEWAY && c.gw.outbound
 	c.mu.Unlock()
@@ -3238,6 +3306,14 @@ func (c *client) processInboundClientMsg(msg []byte) bool {
 		}
 	}
 
+	// Apply any subject mapping of the account before matching. Permissions
+	// have been checked against the subject as published.
+	if c.kind == CLIENT && c.acc.hasMappings() {
+		if subject, changed := c.acc.selectMappedSubject(string(c.pa.subject)); changed {
+			c.pa.subject = []byte(subject)
+		}
+	}
+
 	// Match the subscriptions. We will use our own L1 map if
 	// it's still valid, avoiding contention on the shared sublist.
 	var r *SublistResult
@@ -4147,6 +4223,11 @@ func (c *client) closeConnection(reason ClosedState) {
 		}
 	}
 
+	// Publish the will message and release the session of MQTT clients.
+	if c.mqtt != nil {
+		c.mqttHandleClosedClient(reason)
+	}
+
 	// Don't reconnect connections that have been marked with
 	// the no reconnect flag.
 	if noReconnect {
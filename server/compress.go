@@ -0,0 +1,267 @@
+// Copyright 2020 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/s2"
+)
+
+// Compression modes for route, gateway and leafnode connections.
+const (
+	// CompressionOff disables compression.
+	CompressionOff = "off"
+	// CompressionFast compresses with the fastest S2 level.
+	CompressionFast = "fast"
+	// CompressionBetter trades CPU for a better compression ratio.
+	CompressionBetter = "better"
+	// CompressionAuto selects the level based on the connection RTT.
+	CompressionAuto = "auto"
+)
+
+const (
+	// Level used by the "auto" mode when the RTT is so low that compressing
+	// would only cost CPU. Data is still framed with S2 so that the level
+	// can be changed if the RTT increases.
+	compressionUncompressed = "uncompressed"
+
+	// Below this RTT, the "auto" mode does not compress.
+	compressionAutoFastRTT = 10 * time.Millisecond
+	// Below this RTT, the "auto" mode uses the fast level, and the better
+	// level otherwise.
+	compressionAutoBetterRTT = 50 * time.Millisecond
+
+	// Sent to the remote when this side starts to compress the data it sends.
+	compressionStartProto = "INFO {\"compression_start\":true}" + _CRLF_
+)
+
+// Returned by processInfo when the remote signals that everything that
+// follows its INFO protocol is compressed.
+var errCompressionStart = errors.New("compression start")
+
+// Holds the state of the compression of outbound data.
+type outCompression struct {
+	mode  string       // Negotiated mode (fast, better or auto).
+	level string       // Level currently used by the writer.
+	w     *s2.Writer   // The S2 writer, writes into buf.
+	buf   bytes.Buffer // Compressed data produced by the writer.
+	nb    net.Buffers  // Pending buffers that must be written as is.
+	ub    int64        // Total of uncompressed bytes given to the writer.
+	cb    int64        // Total of compressed bytes produced by the writer.
+}
+
+// Returns true if the given mode enables compression.
+func needsCompression(mode string) bool {
+	return mode != _EMPTY_ && mode != CompressionOff
+}
+
+// Checks that the given compression mode is valid.
+func validateCompressionMode(mode string) error {
+	switch mode {
+	case _EMPTY_, CompressionOff, CompressionFast, CompressionBetter, CompressionAuto:
+		return nil
+	}
+	return fmt.Errorf("invalid compression mode %q, should be one of %q, %q, %q or %q",
+		mode, CompressionOff, CompressionFast, CompressionBetter, CompressionAuto)
+}
+
+// Checks the compression mode of the cluster, gateway, leafnode
+// and leafnode remotes blocks.
+func validateCompressionOptions(o *Options) error {
+	if err := validateCompressionMode(o.Cluster.Compression); err != nil {
+		return fmt.Errorf("cluster: %v", err)
+	}
+	if err := validateCompressionMode(o.Gateway.Compression); err != nil {
+		return fmt.Errorf("gateway: %v", err)
+	}
+	if err := validateCompressionMode(o.LeafNode.Compression); err != nil {
+		return fmt.Errorf("leafnode: %v", err)
+	}
+	for _, r := range o.LeafNode.Remotes {
+		if err := validateCompressionMode(r.Compression); err != nil {
+			return fmt.Errorf("leafnode remote: %v", err)
+		}
+	}
+	return nil
+}
+
+// Parses the value of a "compression" configuration field, which
+// is either a boolean (true selecting the "auto" mode) or a mode.
+func parseCompression(v interface{}) (string, error) {
+	switch mv := v.(type) {
+	case bool:
+		if mv {
+			return CompressionAuto, nil
+		}
+		return CompressionOff, nil
+	case string:
+		mode := strings.ToLower(mv)
+		if err := validateCompressionMode(mode); err != nil {
+			return _EMPTY_, err
+		}
+		return mode, nil
+	default:
+		return _EMPTY_, fmt.Errorf("expected compression to be a boolean or a string, got %T", v)
+	}
+}
+
+// Returns the level at which data is compressed for the given mode.
+// For the "auto" mode, the level depends on the RTT, and is the
+// fast level as long as the RTT is unknown.
+func compressionLevel(mode string, rtt time.Duration) string {
+	if mode != CompressionAuto {
+		return mode
+	}
+	switch {
+	case rtt == 0:
+		return CompressionFast
+	case rtt < compressionAutoFastRTT:
+		return compressionUncompressed
+	case rtt < compressionAutoBetterRTT:
+		return CompressionFast
+	default:
+		return CompressionBetter
+	}
+}
+
+// Creates a S2 writer for the given level. Compression is invoked
+// from flushOutbound, so no concurrency is needed.
+func newCompressionWriter(level string, w io.Writer) *s2.Writer {
+	opts := []s2.WriterOption{s2.WriterConcurrency(1)}
+	switch level {
+	case CompressionBetter:
+		opts = append(opts, s2.WriterBetterCompression())
+	case compressionUncompressed:
+		opts = append(opts, s2.WriterUncompressed())
+	}
+	return s2.NewWriter(w, opts...)
+}
+
+// Starts the compression of the outbound data if both this side (with
+// the `local` mode) and the remote want compression. The remote is
+// notified with an INFO protocol, and only data queued after that
+// protocol is compressed. Websocket connections have their own
+// compression and are not compressed here.
+// Lock held on entry.
+func (c *client) maybeStartCompression(local string, remote bool) {
+	if !needsCompression(local) || !remote || c.ws != nil || c.out.cmp != nil || c.isClosed() {
+		return
+	}
+	c.enqueueProto([]byte(compressionStartProto))
+	cmp := &outCompression{mode: local, level: compressionLevel(local, c.rtt)}
+	cmp.w = newCompressionWriter(cmp.level, &cmp.buf)
+	// What has been queued so far, including the INFO protocol above,
+	// has to be sent as is.
+	if c.out.p != nil {
+		c.out.nb = append(c.out.nb, c.out.p)
+		c.out.p = nil
+	}
+	cmp.nb, c.out.nb = c.out.nb, nil
+	c.out.cmp = cmp
+	c.Debugf("Compression started, mode %q, level %q", cmp.mode, cmp.level)
+}
+
+// For the "auto" mode, possibly changes the compression level after an
+// update of the RTT. Since the writer is flushed each time data is
+// compressed, it can simply be replaced.
+// Lock held on entry.
+func (c *client) updateCompressionLevel() {
+	cmp := c.out.cmp
+	if cmp == nil || cmp.mode != CompressionAuto {
+		return
+	}
+	level := compressionLevel(cmp.mode, c.rtt)
+	if level == cmp.level {
+		return
+	}
+	c.Debugf("Compression level changed from %q to %q (rtt=%v)", cmp.level, level, c.rtt)
+	cmp.level = level
+	cmp.w = newCompressionWriter(level, &cmp.buf)
+}
+
+// Compresses the pending data and returns the buffers to write,
+// starting with the ones that have to be written as is (from a
+// partial write or queued before compression was started).
+// Lock held on entry.
+func (c *client) compCollapsePtoNB() (net.Buffers, int64) {
+	cmp := c.out.cmp
+	nb := c.out.nb
+	if c.out.p != nil {
+		nb = append(nb, c.out.p)
+		c.out.p = nil
+	}
+	c.out.nb = nil
+	if len(nb) > 0 {
+		var usz int
+		for _, b := range nb {
+			usz += len(b)
+			cmp.w.Write(b)
+		}
+		cmp.w.Flush()
+		cb := make([]byte, cmp.buf.Len())
+		copy(cb, cmp.buf.Bytes())
+		cmp.buf.Reset()
+		// Pending bytes now account for the compressed data.
+		c.out.pb += int64(len(cb) - usz)
+		cmp.ub += int64(usz)
+		cmp.cb += int64(len(cb))
+		cmp.nb = append(cmp.nb, cb)
+	}
+	bufs := cmp.nb
+	cmp.nb = nil
+	var total int64
+	for _, b := range bufs {
+		total += int64(len(b))
+	}
+	return bufs, total
+}
+
+// Returns a reader that decompresses the data received from the
+// remote, starting with what was left in the read buffer after
+// the INFO protocol that signaled the start of compression.
+// Invoked from the readLoop.
+func (c *client) newDecompressionReader(nc net.Conn) io.Reader {
+	pending := make([]byte, len(c.in.cpend))
+	copy(pending, c.in.cpend)
+	c.in.cpend = nil
+	c.Debugf("Remote started compression")
+	return s2.NewReader(io.MultiReader(bytes.NewReader(pending), nc))
+}
+
+// Returns the compression information for the monitoring
+// endpoints, or nil if this connection is not compressed.
+// Lock held on entry.
+func (c *client) compressionInfo() *CompressionInfo {
+	cmp := c.out.cmp
+	if cmp == nil {
+		return nil
+	}
+	ci := &CompressionInfo{
+		Mode:              cmp.mode,
+		Level:             cmp.level,
+		UncompressedBytes: cmp.ub,
+		CompressedBytes:   cmp.cb,
+	}
+	if cmp.cb > 0 {
+		ci.Ratio = float64(cmp.ub) / float64(cmp.cb)
+	}
+	return ci
+}
@@ -0,0 +1,413 @@
+// Copyright 2020 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yanzongzhen/nats.go"
+)
+
+func TestCompressionParseOptions(t *testing.T) {
+	conf := createConfFile(t, []byte(`
+		cluster {
+			port: -1
+			compression: fast
+		}
+		gateway {
+			name: "A"
+			port: -1
+			compression: "Better"
+		}
+		leafnodes {
+			port: -1
+			compression: true
+			remotes [
+				{url: "nats://127.0.0.1:1234", compression: false}
+				{url: "nats://127.0.0.1:1235", compression: auto}
+			]
+		}
+	`))
+	defer os.Remove(conf)
+	o, err := ProcessConfigFile(conf)
+	if err != nil {
+		t.Fatalf("Error processing config file: %v", err)
+	}
+	if o.Cluster.Compression != CompressionFast {
+		t.Fatalf("Unexpected cluster compression: %q", o.Cluster.Compression)
+	}
+	if o.Gateway.Compression != CompressionBetter {
+		t.Fatalf("Unexpected gateway compression: %q", o.Gateway.Compression)
+	}
+	if o.LeafNode.Compression != CompressionAuto {
+		t.Fatalf("Unexpected leafnode compression: %q", o.LeafNode.Compression)
+	}
+	if len(o.LeafNode.Remotes) != 2 {
+		t.Fatalf("Expected 2 remotes, got %v", len(o.LeafNode.Remotes))
+	}
+	if c := o.LeafNode.Remotes[0].Compression; c != CompressionOff {
+		t.Fatalf("Unexpected first remote compression: %q", c)
+	}
+	if c := o.LeafNode.Remotes[1].Compression; c != CompressionAuto {
+		t.Fatalf("Unexpected second remote compression: %q", c)
+	}
+
+	conf = createConfFile(t, []byte(`
+		cluster {
+			port: -1
+			compression: "fastest"
+		}
+	`))
+	defer os.Remove(conf)
+	if _, err := ProcessConfigFile(conf); err == nil || !strings.Contains(err.Error(), "invalid compression mode") {
+		t.Fatalf("Expected error about invalid mode, got %v", err)
+	}
+
+	o = DefaultOptions()
+	o.LeafNode.Remotes = []*RemoteLeafOpts{{Compression: "s2"}}
+	if _, err := NewServer(o); err == nil || !strings.Contains(err.Error(), "invalid compression mode") {
+		t.Fatalf("Expected error about invalid mode, got %v", err)
+	}
+}
+
+func TestCompressionAutoLevel(t *testing.T) {
+	for _, test := range []struct {
+		mode     string
+		rtt      time.Duration
+		expected string
+	}{
+		{CompressionFast, 100 * time.Millisecond, CompressionFast},
+		{CompressionBetter, time.Millisecond, CompressionBetter},
+		{CompressionAuto, 0, CompressionFast},
+		{CompressionAuto, time.Millisecond, compressionUncompressed},
+		{CompressionAuto, 20 * time.Millisecond, CompressionFast},
+		{CompressionAuto, 50 * time.Millisecond, CompressionBetter},
+	} {
+		t.Run(fmt.Sprintf("%s_%v", test.mode, test.rtt), func(t *testing.T) {
+			if level := compressionLevel(test.mode, test.rtt); level != test.expected {
+				t.Fatalf("Expected level %q, got %q", test.expected, level)
+			}
+		})
+	}
+}
+
+// Payload that looks like the JSON messages compression is meant for.
+func testCompressionPayload() []byte {
+	var sb strings.Builder
+	sb.WriteString("[")
+	for i := 0; i < 50; i++ {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		fmt.Fprintf(&sb, `{"id":%d,"name":"sensor-%d","status":"ok","value":%d}`, i, i%5, i*3)
+	}
+	sb.WriteString("]")
+	return []byte(sb.String())
+}
+
+func testCheckCompressionInfo(t *testing.T, ci *CompressionInfo, mode string, expected bool) {
+	t.Helper()
+	if !expected {
+		if ci != nil {
+			t.Fatalf("Expected no compression, got %+v", ci)
+		}
+		return
+	}
+	if ci == nil {
+		t.Fatalf("Expected compression info")
+	}
+	if ci.Mode != mode {
+		t.Fatalf("Expected mode %q, got %q", mode, ci.Mode)
+	}
+	if ci.UncompressedBytes == 0 || ci.CompressedBytes == 0 || ci.Ratio <= 1 {
+		t.Fatalf("Unexpected compression stats: %+v", ci)
+	}
+}
+
+func TestCompressionRoutes(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		modeA    string
+		modeB    string
+		expected bool
+	}{
+		{"both fast", CompressionFast, CompressionFast, true},
+		{"better and auto", CompressionBetter, CompressionAuto, true},
+		{"one off", CompressionFast, CompressionOff, false},
+		{"one not set", _EMPTY_, CompressionAuto, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			oa := DefaultOptions()
+			oa.Cluster.Compression = test.modeA
+			sa := RunServer(oa)
+			defer sa.Shutdown()
+
+			ob := DefaultOptions()
+			ob.Cluster.Compression = test.modeB
+			ob.Routes = RoutesFromStr(fmt.Sprintf("nats://127.0.0.1:%d", oa.Cluster.Port))
+			sb := RunServer(ob)
+			defer sb.Shutdown()
+
+			checkClusterFormed(t, sa, sb)
+
+			ncSub := natsConnect(t, sb.ClientURL())
+			defer ncSub.Close()
+			sub := natsSubSync(t, ncSub, "foo")
+			natsFlush(t, ncSub)
+			checkSubInterest(t, sa, globalAccountName, "foo", time.Second)
+
+			ncPub := natsConnect(t, sa.ClientURL())
+			defer ncPub.Close()
+			testCompressionSendAndReceive(t, ncPub, sub)
+
+			rz, err := sa.Routez(nil)
+			if err != nil {
+				t.Fatalf("Error getting routez: %v", err)
+			}
+			if len(rz.Routes) != 1 {
+				t.Fatalf("Expected 1 route, got %v", len(rz.Routes))
+			}
+			testCheckCompressionInfo(t, rz.Routes[0].Compression, test.modeA, test.expected)
+		})
+	}
+}
+
+func testCompressionSendAndReceive(t *testing.T, ncPub *nats.Conn, sub *nats.Subscription) {
+	t.Helper()
+	payload := testCompressionPayload()
+	for i := 0; i < 100; i++ {
+		natsPub(t, ncPub, sub.Subject, payload)
+	}
+	for i := 0; i < 100; i++ {
+		if msg := natsNexMsg(t, sub, time.Second); string(msg.Data) != string(payload) {
+			t.Fatalf("Unexpected message: %q", msg.Data)
+		}
+	}
+}
+
+func TestCompressionAutoLevelChange(t *testing.T) {
+	// Prevent PONGs from updating the RTT we set below.
+	oa := DefaultOptions()
+	oa.Cluster.Compression = CompressionAuto
+	oa.PingInterval = time.Hour
+	oa.DisableShortFirstPing = true
+	sa := RunServer(oa)
+	defer sa.Shutdown()
+
+	ob := DefaultOptions()
+	ob.Cluster.Compression = CompressionAuto
+	ob.Routes = RoutesFromStr(fmt.Sprintf("nats://127.0.0.1:%d", oa.Cluster.Port))
+	sb := RunServer(ob)
+	defer sb.Shutdown()
+
+	checkClusterFormed(t, sa, sb)
+
+	ncSub := natsConnect(t, sb.ClientURL())
+	defer ncSub.Close()
+	sub := natsSubSync(t, ncSub, "foo")
+	natsFlush(t, ncSub)
+	checkSubInterest(t, sa, globalAccountName, "foo", time.Second)
+
+	ncPub := natsConnect(t, sa.ClientURL())
+	defer ncPub.Close()
+
+	var route *client
+	sa.mu.Lock()
+	for _, r := range sa.routes {
+		route = r
+	}
+	sa.mu.Unlock()
+
+	// Simulate RTT updates and make sure that messages still flow
+	// after each change of level.
+	for _, test := range []struct {
+		rtt   time.Duration
+		level string
+	}{
+		{time.Millisecond, compressionUncompressed},
+		{100 * time.Millisecond, CompressionBetter},
+		{20 * time.Millisecond, CompressionFast},
+	} {
+		route.mu.Lock()
+		route.rtt = test.rtt
+		route.updateCompressionLevel()
+		route.mu.Unlock()
+
+		testCompressionSendAndReceive(t, ncPub, sub)
+
+		rz, err := sa.Routez(nil)
+		if err != nil {
+			t.Fatalf("Error getting routez: %v", err)
+		}
+		if ci := rz.Routes[0].Compression; ci == nil || ci.Level != test.level {
+			t.Fatalf("Expected level %q, got %+v", test.level, ci)
+		}
+	}
+}
+
+func TestCompressionGateways(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		modeA    string
+		modeB    string
+		expected bool
+	}{
+		{"both better", CompressionBetter, CompressionBetter, true},
+		{"fast and auto", CompressionFast, CompressionAuto, true},
+		{"one off", CompressionOff, CompressionFast, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ob := testDefaultOptionsForGateway("B")
+			ob.Gateway.Compression = test.modeB
+			sb := runGatewayServer(ob)
+			defer sb.Shutdown()
+
+			oa := testGatewayOptionsFromToWithServers(t, "A", "B", sb)
+			oa.Gateway.Compression = test.modeA
+			sa := runGatewayServer(oa)
+			defer sa.Shutdown()
+
+			waitForOutboundGateways(t, sa, 1, time.Second)
+			waitForOutboundGateways(t, sb, 1, time.Second)
+
+			ncSub := natsConnect(t, sb.ClientURL())
+			defer ncSub.Close()
+			sub := natsSubSync(t, ncSub, "foo")
+			natsFlush(t, ncSub)
+
+			ncPub := natsConnect(t, sa.ClientURL())
+			defer ncPub.Close()
+			testCompressionSendAndReceive(t, ncPub, sub)
+
+			gwz, err := sa.Gatewayz(nil)
+			if err != nil {
+				t.Fatalf("Error getting gatewayz: %v", err)
+			}
+			gw := gwz.OutboundGateways["B"]
+			if gw == nil {
+				t.Fatalf("Expected outbound gateway to B, got %+v", gwz.OutboundGateways)
+			}
+			testCheckCompressionInfo(t, gw.Compression, test.modeA, test.expected)
+
+			// The inbound side on B negotiated compression too.
+			gwz, err = sb.Gatewayz(nil)
+			if err != nil {
+				t.Fatalf("Error getting gatewayz: %v", err)
+			}
+			igws := gwz.InboundGateways["A"]
+			if len(igws) != 1 {
+				t.Fatalf("Expected 1 inbound gateway from A, got %+v", gwz.InboundGateways)
+			}
+			if ci := igws[0].Compression; (ci != nil) != test.expected {
+				t.Fatalf("Unexpected inbound compression: %+v", ci)
+			}
+		})
+	}
+}
+
+func TestCompressionLeafNodes(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		hubMode    string
+		remoteMode string
+		expected   bool
+	}{
+		{"both fast", CompressionFast, CompressionFast, true},
+		{"auto and better", CompressionAuto, CompressionBetter, true},
+		{"hub off", CompressionOff, CompressionFast, false},
+		{"remote not set", CompressionFast, _EMPTY_, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			oh := DefaultOptions()
+			oh.LeafNode.Host = "127.0.0.1"
+			oh.LeafNode.Port = -1
+			oh.LeafNode.Compression = test.hubMode
+			hub := RunServer(oh)
+			defer hub.Shutdown()
+
+			u, err := url.Parse(fmt.Sprintf("nats://127.0.0.1:%d", oh.LeafNode.Port))
+			if err != nil {
+				t.Fatalf("Error parsing url: %v", err)
+			}
+			ol := DefaultOptions()
+			ol.LeafNode.Remotes = []*RemoteLeafOpts{{URLs: []*url.URL{u}, Compression: test.remoteMode}}
+			ln := RunServer(ol)
+			defer ln.Shutdown()
+
+			checkLeafNodeConnected(t, hub)
+			checkLeafNodeConnected(t, ln)
+
+			// Messages in both directions.
+			ncHub := natsConnect(t, hub.ClientURL())
+			defer ncHub.Close()
+			subHub := natsSubSync(t, ncHub, "foo")
+			natsFlush(t, ncHub)
+
+			ncLN := natsConnect(t, ln.ClientURL())
+			defer ncLN.Close()
+			subLN := natsSubSync(t, ncLN, "bar")
+			natsFlush(t, ncLN)
+
+			checkSubInterest(t, ln, globalAccountName, "foo", time.Second)
+			checkSubInterest(t, hub, globalAccountName, "bar", time.Second)
+
+			testCompressionSendAndReceive(t, ncLN, subHub)
+			testCompressionSendAndReceive(t, ncHub, subLN)
+
+			for _, s := range []struct {
+				srv  *Server
+				mode string
+			}{{hub, test.hubMode}, {ln, test.remoteMode}} {
+				lz, err := s.srv.Leafz(nil)
+				if err != nil {
+					t.Fatalf("Error getting leafz: %v", err)
+				}
+				if len(lz.Leafs) != 1 {
+					t.Fatalf("Expected 1 leafnode, got %v", len(lz.Leafs))
+				}
+				testCheckCompressionInfo(t, lz.Leafs[0].Compression, s.mode, test.expected)
+			}
+		})
+	}
+}
+
+func TestCompressionConfigReload(t *testing.T) {
+	tmpl := `
+		listen: "127.0.0.1:%d"
+		cluster {
+			listen: "127.0.0.1:%d"
+			compression: %s
+		}
+	`
+	conf := createConfFile(t, []byte(fmt.Sprintf(tmpl, -1, -1, CompressionFast)))
+	defer os.Remove(conf)
+	s, o := RunServerWithConfig(conf)
+	defer s.Shutdown()
+
+	// The compression of the routes is negotiated when they connect,
+	// so it can't be changed by a reload.
+	changeCurrentConfigContentWithNewContent(t, conf, []byte(fmt.Sprintf(tmpl, o.Port, o.Cluster.Port, CompressionBetter)))
+	if err := s.Reload(); err == nil || !strings.Contains(err.Error(), "cluster compression") {
+		t.Fatalf("Expected error about cluster compression, got %v", err)
+	}
+	changeCurrentConfigContentWithNewContent(t, conf, []byte(fmt.Sprintf(tmpl, o.Port, o.Cluster.Port, CompressionFast)))
+	if err := s.Reload(); err != nil {
+		t.Fatalf("Error on reload: %v", err)
+	}
+}
@@ -19,6 +19,7 @@ import (
 	"crypto/sha256"
 	"encoding/json"
 	"fmt"
+	"math"
 	mrand "math/rand"
 	"reflect"
 	"sort"
@@ -40,21 +41,124 @@ type ConsumerInfo struct {
 	AckFloor       SequencePair   `json:"ack_floor"`
 	NumPending     int            `json:"num_pending"`
 	NumRedelivered int            `json:"num_redelivered"`
+	Paused         bool           `json:"paused,omitempty"`
+	PauseUntil     *time.Time     `json:"pause_until,omitempty"`
+	Stats          *ConsumerStats `json:"stats,omitempty"`
+}
+
+// ConsumerStats are delivery counters for a consumer. They are kept in
+// memory only and start over when the server restarts.
+type ConsumerStats struct {
+	Delivered   uint64            `json:"delivered"`
+	Redelivered uint64            `json:"redelivered"`
+	Acked       uint64            `json:"acked"`
+	Naked       uint64            `json:"naked"`
+	Terminated  uint64            `json:"terminated"`
+	Expired     uint64            `json:"expired"`
+	Exceeded    uint64            `json:"max_deliver_exceeded"`
+	AckLatency  *LatencyHistogram `json:"ack_latency,omitempty"`
+}
+
+// LatencyHistogram holds latency samples in buckets. Bucket counts are
+// cumulative, each one counts the samples less than or equal to its bound.
+// Count also includes samples above the largest bound.
+type LatencyHistogram struct {
+	Count   uint64          `json:"count"`
+	Sum     time.Duration   `json:"sum"`
+	Min     time.Duration   `json:"min"`
+	Max     time.Duration   `json:"max"`
+	Buckets []LatencyBucket `json:"buckets"`
+}
+
+// LatencyBucket is a single bucket of a LatencyHistogram.
+type LatencyBucket struct {
+	LE    time.Duration `json:"le"`
+	Count uint64        `json:"count"`
+}
+
+// Upper bounds for the ack latency buckets.
+var ackLatencyBounds = [...]time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+	10 * time.Second,
+	30 * time.Second,
+	time.Minute,
+}
+
+// Internal histogram for ack latencies.
+type latencyHist struct {
+	counts   [len(ackLatencyBounds)]uint64
+	count    uint64
+	sum      time.Duration
+	min, max time.Duration
+}
+
+func (h *latencyHist) record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	for i, le := range ackLatencyBounds {
+		if d <= le {
+			h.counts[i]++
+			break
+		}
+	}
+	if h.count == 0 || d < h.min {
+		h.min = d
+	}
+	if d > h.max {
+		h.max = d
+	}
+	h.count++
+	h.sum += d
+}
+
+// Returns nil if we have no samples.
+func (h *latencyHist) histogram() *LatencyHistogram {
+	if h.count == 0 {
+		return nil
+	}
+	lh := &LatencyHistogram{
+		Count:   h.count,
+		Sum:     h.sum,
+		Min:     h.min,
+		Max:     h.max,
+		Buckets: make([]LatencyBucket, 0, len(ackLatencyBounds)),
+	}
+	var total uint64
+	for i, le := range ackLatencyBounds {
+		total += h.counts[i]
+		lh.Buckets = append(lh.Buckets, LatencyBucket{LE: le, Count: total})
+	}
+	return lh
 }
 
 type ConsumerConfig struct {
-	Durable         string        `json:"durable_name,omitempty"`
-	DeliverSubject  string        `json:"deliver_subject,omitempty"`
-	DeliverPolicy   DeliverPolicy `json:"deliver_policy"`
-	OptStartSeq     uint64        `json:"opt_start_seq,omitempty"`
-	OptStartTime    *time.Time    `json:"opt_start_time,omitempty"`
-	AckPolicy       AckPolicy     `json:"ack_policy"`
-	AckWait         time.Duration `json:"ack_wait,omitempty"`
-	MaxDeliver      int           `json:"max_deliver,omitempty"`
-	FilterSubject   string        `json:"filter_subject,omitempty"`
-	ReplayPolicy    ReplayPolicy  `json:"replay_policy"`
-	RateLimit       uint64        `json:"rate_limit_bps,omitempty"` // Bits per sec
-	SampleFrequency string        `json:"sample_freq,omitempty"`
+	Durable           string          `json:"durable_name,omitempty"`
+	Description       string          `json:"description,omitempty"`
+	DeliverSubject    string          `json:"deliver_subject,omitempty"`
+	DeliverPolicy     DeliverPolicy   `json:"deliver_policy"`
+	OptStartSeq       uint64          `json:"opt_start_seq,omitempty"`
+	OptStartTime      *time.Time      `json:"opt_start_time,omitempty"`
+	AckPolicy         AckPolicy       `json:"ack_policy"`
+	AckWait           time.Duration   `json:"ack_wait,omitempty"`
+	MaxDeliver        int             `json:"max_deliver,omitempty"`
+	DeadLetterSubject string          `json:"dead_letter_subject,omitempty"`
+	FilterSubject     string          `json:"filter_subject,omitempty"`
+	ReplayPolicy      ReplayPolicy    `json:"replay_policy"`
+	Ordered           bool            `json:"ordered,omitempty"`
+	RateLimit         uint64          `json:"rate_limit_bps,omitempty"` // Bits per sec
+	SampleFrequency   string          `json:"sample_freq,omitempty"`
+	BackOff           []time.Duration `json:"backoff,omitempty"`
+	InactiveThreshold time.Duration   `json:"inactive_threshold,omitempty"`
 }
 
 type CreateConsumerRequest struct {
@@ -146,7 +250,7 @@ var (
 	AckAck = []byte("+ACK") // nil or no payload to ack subject also means ACK
 	AckOK  = []byte(OK)     // deprecated but +OK meant ack as well.
 
-	// Nack
+	// Nack, can be followed by a JSON encoded ConsumerNakOptions to delay redelivery.
 	AckNak = []byte("-NAK")
 	// Progress indicator
 	AckProgress = []byte("+WPI")
@@ -156,6 +260,31 @@ var (
 	AckTerm = []byte("+TERM")
 )
 
+// A NAK with options is separated from them by a space.
+var ackNakWithOpts = []byte("-NAK ")
+
+// ConsumerNakOptions are optional in a NAK, e.g. -NAK {"delay": 5000000000}
+type ConsumerNakOptions struct {
+	// Delay is how long to wait before the message is redelivered.
+	Delay time.Duration `json:"delay"`
+}
+
+// Headers added to messages copied to a consumer's dead letter subject.
+const (
+	JSDeadLetterStream     = "Nats-Dead-Letter-Stream"
+	JSDeadLetterConsumer   = "Nats-Dead-Letter-Consumer"
+	JSDeadLetterSubject    = "Nats-Dead-Letter-Subject"
+	JSDeadLetterSequence   = "Nats-Dead-Letter-Sequence"
+	JSDeadLetterDeliveries = "Nats-Dead-Letter-Deliveries"
+	JSDeadLetterReason     = "Nats-Dead-Letter-Reason"
+)
+
+// Reasons a message was copied to a dead letter subject.
+const (
+	JSDeadLetterReasonMaxDeliveries = "max_deliveries"
+	JSDeadLetterReasonTerminated    = "terminated"
+)
+
 // Consumer is a jetstream consumer.
 type Consumer struct {
 	mu                sync.Mutex
@@ -186,6 +315,12 @@ type Consumer struct {
 	filterWC          bool
 	dtmr              *time.Timer
 	dthresh           time.Duration
+	paused            bool
+	pausedAt          time.Time
+	pauseUntil        time.Time
+	pauseTmr          *time.Timer
+	stats             ConsumerStats
+	alat              latencyHist
 	fch               chan struct{}
 	qch               chan struct{}
 	inch              chan bool
@@ -232,14 +367,28 @@ func (mset *Stream) AddConsumer(config *ConsumerConfig) (*Consumer, error) {
 		}
 	}
 
-	// Setup proper default for ack wait if we are in explicit ack mode.
-	if config.AckWait == 0 && (config.AckPolicy == AckExplicit || config.AckPolicy == AckAll) {
-		config.AckWait = JsAckWaitDefault
+	if err := checkOrderedConsumer(config); err != nil {
+		return nil, err
+	}
+	if config.InactiveThreshold < 0 {
+		return nil, fmt.Errorf("consumer inactive threshold can not be negative")
 	}
+
 	// Setup default of -1, meaning no limit for MaxDeliver.
 	if config.MaxDeliver == 0 {
 		config.MaxDeliver = -1
 	}
+	// This needs to happen before the default for ack wait is applied.
+	if err := checkConsumerBackOff(config); err != nil {
+		return nil, err
+	}
+	// Setup proper default for ack wait if we are in explicit ack mode.
+	if config.AckWait == 0 && (config.AckPolicy == AckExplicit || config.AckPolicy == AckAll) {
+		config.AckWait = JsAckWaitDefault
+	}
+	if err := mset.checkDeadLetterSubject(config); err != nil {
+		return nil, err
+	}
 
 	// Make sure any partition subject is also a literal.
 	if config.FilterSubject != "" {
@@ -291,13 +440,9 @@ func (mset *Stream) AddConsumer(config *ConsumerConfig) (*Consumer, error) {
 		}
 	}
 
-	sampleFreq := 0
-	if config.SampleFrequency != "" {
-		s := strings.TrimSuffix(config.SampleFrequency, "%")
-		sampleFreq, err = strconv.Atoi(s)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse consumer sampling configuration: %v", err)
-		}
+	sampleFreq, err := parseSampleFrequency(config.SampleFrequency)
+	if err != nil {
+		return nil, err
 	}
 
 	// Hold mset lock here.
@@ -390,20 +535,7 @@ func (mset *Stream) AddConsumer(config *ConsumerConfig) (*Consumer, error) {
 
 	// Check if we have a rate limit set.
 	if config.RateLimit != 0 {
-		// TODO(dlc) - Make sane values or error if not sane?
-		// We are configured in bits per sec so adjust to bytes.
-		rl := rate.Limit(config.RateLimit / 8)
-		// Burst should be set to maximum msg size for this account, etc.
-		var burst int
-		if mset.config.MaxMsgSize > 0 {
-			burst = int(mset.config.MaxMsgSize)
-		} else if mset.jsa.account.limits.mpay > 0 {
-			burst = int(mset.jsa.account.limits.mpay)
-		} else {
-			s := mset.jsa.account.srv
-			burst = int(s.getOpts().MaxPayload)
-		}
-		o.rlimit = rate.NewLimiter(rl, burst)
+		o.rlimit = newConsumerRateLimiter(config.RateLimit, mset.rateLimitBurst())
 	}
 
 	// Check if we have  filtered subject that is a wildcard.
@@ -487,9 +619,10 @@ func (mset *Stream) AddConsumer(config *ConsumerConfig) (*Consumer, error) {
 	mset.consumers[o.name] = o
 	mset.mu.Unlock()
 
+	o.dthresh = inactiveThreshold(config)
+
 	// If push mode, register for notifications on interest.
 	if o.isPushMode() {
-		o.dthresh = JsDeleteWaitTimeDefault
 		o.inch = make(chan bool, 4)
 		a.sl.RegisterNotification(config.DeliverSubject, o.inch)
 		o.active = o.hasDeliveryInterest(<-o.inch)
@@ -498,6 +631,17 @@ func (mset *Stream) AddConsumer(config *ConsumerConfig) (*Consumer, error) {
 			o.deleteWithoutAdvisory()
 			return nil, fmt.Errorf("consumer requires interest for delivery subject when ephemeral")
 		}
+		// Ordered consumers can only guarantee order to a single subscriber.
+		if config.Ordered {
+			if r := a.sl.Match(config.DeliverSubject); len(r.psubs) > 1 || len(r.qsubs) > 0 {
+				o.deleteWithoutAdvisory()
+				return nil, fmt.Errorf("ordered consumer requires a single subscriber for delivery subject")
+			}
+		}
+	} else {
+		o.mu.Lock()
+		o.resetPullInactiveTimer()
+		o.mu.Unlock()
 	}
 
 	// If we are not in ReplayInstant mode mark us as in replay state until resolved.
@@ -548,6 +692,28 @@ func (o *Consumer) sendDeleteAdvisoryLocked() {
 	o.sendAdvisory(subj, j)
 }
 
+// Lock should be held.
+func (o *Consumer) sendUpdateAdvisoryLocked() {
+	e := JSConsumerActionAdvisory{
+		TypedEvent: TypedEvent{
+			Type: JSConsumerActionAdvisoryType,
+			ID:   nuid.Next(),
+			Time: time.Now().UTC(),
+		},
+		Stream:   o.stream,
+		Consumer: o.name,
+		Action:   ModifyEvent,
+	}
+
+	j, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return
+	}
+
+	subj := JSAdvisoryConsumerUpdatedPre + "." + o.stream + "." + o.name
+	o.sendAdvisory(subj, j)
+}
+
 func (o *Consumer) sendCreateAdvisory() {
 	o.mu.Lock()
 	defer o.mu.Unlock()
@@ -637,9 +803,10 @@ func (o *Consumer) updateDeliveryInterest(localInterest bool) {
 	// Stop and clear the delete timer always.
 	stopAndClearTimer(&o.dtmr)
 
-	// If we do not have interest anymore and we are not durable start
-	// a timer to delete us. We wait for a bit in case of server reconnect.
-	if !o.isDurable() && !interest {
+	// If we do not have interest anymore and we are not durable, or have an
+	// inactive threshold, start a timer to delete us. We wait for a bit in
+	// case of server reconnect.
+	if o.deleteWhenInactive() && !interest {
 		o.dtmr = time.AfterFunc(o.dthresh, func() { o.Delete() })
 	}
 	o.mu.Unlock()
@@ -680,11 +847,224 @@ func (o *Consumer) updateDeliverSubject(newDeliver string) {
 	o.acc.sl.RegisterNotification(newDeliver, o.inch)
 }
 
+// Check the backoff schedule for redeliveries. When present the first
+// value is the ack wait, so an ack wait that is set needs to match it.
+func checkConsumerBackOff(config *ConsumerConfig) error {
+	if len(config.BackOff) == 0 {
+		return nil
+	}
+	if config.AckPolicy == AckNone {
+		return fmt.Errorf("consumer backoff requires an ack policy")
+	}
+	for _, d := range config.BackOff {
+		if d <= 0 {
+			return fmt.Errorf("consumer backoff values must be positive")
+		}
+	}
+	if config.MaxDeliver > 0 && config.MaxDeliver <= len(config.BackOff) {
+		return fmt.Errorf("consumer max deliver is required to be > length of backoff values")
+	}
+	if config.AckWait != 0 && config.AckWait != config.BackOff[0] {
+		return fmt.Errorf("consumer ack wait conflicts with the first backoff value")
+	}
+	config.AckWait = config.BackOff[0]
+	return nil
+}
+
+// Ordered consumers are ephemeral push consumers with no acks, meant for
+// in-order replay of a stream by a single subscriber.
+func checkOrderedConsumer(config *ConsumerConfig) error {
+	if !config.Ordered {
+		return nil
+	}
+	switch {
+	case config.DeliverSubject == _EMPTY_:
+		return fmt.Errorf("ordered consumer requires a deliver subject")
+	case config.Durable != _EMPTY_:
+		return fmt.Errorf("ordered consumer can not be durable")
+	case config.AckPolicy != AckNone:
+		return fmt.Errorf("ordered consumer requires ack policy of none")
+	case config.MaxDeliver > 1:
+		return fmt.Errorf("ordered consumer can not have max deliver set")
+	}
+	return nil
+}
+
+// Check the subject messages are copied to when they exceed max deliveries or
+// are terminated. It can not be captured by our own stream.
+func (mset *Stream) checkDeadLetterSubject(config *ConsumerConfig) error {
+	if config.DeadLetterSubject == _EMPTY_ {
+		return nil
+	}
+	if config.AckPolicy == AckNone {
+		return fmt.Errorf("consumer dead letter subject requires an ack policy")
+	}
+	if !IsValidLiteralSubject(config.DeadLetterSubject) {
+		return fmt.Errorf("consumer dead letter subject is not a valid literal subject")
+	}
+	if config.DeadLetterSubject == config.DeliverSubject {
+		return fmt.Errorf("consumer dead letter subject can not be the deliver subject")
+	}
+	if mset.deliveryFormsCycle(config.DeadLetterSubject) {
+		return fmt.Errorf("consumer dead letter subject forms a cycle")
+	}
+	return nil
+}
+
+// Parse the sampling frequency for ack metrics, e.g. "50%".
+func parseSampleFrequency(freq string) (int, error) {
+	if freq == _EMPTY_ {
+		return 0, nil
+	}
+	sampleFreq, err := strconv.Atoi(strings.TrimSuffix(freq, "%"))
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse consumer sampling configuration: %v", err)
+	}
+	return sampleFreq, nil
+}
+
+// Burst for a consumer rate limiter should be set to maximum msg size for this account, etc.
+// Lock should be held.
+func (mset *Stream) rateLimitBurst() int {
+	if mset.config.MaxMsgSize > 0 {
+		return int(mset.config.MaxMsgSize)
+	} else if mset.jsa.account.limits.mpay > 0 {
+		return int(mset.jsa.account.limits.mpay)
+	}
+	s := mset.jsa.account.srv
+	return int(s.getOpts().MaxPayload)
+}
+
+func newConsumerRateLimiter(bps uint64, burst int) *rate.Limiter {
+	// TODO(dlc) - Make sane values or error if not sane?
+	// We are configured in bits per sec so adjust to bytes.
+	return rate.NewLimiter(rate.Limit(bps/8), burst)
+}
+
+// Update will update the consumer's configuration. Only fields that do not
+// change which messages are delivered or the position of the consumer can be
+// changed, e.g. AckWait, MaxDeliver, RateLimit, SampleFrequency and Description.
+func (o *Consumer) Update(config *ConsumerConfig) error {
+	if config == nil {
+		return fmt.Errorf("consumer config required")
+	}
+	cfg := *config
+
+	o.mu.Lock()
+	mset := o.mset
+	ocfg := o.config
+	o.mu.Unlock()
+
+	if mset == nil {
+		return fmt.Errorf("consumer not valid")
+	}
+	if err := checkConsumerConfigUpdate(&ocfg, &cfg); err != nil {
+		return err
+	}
+	if cfg.InactiveThreshold < 0 {
+		return fmt.Errorf("consumer inactive threshold can not be negative")
+	}
+	if cfg.RateLimit > 0 && o.isPullMode() {
+		return fmt.Errorf("consumer in pull mode can not have rate limit set")
+	}
+	// Same defaults as when created.
+	if cfg.MaxDeliver == 0 {
+		cfg.MaxDeliver = -1
+	}
+	if err := checkConsumerBackOff(&cfg); err != nil {
+		return err
+	}
+	if cfg.AckWait == 0 && (cfg.AckPolicy == AckExplicit || cfg.AckPolicy == AckAll) {
+		cfg.AckWait = JsAckWaitDefault
+	}
+	if err := mset.checkDeadLetterSubject(&cfg); err != nil {
+		return err
+	}
+	sampleFreq, err := parseSampleFrequency(cfg.SampleFrequency)
+	if err != nil {
+		return err
+	}
+	var rlimit *rate.Limiter
+	if cfg.RateLimit != 0 {
+		mset.mu.RLock()
+		burst := mset.rateLimitBurst()
+		mset.mu.RUnlock()
+		rlimit = newConsumerRateLimiter(cfg.RateLimit, burst)
+	}
+
+	o.mu.Lock()
+	if o.mset == nil {
+		o.mu.Unlock()
+		return fmt.Errorf("consumer not valid")
+	}
+	// The config may have changed while we were not holding the lock,
+	// e.g. the deliver subject, so check again against the current one.
+	if err := checkConsumerConfigUpdate(&o.config, &cfg); err != nil {
+		o.mu.Unlock()
+		return err
+	}
+	o.config = cfg
+	o.maxdc = uint64(cfg.MaxDeliver)
+	o.sfreq = int32(sampleFreq)
+	o.rlimit = rlimit
+	o.dthresh = inactiveThreshold(&cfg)
+	if o.isPullMode() {
+		o.resetPullInactiveTimer()
+	} else {
+		stopAndClearTimer(&o.dtmr)
+		if !o.active && o.deleteWhenInactive() {
+			o.dtmr = time.AfterFunc(o.dthresh, func() { o.Delete() })
+		}
+	}
+	// Have pending checked against the new ack wait.
+	if o.ptmr != nil && !o.paused {
+		o.ptmr.Reset(o.ackWait(0))
+	}
+	o.sendUpdateAdvisoryLocked()
+	// Write out new config, under the lock so concurrent updates are stored in order.
+	if store, ok := o.store.(*consumerFileStore); ok {
+		err = store.updateConfig(cfg)
+	}
+	o.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+	mset.signalConsumers()
+	return nil
+}
+
+// Make sure an update to a consumer's configuration only changes what is allowed.
+func checkConsumerConfigUpdate(ocfg, ncfg *ConsumerConfig) error {
+	switch {
+	case ncfg.Durable != ocfg.Durable:
+		return fmt.Errorf("consumer durable name can not be updated")
+	case ncfg.DeliverSubject != ocfg.DeliverSubject:
+		return fmt.Errorf("consumer deliver subject can not be updated")
+	case ncfg.DeliverPolicy != ocfg.DeliverPolicy:
+		return fmt.Errorf("consumer deliver policy can not be updated")
+	case ncfg.OptStartSeq != ocfg.OptStartSeq:
+		return fmt.Errorf("consumer start sequence can not be updated")
+	case (ncfg.OptStartTime == nil) != (ocfg.OptStartTime == nil),
+		ncfg.OptStartTime != nil && !ncfg.OptStartTime.Equal(*ocfg.OptStartTime):
+		return fmt.Errorf("consumer start time can not be updated")
+	case ncfg.AckPolicy != ocfg.AckPolicy:
+		return fmt.Errorf("consumer ack policy can not be updated")
+	case ncfg.FilterSubject != ocfg.FilterSubject:
+		return fmt.Errorf("consumer filter subject can not be updated")
+	case ncfg.ReplayPolicy != ocfg.ReplayPolicy:
+		return fmt.Errorf("consumer replay policy can not be updated")
+	case ncfg.Ordered != ocfg.Ordered:
+		return fmt.Errorf("consumer ordered mode can not be updated")
+	}
+	return nil
+}
+
 // Check that configs are equal but allow delivery subjects to be different.
 func configsEqualSansDelivery(a, b ConsumerConfig) bool {
 	// These were copied in so can set Delivery here.
 	a.DeliverSubject, b.DeliverSubject = _EMPTY_, _EMPTY_
-	return a == b
+	return reflect.DeepEqual(a, b)
 }
 
 // Helper to send a reply to an ack.
@@ -704,8 +1084,8 @@ func (o *Consumer) processAck(_ *subscription, _ *client, subject, reply string,
 	case bytes.Equal(msg, AckNext):
 		o.ackMsg(sseq, dseq, dcount)
 		o.processNextMsgReq(nil, nil, subject, reply, nil)
-	case bytes.Equal(msg, AckNak):
-		o.processNak(sseq, dseq)
+	case bytes.Equal(msg, AckNak), bytes.HasPrefix(msg, ackNakWithOpts):
+		o.processNak(sseq, dseq, msg[len(AckNak):])
 	case bytes.Equal(msg, AckProgress):
 		o.progressUpdate(sseq)
 	case bytes.Equal(msg, AckTerm):
@@ -729,8 +1109,20 @@ func (o *Consumer) progressUpdate(seq uint64) {
 	o.mu.Unlock()
 }
 
-// Process a NAK.
-func (o *Consumer) processNak(sseq, dseq uint64) {
+// Process a NAK. The remainder of the NAK payload can hold a delay for the redelivery.
+func (o *Consumer) processNak(sseq, dseq uint64, nak []byte) {
+	var delay time.Duration
+	if nak = bytes.TrimSpace(nak); len(nak) > 0 {
+		var opts ConsumerNakOptions
+		// Ignore a NAK we can not make sense of, it will be redelivered after the ack wait.
+		if err := json.Unmarshal(nak, &opts); err != nil {
+			return
+		}
+		if opts.Delay > 0 {
+			delay = opts.Delay
+		}
+	}
+
 	var mset *Stream
 	o.mu.Lock()
 	// Check for out of range.
@@ -744,6 +1136,19 @@ func (o *Consumer) processNak(sseq, dseq uint64) {
 			o.mu.Unlock()
 			return
 		}
+		o.stats.Naked++
+		// Without an explicit delay we follow our backoff schedule if we have one.
+		if delay == 0 && len(o.config.BackOff) > 0 {
+			delay = o.ackWaitFor(sseq)
+		}
+		// If delayed we let the pending timer redeliver.
+		if delay > 0 {
+			o.pending[sseq] = time.Now().Add(delay).UnixNano() - int64(o.ackWaitFor(sseq))
+			o.removeFromRedeliverQueue(sseq)
+			o.resetPendingTimer()
+			o.mu.Unlock()
+			return
+		}
 	}
 	// If already queued up also ignore.
 	if !o.onRedeliverQueue(sseq) {
@@ -758,12 +1163,20 @@ func (o *Consumer) processNak(sseq, dseq uint64) {
 
 // Process a TERM
 func (o *Consumer) processTerm(sseq, dseq, dcount uint64) {
+	// Copy to the dead letter subject first since the ack can remove
+	// the message from the stream, e.g. with work queue retention.
+	o.mu.Lock()
+	o.sendToDeadLetter(sseq, dcount, JSDeadLetterReasonTerminated)
+	o.mu.Unlock()
+
 	// Treat like an ack to suppress redelivery.
 	o.processAckMsg(sseq, dseq, dcount, false)
 
 	o.mu.Lock()
 	defer o.mu.Unlock()
 
+	o.stats.Terminated++
+
 	// Deliver an advisory
 	e := JSConsumerDeliveryTerminatedAdvisory{
 		TypedEvent: TypedEvent{
@@ -799,6 +1212,44 @@ func (o *Consumer) ackWait(next time.Duration) time.Duration {
 	return o.config.AckWait + ackWaitDelay
 }
 
+// ackWaitFor returns how long to wait for an ack of the message given how many
+// times it has been delivered. Lock should be held.
+func (o *Consumer) ackWaitFor(sseq uint64) time.Duration {
+	if n := len(o.config.BackOff); n > 0 {
+		// The redelivery count is the number of deliveries - 1.
+		i := int(o.rdc[sseq])
+		if i >= n {
+			i = n - 1
+		}
+		return o.config.BackOff[i]
+	}
+	return o.config.AckWait
+}
+
+// Reset the pending timer to fire at the earliest ack deadline.
+// Lock should be held.
+func (o *Consumer) resetPendingTimer() {
+	if len(o.pending) == 0 || o.paused {
+		return
+	}
+	now := time.Now().UnixNano()
+	next := int64(math.MaxInt64)
+	for seq, ts := range o.pending {
+		if rem := ts + int64(o.ackWaitFor(seq)) - now; rem < next {
+			next = rem
+		}
+	}
+	if next < 0 {
+		next = 0
+	}
+	fire := time.Duration(next) + ackWaitDelay
+	if o.ptmr == nil {
+		o.ptmr = time.AfterFunc(fire, o.checkPending)
+	} else {
+		o.ptmr.Reset(fire)
+	}
+}
+
 // This will restore the state from disk.
 func (o *Consumer) readStoredState() error {
 	if o.store == nil {
@@ -887,11 +1338,142 @@ func (o *Consumer) Info() *ConsumerInfo {
 		},
 		NumPending:     len(o.pending),
 		NumRedelivered: len(o.rdc),
+		Paused:         o.paused,
+	}
+	stats := o.stats
+	stats.AckLatency = o.alat.histogram()
+	info.Stats = &stats
+	if o.paused && !o.pauseUntil.IsZero() {
+		until := o.pauseUntil
+		info.PauseUntil = &until
 	}
 	o.mu.Unlock()
 	return info
 }
 
+// Pause will stop delivery of messages, including redeliveries, until the given
+// time or until Resume is called. A zero time pauses until resumed.
+func (o *Consumer) Pause(until time.Time) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.mset == nil {
+		return fmt.Errorf("consumer not valid")
+	}
+	now := time.Now()
+	if !until.IsZero() && !until.After(now) {
+		return fmt.Errorf("consumer pause time is in the past")
+	}
+	if !o.paused {
+		o.paused = true
+		o.pausedAt = now
+		// Stop the redelivery timer, it will be restarted on resume.
+		if o.ptmr != nil {
+			o.ptmr.Stop()
+		}
+	}
+	o.pauseUntil = until.UTC()
+	stopAndClearTimer(&o.pauseTmr)
+	if !until.IsZero() {
+		o.pauseTmr = time.AfterFunc(until.Sub(now), func() { o.Resume() })
+	}
+	o.storePauseState()
+	return nil
+}
+
+// Resume will resume delivery of messages for a paused consumer.
+func (o *Consumer) Resume() error {
+	o.mu.Lock()
+	mset := o.mset
+	if mset == nil {
+		o.mu.Unlock()
+		return fmt.Errorf("consumer not valid")
+	}
+	if !o.paused {
+		o.mu.Unlock()
+		return nil
+	}
+	o.paused = false
+	o.pauseUntil = time.Time{}
+	stopAndClearTimer(&o.pauseTmr)
+	o.storePauseState()
+
+	// Time spent paused does not count towards the ack wait of pending messages.
+	if len(o.pending) > 0 {
+		off := time.Since(o.pausedAt).Nanoseconds()
+		for seq := range o.pending {
+			o.pending[seq] += off
+		}
+		if o.ptmr != nil {
+			o.ptmr.Reset(o.ackWait(0))
+		} else {
+			o.ptmr = time.AfterFunc(o.ackWait(0), o.checkPending)
+		}
+	}
+	o.mu.Unlock()
+
+	mset.signalConsumers()
+	return nil
+}
+
+// Reset restarts delivery for an ordered consumer after the stream sequence
+// last processed by the subscriber. A sequence of 0 restarts after the last
+// message delivered. Delivery sequences start over at 1 so the subscriber
+// can continue its gap detection.
+func (o *Consumer) Reset(sseq uint64) error {
+	o.mu.Lock()
+	mset := o.mset
+	if mset == nil {
+		o.mu.Unlock()
+		return fmt.Errorf("consumer not valid")
+	}
+	if !o.config.Ordered {
+		o.mu.Unlock()
+		return fmt.Errorf("consumer is not ordered")
+	}
+	if sseq == 0 {
+		sseq = o.sseq - 1
+	}
+	o.sseq = sseq + 1
+	o.setStartingSeqNo(mset.store.State())
+	o.pending, o.rdq, o.rdc = nil, nil, nil
+	stopAndClearTimer(&o.ptmr)
+	o.updateStore()
+	o.mu.Unlock()
+
+	mset.signalConsumers()
+	return nil
+}
+
+// Persist the pause state with the consumer meta data.
+// Lock should be held.
+func (o *Consumer) storePauseState() {
+	if store, ok := o.store.(*consumerFileStore); ok {
+		store.updatePause(o.paused, o.pauseUntil)
+	}
+}
+
+// restorePause is called on startup to restore a persisted pause state.
+func (o *Consumer) restorePause(until *time.Time) {
+	var t time.Time
+	if until != nil {
+		t = *until
+	}
+	// If the deadline passed while we were down clear the stored state.
+	if err := o.Pause(t); err != nil {
+		o.mu.Lock()
+		o.storePauseState()
+		o.mu.Unlock()
+	}
+}
+
+// IsPaused returns if the consumer is currently paused.
+func (o *Consumer) IsPaused() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.paused
+}
+
 // Will update the underlying store.
 // Lock should be held.
 func (o *Consumer) updateStore() {
@@ -959,8 +1541,9 @@ func (o *Consumer) processAckMsg(sseq, dseq, dcount uint64, doSample bool) {
 	o.mu.Lock()
 	switch o.config.AckPolicy {
 	case AckExplicit:
-		if _, ok := o.pending[sseq]; ok {
+		if ts, ok := o.pending[sseq]; ok {
 			if doSample {
+				o.recordAck(ts)
 				o.sampleAck(sseq, dseq, dcount)
 			}
 			delete(o.pending, sseq)
@@ -983,6 +1566,9 @@ func (o *Consumer) processAckMsg(sseq, dseq, dcount uint64, doSample bool) {
 		sagap = sseq - o.asflr
 		o.adflr, o.asflr = dseq, sseq
 		for seq := sseq; seq > sseq-sagap; seq-- {
+			if ts, ok := o.pending[seq]; ok && doSample {
+				o.recordAck(ts)
+			}
 			delete(o.pending, seq)
 			delete(o.rdc, seq)
 			o.removeFromRedeliverQueue(seq)
@@ -1010,6 +1596,14 @@ func (o *Consumer) processAckMsg(sseq, dseq, dcount uint64, doSample bool) {
 	}
 }
 
+// Count an ack and record its latency from when the message was delivered,
+// or last updated with a progress ack.
+// Lock should be held.
+func (o *Consumer) recordAck(ts int64) {
+	o.stats.Acked++
+	o.alat.record(time.Duration(time.Now().UnixNano() - ts))
+}
+
 // Check if we need an ack for this store seq.
 func (o *Consumer) needAck(sseq uint64) bool {
 	var na bool
@@ -1051,12 +1645,15 @@ func (o *Consumer) processNextMsgReq(_ *subscription, _ *client, _, reply string
 		return
 	}
 	shouldSignal := false
+	o.resetPullInactiveTimer()
 
 	for i := 0; i < batchSize; i++ {
 		// If we are in replay mode, defer to processReplay for delivery.
 		if o.replay {
 			o.waiting = append(o.waiting, reply)
 			shouldSignal = true
+		} else if o.paused {
+			o.waiting = append(o.waiting, reply)
 		} else if subj, hdr, msg, seq, dc, ts, err := o.getNextMsg(); err == nil {
 			o.deliverMsg(reply, subj, hdr, msg, seq, dc, ts)
 		} else {
@@ -1102,6 +1699,34 @@ func (o *Consumer) notifyDeliveryExceeded(sseq, dcount uint64) {
 	o.sendAdvisory(o.deliveryExcEventT, j)
 }
 
+// Copy the original message to the dead letter subject if one is configured,
+// adding headers that describe where it came from and why.
+// Lock should be held on entry but will be released.
+func (o *Consumer) sendToDeadLetter(sseq, dcount uint64, reason string) {
+	if o.config.DeadLetterSubject == _EMPTY_ || o.mset == nil || o.mset.sendq == nil {
+		return
+	}
+	subj, hdr, msg, _, err := o.mset.store.LoadMsg(sseq)
+	if err != nil {
+		return
+	}
+	hdr = genHeader(hdr, JSDeadLetterStream, o.stream)
+	hdr = genHeader(hdr, JSDeadLetterConsumer, o.name)
+	hdr = genHeader(hdr, JSDeadLetterSubject, subj)
+	hdr = genHeader(hdr, JSDeadLetterSequence, strconv.FormatUint(sseq, 10))
+	hdr = genHeader(hdr, JSDeadLetterDeliveries, strconv.FormatUint(dcount, 10))
+	hdr = genHeader(hdr, JSDeadLetterReason, reason)
+	if len(msg) > 0 {
+		msg = append(msg[:0:0], msg...)
+	}
+
+	dsubj := o.config.DeadLetterSubject
+	sendq := o.mset.sendq
+	o.mu.Unlock()
+	sendq <- &jsPubMsg{dsubj, dsubj, _EMPTY_, hdr, msg, nil, 0}
+	o.mu.Lock()
+}
+
 // Check to see if the candidate subject matches a filter if its present.
 func (o *Consumer) isFilteredMatch(subj string) bool {
 	if !o.filterWC {
@@ -1128,7 +1753,9 @@ func (o *Consumer) getNextMsg() (subj string, hdr, msg []byte, seq uint64, dcoun
 			if o.maxdc > 0 && dcount > o.maxdc {
 				// Only send once
 				if dcount == o.maxdc+1 {
+					o.stats.Exceeded++
 					o.notifyDeliveryExceeded(seq, dcount-1)
+					o.sendToDeadLetter(seq, dcount-1, JSDeadLetterReasonMaxDeliveries)
 				}
 				// Make sure to remove from pending.
 				delete(o.pending, seq)
@@ -1208,6 +1835,11 @@ func (o *Consumer) loopAndDeliverMsgs(s *Server, a *Account) {
 		}
 		mset = o.mset
 
+		// If we are paused wait until we are resumed.
+		if o.paused {
+			goto waitForMsgs
+		}
+
 		// If we are in push mode and not active let's stop sending.
 		if o.isPushMode() && !o.active {
 			goto waitForMsgs
@@ -1295,7 +1927,7 @@ func (o *Consumer) ackReply(sseq, dseq, dcount uint64, ts int64) string {
 // Will return if the message was delivered or not.
 func (o *Consumer) deliverCurrentMsg(subj string, hdr, msg []byte, seq uint64, ts int64) bool {
 	o.mu.Lock()
-	if seq != o.sseq {
+	if seq != o.sseq || o.paused {
 		o.mu.Unlock()
 		return false
 	}
@@ -1347,6 +1979,11 @@ func (o *Consumer) deliverMsg(dsubj, subj string, hdr, msg []byte, seq, dcount u
 	}
 
 	pmsg := &jsPubMsg{dsubj, subj, o.ackReply(seq, o.dseq, dcount, ts), hdr, msg, o, seq}
+	if dcount == 1 {
+		o.stats.Delivered++
+	} else {
+		o.stats.Redelivered++
+	}
 	sendq := o.mset.sendq
 
 	// This needs to be unlocked since the other side may need this lock on failed delivery.
@@ -1435,11 +2072,10 @@ func (o *Consumer) removeFromRedeliverQueue(seq uint64) bool {
 func (o *Consumer) checkPending() {
 	o.mu.Lock()
 	mset := o.mset
-	if mset == nil {
+	if mset == nil || o.paused {
 		o.mu.Unlock()
 		return
 	}
-	ttl := int64(o.config.AckWait)
 	next := int64(o.ackWait(0))
 	now := time.Now().UnixNano()
 	shouldSignal := false
@@ -1449,6 +2085,8 @@ func (o *Consumer) checkPending() {
 	// We also need to sort after.
 	var expired []uint64
 	for seq, ts := range o.pending {
+		// This can differ per message with a backoff schedule.
+		ttl := int64(o.ackWaitFor(seq))
 		elapsed := now - ts
 		if elapsed >= ttl {
 			if !o.onRedeliverQueue(seq) {
@@ -1464,6 +2102,7 @@ func (o *Consumer) checkPending() {
 	if len(expired) > 0 {
 		sort.Slice(expired, func(i, j int) bool { return expired[i] < expired[j] })
 		o.rdq = append(o.rdq, expired...)
+		o.stats.Expired += uint64(len(expired))
 		// Now we should update the timestamp here since we are redelivering.
 		// We will use an incrementing time to preserve order for any other redelivery.
 		off := now - o.pending[expired[0]]
@@ -1558,7 +2197,13 @@ func (o *Consumer) selectStartingSeqNo() {
 	} else {
 		o.sseq = o.config.OptStartSeq
 	}
+	o.setStartingSeqNo(stats)
+}
 
+// Clamp the starting sequence to the stream and reset our delivery
+// sequence and ack floors.
+// Lock should be held.
+func (o *Consumer) setStartingSeqNo(stats StreamState) {
 	if stats.FirstSeq == 0 {
 		o.sseq = 1
 	} else if o.sseq < stats.FirstSeq {
@@ -1708,6 +2353,7 @@ func (o *Consumer) stop(dflag, doSignal, advisory bool) error {
 	o.reqSub = nil
 	stopAndClearTimer(&o.ptmr)
 	stopAndClearTimer(&o.dtmr)
+	stopAndClearTimer(&o.pauseTmr)
 	delivery := o.config.DeliverSubject
 	o.mu.Unlock()
 
@@ -1763,6 +2409,40 @@ func (mset *Stream) validSubject(partitionSubject string) bool {
 	return mset.deliveryFormsCycle(partitionSubject)
 }
 
+// Returns how long to wait before deleting an inactive consumer.
+func inactiveThreshold(config *ConsumerConfig) time.Duration {
+	if config.InactiveThreshold > 0 {
+		return config.InactiveThreshold
+	}
+	return JsDeleteWaitTimeDefault
+}
+
+// Ephemeral consumers are always deleted when inactive, durables only when
+// they have an inactive threshold set.
+// Lock should be held.
+func (o *Consumer) deleteWhenInactive() bool {
+	return !o.isDurable() || o.config.InactiveThreshold > 0
+}
+
+// Restart the timer that deletes a pull consumer that has not seen a request
+// for messages within its inactive threshold. Pull consumers without an
+// inactive threshold are never deleted.
+// Lock should be held.
+func (o *Consumer) resetPullInactiveTimer() {
+	if !o.isPullMode() {
+		return
+	}
+	if o.config.InactiveThreshold <= 0 {
+		stopAndClearTimer(&o.dtmr)
+		return
+	}
+	if o.dtmr != nil {
+		o.dtmr.Reset(o.dthresh)
+	} else {
+		o.dtmr = time.AfterFunc(o.dthresh, func() { o.Delete() })
+	}
+}
+
 // SetInActiveDeleteThreshold sets the delete threshold for how long to wait
 // before deleting an inactive ephemeral observable.
 func (o *Consumer) SetInActiveDeleteThreshold(dthresh time.Duration) error {
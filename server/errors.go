@@ -160,6 +160,12 @@ var (
 
 	// ErrSubscribePermissionViolation is returned when processing of a subscription fails due to permissions.
 	ErrSubscribePermissionViolation = errors.New("subscribe permission viloation")
+
+	// ErrBadSubjectMappingDestination is returned when a subject mapping destination is not valid for its source.
+	ErrBadSubjectMappingDestination = errors.New("invalid subject mapping destination")
+
+	// ErrMappingWeightsExceeded is returned when the weights of a subject mapping add up to more than 100 percent.
+	ErrMappingWeightsExceeded = errors.New("subject mapping weights exceed 100 percent")
 )
 
 // configErr is a configuration error.
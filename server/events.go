@@ -39,6 +39,7 @@ const (
 	accConnsEventSubj        = "$SYS.SERVER.ACCOUNT.%s.CONNS"
 	shutdownEventSubj        = "$SYS.SERVER.%s.SHUTDOWN"
 	authErrorEventSubj       = "$SYS.SERVER.%s.CLIENT.AUTH.ERR"
+	tlsCertRotationEventSubj = "$SYS.SERVER.%s.TLS.CERT.ROTATION"
 	serverStatsSubj          = "$SYS.SERVER.%s.STATSZ"
 	serverStatsReqSubj       = "$SYS.REQ.SERVER.%s.STATSZ"
 	serverStatsPingReqSubj   = "$SYS.REQ.SERVER.PING"
@@ -112,6 +113,23 @@ type DisconnectEventMsg struct {
 // DisconnectEventMsgType is the schema type for DisconnectEventMsg
 const DisconnectEventMsgType = "io.nats.server.advisory.v1.client_disconnect"
 
+// TLSCertRotationEventMsg is sent when the certificate of a TLS listener
+// was swapped after its files changed, or failed to be.
+type TLSCertRotationEventMsg struct {
+	TypedEvent
+	Server   ServerInfo `json:"server"`
+	Kind     string     `json:"kind"`
+	CertFile string     `json:"cert_file"`
+	KeyFile  string     `json:"key_file"`
+	Subject  string     `json:"subject,omitempty"`
+	Serial   string     `json:"serial,omitempty"`
+	Expires  time.Time  `json:"expires,omitempty"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// TLSCertRotationEventMsgType is the schema type for TLSCertRotationEventMsg
+const TLSCertRotationEventMsgType = "io.nats.server.advisory.v1.tls_cert_rotation"
+
 // AccountNumConns is an event that will be sent from a server that is tracking
 // a given account when the number of connections changes. It will also HB
 // updates in the absence of any changes.
@@ -346,6 +364,11 @@ func (s *Server) sendShutdownEvent() {
 
 // Used to send an internal message to an arbitrary account.
 func (s *Server) sendInternalAccountMsg(a *Account, subject string, msg interface{}) error {
+	return s.sendInternalAccountMsgWithReply(a, subject, _EMPTY_, msg)
+}
+
+// Used to send an internal message with a reply to an arbitrary account.
+func (s *Server) sendInternalAccountMsgWithReply(a *Account, subject, reply string, msg interface{}) error {
 	s.mu.Lock()
 	if s.sys == nil || s.sys.sendq == nil {
 		s.mu.Unlock()
@@ -354,7 +377,7 @@ func (s *Server) sendInternalAccountMsg(a *Account, subject string, msg interfac
 	sendq := s.sys.sendq
 	// Don't hold lock while placing on the channel.
 	s.mu.Unlock()
-	sendq <- &pubMsg{a, subject, "", nil, msg, false}
+	sendq <- &pubMsg{a, subject, reply, nil, msg, false}
 	return nil
 }
 
@@ -629,6 +652,22 @@ func (s *Server) initEventTracking() {
 			optz := &LeafzEventOptions{}
 			s.zReq(reply, msg, &optz.EventFilterOptions, optz, func() (interface{}, error) { return s.Leafz(&optz.LeafzOptions) })
 		},
+		"CONSUMERZ": func(sub *subscription, _ *client, subject, reply string, msg []byte) {
+			optz := &ConsumerzEventOptions{}
+			s.zReq(reply, msg, &optz.EventFilterOptions, optz, func() (interface{}, error) { return s.Consumerz(&optz.ConsumerzOptions) })
+		},
+		"JSZ": func(sub *subscription, _ *client, subject, reply string, msg []byte) {
+			optz := &JszEventOptions{}
+			s.zReq(reply, msg, &optz.EventFilterOptions, optz, func() (interface{}, error) { return s.Jsz(&optz.JSzOptions) })
+		},
+		"ACCOUNTZ": func(sub *subscription, _ *client, subject, reply string, msg []byte) {
+			optz := &AccountzEventOptions{}
+			s.zReq(reply, msg, &optz.EventFilterOptions, optz, func() (interface{}, error) { return s.Accountz(&optz.AccountzOptions) })
+		},
+		"HEALTHZ": func(sub *subscription, _ *client, subject, reply string, msg []byte) {
+			optz := &HealthzEventOptions{}
+			s.zReq(reply, msg, &optz.EventFilterOptions, optz, func() (interface{}, error) { return s.Healthz(), nil })
+		},
 	}
 
 	for name, req := range monSrvc {
@@ -887,6 +926,31 @@ type LeafzEventOptions struct {
 	EventFilterOptions
 }
 
+// In the context of system events, ConsumerzEventOptions are options passed to Consumerz
+type ConsumerzEventOptions struct {
+	ConsumerzOptions
+	EventFilterOptions
+}
+
+// In the context of system events, JszEventOptions are options passed to Jsz
+type JszEventOptions struct {
+	JSzOptions
+	EventFilterOptions
+}
+
+// In the context of system events, AccountzEventOptions are options passed to Accountz
+type AccountzEventOptions struct {
+	AccountzOptions
+	EventFilterOptions
+}
+
+// HealthzEventOptions are options passed to Healthz
+type HealthzEventOptions struct {
+	// No actual options yet
+
+	EventFilterOptions
+}
+
 // returns true if the request does NOT apply to this server and can be ignored.
 // DO NOT hold the server lock when
 func (s *Server) filterRequest(fOpts *EventFilterOptions) bool {
@@ -1607,3 +1671,38 @@ func (s *Server) wrapChk(f func()) func() {
 		s.mu.Unlock()
 	}
 }
+
+// sendTLSCertRotationEvent will send an advisory about the rotation
+// of the certificate of a TLS listener.
+func (s *Server) sendTLSCertRotationEvent(c *tlsCert, err error) {
+	s.mu.Lock()
+	if !s.eventsEnabled() {
+		s.mu.Unlock()
+		return
+	}
+	eid := s.nextEventID()
+	s.mu.Unlock()
+
+	m := TLSCertRotationEventMsg{
+		TypedEvent: TypedEvent{
+			Type: TLSCertRotationEventMsgType,
+			ID:   eid,
+			Time: time.Now().UTC(),
+		},
+		Kind:     c.kind,
+		CertFile: c.certFile,
+		KeyFile:  c.keyFile,
+	}
+	if err != nil {
+		m.Error = err.Error()
+	} else if leaf := c.certificate().Leaf; leaf != nil {
+		m.Subject = leaf.Subject.String()
+		m.Serial = leaf.SerialNumber.String()
+		m.Expires = leaf.NotAfter.UTC()
+	}
+
+	s.mu.Lock()
+	subj := fmt.Sprintf(tlsCertRotationEventSubj, s.info.ID)
+	s.sendInternalMsg(subj, _EMPTY_, &m.Server, &m)
+	s.mu.Unlock()
+}
@@ -1354,7 +1354,7 @@ func TestSystemAccountWithGateways(t *testing.T) {
 
 	// If this tests fails with wrong number after 10 seconds we may have
 	// added a new inititial subscription for the eventing system.
-	checkExpectedSubs(t, 25, sa)
+	checkExpectedSubs(t, 33, sa)
 
 	// Create a client on B and see if we receive the event
 	urlb := fmt.Sprintf("nats://%s:%d", ob.Host, ob.Port)
@@ -1689,6 +1689,14 @@ func TestServerEventsPingMonitorz(t *testing.T) {
 			[]string{"now", "outbound_gateways", "inbound_gateways"}},
 		{"LEAFZ", nil, &Leafz{},
 			[]string{"now", "leafs"}},
+		{"CONSUMERZ", nil, &Consumerz{},
+			[]string{"now", "num_consumers"}},
+		{"JSZ", nil, &JSInfo{},
+			[]string{"now", "accounts"}},
+		{"ACCOUNTZ", nil, &Accountz{},
+			[]string{"now", "accounts"}},
+		{"HEALTHZ", nil, &HealthStatus{},
+			[]string{"status"}},
 
 		{"SUBSZ", &SubszOptions{}, &Subsz{},
 			[]string{"num_subscriptions", "num_cache"}},
@@ -1711,6 +1719,10 @@ func TestServerEventsPingMonitorz(t *testing.T) {
 			[]string{"now", "outbound_gateways", "inbound_gateways"}},
 		{"LEAFZ", &LeafzOptions{Subscriptions: true}, &Leafz{},
 			[]string{"now", "leafs"}},
+		{"CONSUMERZ", &ConsumerzOptions{Stream: "foo"}, &Consumerz{},
+			[]string{"now", "num_consumers"}},
+		{"JSZ", &JSzOptions{Accounts: true}, &JSInfo{},
+			[]string{"now", "accounts"}},
 
 		{"ROUTEZ", json.RawMessage(`{"cluster":""}`), &Routez{},
 			[]string{"now", "routes"}},
@@ -62,6 +62,9 @@ type FileConsumerInfo struct {
 	Created time.Time
 	Name    string
 	ConsumerConfig
+	// The pause state is kept with the meta data so it survives restarts.
+	Paused     bool       `json:",omitempty"`
+	PauseUntil *time.Time `json:",omitempty"`
 }
 
 type fileStore struct {
@@ -180,7 +183,7 @@ func newFileStoreWithCreated(fcfg FileStoreConfig, cfg StreamConfig, created tim
 	if cfg.Name == "" {
 		return nil, fmt.Errorf("name required")
 	}
-	if cfg.Storage != FileStorage {
+	if cfg.Storage != FileStorage && cfg.Storage != TieredStorage {
 		return nil, fmt.Errorf("fileStore requires file storage type in config")
 	}
 	// Default values.
@@ -264,7 +267,7 @@ func (fs *fileStore) UpdateConfig(cfg *StreamConfig) error {
 	if cfg.Name == "" {
 		return fmt.Errorf("name required")
 	}
-	if cfg.Storage != FileStorage {
+	if cfg.Storage != FileStorage && cfg.Storage != TieredStorage {
 		return fmt.Errorf("fileStore requires file storage type in config")
 	}
 
@@ -309,21 +312,26 @@ func dynBlkSize(retention RetentionPolicy, maxBytes int64) uint64 {
 // Write out meta and the checksum.
 // Lock should be held.
 func (fs *fileStore) writeStreamMeta() error {
-	meta := path.Join(fs.fcfg.StoreDir, JetStreamMetaFile)
+	return writeStreamMetaFile(fs.fcfg.StoreDir, &fs.cfg, fs.hh)
+}
+
+// Write out stream meta and the checksum to the given directory.
+func writeStreamMetaFile(dir string, fsi *FileStreamInfo, hh hash.Hash64) error {
+	meta := path.Join(dir, JetStreamMetaFile)
 	if _, err := os.Stat(meta); err != nil && !os.IsNotExist(err) {
 		return err
 	}
-	b, err := json.MarshalIndent(fs.cfg, _EMPTY_, "  ")
+	b, err := json.MarshalIndent(fsi, _EMPTY_, "  ")
 	if err != nil {
 		return err
 	}
 	if err := ioutil.WriteFile(meta, b, 0644); err != nil {
 		return err
 	}
-	fs.hh.Reset()
-	fs.hh.Write(b)
-	checksum := hex.EncodeToString(fs.hh.Sum(nil))
-	sum := path.Join(fs.fcfg.StoreDir, JetStreamMetaFileSum)
+	hh.Reset()
+	hh.Write(b)
+	checksum := hex.EncodeToString(hh.Sum(nil))
+	sum := path.Join(dir, JetStreamMetaFileSum)
 	if err := ioutil.WriteFile(sum, []byte(checksum), 0644); err != nil {
 		return err
 	}
@@ -1620,6 +1628,13 @@ func (fs *fileStore) State() StreamState {
 	return state
 }
 
+// Returns the first sequence held in the store.
+func (fs *fileStore) firstSeq() uint64 {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return fs.state.FirstSeq
+}
+
 func fileStoreMsgSize(subj string, hdr, msg []byte) uint64 {
 	if len(hdr) == 0 {
 		// length of the message record (4bytes) + seq(8) + ts(8) + subj_len(2) + subj + msg + hash(8)
@@ -1757,6 +1772,11 @@ func (mb *msgBlock) readIndexInfo() error {
 	mb.last.ts = readTimeStamp()
 	dmapLen := readCount()
 
+	// Make sure we have a complete header.
+	if bi < 0 || bi+checksumSize > len(buf) {
+		return fmt.Errorf("short index file")
+	}
+
 	// Checksum
 	copy(mb.lchk[0:], buf[bi:bi+checksumSize])
 	bi += checksumSize
@@ -2293,13 +2313,21 @@ func (fs *fileStore) ConsumerStore(name string, cfg *ConsumerConfig) (ConsumerSt
 	}
 	o.hh = hh
 
-	// Write our meta data iff does not exist.
+	// Write our meta data iff does not exist. Otherwise keep the creation
+	// time and pause state that were persisted, since the meta data is
+	// rewritten when the pause state changes or the config is updated.
 	meta := path.Join(odir, JetStreamMetaFile)
 	if _, err := os.Stat(meta); err != nil && os.IsNotExist(err) {
 		csi.Created = time.Now().UTC()
 		if err := o.writeConsumerMeta(); err != nil {
 			return nil, err
 		}
+	} else if buf, err := ioutil.ReadFile(meta); err == nil {
+		var ocfg FileConsumerInfo
+		if err := json.Unmarshal(buf, &ocfg); err == nil {
+			csi.Created = ocfg.Created
+			csi.Paused, csi.PauseUntil = ocfg.Paused, ocfg.PauseUntil
+		}
 	}
 
 	fs.mu.Lock()
@@ -2401,11 +2429,22 @@ func (o *consumerFileStore) Update(state *ConsumerState) error {
 	return err
 }
 
-// Will upodate the config. Only used when recovering ephemerals.
+// Will update the config, when recovering ephemerals or updating a consumer.
 func (o *consumerFileStore) updateConfig(cfg ConsumerConfig) error {
 	o.mu.Lock()
 	defer o.mu.Unlock()
-	o.cfg = &FileConsumerInfo{ConsumerConfig: cfg}
+	o.cfg.ConsumerConfig = cfg
+	return o.writeConsumerMeta()
+}
+
+// Will update the pause state. A zero time means paused until resumed.
+func (o *consumerFileStore) updatePause(paused bool, until time.Time) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.cfg.Paused, o.cfg.PauseUntil = paused, nil
+	if paused && !until.IsZero() {
+		o.cfg.PauseUntil = &until
+	}
 	return o.writeConsumerMeta()
 }
 
@@ -2413,9 +2452,6 @@ func (o *consumerFileStore) updateConfig(cfg ConsumerConfig) error {
 // Lock should be held.
 func (cfs *consumerFileStore) writeConsumerMeta() error {
 	meta := path.Join(cfs.odir, JetStreamMetaFile)
-	if _, err := os.Stat(meta); (err != nil && !os.IsNotExist(err)) || err == nil {
-		return err
-	}
 	b, err := json.MarshalIndent(cfs.cfg, _EMPTY_, "  ")
 	if err != nil {
 		return err
@@ -0,0 +1,497 @@
+// Copyright 2020 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/minio/highwayhash"
+)
+
+// StoreCheckReport is the result of an offline check of a JetStream storage directory.
+type StoreCheckReport struct {
+	StoreDir string
+	Streams  []*StreamCheckReport
+}
+
+// StreamCheckReport holds the results for a single stream and its consumers.
+type StreamCheckReport struct {
+	Account   string
+	Name      string
+	Blocks    int
+	Msgs      uint64
+	Consumers int
+	Problems  []*StoreProblem
+}
+
+// StoreProblem describes a single inconsistency found in the store.
+type StoreProblem struct {
+	Description string
+	Repaired    bool
+}
+
+// Problems returns the number of problems found and how many of those were repaired.
+func (r *StoreCheckReport) Problems() (found, repaired int) {
+	for _, sr := range r.Streams {
+		for _, p := range sr.Problems {
+			found++
+			if p.Repaired {
+				repaired++
+			}
+		}
+	}
+	return found, repaired
+}
+
+// Print writes a human readable form of the report.
+func (r *StoreCheckReport) Print(w io.Writer) {
+	fmt.Fprintf(w, "Checked JetStream storage directory %q\n", r.StoreDir)
+	for _, sr := range r.Streams {
+		fmt.Fprintf(w, "  Stream %q in account %q: %d blocks, %s messages, %d consumers\n",
+			sr.Name, sr.Account, sr.Blocks, comma(int64(sr.Msgs)), sr.Consumers)
+		for _, p := range sr.Problems {
+			status := "not repaired"
+			if p.Repaired {
+				status = "repaired"
+			}
+			fmt.Fprintf(w, "    %s (%s)\n", p.Description, status)
+		}
+	}
+	found, repaired := r.Problems()
+	fmt.Fprintf(w, "Found %d problems, repaired %d\n", found, repaired)
+}
+
+// CheckJetStreamStore will check, and optionally repair, the JetStream storage
+// directory referenced by the options. The server must not be running.
+// It returns an error if problems remain after the check.
+func CheckJetStreamStore(opts *Options, w io.Writer) error {
+	if opts.StoreDir == _EMPTY_ {
+		return fmt.Errorf("a JetStream storage directory is required, set one with [-sd, --store_dir]")
+	}
+	// Mirror how EnableJetStream selects the directory.
+	storeDir := opts.StoreDir
+	if opts.JetStreamMaxMemory <= 0 || opts.JetStreamMaxStore <= 0 {
+		storeDir = filepath.Join(storeDir, JetStreamStoreDir)
+	}
+	report, err := CheckJetStreamStoreDir(storeDir, opts.JetStreamRepair)
+	if err != nil {
+		return err
+	}
+	report.Print(w)
+	if found, repaired := report.Problems(); found > repaired {
+		return fmt.Errorf("%d problems remain in %q", found-repaired, storeDir)
+	}
+	return nil
+}
+
+// CheckJetStreamStoreDir will verify all streams and consumers in the given
+// JetStream storage directory. This includes the checksum of every message,
+// the message block index files, and the meta and state files for consumers.
+// If repair is true damaged blocks are truncated at the first unreadable
+// record, messages with bad checksums are marked as deleted and indexes and
+// consumer state are rebuilt. The server must not be running.
+func CheckJetStreamStoreDir(storeDir string, repair bool) (*StoreCheckReport, error) {
+	if stat, err := os.Stat(storeDir); err != nil {
+		return nil, fmt.Errorf("could not access storage directory - %v", err)
+	} else if !stat.IsDir() {
+		return nil, fmt.Errorf("storage directory is not a directory")
+	}
+	afis, err := ioutil.ReadDir(storeDir)
+	if err != nil {
+		return nil, fmt.Errorf("storage directory not readable")
+	}
+	report := &StoreCheckReport{StoreDir: storeDir}
+	for _, afi := range afis {
+		if !afi.IsDir() {
+			continue
+		}
+		sdir := path.Join(storeDir, afi.Name(), streamsDir)
+		sfis, err := ioutil.ReadDir(sdir)
+		if err != nil {
+			continue
+		}
+		for _, sfi := range sfis {
+			if !sfi.IsDir() {
+				continue
+			}
+			sr := &StreamCheckReport{Account: afi.Name(), Name: sfi.Name()}
+			checkStreamDir(sr, path.Join(sdir, sfi.Name()), repair)
+			report.Streams = append(report.Streams, sr)
+		}
+	}
+	return report, nil
+}
+
+// Helper to record a problem.
+func (sr *StreamCheckReport) addProblem(repaired bool, format string, args ...interface{}) *StoreProblem {
+	p := &StoreProblem{fmt.Sprintf(format, args...), repaired}
+	sr.Problems = append(sr.Problems, p)
+	return p
+}
+
+// Checks a meta file against its checksum and rewrites the checksum if
+// the meta file itself is intact. Returns the contents of the meta file.
+func checkMetaFile(sr *StreamCheckReport, dir, what string, key []byte, v interface{}, repair bool) []byte {
+	metafile := path.Join(dir, JetStreamMetaFile)
+	metasum := path.Join(dir, JetStreamMetaFileSum)
+	buf, err := ioutil.ReadFile(metafile)
+	if err != nil {
+		sr.addProblem(false, "%s metafile could not be read: %v", what, err)
+		return nil
+	}
+	if err := json.Unmarshal(buf, v); err != nil {
+		sr.addProblem(false, "%s metafile is corrupt: %v", what, err)
+		return nil
+	}
+	hkey := sha256.Sum256(key)
+	hh, err := highwayhash.New64(hkey[:])
+	if err != nil {
+		return buf
+	}
+	hh.Write(buf)
+	checksum := hex.EncodeToString(hh.Sum(nil))
+	if sum, err := ioutil.ReadFile(metasum); err != nil || string(sum) != checksum {
+		var repaired bool
+		if repair {
+			repaired = ioutil.WriteFile(metasum, []byte(checksum), 0644) == nil
+		}
+		sr.addProblem(repaired, "%s metafile checksum is missing or does not match", what)
+	}
+	return buf
+}
+
+// Checks a single stream directory, its message blocks and its consumers.
+func checkStreamDir(sr *StreamCheckReport, sdir string, repair bool) {
+	var cfg FileStreamInfo
+	checkMetaFile(sr, sdir, "Stream", []byte(sr.Name), &cfg, repair)
+
+	// We use a fileStore shell to reuse the block helpers. It is never started.
+	fs := &fileStore{fcfg: FileStoreConfig{StoreDir: sdir}}
+	fs.cfg.Name = sr.Name
+
+	mdir := path.Join(sdir, msgDir)
+	fis, err := ioutil.ReadDir(mdir)
+	if err != nil {
+		sr.addProblem(false, "Message directory not readable: %v", err)
+		return
+	}
+	var indexes []uint64
+	for _, fi := range fis {
+		var index uint64
+		if n, err := fmt.Sscanf(fi.Name(), blkScan, &index); err == nil && n == 1 {
+			indexes = append(indexes, index)
+		}
+	}
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+
+	var lseq uint64
+	for i, index := range indexes {
+		mb := fs.checkMsgBlock(sr, index, i == len(indexes)-1, repair)
+		if mb == nil {
+			continue
+		}
+		sr.Blocks++
+		sr.Msgs += mb.msgs
+		if mb.last.seq > lseq {
+			lseq = mb.last.seq
+		}
+	}
+
+	// Now check our consumers.
+	odir := path.Join(sdir, consumerDir)
+	ofis, _ := ioutil.ReadDir(odir)
+	for _, ofi := range ofis {
+		if !ofi.IsDir() {
+			continue
+		}
+		sr.Consumers++
+		checkConsumerDir(sr, path.Join(odir, ofi.Name()), ofi.Name(), lseq, repair)
+	}
+}
+
+// Result of scanning the raw records of a message block file.
+type blkScanResult struct {
+	recs  []blkRecord
+	trunc int64 // Offset of the first unreadable record, -1 if none.
+	size  int64
+}
+
+type blkRecord struct {
+	seq uint64
+	ts  int64
+	rl  uint64
+	bad bool
+	end int64
+}
+
+// Scan all records in a message block checking framing and checksums.
+func scanMsgBlockFile(buf []byte, hh hash.Hash64) *blkScanResult {
+	var le = binary.LittleEndian
+	res := &blkScanResult{trunc: -1, size: int64(len(buf))}
+
+	for index := 0; index < len(buf); {
+		if len(buf)-index < msgHdrSize {
+			res.trunc = int64(index)
+			break
+		}
+		hdr := buf[index : index+msgHdrSize]
+		rl := int(le.Uint32(hdr[0:]) &^ hbit)
+		hasHeaders := le.Uint32(hdr[0:])&hbit != 0
+		slen := int(le.Uint16(hdr[20:]))
+		dlen := rl - msgHdrSize
+		if dlen < checksumSize || slen > dlen-checksumSize || index+rl > len(buf) {
+			res.trunc = int64(index)
+			break
+		}
+		seq := le.Uint64(hdr[4:])
+		ts := int64(le.Uint64(hdr[12:]))
+		rec := blkRecord{seq: seq, ts: ts, rl: uint64(rl), end: int64(index + rl)}
+		// Erased messages carry no data so we do not check those.
+		if seq != 0 {
+			data := buf[index+msgHdrSize : index+rl]
+			if hasHeaders {
+				if slen+4 > dlen-checksumSize {
+					rec.bad = true
+				} else if hl := int(le.Uint32(data[slen:])); slen+4+hl > dlen-checksumSize {
+					rec.bad = true
+				}
+			}
+			if !rec.bad {
+				if _, _, _, _, _, err := msgFromBuf(buf[index:index+rl], hh); err != nil {
+					rec.bad = true
+				}
+			}
+		}
+		res.recs = append(res.recs, rec)
+		index += rl
+	}
+	return res
+}
+
+// Checks a single message block and its index. Returns a msgBlock
+// representing the block state after any repairs, or nil if the
+// block was unusable or removed.
+func (fs *fileStore) checkMsgBlock(sr *StreamCheckReport, index uint64, isLast, repair bool) *msgBlock {
+	mdir := path.Join(fs.fcfg.StoreDir, msgDir)
+	mb := &msgBlock{index: index}
+	mb.mfn = path.Join(mdir, fmt.Sprintf(blkScan, index))
+	mb.ifn = path.Join(mdir, fmt.Sprintf(indexScan, index))
+	key := sha256.Sum256(fs.hashKeyForBlock(index))
+	mb.hh, _ = highwayhash.New64(key[:])
+
+	buf, err := ioutil.ReadFile(mb.mfn)
+	if err != nil {
+		sr.addProblem(false, "Block %d could not be read: %v", index, err)
+		return nil
+	}
+
+	// Load the index if it is present and sane. We need this for any
+	// deleted messages that are still physically present in the block.
+	var hasIndex bool
+	var idx msgBlock
+	if ibuf, err := ioutil.ReadFile(mb.ifn); err == nil && checkHeader(ibuf) == nil {
+		idx.ifn = mb.ifn
+		hasIndex = idx.readIndexInfo() == nil
+	}
+
+	res := scanMsgBlockFile(buf, mb.hh)
+
+	// Bad messages that are already deleted are not a problem, e.g. after a repair.
+	isDeleted := func(seq uint64) bool {
+		if !hasIndex {
+			return false
+		}
+		_, ok := idx.dmap[seq]
+		return ok || seq < idx.first.seq
+	}
+
+	// Problems whose repair is completed by rewriting the index or
+	// removing the block below.
+	var pending []*StoreProblem
+	unrepaired := func() {
+		for _, p := range pending {
+			p.Repaired = false
+		}
+	}
+
+	var nbad int
+	var badSeqs []uint64
+	for _, rec := range res.recs {
+		if rec.bad && !isDeleted(rec.seq) {
+			nbad++
+			badSeqs = append(badSeqs, rec.seq)
+		}
+	}
+	if nbad > 0 {
+		pending = append(pending, sr.addProblem(repair, "Block %d has %d messages with bad checksums %v", index, nbad, badSeqs))
+	}
+
+	// Truncate any unreadable tail.
+	if res.trunc >= 0 {
+		var repaired bool
+		if repair {
+			repaired = os.Truncate(mb.mfn, res.trunc) == nil
+		}
+		pending = append(pending, sr.addProblem(repaired, "Block %d has %d unreadable bytes at offset %d",
+			index, res.size-res.trunc, res.trunc))
+	}
+
+	// Rebuild block accounting from what we scanned.
+	for _, rec := range res.recs {
+		if rec.seq == 0 {
+			continue
+		}
+		if rec.seq > mb.last.seq {
+			mb.last = msgId{rec.seq, rec.ts}
+		}
+		if rec.bad || isDeleted(rec.seq) {
+			continue
+		}
+		if mb.first.seq == 0 {
+			mb.first = msgId{rec.seq, rec.ts}
+		}
+		mb.msgs++
+		mb.bytes += rec.rl
+	}
+	if n := len(res.recs); n > 0 {
+		end := res.recs[n-1].end
+		copy(mb.lchk[0:], buf[end-checksumSize:end])
+	}
+	// Anything between first and last that is not live is marked as deleted.
+	if mb.first.seq > 0 {
+		live := make(map[uint64]struct{}, mb.msgs)
+		for _, rec := range res.recs {
+			if rec.seq >= mb.first.seq && !rec.bad && !isDeleted(rec.seq) {
+				live[rec.seq] = struct{}{}
+			}
+		}
+		for seq := mb.first.seq + 1; seq <= mb.last.seq; seq++ {
+			if _, ok := live[seq]; !ok {
+				if mb.dmap == nil {
+					mb.dmap = make(map[uint64]struct{})
+				}
+				mb.dmap[seq] = struct{}{}
+			}
+		}
+	} else {
+		// No messages, this represents an empty block.
+		if hasIndex && idx.last.seq > mb.last.seq && res.trunc < 0 && nbad == 0 {
+			mb.last = idx.last
+		}
+		mb.first.seq = mb.last.seq + 1
+	}
+
+	// If there are no messages left and this is not the last block, remove it.
+	if mb.msgs == 0 && !isLast {
+		if nbad > 0 || res.trunc >= 0 {
+			if repair {
+				if err := os.Remove(mb.ifn); err != nil && !os.IsNotExist(err) {
+					unrepaired()
+				}
+				if err := os.Remove(mb.mfn); err != nil {
+					unrepaired()
+				}
+			}
+			return nil
+		}
+	}
+
+	// Check the index against what we found.
+	if !hasIndex || idx.msgs != mb.msgs || idx.bytes != mb.bytes ||
+		idx.first.seq != mb.first.seq || idx.last.seq != mb.last.seq ||
+		idx.lchk != mb.lchk || len(idx.dmap) != len(mb.dmap) {
+		// Only report if not already explained above.
+		if nbad == 0 && res.trunc < 0 {
+			var repaired bool
+			if repair {
+				repaired = mb.writeIndexInfo() == nil
+			}
+			sr.addProblem(repaired, "Index for block %d is missing or does not match block contents", index)
+		} else if repair && mb.writeIndexInfo() != nil {
+			unrepaired()
+		}
+	}
+	if mb.ifd != nil {
+		mb.ifd.Sync()
+		mb.ifd.Close()
+		mb.ifd = nil
+	}
+	return mb
+}
+
+// Checks the meta and state files for a consumer. The lseq is
+// the last sequence for the parent stream.
+func checkConsumerDir(sr *StreamCheckReport, odir, name string, lseq uint64, repair bool) {
+	var cfg FileConsumerInfo
+	checkMetaFile(sr, odir, fmt.Sprintf("Consumer %q", name), []byte(sr.Name+"/"+name), &cfg, repair)
+
+	o := &consumerFileStore{name: name, odir: odir, ifn: path.Join(odir, consumerState)}
+	state, err := o.State()
+	if err != nil {
+		var repaired bool
+		if repair {
+			repaired = os.Remove(o.ifn) == nil
+		}
+		sr.addProblem(repaired, "Consumer %q state is corrupt and will be reset: %v", name, err)
+		return
+	}
+	if state == nil || state.Delivered.StreamSeq <= lseq {
+		return
+	}
+
+	// The consumer is ahead of the stream, which can happen when
+	// the tail of the stream was lost. Pull it back to the stream.
+	dseq := state.Delivered.StreamSeq
+	var repaired bool
+	if repair {
+		if lseq == 0 {
+			repaired = os.Remove(o.ifn) == nil
+		} else {
+			state.Delivered.StreamSeq = lseq
+			if state.AckFloor.StreamSeq > lseq {
+				state.AckFloor.StreamSeq = lseq
+			}
+			for seq := range state.Pending {
+				if seq > lseq {
+					delete(state.Pending, seq)
+				}
+			}
+			for seq := range state.Redelivered {
+				if seq > lseq {
+					delete(state.Redelivered, seq)
+				}
+			}
+			if err := o.Update(state); err == nil {
+				o.syncStateFile()
+				repaired = true
+			}
+			if o.ifd != nil {
+				o.ifd.Close()
+			}
+		}
+	}
+	sr.addProblem(repaired, "Consumer %q delivered sequence %d is beyond last stream sequence %d",
+		name, dseq, lseq)
+}
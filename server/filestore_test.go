@@ -709,6 +709,107 @@ func TestFileStoreBitRot(t *testing.T) {
 	}
 }
 
+func TestFileStoreCheckAndRepair(t *testing.T) {
+	storeDir, _ := ioutil.TempDir("", JetStreamStoreDir)
+	os.MkdirAll(storeDir, 0755)
+	defer os.RemoveAll(storeDir)
+
+	sdir := path.Join(storeDir, globalAccountName, streamsDir, "zzz")
+	fs, err := newFileStore(FileStoreConfig{StoreDir: sdir}, StreamConfig{Name: "zzz", Storage: FileStorage})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer fs.Stop()
+
+	// Make sure we check messages with headers too.
+	subj, hdr, msg := "foo", []byte("name:derek"), []byte("Hello World")
+	toStore := 100
+	for i := 0; i < toStore; i++ {
+		if i < 5 {
+			fs.StoreMsg(subj, hdr, msg)
+		} else {
+			fs.StoreMsg(subj, nil, msg)
+		}
+	}
+	o, err := fs.ConsumerStore("dlc", &ConsumerConfig{Durable: "dlc", AckPolicy: AckExplicit})
+	if err != nil {
+		t.Fatalf("Unexepected error: %v", err)
+	}
+	state := &ConsumerState{}
+	state.Delivered = SequencePair{100, 100}
+	state.AckFloor = SequencePair{50, 50}
+	if err := o.Update(state); err != nil {
+		t.Fatalf("Unexepected error updating state: %v", err)
+	}
+	fs.Stop()
+
+	checkProblems := func(repair bool, efound, erepaired int) {
+		t.Helper()
+		report, err := CheckJetStreamStoreDir(storeDir, repair)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(report.Streams) != 1 {
+			t.Fatalf("Expected 1 stream, got %d", len(report.Streams))
+		}
+		if found, repaired := report.Problems(); found != efound || repaired != erepaired {
+			var b bytes.Buffer
+			report.Print(&b)
+			t.Fatalf("Expected %d problems and %d repaired, got %d and %d\n%s", efound, erepaired, found, repaired, b.String())
+		}
+	}
+	checkProblems(false, 0, 0)
+
+	// Twiddle a bit in message 10 and chop the last message in half.
+	mfn := path.Join(sdir, msgDir, fmt.Sprintf(blkScan, 1))
+	contents, _ := ioutil.ReadFile(mfn)
+	off := 5*fileStoreMsgSize(subj, hdr, msg) + 4*fileStoreMsgSize(subj, nil, msg)
+	contents[off+msgHdrSize+1] ^= 0xff
+	contents = contents[:len(contents)-int(fileStoreMsgSize(subj, nil, msg))/2]
+	ioutil.WriteFile(mfn, contents, 0644)
+
+	// Bad checksum, short block and the consumer is now past the end of the stream.
+	checkProblems(false, 3, 0)
+
+	// The block problems are not repaired if the index can't be written.
+	ifn := path.Join(sdir, msgDir, fmt.Sprintf(indexScan, 1))
+	os.Remove(ifn)
+	if err := os.Mkdir(ifn, 0755); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	checkProblems(true, 3, 1)
+	os.Remove(ifn)
+
+	// Only the bad checksum is left, the block was truncated.
+	checkProblems(true, 1, 1)
+	checkProblems(false, 0, 0)
+
+	fs, err = newFileStore(FileStoreConfig{StoreDir: sdir}, StreamConfig{Name: "zzz", Storage: FileStorage})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer fs.Stop()
+
+	if state := fs.State(); state.Msgs != uint64(toStore-2) || state.LastSeq != uint64(toStore-1) {
+		t.Fatalf("Unexpected state after repair: %+v", state)
+	}
+	if _, _, _, _, err := fs.LoadMsg(10); err == nil {
+		t.Fatalf("Expected an error loading corrupt msg")
+	}
+	for _, seq := range []uint64{1, 9, 11, 99} {
+		if _, _, _, _, err := fs.LoadMsg(seq); err != nil {
+			t.Fatalf("Unexpected error looking up msg %d: %v", seq, err)
+		}
+	}
+	o, err = fs.ConsumerStore("dlc", &ConsumerConfig{Durable: "dlc", AckPolicy: AckExplicit})
+	if err != nil {
+		t.Fatalf("Unexepected error: %v", err)
+	}
+	if state, err := o.State(); err != nil || state.Delivered.StreamSeq != uint64(toStore-1) {
+		t.Fatalf("Unexpected consumer state after repair: %+v, %v", state, err)
+	}
+}
+
 func TestFileStoreEraseMsg(t *testing.T) {
 	storeDir, _ := ioutil.TempDir("", JetStreamStoreDir)
 	os.MkdirAll(storeDir, 0755)
@@ -899,7 +1000,7 @@ func TestFileStoreMeta(t *testing.T) {
 	if err := json.Unmarshal(buf, &oconfig2); err != nil {
 		t.Fatalf("Error unmarshalling: %v", err)
 	}
-	if oconfig2 != oconfig {
+	if !reflect.DeepEqual(oconfig2, oconfig) {
 		t.Fatalf("Consumer configs not equal, got %+v vs %+v", oconfig2, oconfig)
 	}
 	checksum, err = ioutil.ReadFile(ometasum)
@@ -242,6 +242,7 @@ func (r *RemoteGatewayOpts) clone() *RemoteGatewayOpts {
 	if r.TLSConfig != nil {
 		clone.TLSConfig = r.TLSConfig.Clone()
 		clone.TLSTimeout = r.TLSTimeout
+		clone.TLSPinnedCerts = r.TLSPinnedCerts
 	}
 	return clone
 }
@@ -342,6 +343,7 @@ func (s *Server) newGateway(opts *Options) error {
 		}
 		if opts.Gateway.TLSConfig != nil && cfg.TLSConfig == nil {
 			cfg.TLSConfig = opts.Gateway.TLSConfig.Clone()
+			cfg.TLSPinnedCerts = opts.Gateway.TLSPinnedCerts
 		}
 		if cfg.TLSTimeout == 0 {
 			cfg.TLSTimeout = opts.Gateway.TLSTimeout
@@ -449,6 +451,10 @@ func (s *Server) startGatewayAcceptLoop() {
 		GatewayNRP:   true,
 		Headers:      s.supportsHeaders(),
 	}
+	// Advertise the compression mode if compression is enabled.
+	if needsCompression(opts.Gateway.Compression) {
+		info.Compression = opts.Gateway.Compression
+	}
 	// If we have selected a random port...
 	if port == 0 {
 		// Write resolved port back to options.
@@ -717,6 +723,7 @@ func (s *Server) createGateway(cfg *gatewayCfg, url *url.URL, conn net.Conn) {
 	if tlsRequired {
 		var host string
 		var timeout float64
+		var pinned PinnedCertSet
 		// If we solicited, we will act like the client, otherwise the server.
 		if solicit {
 			c.Debugf("Starting TLS gateway client handshake")
@@ -724,6 +731,7 @@ func (s *Server) createGateway(cfg *gatewayCfg, url *url.URL, conn net.Conn) {
 			tlsName := cfg.tlsName
 			tlsConfig := cfg.TLSConfig.Clone()
 			timeout = cfg.TLSTimeout
+			pinned = cfg.TLSPinnedCerts
 			cfg.RUnlock()
 			if tlsConfig.ServerName == "" {
 				// If the given url is a hostname, use this hostname for the
@@ -740,6 +748,7 @@ func (s *Server) createGateway(cfg *gatewayCfg, url *url.URL, conn net.Conn) {
 			c.Debugf("Starting TLS gateway server handshake")
 			c.nc = tls.Server(c.nc, opts.Gateway.TLSConfig)
 			timeout = opts.Gateway.TLSTimeout
+			pinned = opts.Gateway.TLSPinnedCerts
 		}
 
 		conn := c.nc.(*tls.Conn)
@@ -770,6 +779,12 @@ func (s *Server) createGateway(cfg *gatewayCfg, url *url.URL, conn net.Conn) {
 		// Reset the read deadline
 		conn.SetReadDeadline(time.Time{})
 
+		if !c.matchesPinnedCert(pinned) {
+			c.sendErr("Secure Connection - Certificate Not Pinned")
+			c.closeConnection(TLSHandshakeError)
+			return
+		}
+
 		// Re-Grab lock
 		c.mu.Lock()
 
@@ -994,6 +1009,8 @@ func (c *client) processGatewayInfo(info *Info) {
 			c.enqueueProto(infoJSON)
 			c.gw.useOldPrefix = !info.GatewayNRP
 			c.headers = supportsHeaders && info.Headers
+			// Our INFO has been sent, so start compressing if both sides want to.
+			c.maybeStartCompression(s.getOpts().Gateway.Compression, needsCompression(info.Compression))
 			c.mu.Unlock()
 
 			// Register as an outbound gateway.. if we had a protocol to ack our connect,
@@ -1037,6 +1054,12 @@ func (c *client) processGatewayInfo(info *Info) {
 	} else if isFirstINFO {
 		// This is the first INFO of an inbound connection...
 
+		// Our INFO was sent when the connection was accepted, so
+		// start compressing if both sides want to.
+		c.mu.Lock()
+		c.maybeStartCompression(s.getOpts().Gateway.Compression, needsCompression(info.Compression))
+		c.mu.Unlock()
+
 		s.registerInboundGatewayConnection(cid, c)
 		c.Noticef("Inbound gateway connection from %q (%s) registered", info.Gateway, info.ID)
 
@@ -1295,6 +1318,7 @@ func (s *Server) processImplicitGateway(info *Info) {
 	if opts.Gateway.TLSConfig != nil {
 		cfg.TLSConfig = opts.Gateway.TLSConfig.Clone()
 		cfg.TLSTimeout = opts.Gateway.TLSTimeout
+		cfg.TLSPinnedCerts = opts.Gateway.TLSPinnedCerts
 	}
 
 	// Since we know we don't have URLs (no config, so just based on what we
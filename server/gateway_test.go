@@ -18,10 +18,13 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/url"
+	"os"
 	"runtime"
 	"strconv"
 	"strings"
@@ -1022,6 +1025,109 @@ func TestGatewayTLS(t *testing.T) {
 	}
 }
 
+func TestGatewayPinnedCerts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pinned")
+	if err != nil {
+		t.Fatalf("Error creating dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newOCSPTestCA(t)
+	caFile := ca.writeCert(t, dir)
+	certA, certFileA, keyFileA := ca.issue(t, dir, 2, _EMPTY_, _EMPTY_, false)
+	certB, certFileB, keyFileB := ca.issue(t, dir, 3, _EMPTY_, _EMPTY_, false)
+	other, _, _ := ca.issue(t, dir, 4, _EMPTY_, _EMPTY_, false)
+
+	tmpl := `
+		listen: "127.0.0.1:%d"
+		gateway {
+			name: %q
+			listen: "127.0.0.1:%d"
+			tls {
+				cert_file: %q
+				key_file: %q
+				ca_file: %q
+				verify: true
+				pinned_certs: [%q, %q]
+			}
+			%s
+		}
+	`
+	// A has no remote, so its connection to B is implicit.
+	confA := createConfFile(t, []byte(fmt.Sprintf(tmpl, -1, "A", -1, certFileA, keyFileA, caFile,
+		pinnedCertFingerprint(certA), pinnedCertFingerprint(other), _EMPTY_)))
+	defer os.Remove(confA)
+	sA, optsA := RunServerWithConfig(confA)
+	defer sA.Shutdown()
+
+	// B has a remote for A without its own tls block.
+	contentB := func(port, gwPort int, pinned *x509.Certificate) []byte {
+		return []byte(fmt.Sprintf(tmpl, port, "B", gwPort, certFileB, keyFileB, caFile,
+			pinnedCertFingerprint(pinned), pinnedCertFingerprint(certB),
+			fmt.Sprintf("gateways: [{name: \"A\", url: \"tls://127.0.0.1:%d\"}]", optsA.Gateway.Port)))
+	}
+	confB := createConfFile(t, contentB(-1, -1, certA))
+	defer os.Remove(confB)
+	sB, optsB := RunServerWithConfig(confB)
+	defer sB.Shutdown()
+
+	// B is not pinned by A, so the handshake fails.
+	time.Sleep(250 * time.Millisecond)
+	if n := sA.numInboundGateways(); n != 0 {
+		t.Fatalf("Expected no inbound gateway, got %v", n)
+	}
+	if n := sB.numOutboundGateways(); n != 0 {
+		t.Fatalf("Expected no outbound gateway, got %v", n)
+	}
+
+	reloadA := func(pinned *x509.Certificate) {
+		t.Helper()
+		changeCurrentConfigContentWithNewContent(t, confA, []byte(fmt.Sprintf(tmpl, optsA.Port, "A", optsA.Gateway.Port,
+			certFileA, keyFileA, caFile, pinnedCertFingerprint(certA), pinnedCertFingerprint(pinned), _EMPTY_)))
+		if err := sA.Reload(); err != nil {
+			t.Fatalf("Error on reload: %v", err)
+		}
+	}
+	reloadB := func(pinned *x509.Certificate) {
+		t.Helper()
+		changeCurrentConfigContentWithNewContent(t, confB, contentB(optsB.Port, optsB.Gateway.Port, pinned))
+		if err := sB.Reload(); err != nil {
+			t.Fatalf("Error on reload: %v", err)
+		}
+	}
+	checkConnected := func() {
+		t.Helper()
+		waitForOutboundGateways(t, sA, 1, 2*time.Second)
+		waitForOutboundGateways(t, sB, 1, 2*time.Second)
+	}
+	checkNotConnected := func(s *Server) {
+		t.Helper()
+		waitForOutboundGateways(t, s, 0, 2*time.Second)
+		time.Sleep(250 * time.Millisecond)
+		if n := s.numOutboundGateways(); n != 0 {
+			t.Fatalf("Expected no outbound gateway, got %v", n)
+		}
+	}
+
+	reloadA(certB)
+	checkConnected()
+
+	// The implicit remote of A uses the new pinned certificates, so
+	// A can't connect to B anymore.
+	reloadA(other)
+	checkNotConnected(sA)
+
+	reloadA(certB)
+	checkConnected()
+
+	// Same for the remote of B, which has no tls block.
+	reloadB(other)
+	checkNotConnected(sB)
+
+	reloadB(certA)
+	checkConnected()
+}
+
 func TestGatewayTLSErrors(t *testing.T) {
 	o2 := testDefaultOptionsForGateway("B")
 	s2 := runGatewayServer(o2)
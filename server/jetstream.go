@@ -34,9 +34,9 @@ import (
 // JetStreamConfig determines this server's configuration.
 // MaxMemory and MaxStore are in bytes.
 type JetStreamConfig struct {
-	MaxMemory int64
-	MaxStore  int64
-	StoreDir  string
+	MaxMemory int64  `json:"max_memory"`
+	MaxStore  int64  `json:"max_storage"`
+	StoreDir  string `json:"store_dir,omitempty"`
 }
 
 // TODO(dlc) - need to track and rollup against server limits, etc.
@@ -63,6 +63,8 @@ type jetStream struct {
 	accounts      map[*Account]*jsAccount
 	memReserved   int64
 	storeReserved int64
+	// Set once all streams and consumers have been recovered.
+	ready bool
 }
 
 // This represents a jetstream enabled account.
@@ -183,9 +185,25 @@ func (s *Server) EnableJetStream(config *JetStreamConfig) error {
 		return fmt.Errorf("Error enabling jetstream on configured accounts: %v", err)
 	}
 
+	s.js.mu.Lock()
+	s.js.ready = true
+	s.js.mu.Unlock()
+
 	return nil
 }
 
+// JetStreamIsReady returns true once JetStream is enabled and has recovered
+// all streams and consumers from its store directory.
+func (s *Server) JetStreamIsReady() bool {
+	js := s.getJetStream()
+	if js == nil {
+		return false
+	}
+	js.mu.RLock()
+	defer js.mu.RUnlock()
+	return js.ready
+}
+
 // enableAllJetStreamServiceImports turns on all service imports for jetstream for this account.
 func (a *Account) enableAllJetStreamServiceImports() error {
 	a.mu.RLock()
@@ -575,6 +593,9 @@ func (a *Account) EnableJetStream(limits *JetStreamAccountLimits) error {
 			if err := obs.readStoredState(); err != nil {
 				s.Warnf("    Error restoring Consumer state: %v", err)
 			}
+			if cfg.Paused {
+				obs.restorePause(cfg.PauseUntil)
+			}
 		}
 	}
 
@@ -802,7 +823,13 @@ func (jsa *jsAccount) checkLimits(config *StreamConfig) error {
 
 	// Check storage, memory or disk.
 	if config.MaxBytes > 0 {
-		return jsa.checkBytesLimits(config.MaxBytes*int64(config.Replicas), config.Storage)
+		if err := jsa.checkBytesLimits(config.MaxBytes*int64(config.Replicas), config.Storage.accounting()); err != nil {
+			return err
+		}
+	}
+	// The memory head of tiered storage counts against memory.
+	if config.MaxHeadBytes > 0 {
+		return jsa.checkBytesLimits(config.MaxHeadBytes*int64(config.Replicas), MemoryStorage)
 	}
 	return nil
 }
@@ -1017,7 +1044,7 @@ func (a *Account) AddStreamTemplate(tc *StreamTemplateConfig) (*StreamTemplate,
 	if jsa.templates == nil {
 		jsa.templates = make(map[string]*StreamTemplate)
 		// Create the appropriate store
-		if cfg.Storage == FileStorage {
+		if cfg.Storage.accounting() == FileStorage {
 			jsa.store = newTemplateFileStore(jsa.storeDir)
 		} else {
 			jsa.store = newTemplateMemStore()
@@ -117,6 +117,11 @@ const (
 	JSApiDurableCreate  = "$JS.API.CONSUMER.DURABLE.CREATE.*.*"
 	JSApiDurableCreateT = "$JS.API.CONSUMER.DURABLE.CREATE.%s.%s"
 
+	// JSApiConsumerUpdate is the endpoint to update the configuration of a consumer.
+	// You need to include the stream and consumer name in the subject.
+	JSApiConsumerUpdate  = "$JS.API.CONSUMER.UPDATE.*.*"
+	JSApiConsumerUpdateT = "$JS.API.CONSUMER.UPDATE.%s.%s"
+
 	// JSApiConsumers is the endpoint to list all consumer names for the stream.
 	// Will return JSON response.
 	JSApiConsumers  = "$JS.API.CONSUMER.NAMES.*"
@@ -136,6 +141,21 @@ const (
 	JSApiConsumerDelete  = "$JS.API.CONSUMER.DELETE.*.*"
 	JSApiConsumerDeleteT = "$JS.API.CONSUMER.DELETE.%s.%s"
 
+	// JSApiConsumerPause is the endpoint to pause delivery for a consumer.
+	// Will return JSON response.
+	JSApiConsumerPause  = "$JS.API.CONSUMER.PAUSE.*.*"
+	JSApiConsumerPauseT = "$JS.API.CONSUMER.PAUSE.%s.%s"
+
+	// JSApiConsumerResume is the endpoint to resume delivery for a paused consumer.
+	// Will return JSON response.
+	JSApiConsumerResume  = "$JS.API.CONSUMER.RESUME.*.*"
+	JSApiConsumerResumeT = "$JS.API.CONSUMER.RESUME.%s.%s"
+
+	// JSApiConsumerReset is the endpoint to restart delivery for an ordered consumer.
+	// Will return JSON response.
+	JSApiConsumerReset  = "$JS.API.CONSUMER.RESET.*.*"
+	JSApiConsumerResetT = "$JS.API.CONSUMER.RESET.%s.%s"
+
 	// JSApiRequestNextT is the prefix for the request next message(s) for a consumer in worker/pull mode.
 	JSApiRequestNextT = "$JS.API.CONSUMER.MSG.NEXT.%s.%s"
 
@@ -183,6 +203,9 @@ const (
 	// JSAdvisoryConsumerDeletedPre notification that a template deleted
 	JSAdvisoryConsumerDeletedPre = "$JS.EVENT.ADVISORY.CONSUMER.DELETED"
 
+	// JSAdvisoryConsumerUpdatedPre notification that a consumer was updated
+	JSAdvisoryConsumerUpdatedPre = "$JS.EVENT.ADVISORY.CONSUMER.UPDATED"
+
 	// JSAdvisoryStreamSnapshotCreatePre notification that a snapshot was created
 	JSAdvisoryStreamSnapshotCreatePre = "$JS.EVENT.ADVISORY.STREAM.SNAPSHOT_CREATE"
 
@@ -374,6 +397,14 @@ type JSApiConsumerCreateResponse struct {
 
 const JSApiConsumerCreateResponseType = "io.nats.jetstream.api.v1.consumer_create_response"
 
+// JSApiConsumerUpdateResponse.
+type JSApiConsumerUpdateResponse struct {
+	ApiResponse
+	*ConsumerInfo
+}
+
+const JSApiConsumerUpdateResponseType = "io.nats.jetstream.api.v1.consumer_update_response"
+
 // JSApiConsumerDeleteResponse.
 type JSApiConsumerDeleteResponse struct {
 	ApiResponse
@@ -413,6 +444,41 @@ type JSApiConsumerListResponse struct {
 
 const JSApiConsumerListResponseType = "io.nats.jetstream.api.v1.consumer_list_response"
 
+// JSApiConsumerPauseRequest is optional, without a time the consumer is paused until resumed.
+type JSApiConsumerPauseRequest struct {
+	PauseUntil *time.Time `json:"pause_until,omitempty"`
+}
+
+// JSApiConsumerPauseResponse.
+type JSApiConsumerPauseResponse struct {
+	ApiResponse
+	*ConsumerInfo
+}
+
+const JSApiConsumerPauseResponseType = "io.nats.jetstream.api.v1.consumer_pause_response"
+
+// JSApiConsumerResumeResponse.
+type JSApiConsumerResumeResponse struct {
+	ApiResponse
+	*ConsumerInfo
+}
+
+const JSApiConsumerResumeResponseType = "io.nats.jetstream.api.v1.consumer_resume_response"
+
+// JSApiConsumerResetRequest holds the last stream sequence processed by the client.
+// Without it delivery restarts after the last message delivered by the server.
+type JSApiConsumerResetRequest struct {
+	Seq uint64 `json:"seq,omitempty"`
+}
+
+// JSApiConsumerResetResponse.
+type JSApiConsumerResetResponse struct {
+	ApiResponse
+	*ConsumerInfo
+}
+
+const JSApiConsumerResetResponseType = "io.nats.jetstream.api.v1.consumer_reset_response"
+
 // JSApiStreamTemplateCreateResponse for creating templates.
 type JSApiStreamTemplateCreateResponse struct {
 	ApiResponse
@@ -478,10 +544,14 @@ var allJsExports = []string{
 	JSApiMsgGet,
 	JSApiConsumerCreate,
 	JSApiDurableCreate,
+	JSApiConsumerUpdate,
 	JSApiConsumers,
 	JSApiConsumerList,
 	JSApiConsumerInfo,
 	JSApiConsumerDelete,
+	JSApiConsumerPause,
+	JSApiConsumerResume,
+	JSApiConsumerReset,
 }
 
 func (s *Server) setJetStreamExportSubs() error {
@@ -507,10 +577,14 @@ func (s *Server) setJetStreamExportSubs() error {
 		{JSApiMsgGet, s.jsMsgGetRequest},
 		{JSApiConsumerCreate, s.jsConsumerCreateRequest},
 		{JSApiDurableCreate, s.jsDurableCreateRequest},
+		{JSApiConsumerUpdate, s.jsConsumerUpdateRequest},
 		{JSApiConsumers, s.jsConsumerNamesRequest},
 		{JSApiConsumerList, s.jsConsumerListRequest},
 		{JSApiConsumerInfo, s.jsConsumerInfoRequest},
 		{JSApiConsumerDelete, s.jsConsumerDeleteRequest},
+		{JSApiConsumerPause, s.jsConsumerPauseRequest},
+		{JSApiConsumerResume, s.jsConsumerResumeRequest},
+		{JSApiConsumerReset, s.jsConsumerResetRequest},
 	}
 
 	for _, p := range pairs {
@@ -1502,6 +1576,56 @@ func (s *Server) jsConsumerCreate(sub *subscription, c *client, subject, reply s
 	s.sendAPIResponse(c, subject, reply, string(msg), s.jsonResponse(resp))
 }
 
+// Request to update the configuration of a consumer.
+func (s *Server) jsConsumerUpdateRequest(sub *subscription, c *client, subject, reply string, msg []byte) {
+	if c == nil || c.acc == nil {
+		return
+	}
+
+	var resp = JSApiConsumerUpdateResponse{ApiResponse: ApiResponse{Type: JSApiConsumerUpdateResponseType}}
+	if !c.acc.JetStreamEnabled() {
+		resp.Error = jsNotEnabledErr
+		s.sendAPIResponse(c, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+	var req CreateConsumerRequest
+	if err := json.Unmarshal(msg, &req); err != nil {
+		resp.Error = jsInvalidJSONErr
+		s.sendAPIResponse(c, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+	if streamNameFromSubject(subject) != req.Stream {
+		resp.Error = &ApiError{Code: 400, Description: "stream name in subject does not match request"}
+		s.sendAPIResponse(c, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+	mset, err := c.acc.LookupStream(req.Stream)
+	if err != nil {
+		resp.Error = jsNotFoundError(err)
+		s.sendAPIResponse(c, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+	consumer := consumerNameFromSubject(subject)
+	if req.Config.Durable != _EMPTY_ && req.Config.Durable != consumer {
+		resp.Error = &ApiError{Code: 400, Description: "consumer name in subject does not match durable name in request"}
+		s.sendAPIResponse(c, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+	obs := mset.LookupConsumer(consumer)
+	if obs == nil {
+		resp.Error = &ApiError{Code: 404, Description: "consumer not found"}
+		s.sendAPIResponse(c, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+	if err := obs.Update(&req.Config); err != nil {
+		resp.Error = jsError(err)
+		s.sendAPIResponse(c, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+	resp.ConsumerInfo = obs.Info()
+	s.sendAPIResponse(c, subject, reply, string(msg), s.jsonResponse(resp))
+}
+
 // Request for the list of all consumer names.
 func (s *Server) jsConsumerNamesRequest(sub *subscription, c *client, subject, reply string, msg []byte) {
 	if c == nil || c.acc == nil {
@@ -1682,6 +1806,136 @@ func (s *Server) jsConsumerDeleteRequest(sub *subscription, c *client, subject,
 	s.sendAPIResponse(c, subject, reply, string(msg), s.jsonResponse(resp))
 }
 
+// Request to pause delivery for a consumer.
+func (s *Server) jsConsumerPauseRequest(sub *subscription, c *client, subject, reply string, msg []byte) {
+	if c == nil || c.acc == nil {
+		return
+	}
+
+	var resp = JSApiConsumerPauseResponse{ApiResponse: ApiResponse{Type: JSApiConsumerPauseResponseType}}
+	if !c.acc.JetStreamEnabled() {
+		resp.Error = jsNotEnabledErr
+		s.sendAPIResponse(c, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+	var until time.Time
+	if !isEmptyRequest(msg) {
+		var req JSApiConsumerPauseRequest
+		if err := json.Unmarshal(msg, &req); err != nil {
+			resp.Error = jsInvalidJSONErr
+			s.sendAPIResponse(c, subject, reply, string(msg), s.jsonResponse(&resp))
+			return
+		}
+		if req.PauseUntil != nil {
+			until = *req.PauseUntil
+		}
+	}
+	stream := streamNameFromSubject(subject)
+	mset, err := c.acc.LookupStream(stream)
+	if err != nil {
+		resp.Error = jsNotFoundError(err)
+		s.sendAPIResponse(c, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+	consumer := consumerNameFromSubject(subject)
+	obs := mset.LookupConsumer(consumer)
+	if obs == nil {
+		resp.Error = &ApiError{Code: 404, Description: "consumer not found"}
+		s.sendAPIResponse(c, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+	if err := obs.Pause(until); err != nil {
+		resp.Error = jsError(err)
+		s.sendAPIResponse(c, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+	resp.ConsumerInfo = obs.Info()
+	s.sendAPIResponse(c, subject, reply, string(msg), s.jsonResponse(resp))
+}
+
+// Request to resume delivery for a paused consumer.
+func (s *Server) jsConsumerResumeRequest(sub *subscription, c *client, subject, reply string, msg []byte) {
+	if c == nil || c.acc == nil {
+		return
+	}
+
+	var resp = JSApiConsumerResumeResponse{ApiResponse: ApiResponse{Type: JSApiConsumerResumeResponseType}}
+	if !c.acc.JetStreamEnabled() {
+		resp.Error = jsNotEnabledErr
+		s.sendAPIResponse(c, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+	if !isEmptyRequest(msg) {
+		resp.Error = jsNotEmptyRequestErr
+		s.sendAPIResponse(c, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+	stream := streamNameFromSubject(subject)
+	mset, err := c.acc.LookupStream(stream)
+	if err != nil {
+		resp.Error = jsNotFoundError(err)
+		s.sendAPIResponse(c, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+	consumer := consumerNameFromSubject(subject)
+	obs := mset.LookupConsumer(consumer)
+	if obs == nil {
+		resp.Error = &ApiError{Code: 404, Description: "consumer not found"}
+		s.sendAPIResponse(c, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+	if err := obs.Resume(); err != nil {
+		resp.Error = jsError(err)
+		s.sendAPIResponse(c, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+	resp.ConsumerInfo = obs.Info()
+	s.sendAPIResponse(c, subject, reply, string(msg), s.jsonResponse(resp))
+}
+
+// Request to restart delivery for an ordered consumer after the client detected a gap.
+func (s *Server) jsConsumerResetRequest(sub *subscription, c *client, subject, reply string, msg []byte) {
+	if c == nil || c.acc == nil {
+		return
+	}
+
+	var resp = JSApiConsumerResetResponse{ApiResponse: ApiResponse{Type: JSApiConsumerResetResponseType}}
+	if !c.acc.JetStreamEnabled() {
+		resp.Error = jsNotEnabledErr
+		s.sendAPIResponse(c, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+	var req JSApiConsumerResetRequest
+	if !isEmptyRequest(msg) {
+		if err := json.Unmarshal(msg, &req); err != nil {
+			resp.Error = jsInvalidJSONErr
+			s.sendAPIResponse(c, subject, reply, string(msg), s.jsonResponse(&resp))
+			return
+		}
+	}
+	stream := streamNameFromSubject(subject)
+	mset, err := c.acc.LookupStream(stream)
+	if err != nil {
+		resp.Error = jsNotFoundError(err)
+		s.sendAPIResponse(c, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+	consumer := consumerNameFromSubject(subject)
+	obs := mset.LookupConsumer(consumer)
+	if obs == nil {
+		resp.Error = &ApiError{Code: 404, Description: "consumer not found"}
+		s.sendAPIResponse(c, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+	if err := obs.Reset(req.Seq); err != nil {
+		resp.Error = jsError(err)
+		s.sendAPIResponse(c, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+	resp.ConsumerInfo = obs.Info()
+	s.sendAPIResponse(c, subject, reply, string(msg), s.jsonResponse(resp))
+}
+
 // sendJetStreamAPIAuditAdvisor will send the audit event for a given event.
 func (s *Server) sendJetStreamAPIAuditAdvisory(c *client, subject, request, response string) {
 	s.publishAdvisory(c.acc, JSAuditAdvisory, JSAPIAudit{
@@ -16,6 +16,7 @@ package server
 import (
 	"bufio"
 	"bytes"
+	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/base64"
@@ -24,6 +25,7 @@ import (
 	"io"
 	"io/ioutil"
 	"net"
+	"net/http"
 	"net/url"
 	"reflect"
 	"regexp"
@@ -72,6 +74,8 @@ type leaf struct {
 	// we would add it a second time in the smap causing later unsub to suppress the LS-.
 	tsub  map[*subscription]struct{}
 	tsubt *time.Timer
+	// For solicited connections, set if the remote advertised compression in its INFO.
+	compress bool
 }
 
 // Used for remote (solicited) leafnodes.
@@ -277,6 +281,8 @@ func (s *Server) connectToRemoteLeafNode(remote *leafNodeCfg, firstConnect bool)
 	}
 
 	var conn net.Conn
+	var ws *websocket
+	var br *bufio.Reader
 
 	const connErrFmt = "Error trying to connect as leafnode to remote server %q (attempt %v): %v"
 
@@ -291,6 +297,9 @@ func (s *Server) connectToRemoteLeafNode(remote *leafNodeCfg, firstConnect bool)
 			}
 			s.Debugf("Trying to connect as leafnode to remote server on %q%s", rURL.Host, ipStr)
 			conn, err = natsDialTimeout("tcp", url, dialTimeout)
+			if err == nil && isWSURL(rURL) {
+				conn, ws, br, err = s.leafNodeSolicitWSConnection(conn, remote, rURL, dialTimeout)
+			}
 		}
 		if err != nil {
 			attempts++
@@ -313,7 +322,7 @@ func (s *Server) connectToRemoteLeafNode(remote *leafNodeCfg, firstConnect bool)
 
 		// We have a connection here to a remote server.
 		// Go ahead and create our leaf node and return.
-		s.createLeafNode(conn, remote)
+		s.createLeafNode(conn, remote, ws, br)
 
 		// We will put this in the normal log if first connect, does not force -DV mode to know
 		// that the connect worked.
@@ -327,7 +336,7 @@ func (s *Server) connectToRemoteLeafNode(remote *leafNodeCfg, firstConnect bool)
 // Save off the tlsName for when we use TLS and mix hostnames and IPs. IPs usually
 // come from the server we connect to.
 func (cfg *leafNodeCfg) saveTLSHostname(u *url.URL) {
-	isTLS := cfg.TLSConfig != nil || u.Scheme == "tls"
+	isTLS := cfg.TLSConfig != nil || u.Scheme == "tls" || u.Scheme == "wss"
 	if isTLS && cfg.tlsName == "" && net.ParseIP(u.Hostname()) == nil {
 		cfg.tlsName = u.Hostname()
 	}
@@ -342,6 +351,121 @@ func (cfg *leafNodeCfg) saveUserPassword(u *url.URL) {
 	}
 }
 
+// Returns true if the URL of a remote leafnode has a websocket scheme.
+func isWSURL(u *url.URL) bool {
+	return u.Scheme == "ws" || u.Scheme == "wss"
+}
+
+// Performs the websocket handshake on a solicited leafnode connection,
+// after the TLS handshake for the "wss" scheme. Returns the connection
+// to use, which is a TLS connection for "wss", and the reader from which
+// the remote's INFO is read. The connection is closed on error.
+func (s *Server) leafNodeSolicitWSConnection(conn net.Conn, remote *leafNodeCfg, rURL *url.URL, timeout time.Duration) (net.Conn, *websocket, *bufio.Reader, error) {
+	if rURL.Scheme == "wss" {
+		var tlsConfig *tls.Config
+		if remote.TLSConfig != nil {
+			tlsConfig = remote.TLSConfig.Clone()
+		} else {
+			tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		// If ServerName was given to us from the option, use that, always.
+		if tlsConfig.ServerName == "" {
+			host := rURL.Hostname()
+			if remote.tlsName != "" && net.ParseIP(host) != nil {
+				host = remote.tlsName
+			}
+			tlsConfig.ServerName = host
+		}
+		wait := TLS_TIMEOUT
+		if remote.TLSTimeout != 0 {
+			wait = secondsToDuration(remote.TLSTimeout)
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		tlsConn.SetDeadline(time.Now().Add(wait))
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, nil, nil, fmt.Errorf("TLS handshake error: %v", err)
+		}
+		conn = tlsConn
+	}
+
+	// From https://tools.ietf.org/html/rfc6455#section-4.1
+	var nonce [16]byte
+	rand.Read(nonce[:])
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+	req, err := http.NewRequest("GET", "http://"+rURL.Host+wsLeafNodePath, nil)
+	if err != nil {
+		conn.Close()
+		return nil, nil, nil, err
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if remote.Websocket.Compression {
+		req.Header.Set("Sec-WebSocket-Extensions", "permessage-deflate; server_no_context_takeover; client_no_context_takeover")
+	}
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	br := bufio.NewReaderSize(conn, MAX_CONTROL_LINE_SIZE)
+	var resp *http.Response
+	if err = req.Write(conn); err == nil {
+		resp, err = http.ReadResponse(br, req)
+	}
+	if err == nil {
+		resp.Body.Close()
+		switch {
+		case resp.StatusCode != http.StatusSwitchingProtocols:
+			err = fmt.Errorf("unexpected status %q", resp.Status)
+		case !wsHeaderContains(resp.Header, "Upgrade", "websocket"):
+			err = fmt.Errorf("invalid value for header 'Upgrade'")
+		case !wsHeaderContains(resp.Header, "Connection", "Upgrade"):
+			err = fmt.Errorf("invalid value for header 'Connection'")
+		case resp.Header.Get("Sec-WebSocket-Accept") != wsAcceptKey(key):
+			err = fmt.Errorf("invalid accept key")
+		}
+	}
+	if err != nil {
+		conn.Close()
+		return nil, nil, nil, fmt.Errorf("websocket handshake error: %v", err)
+	}
+	conn.SetDeadline(time.Time{})
+
+	ws := &websocket{maskwrite: true}
+	ws.compress = remote.Websocket.Compression && wsClientSupportsCompression(resp.Header)
+	return conn, ws, br, nil
+}
+
+// Reads the INFO that the remote sends in a websocket frame once the
+// websocket handshake is complete. The remote does not send anything
+// else until it gets our CONNECT.
+// Lock MUST NOT be held on entry.
+func (c *client) leafNodeWSReadInfo(br *bufio.Reader) (string, error) {
+	r := &wsReadInfo{nomask: true}
+	r.init()
+	var info []byte
+	buf := make([]byte, MAX_CONTROL_LINE_SIZE)
+	for {
+		n, err := br.Read(buf)
+		if n == 0 && err != nil {
+			return _EMPTY_, err
+		}
+		bufs, err := c.wsRead(r, br, buf[:n])
+		for _, b := range bufs {
+			info = append(info, b...)
+		}
+		if i := bytes.IndexByte(info, '\n'); i >= 0 {
+			return string(info[:i+1]), nil
+		}
+		if err != nil {
+			return _EMPTY_, err
+		}
+		if len(info) > MAX_CONTROL_LINE_SIZE {
+			return _EMPTY_, ErrMaxControlLine
+		}
+	}
+}
+
 // This starts the leafnode accept loop in a go routine, unless it
 // is detected that the server has already been shutdown.
 func (s *Server) startLeafNodeAcceptLoop() {
@@ -383,6 +507,10 @@ func (s *Server) startLeafNodeAcceptLoop() {
 		Headers:      s.supportsHeaders(),
 		Proto:        1, // Fixed for now.
 	}
+	// Advertise the compression mode if compression is enabled.
+	if needsCompression(opts.LeafNode.Compression) {
+		info.Compression = opts.LeafNode.Compression
+	}
 	// If we have selected a random port...
 	if port == 0 {
 		// Write resolved port back to options.
@@ -421,7 +549,7 @@ func (s *Server) startLeafNodeAcceptLoop() {
 	if warn {
 		s.Warnf(leafnodeTLSInsecureWarning)
 	}
-	go s.acceptConnections(l, "Leafnode", func(conn net.Conn) { s.createLeafNode(conn, nil) }, nil)
+	go s.acceptConnections(l, "Leafnode", func(conn net.Conn) { s.createLeafNode(conn, nil, nil, nil) }, nil)
 	s.mu.Unlock()
 }
 
@@ -436,6 +564,7 @@ func (c *client) sendLeafConnect(clusterName string, tlsRequired bool) error {
 		Name:    c.srv.info.ID,
 		Hub:     c.leaf.remote.Hub,
 		Cluster: clusterName,
+		Comp:    needsCompression(c.leaf.remote.Compression),
 	}
 
 	// Check for credentials first, that will take precedence..
@@ -548,7 +677,10 @@ func (s *Server) sendAsyncLeafNodeInfo() {
 }
 
 // Called when an inbound leafnode connection is accepted or we create one for a solicited leafnode.
-func (s *Server) createLeafNode(conn net.Conn, remote *leafNodeCfg) *client {
+// Creates a leafnode connection, soliciting the remote if `remote` is not nil.
+// For websocket connections, `ws` is not nil and, when soliciting, `br` is
+// the reader from which the remote's INFO is read.
+func (s *Server) createLeafNode(conn net.Conn, remote *leafNodeCfg, ws *websocket, br *bufio.Reader) *client {
 	// Snapshot server options.
 	opts := s.getOpts()
 
@@ -560,7 +692,7 @@ func (s *Server) createLeafNode(conn net.Conn, remote *leafNodeCfg) *client {
 	}
 	now := time.Now()
 
-	c := &client{srv: s, nc: conn, kind: LEAF, opts: defaultOpts, mpay: maxPay, msubs: maxSubs, start: now, last: now}
+	c := &client{srv: s, nc: conn, kind: LEAF, opts: defaultOpts, mpay: maxPay, msubs: maxSubs, start: now, last: now, ws: ws}
 	// Do not update the smap here, we need to do it in initLeafNodeSmapAndSendSubs
 	c.leaf = &leaf{}
 
@@ -620,8 +752,16 @@ func (s *Server) createLeafNode(conn net.Conn, remote *leafNodeCfg) *client {
 	if solicited {
 		// We need to wait here for the info, but not for too long.
 		c.nc.SetReadDeadline(time.Now().Add(DEFAULT_LEAFNODE_INFO_WAIT))
-		br := bufio.NewReaderSize(c.nc, MAX_CONTROL_LINE_SIZE)
-		info, err := br.ReadString('\n')
+		var info string
+		var err error
+		if ws != nil {
+			c.mu.Unlock()
+			info, err = c.leafNodeWSReadInfo(br)
+			c.mu.Lock()
+		} else {
+			br := bufio.NewReaderSize(c.nc, MAX_CONTROL_LINE_SIZE)
+			info, err = br.ReadString('\n')
+		}
 		if err != nil {
 			c.mu.Unlock()
 			if err == io.EOF {
@@ -651,7 +791,18 @@ func (s *Server) createLeafNode(conn net.Conn, remote *leafNodeCfg) *client {
 
 		// Do TLS here as needed.
 		tlsRequired := remote.TLS || remote.TLSConfig != nil
-		if tlsRequired {
+		if ws != nil {
+			// For websocket, TLS was done before the websocket handshake.
+			_, tlsRequired = c.nc.(*tls.Conn)
+			if tlsRequired {
+				c.mu.Unlock()
+				if !c.matchesPinnedCert(remote.TLSPinnedCerts) {
+					c.closeConnection(TLSHandshakeError)
+					return nil
+				}
+				c.mu.Lock()
+			}
+		} else if tlsRequired {
 			c.Debugf("Starting TLS leafnode client handshake")
 			// Specify the ServerName we are expecting.
 			var tlsConfig *tls.Config
@@ -710,6 +861,11 @@ func (s *Server) createLeafNode(conn net.Conn, remote *leafNodeCfg) *client {
 			// Reset the read deadline
 			conn.SetReadDeadline(time.Time{})
 
+			if !c.matchesPinnedCert(remote.TLSPinnedCerts) {
+				c.closeConnection(TLSHandshakeError)
+				return nil
+			}
+
 			// Re-Grab lock
 			c.mu.Lock()
 		}
@@ -721,6 +877,9 @@ func (s *Server) createLeafNode(conn net.Conn, remote *leafNodeCfg) *client {
 		}
 		c.Debugf("Remote leafnode connect msg sent")
 
+		// Start compressing after the CONNECT if both sides want to.
+		c.maybeStartCompression(remote.Compression, c.leaf.compress)
+
 	} else {
 		// Send our info to the other side.
 		// Remember the nonce we sent here for signatures, etc.
@@ -728,6 +887,11 @@ func (s *Server) createLeafNode(conn net.Conn, remote *leafNodeCfg) *client {
 		copy(c.nonce, nonce[:])
 		info.Nonce = string(c.nonce)
 		info.CID = c.cid
+		// For websocket, TLS is handled by the websocket listener, and the
+		// leafnode certificate was checked when upgrading the connection.
+		if ws != nil {
+			info.TLSRequired, info.TLSVerify = false, false
+		}
 		b, _ := json.Marshal(info)
 		pcs := [][]byte{[]byte("INFO"), b, []byte(CR_LF)}
 		// We have to send from this go routine because we may
@@ -757,6 +921,11 @@ func (s *Server) createLeafNode(conn net.Conn, remote *leafNodeCfg) *client {
 			// Reset the read deadline
 			conn.SetReadDeadline(time.Time{})
 
+			if !c.matchesPinnedCert(opts.LeafNode.TLSPinnedCerts) {
+				c.closeConnection(TLSHandshakeError)
+				return nil
+			}
+
 			// Re-Grab lock
 			c.mu.Lock()
 
@@ -866,6 +1035,7 @@ func (c *client) processLeafnodeInfo(info *Info) error {
 		}
 		supportsHeaders := c.srv.supportsHeaders()
 		c.headers = supportsHeaders && info.Headers
+		c.leaf.compress = needsCompression(info.Compression)
 	}
 	// For both initial INFO and async INFO protocols, Possibly
 	// update our list of remote leafnode URLs we can connect to.
@@ -899,6 +1069,11 @@ func (c *client) processLeafnodeInfo(info *Info) error {
 // When getting a leaf node INFO protocol, use the provided
 // array of urls to update the list of possible endpoints.
 func (c *client) updateLeafNodeURLs(info *Info) {
+	// The advertised URLs are for the remote's leafnode port, which
+	// can not be reached by a websocket connection.
+	if c.ws != nil {
+		return
+	}
 	cfg := c.leaf.remote
 	cfg.Lock()
 	defer cfg.Unlock()
@@ -1050,6 +1225,12 @@ func (c *client) processLeafNodeConnect(s *Server, arg []byte, lang string) erro
 		c.leaf.remoteCluster = proto.Cluster
 	}
 
+	// Our INFO has already been sent, so start compressing if the
+	// soliciting side asked for it and this server allows it.
+	c.mu.Lock()
+	c.maybeStartCompression(s.getOpts().LeafNode.Compression, proto.Comp)
+	c.mu.Unlock()
+
 	// If we have permissions bound to this leafnode we need to send then back to the
 	// origin server for local enforcement.
 	s.sendPermsInfo(c)
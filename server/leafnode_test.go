@@ -18,6 +18,7 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/url"
 	"os"
@@ -1650,3 +1651,249 @@ func TestLeafNodeOriginClusterInfo(t *testing.T) {
 		t.Fatalf("Expected a different id, got the same")
 	}
 }
+
+func TestLeafNodePinnedCerts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pinned")
+	if err != nil {
+		t.Fatalf("Error creating dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newOCSPTestCA(t)
+	caFile := ca.writeCert(t, dir)
+	hubCert, hubCertFile, hubKeyFile := ca.issue(t, dir, 2, _EMPTY_, _EMPTY_, false)
+	leafCert, leafCertFile, leafKeyFile := ca.issue(t, dir, 3, _EMPTY_, _EMPTY_, false)
+	other, _, _ := ca.issue(t, dir, 4, _EMPTY_, _EMPTY_, false)
+
+	hubTmpl := `
+		listen: "127.0.0.1:%d"
+		leafnodes {
+			listen: "127.0.0.1:%d"
+			tls {
+				cert_file: %q
+				key_file: %q
+				ca_file: %q
+				verify: true
+				pinned_certs: [%q]
+			}
+		}
+	`
+	hubConf := createConfFile(t, []byte(fmt.Sprintf(hubTmpl, -1, -1, hubCertFile, hubKeyFile, caFile,
+		pinnedCertFingerprint(leafCert))))
+	defer os.Remove(hubConf)
+	hub, hubOpts := RunServerWithConfig(hubConf)
+	defer hub.Shutdown()
+
+	leafTmpl := `
+		listen: "127.0.0.1:-1"
+		leafnodes {
+			reconnect: 1
+			remotes [{
+				url: "tls://127.0.0.1:%d"
+				tls {
+					cert_file: %q
+					key_file: %q
+					ca_file: %q
+					pinned_certs: [%q]
+				}
+			}]
+		}
+	`
+	// The hub certificate is not pinned by the remote.
+	leafConf := createConfFile(t, []byte(fmt.Sprintf(leafTmpl, hubOpts.LeafNode.Port, leafCertFile, leafKeyFile, caFile,
+		pinnedCertFingerprint(other))))
+	defer os.Remove(leafConf)
+	leaf, _ := RunServerWithConfig(leafConf)
+	time.Sleep(250 * time.Millisecond)
+	if n := hub.NumLeafNodes(); n != 0 {
+		t.Fatalf("Expected no leafnode, got %v", n)
+	}
+	leaf.Shutdown()
+
+	leafConf2 := createConfFile(t, []byte(fmt.Sprintf(leafTmpl, hubOpts.LeafNode.Port, leafCertFile, leafKeyFile, caFile,
+		pinnedCertFingerprint(hubCert))))
+	defer os.Remove(leafConf2)
+	leaf, _ = RunServerWithConfig(leafConf2)
+	defer leaf.Shutdown()
+	checkLeafNodeConnected(t, hub)
+	checkLeafNodeConnected(t, leaf)
+
+	// The leafnode is closed once its certificate is no longer pinned by the hub.
+	changeCurrentConfigContentWithNewContent(t, hubConf, []byte(fmt.Sprintf(hubTmpl, hubOpts.Port, hubOpts.LeafNode.Port,
+		hubCertFile, hubKeyFile, caFile, pinnedCertFingerprint(other))))
+	if err := hub.Reload(); err != nil {
+		t.Fatalf("Error on reload: %v", err)
+	}
+	checkLeafNodeConnectedCount(t, hub, 0)
+	time.Sleep(1500 * time.Millisecond)
+	if n := hub.NumLeafNodes(); n != 0 {
+		t.Fatalf("Expected no leafnode, got %v", n)
+	}
+}
+
+func testLeafNodeWSHubOptions() *Options {
+	o := testWSOptions()
+	o.Websocket.TLSConfig = nil
+	o.Websocket.NoTLS = true
+	o.LeafNode.Host = "127.0.0.1"
+	o.LeafNode.Port = -1
+	return o
+}
+
+func testLeafNodeWSSpokeOptions(t *testing.T, hubOpts *Options, compression bool) *Options {
+	t.Helper()
+	u, err := url.Parse(fmt.Sprintf("ws://127.0.0.1:%d", hubOpts.Websocket.Port))
+	if err != nil {
+		t.Fatalf("Error parsing url: %v", err)
+	}
+	o := DefaultOptions()
+	remote := &RemoteLeafOpts{URLs: []*url.URL{u}}
+	remote.Websocket.Compression = compression
+	o.LeafNode.Remotes = []*RemoteLeafOpts{remote}
+	return o
+}
+
+func TestLeafNodeWSBasic(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		compression bool
+	}{
+		{"no compression", false},
+		{"compression", true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			oh := testLeafNodeWSHubOptions()
+			oh.Websocket.Compression = test.compression
+			hub := RunServer(oh)
+			defer hub.Shutdown()
+
+			ol := testLeafNodeWSSpokeOptions(t, oh, test.compression)
+			ln := RunServer(ol)
+			defer ln.Shutdown()
+
+			checkLeafNodeConnected(t, hub)
+			checkLeafNodeConnected(t, ln)
+
+			var ws *websocket
+			hub.mu.Lock()
+			for _, l := range hub.leafs {
+				ws = l.ws
+			}
+			hub.mu.Unlock()
+			if ws == nil || ws.maskwrite || ws.compress != test.compression {
+				t.Fatalf("Unexpected websocket state on hub: %+v", ws)
+			}
+
+			ncHub := natsConnect(t, hub.ClientURL())
+			defer ncHub.Close()
+			subHub := natsSubSync(t, ncHub, "foo")
+			natsFlush(t, ncHub)
+
+			ncLN := natsConnect(t, ln.ClientURL())
+			defer ncLN.Close()
+			subLN := natsSubSync(t, ncLN, "bar")
+			natsFlush(t, ncLN)
+
+			checkSubInterest(t, ln, globalAccountName, "foo", time.Second)
+			checkSubInterest(t, hub, globalAccountName, "bar", time.Second)
+
+			// Use a message large enough to need an extended payload length.
+			big := make([]byte, 100*1024)
+			for i := range big {
+				big[i] = byte('a' + i%26)
+			}
+			for _, payload := range [][]byte{[]byte("hello"), big} {
+				natsPub(t, ncLN, "foo", payload)
+				if msg := natsNexMsg(t, subHub, time.Second); string(msg.Data) != string(payload) {
+					t.Fatalf("Unexpected message of %v bytes on hub", len(msg.Data))
+				}
+				natsPub(t, ncHub, "bar", payload)
+				if msg := natsNexMsg(t, subLN, time.Second); string(msg.Data) != string(payload) {
+					t.Fatalf("Unexpected message of %v bytes on leafnode", len(msg.Data))
+				}
+			}
+		})
+	}
+}
+
+func TestLeafNodeWSNotAccepted(t *testing.T) {
+	oh := testLeafNodeWSHubOptions()
+	oh.LeafNode.Port = 0
+	hub := RunServer(oh)
+	defer hub.Shutdown()
+
+	ol := testLeafNodeWSSpokeOptions(t, oh, false)
+	ol.LeafNode.ReconnectInterval = 50 * time.Millisecond
+	ln := RunServer(ol)
+	defer ln.Shutdown()
+
+	time.Sleep(250 * time.Millisecond)
+	if n := ln.NumLeafNodes(); n != 0 {
+		t.Fatalf("Expected no leafnode, got %v", n)
+	}
+}
+
+func TestLeafNodeWSVerifyAndPinnedCerts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pinned")
+	if err != nil {
+		t.Fatalf("Error creating dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newOCSPTestCA(t)
+	caFile := ca.writeCert(t, dir)
+	_, hubCertFile, hubKeyFile := ca.issue(t, dir, 2, _EMPTY_, _EMPTY_, false)
+	leafCert, leafCertFile, leafKeyFile := ca.issue(t, dir, 3, _EMPTY_, _EMPTY_, false)
+	_, otherCertFile, otherKeyFile := ca.issue(t, dir, 4, _EMPTY_, _EMPTY_, false)
+
+	genTLSConfig := func(tc *TLSConfigOpts) *tls.Config {
+		t.Helper()
+		config, err := GenTLSConfig(tc)
+		if err != nil {
+			t.Fatalf("Error generating tls config: %v", err)
+		}
+		return config
+	}
+	for _, test := range []struct {
+		name      string
+		wsVerify  bool
+		certFile  string
+		keyFile   string
+		connected bool
+	}{
+		{"pinned", true, leafCertFile, leafKeyFile, true},
+		{"not pinned", true, otherCertFile, otherKeyFile, false},
+		// The websocket listener does not ask for a certificate.
+		{"no certificate", false, leafCertFile, leafKeyFile, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			oh := testLeafNodeWSHubOptions()
+			oh.Websocket.NoTLS = false
+			oh.Websocket.TLSConfig = genTLSConfig(&TLSConfigOpts{CertFile: hubCertFile, KeyFile: hubKeyFile,
+				CaFile: caFile, Verify: test.wsVerify})
+			oh.LeafNode.TLSConfig = genTLSConfig(&TLSConfigOpts{CertFile: hubCertFile, KeyFile: hubKeyFile,
+				CaFile: caFile, Verify: true})
+			oh.LeafNode.TLSPinnedCerts = PinnedCertSet{pinnedCertFingerprint(leafCert): {}}
+			hub := RunServer(oh)
+			defer hub.Shutdown()
+
+			ol := testLeafNodeWSSpokeOptions(t, oh, false)
+			ol.LeafNode.ReconnectInterval = 50 * time.Millisecond
+			remote := ol.LeafNode.Remotes[0]
+			remote.URLs[0].Scheme = "wss"
+			remote.TLSConfig = genTLSConfig(&TLSConfigOpts{CertFile: test.certFile, KeyFile: test.keyFile, CaFile: caFile})
+			remote.TLSConfig.RootCAs = remote.TLSConfig.ClientCAs
+			ln := RunServer(ol)
+			defer ln.Shutdown()
+
+			if test.connected {
+				checkLeafNodeConnected(t, hub)
+				return
+			}
+			time.Sleep(250 * time.Millisecond)
+			if n := hub.NumLeafNodes(); n != 0 {
+				t.Fatalf("Expected no leafnode, got %v", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,252 @@
+// Copyright 2020 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// An additional client listener. Its options can't be changed by a
+// configuration reload, but its users are bound again to the reloaded
+// accounts.
+type clientListener struct {
+	idx      int          // Index of the listener in Options.Listeners.
+	name     string       // Immutable.
+	listener net.Listener // Set once listening, protected by the server lock.
+	numConns int          // Number of registered clients, protected by the server lock.
+
+	// Authorization, protected by the server lock.
+	users        map[string]*User
+	nkeys        map[string]*NkeyUser
+	authOverride bool
+}
+
+// Returns the options of this listener.
+func (cl *clientListener) opts(o *Options) *ListenerOpts {
+	return o.Listeners[cl.idx]
+}
+
+// Checks the additional client listeners options.
+func validateListenersOptions(o *Options) error {
+	names := make(map[string]struct{}, len(o.Listeners))
+	for _, l := range o.Listeners {
+		if _, dup := names[l.Name]; dup {
+			return fmt.Errorf("duplicate client listener %q", l.Name)
+		}
+		names[l.Name] = struct{}{}
+		if l.Socket != _EMPTY_ {
+			if l.Port != 0 {
+				return fmt.Errorf("client listener %q can't have both a port and a socket", l.Name)
+			}
+		} else if l.Port == 0 {
+			return fmt.Errorf("client listener %q requires a port or a socket", l.Name)
+		}
+		if l.MaxConn < 0 {
+			return fmt.Errorf("client listener %q max connections can't be negative", l.Name)
+		}
+		// If there is a NoAuthUser, we need to have Users defined and
+		// the user to be present.
+		if l.NoAuthUser != _EMPTY_ {
+			if l.Users == nil {
+				return fmt.Errorf("client listener %q no_auth_user %q configured, but users are not", l.Name, l.NoAuthUser)
+			}
+			found := false
+			for _, u := range l.Users {
+				if u.Username == l.NoAuthUser {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return fmt.Errorf("client listener %q no_auth_user %q not found in users configuration", l.Name, l.NoAuthUser)
+			}
+		}
+	}
+	return nil
+}
+
+// Creates the additional client listeners, which are started by the AcceptLoop.
+func newClientListeners(o *Options) []*clientListener {
+	if len(o.Listeners) == 0 {
+		return nil
+	}
+	listeners := make([]*clientListener, len(o.Listeners))
+	for i, l := range o.Listeners {
+		listeners[i] = &clientListener{idx: i, name: l.Name}
+	}
+	return listeners
+}
+
+// Build the users and nkeys maps of the additional client listeners,
+// and update a boolean that indicates if they override the server's
+// authorization. Same logic than for websocket clients.
+// Server lock is held on entry.
+func (s *Server) listenersConfigAuth(o *Options) {
+	for _, cl := range s.listeners {
+		lo := cl.opts(o)
+		if len(lo.Nkeys) > 0 || len(lo.Users) > 0 {
+			cl.nkeys, cl.users = s.buildNkeysAndUsersFromOptions(lo.Nkeys, lo.Users)
+			cl.authOverride = true
+		} else if lo.Username != _EMPTY_ || lo.Token != _EMPTY_ {
+			cl.authOverride = true
+		} else {
+			cl.users = nil
+			cl.nkeys = nil
+			cl.authOverride = false
+		}
+	}
+}
+
+// Starts the additional client listeners. On error, the server is
+// stopped with a fatal error and false is returned.
+// Server lock is held on entry.
+func (s *Server) startClientListeners(o *Options) bool {
+	for _, cl := range s.listeners {
+		lo := cl.opts(o)
+		var l net.Listener
+		var err error
+		if lo.Socket != _EMPTY_ {
+			if err = removeStaleUnixSocket(lo.Socket); err == nil {
+				l, err = net.Listen("unix", lo.Socket)
+			}
+		} else {
+			port := lo.Port
+			if port == RANDOM_PORT {
+				port = 0
+			}
+			l, err = natsListen("tcp", net.JoinHostPort(lo.Host, strconv.Itoa(port)))
+		}
+		if err != nil {
+			s.Fatalf("Error listening for client connections on listener %q: %v", cl.name, err)
+			return false
+		}
+		if lo.Socket != _EMPTY_ {
+			s.Noticef("Listening for client connections on unix socket %s (listener %q)", lo.Socket, cl.name)
+		} else {
+			// Write resolved port back to options.
+			if lo.Port == RANDOM_PORT {
+				lo.Port = l.Addr().(*net.TCPAddr).Port
+			}
+			s.Noticef("Listening for client connections on %s (listener %q)",
+				net.JoinHostPort(lo.Host, strconv.Itoa(lo.Port)), cl.name)
+		}
+		if lo.TLSConfig != nil {
+			s.Noticef("TLS required for client connections on listener %q", cl.name)
+		}
+		cl.listener = l
+		cl := cl
+		go s.acceptConnections(l, "Client listener "+strconv.Quote(cl.name), func(conn net.Conn) {
+			s.createClientFromListener(conn, nil, cl)
+		},
+			func(_ error) bool {
+				if s.isLameDuckMode() {
+					// Signal that we are not accepting new clients
+					s.ldmCh <- true
+					// Now wait for the Shutdown...
+					<-s.quitCh
+					return true
+				}
+				return false
+			})
+	}
+	return true
+}
+
+// Removes the socket file left behind by a server that did not shut down
+// cleanly. An error is returned if the socket is in use or if the path
+// exists and is not a socket.
+func removeStaleUnixSocket(path string) error {
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%q exists and is not a unix socket", path)
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("unix socket %q is already in use", path)
+	}
+	return os.Remove(path)
+}
+
+// Closes the additional client listeners and returns how many were
+// closed, so that the caller can wait for their accept loops.
+// Server lock is held on entry.
+func (s *Server) closeClientListeners() int {
+	closed := 0
+	for _, cl := range s.listeners {
+		if cl.listener != nil {
+			closed++
+			cl.listener.Close()
+			cl.listener = nil
+		}
+	}
+	return closed
+}
+
+// Returns the URLs of the additional client listeners, keyed by name.
+// Server lock is held on entry.
+func (s *Server) clientListenersURLs(o *Options) map[string][]string {
+	var urls map[string][]string
+	for _, cl := range s.listeners {
+		if cl.listener == nil {
+			continue
+		}
+		if urls == nil {
+			urls = make(map[string][]string, len(s.listeners))
+		}
+		lo := cl.opts(o)
+		if lo.Socket != _EMPTY_ {
+			urls[cl.name] = []string{"unix://" + lo.Socket}
+			continue
+		}
+		proto := "nats"
+		if lo.TLSConfig != nil {
+			proto = "tls"
+		}
+		urls[cl.name] = formatURL(proto, cl.listener)
+	}
+	return urls
+}
+
+// Returns the monitoring information of the additional client listeners.
+// Server lock is held on entry.
+func (s *Server) clientListenersVarz(o *Options) []ListenerVarz {
+	if len(s.listeners) == 0 {
+		return nil
+	}
+	lv := make([]ListenerVarz, len(s.listeners))
+	for i, cl := range s.listeners {
+		lo := cl.opts(o)
+		lv[i] = ListenerVarz{
+			Name:         cl.name,
+			Host:         lo.Host,
+			Port:         lo.Port,
+			Socket:       lo.Socket,
+			AuthRequired: s.info.AuthRequired || cl.authOverride,
+			TLSRequired:  lo.TLSConfig != nil,
+			TLSVerify:    lo.TLSConfig != nil && lo.TLSConfig.ClientAuth == tls.RequireAndVerifyClientCert,
+			MaxConn:      lo.MaxConn,
+			Connections:  cl.numConns,
+		}
+	}
+	return lv
+}
@@ -0,0 +1,474 @@
+// Copyright 2020 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yanzongzhen/nats.go"
+)
+
+func testListenerURL(o *ListenerOpts) string {
+	return fmt.Sprintf("nats://%s:%d", o.Host, o.Port)
+}
+
+// Dials the unix socket whatever the URL given to the client.
+type testUnixDialer struct {
+	path string
+}
+
+func (d *testUnixDialer) Dial(_, _ string) (net.Conn, error) {
+	return net.Dial("unix", d.path)
+}
+
+func TestListenersParseConfig(t *testing.T) {
+	conf := createConfFile(t, []byte(`
+		listen: "127.0.0.1:-1"
+		listeners [
+			{
+				name: "public"
+				listen: "127.0.0.1:-1"
+				max_connections: 10
+				tls {
+					cert_file: "../test/configs/certs/server-cert.pem"
+					key_file: "../test/configs/certs/server-key.pem"
+					ca_file: "../test/configs/certs/ca.pem"
+					timeout: 3
+					verify_and_map: true
+				}
+			}
+			{
+				socket: "/tmp/nats.sock"
+				no_auth_user: "svc"
+				authorization {
+					users [
+						{user: "svc", password: "pwd"}
+						{user: "admin", password: "pwd"}
+					]
+					timeout: 4
+				}
+			}
+		]
+	`))
+	defer os.Remove(conf)
+	o, err := ProcessConfigFile(conf)
+	if err != nil {
+		t.Fatalf("Error processing config file: %v", err)
+	}
+	if n := len(o.Listeners); n != 2 {
+		t.Fatalf("Expected 2 listeners, got %v", n)
+	}
+	setBaselineOptions(o)
+
+	pub := o.Listeners[0]
+	if pub.Name != "public" || pub.Host != "127.0.0.1" || pub.Port != -1 || pub.Socket != "" {
+		t.Fatalf("Unexpected public listener: %+v", pub)
+	}
+	if pub.MaxConn != 10 {
+		t.Fatalf("Expected max connections to be 10, got %v", pub.MaxConn)
+	}
+	if pub.TLSConfig == nil || pub.TLSTimeout != 3 || !pub.TLSMap {
+		t.Fatalf("Unexpected TLS settings: %+v", pub)
+	}
+	if pub.TLSConfig.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Fatalf("Expected client certificates to be verified, got %v", pub.TLSConfig.ClientAuth)
+	}
+
+	sock := o.Listeners[1]
+	if sock.Name != "/tmp/nats.sock" || sock.Socket != "/tmp/nats.sock" || sock.Host != "" || sock.Port != 0 {
+		t.Fatalf("Unexpected socket listener: %+v", sock)
+	}
+	if sock.NoAuthUser != "svc" || len(sock.Users) != 2 || sock.AuthTimeout != 4 {
+		t.Fatalf("Unexpected socket listener authorization: %+v", sock)
+	}
+	if sock.TLSConfig != nil || sock.TLSTimeout != float64(TLS_TIMEOUT)/float64(time.Second) {
+		t.Fatalf("Unexpected socket listener TLS settings: %+v", sock)
+	}
+
+	for _, test := range []struct {
+		name   string
+		config string
+		err    string
+	}{
+		{"not an array", `listeners: {port: -1}`, "Expected listeners to be an array"},
+		{"not a map", `listeners: [-1]`, "Expected listener entry to be a map/struct"},
+		{"unknown field", `listeners: [{port: -1, foo: bar}]`, "unknown field"},
+		{"pinned certs", `listeners: [{
+			port: -1
+			tls {
+				cert_file: "../test/configs/certs/server-cert.pem"
+				key_file: "../test/configs/certs/server-key.pem"
+				pinned_certs: ["a8c5ed3d3c8bb8f2d3a3ea2b4d3e2e0fa1e8aa5b76cd7d4cd6d0c6a3c0e1b4a1"]
+			}
+		}]`, "pinned_certs is only supported"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			conf := createConfFile(t, []byte(test.config))
+			defer os.Remove(conf)
+			if _, err := ProcessConfigFile(conf); err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("Expected error about %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestListenersValidateOptions(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		listeners []*ListenerOpts
+		err       string
+	}{
+		{"no port", []*ListenerOpts{{Name: "a"}}, "requires a port or a socket"},
+		{"port and socket", []*ListenerOpts{{Name: "a", Port: -1, Socket: "/tmp/nats.sock"}}, "both a port and a socket"},
+		{"duplicate", []*ListenerOpts{{Name: "a", Port: -1}, {Name: "a", Port: -1}}, "duplicate"},
+		{"negative max conn", []*ListenerOpts{{Name: "a", Port: -1, MaxConn: -1}}, "can't be negative"},
+		{"no users", []*ListenerOpts{{Name: "a", Port: -1, NoAuthUser: "user"}}, "users are not"},
+		{"no auth user not found", []*ListenerOpts{{Name: "a", Port: -1, NoAuthUser: "notfound",
+			Users: []*User{{Username: "user", Password: "pwd"}}}}, "not found"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			o := DefaultOptions()
+			// The no_auth_user of a listener has to be one of its own users.
+			o.Users = []*User{{Username: "user", Password: "pwd"}}
+			o.Listeners = test.listeners
+			if _, err := NewServer(o); err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("Expected error about %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestListenersAuthorization(t *testing.T) {
+	o := DefaultOptions()
+	normalAcc := NewAccount("normal")
+	svcAcc := NewAccount("svc")
+	o.Accounts = []*Account{normalAcc, svcAcc}
+	o.Users = []*User{{Username: "user", Password: "pwd", Account: normalAcc}}
+	o.Listeners = []*ListenerOpts{
+		// Uses the server's authorization.
+		{Name: "same", Host: "127.0.0.1", Port: -1},
+		// Has its own users, with a default one.
+		{Name: "internal", Host: "127.0.0.1", Port: -1, NoAuthUser: "svc", Users: []*User{
+			{Username: "svc", Password: "pwd", Account: svcAcc},
+			{Username: "admin", Password: "pwd", Account: svcAcc},
+		}},
+	}
+	s := RunServer(o)
+	defer s.Shutdown()
+
+	same, internal := o.Listeners[0], o.Listeners[1]
+
+	checkUser := func(t *testing.T, nc *nats.Conn, user, acc string) {
+		t.Helper()
+		cid, err := nc.GetClientID()
+		if err != nil {
+			t.Fatalf("Error getting client id: %v", err)
+		}
+		c := s.getClient(cid)
+		if c == nil {
+			t.Fatalf("Client %v not found", cid)
+		}
+		c.mu.Lock()
+		uname := c.opts.Username
+		aname := c.acc.GetName()
+		c.mu.Unlock()
+		if uname != user || aname != acc {
+			t.Fatalf("Expected user %q in account %q, got %q in %q", user, acc, uname, aname)
+		}
+	}
+
+	// The main listener and the listener without override require
+	// the server's users.
+	for _, url := range []string{fmt.Sprintf("nats://127.0.0.1:%d", o.Port), testListenerURL(same)} {
+		if nc, err := nats.Connect(url); err == nil {
+			nc.Close()
+			t.Fatalf("Expected connection to %s to fail", url)
+		}
+		nc, err := nats.Connect(url, nats.UserInfo("user", "pwd"))
+		if err != nil {
+			t.Fatalf("Error on connect to %s: %v", url, err)
+		}
+		checkUser(t, nc, "user", "normal")
+		nc.Close()
+	}
+
+	// The listener with its own users does not accept the server's ones.
+	if nc, err := nats.Connect(testListenerURL(internal), nats.UserInfo("user", "pwd")); err == nil {
+		nc.Close()
+		t.Fatal("Expected connection with server user to fail")
+	}
+	nc, err := nats.Connect(testListenerURL(internal), nats.UserInfo("admin", "pwd"))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	checkUser(t, nc, "admin", "svc")
+	nc.Close()
+
+	// Without credentials, the listener's no auth user is used.
+	nc, err = nats.Connect(testListenerURL(internal))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer nc.Close()
+	checkUser(t, nc, "svc", "svc")
+
+	// But the listener's users are not accepted by the main listener.
+	if nc, err := nats.Connect(fmt.Sprintf("nats://127.0.0.1:%d", o.Port), nats.UserInfo("admin", "pwd")); err == nil {
+		nc.Close()
+		t.Fatal("Expected connection with listener user to fail")
+	}
+}
+
+func TestListenersMaxConn(t *testing.T) {
+	o := DefaultOptions()
+	o.Listeners = []*ListenerOpts{{Name: "limited", Host: "127.0.0.1", Port: -1, MaxConn: 1}}
+	s := RunServer(o)
+	defer s.Shutdown()
+
+	url := testListenerURL(o.Listeners[0])
+	nc, err := nats.Connect(url)
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	if nc2, err := nats.Connect(url); err == nil {
+		nc2.Close()
+		t.Fatal("Expected connection to be rejected")
+	}
+
+	// The limit does not apply to the main listener.
+	nc2, err := nats.Connect(fmt.Sprintf("nats://127.0.0.1:%d", o.Port))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer nc2.Close()
+
+	// Once the client is gone, a new one can connect.
+	nc.Close()
+	checkFor(t, 2*time.Second, 15*time.Millisecond, func() error {
+		nc, err := nats.Connect(url)
+		if err != nil {
+			return err
+		}
+		nc.Close()
+		return nil
+	})
+}
+
+func TestListenersTLS(t *testing.T) {
+	tc := &TLSConfigOpts{
+		CertFile: "../test/configs/certs/server-cert.pem",
+		KeyFile:  "../test/configs/certs/server-key.pem",
+		CaFile:   "../test/configs/certs/ca.pem",
+	}
+	tlsConfig, err := GenTLSConfig(tc)
+	if err != nil {
+		t.Fatalf("Error generating tls config: %v", err)
+	}
+	o := DefaultOptions()
+	o.Listeners = []*ListenerOpts{{Name: "secure", Host: "127.0.0.1", Port: -1, TLSConfig: tlsConfig, TLSTimeout: 2}}
+	s := RunServer(o)
+	defer s.Shutdown()
+
+	url := testListenerURL(o.Listeners[0])
+	if nc, err := nats.Connect(url); err == nil {
+		nc.Close()
+		t.Fatal("Expected plain connection to TLS listener to fail")
+	}
+	nc, err := nats.Connect(url, nats.Secure(&tls.Config{InsecureSkipVerify: true}))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer nc.Close()
+	if !nc.TLSRequired() {
+		t.Fatal("Expected TLS to be required")
+	}
+
+	// The main listener is still plain.
+	nc2, err := nats.Connect(fmt.Sprintf("nats://127.0.0.1:%d", o.Port))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer nc2.Close()
+	if nc2.TLSRequired() {
+		t.Fatal("Expected TLS not to be required")
+	}
+
+	// Clients of both listeners can exchange messages.
+	sub := natsSubSync(t, nc, "foo")
+	natsFlush(t, nc)
+	natsPub(t, nc2, "foo", []byte("hello"))
+	natsNexMsg(t, sub, time.Second)
+}
+
+func TestListenersUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nats")
+	if err != nil {
+		t.Fatalf("Error creating dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "nats.sock")
+
+	o := DefaultOptions()
+	o.Listeners = []*ListenerOpts{{Name: "local", Socket: path}}
+	s := RunServer(o)
+
+	nc, err := nats.Connect("nats://localhost:4222", nats.SetCustomDialer(&testUnixDialer{path}), nats.NoReconnect())
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer nc.Close()
+	sub := natsSubSync(t, nc, "foo")
+	natsPub(t, nc, "foo", []byte("hello"))
+	natsNexMsg(t, sub, time.Second)
+
+	// The socket file is removed when the server is shut down.
+	s.Shutdown()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("Expected socket file to be removed, got %v", err)
+	}
+}
+
+func TestListenersUnixSocketStale(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nats")
+	if err != nil {
+		t.Fatalf("Error creating dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "nats.sock")
+
+	// Leave a socket file behind, as a server that crashed would.
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+
+	o := DefaultOptions()
+	o.Listeners = []*ListenerOpts{{Name: "local", Socket: path}}
+	s := RunServer(o)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect("nats://localhost:4222", nats.SetCustomDialer(&testUnixDialer{path}), nats.NoReconnect())
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	nc.Close()
+
+	// A socket in use or a file that is not a socket is not removed.
+	if err := removeStaleUnixSocket(path); err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Fatalf("Expected error about socket in use, got %v", err)
+	}
+	file := filepath.Join(dir, "nats.file")
+	if err := ioutil.WriteFile(file, []byte("x"), 0600); err != nil {
+		t.Fatalf("Error writing file: %v", err)
+	}
+	if err := removeStaleUnixSocket(file); err == nil || !strings.Contains(err.Error(), "not a unix socket") {
+		t.Fatalf("Expected error about file not being a socket, got %v", err)
+	}
+	if _, err := os.Stat(file); err != nil {
+		t.Fatalf("Expected file to still exist, got %v", err)
+	}
+}
+
+func TestListenersMonitoring(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nats")
+	if err != nil {
+		t.Fatalf("Error creating dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "nats.sock")
+
+	o := DefaultOptions()
+	o.Listeners = []*ListenerOpts{
+		{Name: "internal", Host: "127.0.0.1", Port: -1, MaxConn: 5, Users: []*User{{Username: "svc", Password: "pwd"}}},
+		{Name: "local", Socket: path},
+	}
+	s := RunServer(o)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(testListenerURL(o.Listeners[0]), nats.UserInfo("svc", "pwd"))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer nc.Close()
+
+	v, err := s.Varz(nil)
+	if err != nil {
+		t.Fatalf("Error on varz: %v", err)
+	}
+	if n := len(v.Listeners); n != 2 {
+		t.Fatalf("Expected 2 listeners, got %v", n)
+	}
+	expected := []ListenerVarz{
+		{Name: "internal", Host: "127.0.0.1", Port: o.Listeners[0].Port, AuthRequired: true, MaxConn: 5, Connections: 1},
+		{Name: "local", Socket: path},
+	}
+	for i, lv := range v.Listeners {
+		if lv != expected[i] {
+			t.Fatalf("Expected listener %+v, got %+v", expected[i], lv)
+		}
+	}
+	if v.Connections != 1 {
+		t.Fatalf("Expected 1 connection, got %v", v.Connections)
+	}
+
+	ports := s.PortsInfo(time.Second)
+	if ports == nil {
+		t.Fatal("Expected ports info")
+	}
+	if len(ports.Nats) != 1 {
+		t.Fatalf("Unexpected nats URLs: %v", ports.Nats)
+	}
+	if urls := ports.Listeners["internal"]; len(urls) != 1 || urls[0] != testListenerURL(o.Listeners[0]) {
+		t.Fatalf("Unexpected internal listener URLs: %v", urls)
+	}
+	if urls := ports.Listeners["local"]; len(urls) != 1 || urls[0] != "unix://"+path {
+		t.Fatalf("Unexpected local listener URLs: %v", urls)
+	}
+}
+
+func TestListenersConfigReload(t *testing.T) {
+	template := `
+		listen: "127.0.0.1:-1"
+		listeners [
+			{name: "internal", listen: "127.0.0.1:-1", max_connections: %d}
+		]
+	`
+	conf := createConfFile(t, []byte(fmt.Sprintf(template, 10)))
+	defer os.Remove(conf)
+	s, o := RunServerWithConfig(conf)
+	defer s.Shutdown()
+
+	// The random port of the listener is kept on reload.
+	if err := s.Reload(); err != nil {
+		t.Fatalf("Error on reload: %v", err)
+	}
+	if port := s.getOpts().Listeners[0].Port; port != o.Listeners[0].Port {
+		t.Fatalf("Expected port %v, got %v", o.Listeners[0].Port, port)
+	}
+
+	changeCurrentConfigContentWithNewContent(t, conf, []byte(fmt.Sprintf(template, 20)))
+	if err := s.Reload(); err == nil || !strings.Contains(err.Error(), "not supported for Listeners") {
+		t.Fatalf("Expected reload to fail, got %v", err)
+	}
+}
@@ -82,6 +82,10 @@ func (ms *memStore) UpdateConfig(cfg *StreamConfig) error {
 // Store stores a message.
 func (ms *memStore) StoreMsg(subj string, hdr, msg []byte) (uint64, int64, error) {
 	ms.mu.Lock()
+	if ms.msgs == nil {
+		ms.mu.Unlock()
+		return 0, 0, ErrStoreClosed
+	}
 
 	// Check if we are discarding new messages when we reach the limit.
 	if ms.cfg.Discard == DiscardNew {
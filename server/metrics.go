@@ -0,0 +1,423 @@
+// Copyright 2020 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// OpenMetricsContentType is the content type of the /metrics endpoint.
+const OpenMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// Metric types in the OpenMetrics text format.
+const (
+	metricGauge     = "gauge"
+	metricCounter   = "counter"
+	metricInfo      = "info"
+	metricHistogram = "histogram"
+)
+
+// Writes metric families in the OpenMetrics text format. Every sample
+// carries the server_id label.
+type metricsWriter struct {
+	buf      bytes.Buffer
+	serverID string
+}
+
+var metricsLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func (w *metricsWriter) family(name, typ, help string) {
+	w.buf.WriteString("# TYPE " + name + " " + typ + "\n")
+	w.buf.WriteString("# HELP " + name + " " + help + "\n")
+}
+
+// Labels are given as name and value pairs.
+func (w *metricsWriter) sample(name string, value float64, labels ...string) {
+	w.buf.WriteString(name)
+	w.buf.WriteString(`{server_id="`)
+	w.buf.WriteString(metricsLabelEscaper.Replace(w.serverID))
+	w.buf.WriteByte('"')
+	for i := 0; i+1 < len(labels); i += 2 {
+		w.buf.WriteString("," + labels[i] + `="`)
+		w.buf.WriteString(metricsLabelEscaper.Replace(labels[i+1]))
+		w.buf.WriteByte('"')
+	}
+	w.buf.WriteString("} ")
+	w.buf.WriteString(strconv.FormatFloat(value, 'f', -1, 64))
+	w.buf.WriteByte('\n')
+}
+
+// A family with a single gauge sample.
+func (w *metricsWriter) gauge(name, help string, value float64, labels ...string) {
+	w.family(name, metricGauge, help)
+	w.sample(name, value, labels...)
+}
+
+// A family with a single counter sample.
+func (w *metricsWriter) counter(name, help string, value float64, labels ...string) {
+	w.family(name, metricCounter, help)
+	w.sample(name+"_total", value, labels...)
+}
+
+// Name of the sample for a metric of the given type.
+func metricSampleName(name, typ string) string {
+	switch typ {
+	case metricCounter:
+		return name + "_total"
+	case metricInfo:
+		return name + "_info"
+	}
+	return name
+}
+
+// Metrics gathers server, route, gateway, leafnode, account and JetStream
+// metrics in the OpenMetrics text format.
+func (s *Server) Metrics() ([]byte, error) {
+	v, err := s.Varz(nil)
+	if err != nil {
+		return nil, err
+	}
+	w := &metricsWriter{serverID: v.ID}
+
+	s.serverMetrics(w, v)
+	if err := s.routeMetrics(w); err != nil {
+		return nil, err
+	}
+	if err := s.gatewayMetrics(w); err != nil {
+		return nil, err
+	}
+	if err := s.leafMetrics(w); err != nil {
+		return nil, err
+	}
+	s.accountMetrics(w)
+	if err := s.jetStreamMetrics(w); err != nil {
+		return nil, err
+	}
+
+	w.buf.WriteString("# EOF\n")
+	return w.buf.Bytes(), nil
+}
+
+func (s *Server) serverMetrics(w *metricsWriter, v *Varz) {
+	w.family("nats_server", metricInfo, "Information about the server.")
+	w.sample("nats_server_info", 1, "server_name", v.Name, "version", v.Version, "go", v.GoVersion)
+	w.gauge("nats_server_start_time_seconds", "Time the server was started in seconds since the epoch.", float64(v.Start.UnixNano())/1e9)
+	w.gauge("nats_server_mem_bytes", "Resident memory of the server process.", float64(v.Mem))
+	w.gauge("nats_server_cpu_percent", "CPU usage of the server process.", v.CPU)
+	w.gauge("nats_server_cores", "Number of cores available.", float64(v.Cores))
+	w.gauge("nats_server_max_connections", "Maximum number of client connections allowed.", float64(v.MaxConn))
+	w.gauge("nats_server_max_payload_bytes", "Maximum message payload allowed.", float64(v.MaxPayload))
+	w.gauge("nats_server_connections", "Current number of client connections.", float64(v.Connections))
+	w.counter("nats_server_accepted_connections", "Client connections accepted since the server started.", float64(v.TotalConnections))
+	w.gauge("nats_server_routes", "Current number of routes.", float64(v.Routes))
+	w.gauge("nats_server_remotes", "Current number of remote servers.", float64(v.Remotes))
+	w.gauge("nats_server_leafnodes", "Current number of leafnode connections.", float64(v.Leafs))
+	w.gauge("nats_server_subscriptions", "Current number of subscriptions.", float64(v.Subscriptions))
+	w.counter("nats_server_in_msgs", "Messages received by the server.", float64(v.InMsgs))
+	w.counter("nats_server_out_msgs", "Messages sent by the server.", float64(v.OutMsgs))
+	w.counter("nats_server_in_bytes", "Bytes received by the server.", float64(v.InBytes))
+	w.counter("nats_server_out_bytes", "Bytes sent by the server.", float64(v.OutBytes))
+	w.counter("nats_server_slow_consumers", "Slow consumers detected by the server.", float64(v.SlowConsumers))
+}
+
+// Metrics common to route, gateway and leafnode connections.
+var connMetrics = []struct {
+	name, typ, help string
+	value           func(ci *ConnInfo) float64
+}{
+	{"in_msgs", metricCounter, "Messages received on the connection.", func(ci *ConnInfo) float64 { return float64(ci.InMsgs) }},
+	{"out_msgs", metricCounter, "Messages sent on the connection.", func(ci *ConnInfo) float64 { return float64(ci.OutMsgs) }},
+	{"in_bytes", metricCounter, "Bytes received on the connection.", func(ci *ConnInfo) float64 { return float64(ci.InBytes) }},
+	{"out_bytes", metricCounter, "Bytes sent on the connection.", func(ci *ConnInfo) float64 { return float64(ci.OutBytes) }},
+	{"pending_bytes", metricGauge, "Bytes pending to be sent on the connection.", func(ci *ConnInfo) float64 { return float64(ci.Pending) }},
+	{"subscriptions", metricGauge, "Subscriptions known on the connection.", func(ci *ConnInfo) float64 { return float64(ci.NumSubs) }},
+}
+
+type labeledConn struct {
+	labels []string
+	ci     *ConnInfo
+}
+
+func writeConnMetrics(w *metricsWriter, prefix string, conns []labeledConn) {
+	for _, m := range connMetrics {
+		name := prefix + "_" + m.name
+		w.family(name, m.typ, m.help)
+		for _, lc := range conns {
+			w.sample(metricSampleName(name, m.typ), m.value(lc.ci), lc.labels...)
+		}
+	}
+}
+
+func (s *Server) routeMetrics(w *metricsWriter) error {
+	rz, err := s.Routez(nil)
+	if err != nil {
+		return err
+	}
+	conns := make([]labeledConn, 0, len(rz.Routes))
+	for _, r := range rz.Routes {
+		ci := &ConnInfo{
+			Pending:  r.Pending,
+			InMsgs:   r.InMsgs,
+			OutMsgs:  r.OutMsgs,
+			InBytes:  r.InBytes,
+			OutBytes: r.OutBytes,
+			NumSubs:  r.NumSubs,
+		}
+		labels := []string{"route_id", strconv.FormatUint(r.Rid, 10), "remote_id", r.RemoteID}
+		conns = append(conns, labeledConn{labels, ci})
+	}
+	writeConnMetrics(w, "nats_route", conns)
+	return nil
+}
+
+func (s *Server) gatewayMetrics(w *metricsWriter) error {
+	gz, err := s.Gatewayz(nil)
+	if err != nil {
+		return err
+	}
+	var conns []labeledConn
+	names := make([]string, 0, len(gz.OutboundGateways))
+	for name := range gz.OutboundGateways {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if rgw := gz.OutboundGateways[name]; rgw.Connection != nil {
+			labels := []string{"gateway", name, "direction", "outbound", "cid", strconv.FormatUint(rgw.Connection.Cid, 10)}
+			conns = append(conns, labeledConn{labels, rgw.Connection})
+		}
+	}
+	names = names[:0]
+	for name := range gz.InboundGateways {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, rgw := range gz.InboundGateways[name] {
+			if rgw.Connection != nil {
+				labels := []string{"gateway", name, "direction", "inbound", "cid", strconv.FormatUint(rgw.Connection.Cid, 10)}
+				conns = append(conns, labeledConn{labels, rgw.Connection})
+			}
+		}
+	}
+	writeConnMetrics(w, "nats_gateway", conns)
+	return nil
+}
+
+func (s *Server) leafMetrics(w *metricsWriter) error {
+	lz, err := s.Leafz(nil)
+	if err != nil {
+		return err
+	}
+	conns := make([]labeledConn, 0, len(lz.Leafs))
+	for _, ln := range lz.Leafs {
+		ci := &ConnInfo{
+			InMsgs:   ln.InMsgs,
+			OutMsgs:  ln.OutMsgs,
+			InBytes:  ln.InBytes,
+			OutBytes: ln.OutBytes,
+			NumSubs:  ln.NumSubs,
+		}
+		labels := []string{"account", ln.Account, "remote", net.JoinHostPort(ln.IP, strconv.Itoa(ln.Port))}
+		conns = append(conns, labeledConn{labels, ci})
+	}
+	writeConnMetrics(w, "nats_leafnode", conns)
+	return nil
+}
+
+func (s *Server) accountMetrics(w *metricsWriter) {
+	var accs []*Account
+	s.accounts.Range(func(_, v interface{}) bool {
+		accs = append(accs, v.(*Account))
+		return true
+	})
+	sort.Slice(accs, func(i, j int) bool { return accs[i].Name < accs[j].Name })
+
+	metrics := []struct {
+		name, help string
+		value      func(a *Account) float64
+	}{
+		{"nats_account_connections", "Client connections for the account.", func(a *Account) float64 { return float64(a.NumLocalConnections()) }},
+		{"nats_account_leafnodes", "Leafnode connections for the account.", func(a *Account) float64 { return float64(a.NumLeafNodes()) }},
+		{"nats_account_subscriptions", "Subscriptions for the account.", func(a *Account) float64 { return float64(a.TotalSubs()) }},
+	}
+	for _, m := range metrics {
+		w.family(m.name, metricGauge, m.help)
+		for _, acc := range accs {
+			w.sample(m.name, m.value(acc), "account", acc.Name)
+		}
+	}
+
+	var jsAccs []*Account
+	for _, acc := range accs {
+		if acc.JetStreamEnabled() {
+			jsAccs = append(jsAccs, acc)
+		}
+	}
+	jsMetrics := []struct {
+		name, help string
+		value      func(stats *JetStreamAccountStats) float64
+	}{
+		{"nats_account_jetstream_memory_bytes", "JetStream memory used by the account.", func(st *JetStreamAccountStats) float64 { return float64(st.Memory) }},
+		{"nats_account_jetstream_storage_bytes", "JetStream storage used by the account.", func(st *JetStreamAccountStats) float64 { return float64(st.Store) }},
+		{"nats_account_jetstream_streams", "JetStream streams of the account.", func(st *JetStreamAccountStats) float64 { return float64(st.Streams) }},
+	}
+	stats := make([]JetStreamAccountStats, len(jsAccs))
+	for i, acc := range jsAccs {
+		stats[i] = acc.JetStreamUsage()
+	}
+	for _, m := range jsMetrics {
+		w.family(m.name, metricGauge, m.help)
+		for i, acc := range jsAccs {
+			w.sample(m.name, m.value(&stats[i]), "account", acc.Name)
+		}
+	}
+}
+
+func (s *Server) jetStreamMetrics(w *metricsWriter) error {
+	jsi, err := s.Jsz(&JSzOptions{Consumer: true})
+	if err != nil {
+		return err
+	}
+	// Account details are paged, collect all of them.
+	for len(jsi.AccountDetails) < jsi.Total {
+		page, err := s.Jsz(&JSzOptions{Consumer: true, Offset: len(jsi.AccountDetails)})
+		if err != nil {
+			return err
+		}
+		if len(page.AccountDetails) == 0 {
+			break
+		}
+		jsi.AccountDetails = append(jsi.AccountDetails, page.AccountDetails...)
+	}
+	enabled := 0.0
+	if !jsi.Disabled {
+		enabled = 1
+	}
+	w.gauge("nats_jetstream_enabled", "Whether JetStream is enabled.", enabled)
+	if jsi.Disabled {
+		return nil
+	}
+	w.gauge("nats_jetstream_max_memory_bytes", "Maximum memory for JetStream.", float64(jsi.Config.MaxMemory))
+	w.gauge("nats_jetstream_max_storage_bytes", "Maximum storage for JetStream.", float64(jsi.Config.MaxStore))
+	w.gauge("nats_jetstream_memory_bytes", "Memory used by JetStream.", float64(jsi.Memory))
+	w.gauge("nats_jetstream_storage_bytes", "Storage used by JetStream.", float64(jsi.Store))
+	w.gauge("nats_jetstream_reserved_memory_bytes", "Memory reserved for JetStream accounts.", float64(jsi.ReservedMemory))
+	w.gauge("nats_jetstream_reserved_storage_bytes", "Storage reserved for JetStream accounts.", float64(jsi.ReservedStore))
+	w.gauge("nats_jetstream_accounts", "JetStream enabled accounts.", float64(jsi.Accounts))
+	w.gauge("nats_jetstream_streams", "JetStream streams.", float64(jsi.Streams))
+	w.gauge("nats_jetstream_consumers", "JetStream consumers.", float64(jsi.Consumers))
+	w.gauge("nats_jetstream_messages", "Messages stored in JetStream streams.", float64(jsi.Messages))
+	w.gauge("nats_jetstream_bytes", "Bytes stored in JetStream streams.", float64(jsi.Bytes))
+
+	type labeledStream struct {
+		labels []string
+		sd     *StreamDetail
+	}
+	type labeledConsumer struct {
+		labels []string
+		ci     *ConsumerInfo
+	}
+	var streams []labeledStream
+	var consumers []labeledConsumer
+	for _, ad := range jsi.AccountDetails {
+		for _, sd := range ad.Streams {
+			streams = append(streams, labeledStream{[]string{"account", ad.Name, "stream", sd.Name}, sd})
+			for _, ci := range sd.Consumers {
+				consumers = append(consumers, labeledConsumer{[]string{"account", ad.Name, "stream", sd.Name, "consumer", ci.Name}, ci})
+			}
+		}
+	}
+
+	streamMetrics := []struct {
+		name, help string
+		value      func(sd *StreamDetail) float64
+	}{
+		{"nats_jetstream_stream_messages", "Messages stored in the stream.", func(sd *StreamDetail) float64 { return float64(sd.State.Msgs) }},
+		{"nats_jetstream_stream_bytes", "Bytes stored in the stream.", func(sd *StreamDetail) float64 { return float64(sd.State.Bytes) }},
+		{"nats_jetstream_stream_first_seq", "First sequence in the stream.", func(sd *StreamDetail) float64 { return float64(sd.State.FirstSeq) }},
+		{"nats_jetstream_stream_last_seq", "Last sequence in the stream.", func(sd *StreamDetail) float64 { return float64(sd.State.LastSeq) }},
+		{"nats_jetstream_stream_consumers", "Consumers of the stream.", func(sd *StreamDetail) float64 { return float64(len(sd.Consumers)) }},
+	}
+	for _, m := range streamMetrics {
+		w.family(m.name, metricGauge, m.help)
+		for _, ls := range streams {
+			w.sample(m.name, m.value(ls.sd), ls.labels...)
+		}
+	}
+
+	consumerMetrics := []struct {
+		name, typ, help string
+		value           func(ci *ConsumerInfo) float64
+	}{
+		{"nats_jetstream_consumer_num_pending", metricGauge, "Messages delivered and waiting for an ack.", func(ci *ConsumerInfo) float64 { return float64(ci.NumPending) }},
+		{"nats_jetstream_consumer_num_redelivered", metricGauge, "Messages pending that have been redelivered.", func(ci *ConsumerInfo) float64 { return float64(ci.NumRedelivered) }},
+		{"nats_jetstream_consumer_delivered_stream_seq", metricGauge, "Last stream sequence delivered.", func(ci *ConsumerInfo) float64 { return float64(ci.Delivered.StreamSeq) }},
+		{"nats_jetstream_consumer_ack_floor_stream_seq", metricGauge, "Stream sequence of the ack floor.", func(ci *ConsumerInfo) float64 { return float64(ci.AckFloor.StreamSeq) }},
+		{"nats_jetstream_consumer_delivered", metricCounter, "Messages delivered for the first time.", func(ci *ConsumerInfo) float64 { return float64(ci.Stats.Delivered) }},
+		{"nats_jetstream_consumer_redelivered", metricCounter, "Messages redelivered.", func(ci *ConsumerInfo) float64 { return float64(ci.Stats.Redelivered) }},
+		{"nats_jetstream_consumer_acked", metricCounter, "Messages acked.", func(ci *ConsumerInfo) float64 { return float64(ci.Stats.Acked) }},
+		{"nats_jetstream_consumer_naked", metricCounter, "Messages negatively acked.", func(ci *ConsumerInfo) float64 { return float64(ci.Stats.Naked) }},
+		{"nats_jetstream_consumer_terminated", metricCounter, "Messages terminated.", func(ci *ConsumerInfo) float64 { return float64(ci.Stats.Terminated) }},
+		{"nats_jetstream_consumer_expired", metricCounter, "Messages whose ack wait expired.", func(ci *ConsumerInfo) float64 { return float64(ci.Stats.Expired) }},
+		{"nats_jetstream_consumer_max_deliver_exceeded", metricCounter, "Messages that exceeded max deliveries.", func(ci *ConsumerInfo) float64 { return float64(ci.Stats.Exceeded) }},
+	}
+	for _, m := range consumerMetrics {
+		w.family(m.name, m.typ, m.help)
+		for _, lc := range consumers {
+			w.sample(metricSampleName(m.name, m.typ), m.value(lc.ci), lc.labels...)
+		}
+	}
+
+	name := "nats_jetstream_consumer_ack_latency_seconds"
+	w.family(name, metricHistogram, "Time from delivery to ack.")
+	for _, lc := range consumers {
+		// Report empty buckets until the first ack is sampled.
+		lat := lc.ci.Stats.AckLatency
+		if lat == nil {
+			lat = &LatencyHistogram{}
+			for _, le := range ackLatencyBounds {
+				lat.Buckets = append(lat.Buckets, LatencyBucket{LE: le})
+			}
+		}
+		for _, b := range lat.Buckets {
+			w.sample(name+"_bucket", float64(b.Count), append(lc.labels, "le", strconv.FormatFloat(b.LE.Seconds(), 'f', -1, 64))...)
+		}
+		w.sample(name+"_bucket", float64(lat.Count), append(lc.labels, "le", "+Inf")...)
+		w.sample(name+"_count", float64(lat.Count), lc.labels...)
+		w.sample(name+"_sum", lat.Sum.Seconds(), lc.labels...)
+	}
+	return nil
+}
+
+// HandleMetrics process HTTP requests for metrics in the OpenMetrics text format.
+func (s *Server) HandleMetrics(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.httpReqStats[MetricsPath]++
+	s.mu.Unlock()
+
+	b, err := s.Metrics()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", OpenMetricsContentType)
+	w.Write(b)
+}
@@ -27,6 +27,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/nats-io/jwt/v2"
 	"github.com/yanzongzhen/nats-server/server/pse"
 )
 
@@ -664,6 +665,17 @@ type RouteInfo struct {
 	NumSubs      uint32             `json:"subscriptions"`
 	Subs         []string           `json:"subscriptions_list,omitempty"`
 	SubsDetail   []SubDetail        `json:"subscriptions_list_detail,omitempty"`
+	Compression  *CompressionInfo   `json:"compression,omitempty"`
+}
+
+// CompressionInfo has detailed information on the compression of the
+// data sent over a route, gateway or leafnode connection.
+type CompressionInfo struct {
+	Mode              string  `json:"mode"`
+	Level             string  `json:"level"`
+	UncompressedBytes int64   `json:"uncompressed_bytes"`
+	CompressedBytes   int64   `json:"compressed_bytes"`
+	Ratio             float64 `json:"ratio"`
 }
 
 // Routez returns a Routez struct containing information about routes.
@@ -703,6 +715,7 @@ func (s *Server) Routez(routezOpts *RoutezOptions) (*Routez, error) {
 			Import:       r.opts.Import,
 			Export:       r.opts.Export,
 			RTT:          r.getRTT(),
+			Compression:  r.compressionInfo(),
 		}
 
 		if len(r.subs) > 0 {
@@ -1012,6 +1025,7 @@ type Varz struct {
 	Cluster           ClusterOptsVarz   `json:"cluster,omitempty"`
 	Gateway           GatewayOptsVarz   `json:"gateway,omitempty"`
 	LeafNode          LeafNodeOptsVarz  `json:"leaf,omitempty"`
+	Listeners         []ListenerVarz    `json:"listeners,omitempty"`
 	JetStream         JetStreamVarz     `json:"jetstream,omitempty"`
 	TLSTimeout        float64           `json:"tls_timeout"`
 	WriteDeadline     time.Duration     `json:"write_deadline"`
@@ -1090,6 +1104,19 @@ type RemoteLeafOptsVarz struct {
 	URLs         []string `json:"urls,omitempty"`
 }
 
+// ListenerVarz contains monitoring information of an additional client listener
+type ListenerVarz struct {
+	Name         string `json:"name"`
+	Host         string `json:"host,omitempty"`
+	Port         int    `json:"port,omitempty"`
+	Socket       string `json:"socket,omitempty"`
+	AuthRequired bool   `json:"auth_required,omitempty"`
+	TLSRequired  bool   `json:"tls_required,omitempty"`
+	TLSVerify    bool   `json:"tls_verify,omitempty"`
+	MaxConn      int    `json:"max_connections"`
+	Connections  int    `json:"connections"`
+}
+
 // VarzOptions are the options passed to Varz().
 // Currently, there are no options defined.
 type VarzOptions struct{}
@@ -1144,6 +1171,11 @@ func (s *Server) HandleRoot(w http.ResponseWriter, r *http.Request) {
 	<a href=.%s>gatewayz</a><br/>
 	<a href=.%s>leafz</a><br/>
 	<a href=.%s>subsz</a><br/>
+	<a href=.%s>consumerz</a><br/>
+	<a href=.%s>jsz</a><br/>
+	<a href=.%s>healthz</a><br/>
+	<a href=.%s>accountz</a><br/>
+	<a href=.%s>metrics</a><br/>
     <br/>
     <a href=https://docs.nats.io/nats-server/configuration/monitoring.html>help</a>
   </body>
@@ -1154,6 +1186,11 @@ func (s *Server) HandleRoot(w http.ResponseWriter, r *http.Request) {
 		s.basePath(GatewayzPath),
 		s.basePath(LeafzPath),
 		s.basePath(SubszPath),
+		s.basePath(ConsumerzPath),
+		s.basePath(JszPath),
+		s.basePath(HealthzPath),
+		s.basePath(AccountzPath),
+		s.basePath(MetricsPath),
 	)
 }
 
@@ -1323,6 +1360,7 @@ func (s *Server) updateVarzRuntimeFields(v *Varz, forceUpdate bool, pcpu float64
 	}
 	v.Connections = len(s.clients)
 	v.TotalConnections = s.totalClients
+	v.Listeners = s.clientListenersVarz(s.getOpts())
 	v.Routes = len(s.routes)
 	v.Remotes = len(s.remotes)
 	v.Leafs = len(s.leafs)
@@ -1442,6 +1480,7 @@ type RemoteGatewayz struct {
 	IsConfigured bool               `json:"configured"`
 	Connection   *ConnInfo          `json:"connection,omitempty"`
 	Accounts     []*AccountGatewayz `json:"accounts,omitempty"`
+	Compression  *CompressionInfo   `json:"compression,omitempty"`
 }
 
 // AccountGatewayz represents interest mode for this account
@@ -1553,6 +1592,7 @@ func createOutboundRemoteGatewayz(c *client, opts *GatewayzOptions, now time.Tim
 		}
 		rgw.Connection = &ConnInfo{}
 		rgw.Connection.fill(c, c.nc, now)
+		rgw.Compression = c.compressionInfo()
 		name = c.gw.name
 	}
 	c.mu.Unlock()
@@ -1637,6 +1677,7 @@ func (s *Server) createInboundsRemoteGatewayz(opts *GatewayzOptions, now time.Ti
 			}
 			rgw.Connection = &ConnInfo{}
 			rgw.Connection.fill(c, c.nc, now)
+			rgw.Compression = c.compressionInfo()
 			igws = append(igws, rgw)
 			m[c.gw.name] = igws
 		}
@@ -1741,16 +1782,17 @@ type LeafzOptions struct {
 
 // LeafInfo has detailed information on each remote leafnode connection.
 type LeafInfo struct {
-	Account  string   `json:"account"`
-	IP       string   `json:"ip"`
-	Port     int      `json:"port"`
-	RTT      string   `json:"rtt,omitempty"`
-	InMsgs   int64    `json:"in_msgs"`
-	OutMsgs  int64    `json:"out_msgs"`
-	InBytes  int64    `json:"in_bytes"`
-	OutBytes int64    `json:"out_bytes"`
-	NumSubs  uint32   `json:"subscriptions"`
-	Subs     []string `json:"subscriptions_list,omitempty"`
+	Account     string           `json:"account"`
+	IP          string           `json:"ip"`
+	Port        int              `json:"port"`
+	RTT         string           `json:"rtt,omitempty"`
+	InMsgs      int64            `json:"in_msgs"`
+	OutMsgs     int64            `json:"out_msgs"`
+	InBytes     int64            `json:"in_bytes"`
+	OutBytes    int64            `json:"out_bytes"`
+	NumSubs     uint32           `json:"subscriptions"`
+	Subs        []string         `json:"subscriptions_list,omitempty"`
+	Compression *CompressionInfo `json:"compression,omitempty"`
 }
 
 // Leafz returns a Leafz structure containing information about leafnodes.
@@ -1772,15 +1814,16 @@ func (s *Server) Leafz(opts *LeafzOptions) (*Leafz, error) {
 		for _, ln := range lconns {
 			ln.mu.Lock()
 			lni := &LeafInfo{
-				Account:  ln.acc.Name,
-				IP:       ln.host,
-				Port:     int(ln.port),
-				RTT:      ln.getRTT(),
-				InMsgs:   atomic.LoadInt64(&ln.inMsgs),
-				OutMsgs:  ln.outMsgs,
-				InBytes:  atomic.LoadInt64(&ln.inBytes),
-				OutBytes: ln.outBytes,
-				NumSubs:  uint32(len(ln.subs)),
+				Account:     ln.acc.Name,
+				IP:          ln.host,
+				Port:        int(ln.port),
+				RTT:         ln.getRTT(),
+				InMsgs:      atomic.LoadInt64(&ln.inMsgs),
+				OutMsgs:     ln.outMsgs,
+				InBytes:     atomic.LoadInt64(&ln.inBytes),
+				OutBytes:    ln.outBytes,
+				NumSubs:     uint32(len(ln.subs)),
+				Compression: ln.compressionInfo(),
 			}
 			if opts != nil && opts.Subscriptions {
 				lni.Subs = make([]string, 0, len(ln.subs))
@@ -1830,6 +1873,630 @@ func (s *Server) HandleLeafz(w http.ResponseWriter, r *http.Request) {
 	ResponseHandler(w, r, b)
 }
 
+// Consumerz represents detailed information on JetStream consumers.
+type Consumerz struct {
+	ID           string           `json:"server_id"`
+	Now          time.Time        `json:"now"`
+	NumConsumers int              `json:"num_consumers"`
+	Consumers    []*ConsumerzInfo `json:"consumers"`
+}
+
+// ConsumerzOptions are options passed to Consumerz
+type ConsumerzOptions struct {
+	// Account filters consumers to the named account.
+	Account string `json:"account"`
+	// Stream filters consumers to the named stream.
+	Stream string `json:"stream"`
+	// Consumer filters consumers by name.
+	Consumer string `json:"consumer"`
+}
+
+// ConsumerzInfo has the account of a consumer along with its information and statistics.
+type ConsumerzInfo struct {
+	Account string `json:"account"`
+	*ConsumerInfo
+}
+
+// Consumerz returns a Consumerz structure containing information and delivery
+// statistics about JetStream consumers.
+func (s *Server) Consumerz(opts *ConsumerzOptions) (*Consumerz, error) {
+	if opts == nil {
+		opts = &ConsumerzOptions{}
+	}
+	var accs []*Account
+	if opts.Account != _EMPTY_ {
+		acc, err := s.LookupAccount(opts.Account)
+		if err != nil {
+			return nil, err
+		}
+		accs = append(accs, acc)
+	} else {
+		s.accounts.Range(func(_, v interface{}) bool {
+			accs = append(accs, v.(*Account))
+			return true
+		})
+	}
+
+	consumers := []*ConsumerzInfo{}
+	for _, acc := range accs {
+		if !acc.JetStreamEnabled() {
+			continue
+		}
+		for _, mset := range acc.Streams() {
+			if opts.Stream != _EMPTY_ && mset.Name() != opts.Stream {
+				continue
+			}
+			for _, o := range mset.Consumers() {
+				if opts.Consumer != _EMPTY_ && o.Name() != opts.Consumer {
+					continue
+				}
+				consumers = append(consumers, &ConsumerzInfo{Account: acc.Name, ConsumerInfo: o.Info()})
+			}
+		}
+	}
+	sort.Slice(consumers, func(i, j int) bool {
+		ci, cj := consumers[i], consumers[j]
+		if ci.Account != cj.Account {
+			return ci.Account < cj.Account
+		}
+		if ci.Stream != cj.Stream {
+			return ci.Stream < cj.Stream
+		}
+		return ci.Name < cj.Name
+	})
+
+	return &Consumerz{
+		ID:           s.ID(),
+		Now:          time.Now(),
+		NumConsumers: len(consumers),
+		Consumers:    consumers,
+	}, nil
+}
+
+// HandleConsumerz process HTTP requests for JetStream consumer information.
+func (s *Server) HandleConsumerz(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.httpReqStats[ConsumerzPath]++
+	s.mu.Unlock()
+
+	opts := &ConsumerzOptions{
+		Account:  r.URL.Query().Get("acc"),
+		Stream:   r.URL.Query().Get("stream"),
+		Consumer: r.URL.Query().Get("consumer"),
+	}
+
+	c, err := s.Consumerz(opts)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		s.Errorf("Error marshaling response to /consumerz request: %v", err)
+	}
+
+	// Handle response
+	ResponseHandler(w, r, b)
+}
+
+// DefaultJszAccountListSize is the default size of the account list for Jsz.
+const DefaultJszAccountListSize = 1024
+
+// JSInfo represents information on JetStream for this server.
+type JSInfo struct {
+	ID             string           `json:"server_id"`
+	Now            time.Time        `json:"now"`
+	Disabled       bool             `json:"disabled,omitempty"`
+	Config         *JetStreamConfig `json:"config,omitempty"`
+	Memory         uint64           `json:"memory"`
+	Store          uint64           `json:"storage"`
+	ReservedMemory uint64           `json:"reserved_memory"`
+	ReservedStore  uint64           `json:"reserved_storage"`
+	Accounts       int              `json:"accounts"`
+	Streams        int              `json:"streams"`
+	Consumers      int              `json:"consumers"`
+	Messages       uint64           `json:"messages"`
+	Bytes          uint64           `json:"bytes"`
+	Total          int              `json:"total"`
+	Offset         int              `json:"offset"`
+	Limit          int              `json:"limit"`
+	AccountDetails []*AccountDetail `json:"account_details,omitempty"`
+}
+
+// AccountDetail has the JetStream usage and limits of an account.
+type AccountDetail struct {
+	Name string `json:"name"`
+	JetStreamAccountStats
+	Streams []*StreamDetail `json:"stream_detail,omitempty"`
+}
+
+// StreamDetail has information on a stream and optionally its consumers.
+type StreamDetail struct {
+	Name      string          `json:"name"`
+	Created   time.Time       `json:"created"`
+	Config    *StreamConfig   `json:"config,omitempty"`
+	State     StreamState     `json:"state"`
+	Consumers []*ConsumerInfo `json:"consumer_detail,omitempty"`
+}
+
+// JSzOptions are options passed to Jsz
+type JSzOptions struct {
+	// Account filters the account details to the named account.
+	Account string `json:"account"`
+
+	// Accounts indicates if account details should be included in the results.
+	Accounts bool `json:"accounts"`
+
+	// Streams indicates if stream details should be included, implies Accounts.
+	Streams bool `json:"streams"`
+
+	// Consumer indicates if consumer details should be included, implies Streams.
+	Consumer bool `json:"consumers"`
+
+	// Config indicates if stream and consumer configurations should be included.
+	Config bool `json:"config"`
+
+	// Offset is used for pagination. Jsz() only returns account details starting
+	// at this offset from the global results.
+	Offset int `json:"offset"`
+
+	// Limit is the maximum number of account details that should be returned by Jsz().
+	Limit int `json:"limit"`
+}
+
+// Jsz returns a JSInfo structure containing information about JetStream.
+func (s *Server) Jsz(opts *JSzOptions) (*JSInfo, error) {
+	if opts == nil {
+		opts = &JSzOptions{}
+	}
+	streams := opts.Streams || opts.Consumer
+	details := opts.Accounts || streams || opts.Account != _EMPTY_
+	offset := opts.Offset
+	if offset < 0 {
+		offset = 0
+	}
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = DefaultJszAccountListSize
+	}
+
+	jsi := &JSInfo{
+		ID:     s.ID(),
+		Now:    time.Now(),
+		Offset: offset,
+		Limit:  limit,
+	}
+	js := s.getJetStream()
+	if js == nil {
+		jsi.Disabled = true
+		return jsi, nil
+	}
+
+	js.mu.RLock()
+	config := js.config
+	jsi.ReservedMemory = uint64(js.memReserved)
+	jsi.ReservedStore = uint64(js.storeReserved)
+	accounts := make([]*Account, 0, len(js.accounts))
+	for acc := range js.accounts {
+		accounts = append(accounts, acc)
+	}
+	js.mu.RUnlock()
+
+	jsi.Config = &config
+	jsi.Accounts = len(accounts)
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].Name < accounts[j].Name })
+
+	var filtered []*AccountDetail
+	for _, acc := range accounts {
+		stats := acc.JetStreamUsage()
+		jsi.Memory += stats.Memory
+		jsi.Store += stats.Store
+
+		var sdetails []*StreamDetail
+		for _, mset := range acc.Streams() {
+			state := mset.State()
+			consumers := mset.Consumers()
+			jsi.Streams++
+			jsi.Consumers += len(consumers)
+			jsi.Messages += state.Msgs
+			jsi.Bytes += state.Bytes
+			if !streams {
+				continue
+			}
+			sd := &StreamDetail{Name: mset.Name(), Created: mset.Created(), State: state}
+			if opts.Config {
+				cfg := mset.Config()
+				sd.Config = &cfg
+			}
+			if opts.Consumer {
+				for _, o := range consumers {
+					ci := o.Info()
+					if !opts.Config {
+						ci.Config = ConsumerConfig{}
+					}
+					sd.Consumers = append(sd.Consumers, ci)
+				}
+				sort.Slice(sd.Consumers, func(i, j int) bool { return sd.Consumers[i].Name < sd.Consumers[j].Name })
+			}
+			sdetails = append(sdetails, sd)
+		}
+		if !details || (opts.Account != _EMPTY_ && acc.Name != opts.Account) {
+			continue
+		}
+		sort.Slice(sdetails, func(i, j int) bool { return sdetails[i].Name < sdetails[j].Name })
+		filtered = append(filtered, &AccountDetail{Name: acc.Name, JetStreamAccountStats: stats, Streams: sdetails})
+	}
+
+	jsi.Total = len(filtered)
+	if offset < len(filtered) {
+		end := offset + limit
+		if end > len(filtered) {
+			end = len(filtered)
+		}
+		jsi.AccountDetails = filtered[offset:end]
+	}
+	return jsi, nil
+}
+
+// HandleJsz process HTTP requests for JetStream information.
+func (s *Server) HandleJsz(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.httpReqStats[JszPath]++
+	s.mu.Unlock()
+
+	accounts, err := decodeBool(w, r, "accounts")
+	if err != nil {
+		return
+	}
+	streams, err := decodeBool(w, r, "streams")
+	if err != nil {
+		return
+	}
+	consumers, err := decodeBool(w, r, "consumers")
+	if err != nil {
+		return
+	}
+	config, err := decodeBool(w, r, "config")
+	if err != nil {
+		return
+	}
+	offset, err := decodeInt(w, r, "offset")
+	if err != nil {
+		return
+	}
+	limit, err := decodeInt(w, r, "limit")
+	if err != nil {
+		return
+	}
+
+	opts := &JSzOptions{
+		Account:  r.URL.Query().Get("acc"),
+		Accounts: accounts,
+		Streams:  streams,
+		Consumer: consumers,
+		Config:   config,
+		Offset:   offset,
+		Limit:    limit,
+	}
+
+	l, err := s.Jsz(opts)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	b, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		s.Errorf("Error marshaling response to /jsz request: %v", err)
+	}
+
+	// Handle response
+	ResponseHandler(w, r, b)
+}
+
+// HealthStatus is the result of a health check.
+type HealthStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Healthz returns the health of the server. It is healthy once its listeners are
+// accepting connections, routes and gateways are established and, if enabled,
+// JetStream has recovered all of its streams and consumers. Since configured
+// routes may include this server itself, a single established route is enough
+// for the cluster to be considered joined.
+func (s *Server) Healthz() *HealthStatus {
+	if err := s.healthz(); err != nil {
+		return &HealthStatus{Status: "unavailable", Error: err.Error()}
+	}
+	return &HealthStatus{Status: "ok"}
+}
+
+func (s *Server) healthz() error {
+	opts := s.getOpts()
+
+	if err := s.listenersReady(opts); err != nil {
+		return err
+	}
+	if len(opts.Routes) > 0 && s.NumRoutes() == 0 {
+		return fmt.Errorf("no routes established")
+	}
+	for _, gw := range opts.Gateway.Gateways {
+		if gw.Name == opts.Gateway.Name {
+			continue
+		}
+		if s.getOutboundGatewayConnection(gw.Name) == nil {
+			return fmt.Errorf("gateway %q not established", gw.Name)
+		}
+	}
+	if opts.JetStream && !s.JetStreamIsReady() {
+		return fmt.Errorf("jetstream not ready")
+	}
+	return nil
+}
+
+// HandleHealthz process HTTP requests for the health of the server.
+// Responds with a 503 status code when the server is not healthy.
+func (s *Server) HandleHealthz(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.httpReqStats[HealthzPath]++
+	s.mu.Unlock()
+
+	hs := s.Healthz()
+	b, err := json.Marshal(hs)
+	if err != nil {
+		s.Errorf("Error marshaling response to /healthz request: %v", err)
+	}
+	if hs.Error != _EMPTY_ {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	// Handle response
+	ResponseHandler(w, r, b)
+}
+
+// Accountz represents information on the accounts of this server.
+type Accountz struct {
+	ID            string       `json:"server_id"`
+	Now           time.Time    `json:"now"`
+	SystemAccount string       `json:"system_account,omitempty"`
+	Accounts      []string     `json:"accounts,omitempty"`
+	Account       *AccountInfo `json:"account_detail,omitempty"`
+}
+
+// AccountzOptions are options passed to Accountz
+type AccountzOptions struct {
+	// Account indicates that Accountz will return details for the named account
+	// instead of the list of accounts.
+	Account string `json:"account"`
+}
+
+// AccountInfo has detailed information on an account.
+type AccountInfo struct {
+	Name           string                 `json:"account_name"`
+	LastUpdate     time.Time              `json:"update_time,omitempty"`
+	IsSystem       bool                   `json:"is_system,omitempty"`
+	Expired        bool                   `json:"expired"`
+	Claim          *AccountClaimSummary   `json:"jwt,omitempty"`
+	Exports        []ExtExport            `json:"exports,omitempty"`
+	Imports        []ExtImport            `json:"imports,omitempty"`
+	NumConns       int                    `json:"num_connections"`
+	NumRemoteConns int                    `json:"num_remote_connections"`
+	NumLeafs       int                    `json:"num_leafnodes"`
+	NumSubs        int                    `json:"num_subscriptions"`
+	NumResponses   int                    `json:"num_pending_responses"`
+	JetStream      *JetStreamAccountStats `json:"jetstream,omitempty"`
+}
+
+// AccountClaimSummary summarizes the JWT an account was created from.
+type AccountClaimSummary struct {
+	Subject     string     `json:"subject"`
+	Issuer      string     `json:"issuer"`
+	Name        string     `json:"name,omitempty"`
+	IssuedAt    time.Time  `json:"issued_at"`
+	Expires     *time.Time `json:"expires,omitempty"`
+	SigningKeys []string   `json:"signing_keys,omitempty"`
+}
+
+// ExtServiceLatency is the latency tracking setting of a service export or import.
+type ExtServiceLatency struct {
+	// Sampling is a percentage, 0 means tracking is triggered by a header.
+	Sampling int    `json:"sampling"`
+	Results  string `json:"results"`
+}
+
+// ExtExport is an exported stream or service.
+type ExtExport struct {
+	Subject           string             `json:"subject"`
+	Type              string             `json:"type"`
+	TokenRequired     bool               `json:"token_required,omitempty"`
+	Approved          []string           `json:"approved_accounts,omitempty"`
+	ResponseType      string             `json:"response_type,omitempty"`
+	ResponseThreshold time.Duration      `json:"response_threshold,omitempty"`
+	Latency           *ExtServiceLatency `json:"latency,omitempty"`
+}
+
+// ExtImport is an imported stream or service.
+type ExtImport struct {
+	Type     string             `json:"type"`
+	Account  string             `json:"account"`
+	Subject  string             `json:"subject"`
+	To       string             `json:"to,omitempty"`
+	Invalid  bool               `json:"invalid,omitempty"`
+	Share    bool               `json:"share,omitempty"`
+	Tracking bool               `json:"tracking,omitempty"`
+	Latency  *ExtServiceLatency `json:"latency,omitempty"`
+}
+
+// Accountz returns an Accountz structure listing the accounts of this server,
+// or the details of a single account.
+func (s *Server) Accountz(opts *AccountzOptions) (*Accountz, error) {
+	az := &Accountz{
+		ID:  s.ID(),
+		Now: time.Now(),
+	}
+	sacc := s.SystemAccount()
+	if sacc != nil {
+		az.SystemAccount = sacc.Name
+	}
+	if opts == nil || opts.Account == _EMPTY_ {
+		az.Accounts = []string{}
+		s.accounts.Range(func(k, _ interface{}) bool {
+			az.Accounts = append(az.Accounts, k.(string))
+			return true
+		})
+		sort.Strings(az.Accounts)
+		return az, nil
+	}
+	acc, err := s.LookupAccount(opts.Account)
+	if err != nil {
+		return nil, err
+	}
+	az.Account = acc.accountInfo()
+	az.Account.IsSystem = acc == sacc
+	return az, nil
+}
+
+// Gather the details of an account for Accountz.
+func (a *Account) accountInfo() *AccountInfo {
+	ai := &AccountInfo{
+		Name:           a.Name,
+		NumConns:       a.NumLocalConnections(),
+		NumRemoteConns: a.NumRemoteConnections(),
+		NumLeafs:       a.NumLeafNodes(),
+		NumSubs:        a.TotalSubs(),
+		NumResponses:   a.NumPendingAllResponses(),
+	}
+	if a.JetStreamEnabled() {
+		stats := a.JetStreamUsage()
+		ai.JetStream = &stats
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	ai.LastUpdate = a.updated
+	ai.Expired = a.expired
+	if a.claimJWT != _EMPTY_ {
+		if ac, err := jwt.DecodeAccountClaims(a.claimJWT); err == nil {
+			cs := &AccountClaimSummary{
+				Subject:     ac.Subject,
+				Issuer:      ac.Issuer,
+				Name:        ac.Name,
+				IssuedAt:    time.Unix(ac.IssuedAt, 0).UTC(),
+				SigningKeys: append([]string(nil), a.signingKeys...),
+			}
+			if ac.Expires > 0 {
+				exp := time.Unix(ac.Expires, 0).UTC()
+				cs.Expires = &exp
+			}
+			ai.Claim = cs
+		}
+	}
+
+	for subj, se := range a.exports.streams {
+		e := ExtExport{Subject: subj, Type: jwt.Stream.String()}
+		if se != nil {
+			e.TokenRequired = se.tokenReq
+			e.Approved = approvedAccounts(se.approved)
+		}
+		ai.Exports = append(ai.Exports, e)
+	}
+	for subj, se := range a.exports.services {
+		e := ExtExport{Subject: subj, Type: jwt.Service.String()}
+		if se != nil {
+			e.TokenRequired = se.tokenReq
+			e.Approved = approvedAccounts(se.approved)
+			e.ResponseType = se.respType.String()
+			e.ResponseThreshold = se.respThresh
+			e.Latency = extServiceLatency(se.latency)
+		}
+		ai.Exports = append(ai.Exports, e)
+	}
+	sort.Slice(ai.Exports, func(i, j int) bool {
+		if ai.Exports[i].Type != ai.Exports[j].Type {
+			return ai.Exports[i].Type > ai.Exports[j].Type
+		}
+		return ai.Exports[i].Subject < ai.Exports[j].Subject
+	})
+
+	for _, si := range a.imports.streams {
+		ai.Imports = append(ai.Imports, ExtImport{
+			Type:    jwt.Stream.String(),
+			Account: si.acc.Name,
+			Subject: si.from,
+			To:      si.prefix,
+			Invalid: si.invalid,
+		})
+	}
+	for _, si := range a.imports.services {
+		ei := ExtImport{
+			Type:     jwt.Service.String(),
+			Account:  si.acc.Name,
+			Subject:  si.from,
+			To:       si.to,
+			Invalid:  si.invalid,
+			Share:    si.share,
+			Tracking: si.tracking,
+		}
+		if si.se != nil {
+			ei.Latency = extServiceLatency(si.se.latency)
+		}
+		ai.Imports = append(ai.Imports, ei)
+	}
+	sort.Slice(ai.Imports, func(i, j int) bool {
+		if ai.Imports[i].Type != ai.Imports[j].Type {
+			return ai.Imports[i].Type > ai.Imports[j].Type
+		}
+		return ai.Imports[i].Subject < ai.Imports[j].Subject
+	})
+	return ai
+}
+
+func approvedAccounts(approved map[string]*Account) []string {
+	if len(approved) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(approved))
+	for name := range approved {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func extServiceLatency(sl *serviceLatency) *ExtServiceLatency {
+	if sl == nil {
+		return nil
+	}
+	return &ExtServiceLatency{Sampling: int(sl.sampling), Results: sl.subject}
+}
+
+// HandleAccountz process HTTP requests for account information.
+func (s *Server) HandleAccountz(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.httpReqStats[AccountzPath]++
+	s.mu.Unlock()
+
+	opts := &AccountzOptions{Account: r.URL.Query().Get("acc")}
+
+	a, err := s.Accountz(opts)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	b, err := json.MarshalIndent(a, "", "  ")
+	if err != nil {
+		s.Errorf("Error marshaling response to /accountz request: %v", err)
+	}
+
+	// Handle response
+	ResponseHandler(w, r, b)
+}
+
 // ResponseHandler handles responses for monitoring routes
 func ResponseHandler(w http.ResponseWriter, r *http.Request, data []byte) {
 	// Get callback from request
@@ -1906,6 +2573,8 @@ func (reason ClosedState) String() string {
 		return "No Responders Requires Headers"
 	case ClusterNameConflict:
 		return "Cluster Name Conflict"
+	case DuplicateClientID:
+		return "Duplicate Client ID"
 	}
 
 	return "Unknown State"
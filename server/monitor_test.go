@@ -3646,3 +3646,256 @@ func TestMonitorLeafz(t *testing.T) {
 		}
 	}
 }
+
+func TestMonitorHealthz(t *testing.T) {
+	checkHealthz := func(s *Server, status int, reason string) {
+		t.Helper()
+		url := fmt.Sprintf("http://127.0.0.1:%d/healthz", s.MonitorAddr().Port)
+		body := readBodyEx(t, url, status, appJSONContent)
+		var hs HealthStatus
+		if err := json.Unmarshal(body, &hs); err != nil {
+			t.Fatalf("Got an error unmarshalling the body: %v\n", err)
+		}
+		if hs.Error != reason {
+			t.Fatalf("Expected reason %q, got %q", reason, hs.Error)
+		}
+		expected := "ok"
+		if reason != _EMPTY_ {
+			expected = "unavailable"
+		}
+		if hs.Status != expected {
+			t.Fatalf("Expected status %q, got %q", expected, hs.Status)
+		}
+	}
+
+	s := runMonitorServer()
+	defer s.Shutdown()
+	checkHealthz(s, http.StatusOK, _EMPTY_)
+
+	// Configured routes that can not be established.
+	opts := DefaultMonitorOptions()
+	opts.NoSystemAccount = true
+	opts.Cluster.Host = "127.0.0.1"
+	opts.Cluster.Port = CLUSTER_PORT
+	opts.Routes = RoutesFromStr("nats://127.0.0.1:1234")
+	sr := RunServer(opts)
+	defer sr.Shutdown()
+	checkHealthz(sr, http.StatusServiceUnavailable, "no routes established")
+
+	// JetStream has to be ready.
+	storeDir, err := ioutil.TempDir("", JetStreamStoreDir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.RemoveAll(storeDir)
+	opts = DefaultMonitorOptions()
+	opts.JetStream = true
+	opts.StoreDir = storeDir
+	sj := RunServer(opts)
+	defer sj.Shutdown()
+	checkHealthz(sj, http.StatusOK, _EMPTY_)
+
+	js := sj.getJetStream()
+	js.mu.Lock()
+	js.ready = false
+	js.mu.Unlock()
+	checkHealthz(sj, http.StatusServiceUnavailable, "jetstream not ready")
+}
+
+func TestMonitorAccountz(t *testing.T) {
+	resetPreviousHTTPConnections()
+	opts := DefaultMonitorOptions()
+	opts.Accounts = []*Account{NewAccount("A"), NewAccount("B")}
+	opts.Users = []*User{
+		{Username: "a", Password: "a", Account: opts.Accounts[0]},
+		{Username: "b", Password: "b", Account: opts.Accounts[1]},
+	}
+	s := RunServer(opts)
+	defer s.Shutdown()
+
+	accA, _ := s.LookupAccount("A")
+	accB, _ := s.LookupAccount("B")
+	if err := accA.AddServiceExport("req", nil); err != nil {
+		t.Fatalf("Error adding service export: %v", err)
+	}
+	if err := accA.TrackServiceExportWithSampling("req", "results", 50); err != nil {
+		t.Fatalf("Error tracking service export: %v", err)
+	}
+	if err := accA.AddStreamExport("events.>", []*Account{accB}); err != nil {
+		t.Fatalf("Error adding stream export: %v", err)
+	}
+	if err := accB.AddServiceImport(accA, "a.req", "req"); err != nil {
+		t.Fatalf("Error adding service import: %v", err)
+	}
+	if err := accB.AddStreamImport(accA, "events.>", "a"); err != nil {
+		t.Fatalf("Error adding stream import: %v", err)
+	}
+
+	nc, err := nats.Connect(s.ClientURL(), nats.UserInfo("b", "b"))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer nc.Close()
+	nc.SubscribeSync("foo")
+	nc.Flush()
+
+	url := fmt.Sprintf("http://127.0.0.1:%d/accountz", s.MonitorAddr().Port)
+	accountz := func(query string) *Accountz {
+		t.Helper()
+		body := readBody(t, url+query)
+		az := &Accountz{}
+		if err := json.Unmarshal(body, az); err != nil {
+			t.Fatalf("Got an error unmarshalling the body: %v\n", err)
+		}
+		return az
+	}
+
+	az := accountz("")
+	if !reflect.DeepEqual(az.Accounts, []string{globalAccountName, DEFAULT_SYSTEM_ACCOUNT, "A", "B"}) ||
+		az.SystemAccount != DEFAULT_SYSTEM_ACCOUNT || az.Account != nil {
+		t.Fatalf("Unexpected accounts: %+v", az)
+	}
+
+	ai := accountz("?acc=A").Account
+	if ai == nil || ai.Name != "A" || len(ai.Exports) != 2 || len(ai.Imports) != 0 {
+		t.Fatalf("Unexpected account detail: %+v", ai)
+	}
+	se, ssvc := ai.Exports[0], ai.Exports[1]
+	if se.Type != "stream" || se.Subject != "events.>" || !reflect.DeepEqual(se.Approved, []string{"B"}) {
+		t.Fatalf("Unexpected stream export: %+v", se)
+	}
+	if ssvc.Type != "service" || ssvc.Subject != "req" || ssvc.ResponseType != Singleton.String() ||
+		ssvc.Latency == nil || ssvc.Latency.Sampling != 50 || ssvc.Latency.Results != "results" {
+		t.Fatalf("Unexpected service export: %+v", ssvc)
+	}
+
+	ai = accountz("?acc=B").Account
+	if ai == nil || len(ai.Exports) != 0 || len(ai.Imports) != 2 {
+		t.Fatalf("Unexpected account detail: %+v", ai)
+	}
+	si, ssi := ai.Imports[0], ai.Imports[1]
+	if si.Type != "stream" || si.Account != "A" || si.Subject != "events.>" || si.To != "a." {
+		t.Fatalf("Unexpected stream import: %+v", si)
+	}
+	if ssi.Type != "service" || ssi.Account != "A" || ssi.Subject != "a.req" || ssi.To != "req" || ssi.Latency == nil {
+		t.Fatalf("Unexpected service import: %+v", ssi)
+	}
+	if ai.NumConns != 1 || ai.NumSubs == 0 {
+		t.Fatalf("Expected a connection and subscriptions, got %+v", ai)
+	}
+
+	readBodyEx(t, url+"?acc=NOT_THERE", http.StatusBadRequest, textPlain)
+}
+
+func TestMonitorMetrics(t *testing.T) {
+	storeDir, err := ioutil.TempDir("", JetStreamStoreDir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.RemoveAll(storeDir)
+
+	opts := DefaultMonitorOptions()
+	opts.JetStream = true
+	opts.StoreDir = storeDir
+	s := RunServer(opts)
+	defer s.Shutdown()
+
+	mset, err := s.GlobalAccount().AddStream(&StreamConfig{Name: "ORDERS", Storage: MemoryStorage})
+	if err != nil {
+		t.Fatalf("Unexpected error adding stream: %v", err)
+	}
+	if _, err := mset.AddConsumer(&ConsumerConfig{Durable: "dlc", AckPolicy: AckExplicit}); err != nil {
+		t.Fatalf("Unexpected error adding consumer: %v", err)
+	}
+	nc, err := nats.Connect(fmt.Sprintf("nats://127.0.0.1:%d", opts.Port))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer nc.Close()
+	nc.Publish("ORDERS", []byte("ok"))
+	nc.Flush()
+	checkFor(t, time.Second, 15*time.Millisecond, func() error {
+		if state := mset.State(); state.Msgs != 1 {
+			return fmt.Errorf("expected 1 message, got %d", state.Msgs)
+		}
+		return nil
+	})
+
+	url := fmt.Sprintf("http://127.0.0.1:%d%s", s.MonitorAddr().Port, MetricsPath)
+	body := string(readBodyEx(t, url, http.StatusOK, OpenMetricsContentType))
+
+	if !strings.HasSuffix(body, "\n# EOF\n") {
+		t.Fatalf("Expected metrics to end with EOF marker, got %q", body)
+	}
+	id := s.ID()
+	for _, line := range []string{
+		"# TYPE nats_server_connections gauge",
+		fmt.Sprintf(`nats_server_connections{server_id="%s"} 1`, id),
+		"# TYPE nats_server_in_msgs counter",
+		fmt.Sprintf(`nats_server_in_msgs_total{server_id="%s"}`, id),
+		fmt.Sprintf(`nats_server_info{server_id="%s",server_name="%s",version="%s",go="%s"} 1`, id, s.Name(), VERSION, runtime.Version()),
+		fmt.Sprintf(`nats_account_subscriptions{server_id="%s",account="$G"}`, id),
+		fmt.Sprintf(`nats_jetstream_enabled{server_id="%s"} 1`, id),
+		fmt.Sprintf(`nats_jetstream_stream_messages{server_id="%s",account="$G",stream="ORDERS"} 1`, id),
+		fmt.Sprintf(`nats_jetstream_consumer_num_pending{server_id="%s",account="$G",stream="ORDERS",consumer="dlc"} 0`, id),
+		"# TYPE nats_jetstream_consumer_ack_latency_seconds histogram",
+		fmt.Sprintf(`nats_jetstream_consumer_ack_latency_seconds_bucket{server_id="%s",account="$G",stream="ORDERS",consumer="dlc",le="+Inf"} 0`, id),
+	} {
+		if !strings.Contains(body, line+"\n") && !strings.Contains(body, line+" ") {
+			t.Fatalf("Expected metrics to contain %q, got:\n%s", line, body)
+		}
+	}
+
+	// Every family must be declared only once.
+	families := map[string]bool{}
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(line, "# TYPE ") {
+			name := strings.Fields(line)[2]
+			if families[name] {
+				t.Fatalf("Metric family %q declared more than once", name)
+			}
+			families[name] = true
+		}
+	}
+}
+
+func TestMonitorMetricsJetStreamAllAccounts(t *testing.T) {
+	storeDir, err := ioutil.TempDir("", JetStreamStoreDir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.RemoveAll(storeDir)
+
+	opts := DefaultMonitorOptions()
+	opts.JetStream = true
+	opts.StoreDir = storeDir
+	s := RunServer(opts)
+	defer s.Shutdown()
+
+	// More accounts than fit in a single page of account details.
+	limits := &JetStreamAccountLimits{MaxMemory: 1024, MaxStore: 1024, MaxStreams: -1, MaxConsumers: -1}
+	if err := s.GlobalAccount().UpdateJetStreamLimits(limits); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for i := 0; i < DefaultJszAccountListSize; i++ {
+		acc, _ := s.LookupOrRegisterAccount(fmt.Sprintf("A%d", i))
+		if err := acc.EnableJetStream(limits); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	acc, _ := s.LookupOrRegisterAccount("LAST")
+	if err := acc.EnableJetStream(limits); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := acc.AddStream(&StreamConfig{Name: "ORDERS", Storage: MemoryStorage}); err != nil {
+		t.Fatalf("Unexpected error adding stream: %v", err)
+	}
+
+	url := fmt.Sprintf("http://127.0.0.1:%d%s", s.MonitorAddr().Port, MetricsPath)
+	body := string(readBodyEx(t, url, http.StatusOK, OpenMetricsContentType))
+
+	line := fmt.Sprintf(`nats_jetstream_stream_messages{server_id="%s",account="LAST",stream="ORDERS"} 0`, s.ID())
+	if !strings.Contains(body, line+"\n") {
+		t.Fatalf("Expected metrics to contain %q", line)
+	}
+}
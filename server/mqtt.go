@@ -0,0 +1,1797 @@
+// Copyright 2020 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/nats-io/nuid"
+)
+
+// References to "spec" here are from the MQTT v3.1.1 specification:
+// http://docs.oasis-open.org/mqtt/mqtt/v3.1.1/os/mqtt-v3.1.1-os.html
+
+const (
+	// Packet types, in the high nibble of the first byte.
+	mqttPacketConnect    = byte(0x10)
+	mqttPacketConnectAck = byte(0x20)
+	mqttPacketPub        = byte(0x30)
+	mqttPacketPubAck     = byte(0x40)
+	mqttPacketPubRec     = byte(0x50)
+	mqttPacketPubRel     = byte(0x60)
+	mqttPacketPubComp    = byte(0x70)
+	mqttPacketSub        = byte(0x80)
+	mqttPacketSubAck     = byte(0x90)
+	mqttPacketUnsub      = byte(0xa0)
+	mqttPacketUnsubAck   = byte(0xb0)
+	mqttPacketPing       = byte(0xc0)
+	mqttPacketPingResp   = byte(0xd0)
+	mqttPacketDisconnect = byte(0xe0)
+	mqttPacketMask       = byte(0xf0)
+	mqttPacketFlagMask   = byte(0x0f)
+
+	mqttProtoName  = "MQTT"
+	mqttProtoLevel = byte(0x4)
+
+	// Connect flags
+	mqttConnFlagReserved     = byte(0x1)
+	mqttConnFlagCleanSession = byte(0x2)
+	mqttConnFlagWillFlag     = byte(0x04)
+	mqttConnFlagWillQoS      = byte(0x18)
+	mqttConnFlagWillRetain   = byte(0x20)
+	mqttConnFlagPasswordFlag = byte(0x40)
+	mqttConnFlagUsernameFlag = byte(0x80)
+
+	// Publish flags
+	mqttPubFlagRetain = byte(0x01)
+	mqttPubFlagQoS    = byte(0x06)
+	mqttPubFlagDup    = byte(0x08)
+
+	// Subscribe and unsubscribe packets have this fixed header flags.
+	mqttSubscribeFlags   = byte(0x2)
+	mqttUnsubscribeFlags = byte(0x2)
+
+	// Return code in the SUBACK for a rejected subscription.
+	mqttSubAckFailure = byte(0x80)
+
+	// CONNACK return codes
+	mqttConnAckRCConnectionAccepted          = byte(0x0)
+	mqttConnAckRCUnacceptableProtocolVersion = byte(0x1)
+	mqttConnAckRCIdentifierRejected          = byte(0x2)
+	mqttConnAckRCServerUnavailable           = byte(0x3)
+	mqttConnAckRCNotAuthorized               = byte(0x5)
+
+	// A PUBLISH packet is the payload plus, at most, a topic of
+	// 65535 bytes with its length and the packet identifier.
+	mqttMaxPubOverhead = 65535 + 2 + 2
+
+	// Default time after which an unacknowledged QoS 1 message
+	// is sent again.
+	mqttDefaultAckWait = 30 * time.Second
+
+	// Stream of the QoS 1 messages. Subscriptions with QoS 1 have a durable
+	// consumer on that stream.
+	mqttStreamName          = "$MQTT_msgs"
+	mqttStreamSubjectPrefix = "$MQTT.msgs."
+
+	// Stream of the retained messages.
+	mqttRetainedMsgsStreamName    = "$MQTT_rmsgs"
+	mqttRetainedMsgsStreamSubject = "$MQTT.rmsgs."
+
+	// Stream of the sessions that need to survive a disconnect.
+	mqttSessStreamName          = "$MQTT_sess"
+	mqttSessStreamSubjectPrefix = "$MQTT.sess."
+
+	mqttConsumerNamePrefix   = "mqtt_"
+	mqttDeliverSubjectPrefix = "$MQTT.sub."
+
+	// Header set on messages published with QoS 1. Those messages are
+	// delivered to QoS 1 subscriptions through the stream, not directly.
+	mqttNatsHeader = "Nmqtt-Pub"
+)
+
+var (
+	errMQTTNotConnected       = errors.New("mqtt: first packet should be a CONNECT")
+	errMQTTSecondConnect      = errors.New("mqtt: second CONNECT packet")
+	errMQTTMalformedVarInt    = errors.New("mqtt: malformed variable integer")
+	errMQTTUnsupportedPacket  = errors.New("mqtt: unsupported packet")
+	errMQTTQoS2NotSupported   = errors.New("mqtt: QoS 2 is not supported")
+	errMQTTTopicIsEmpty       = errors.New("mqtt: topic can not be empty")
+	errMQTTPacketIDIsZero     = errors.New("mqtt: packet identifier can not be 0")
+	errMQTTEmptyUnsubscribe   = errors.New("mqtt: unsubscribe packet without topic filter")
+	errMQTTEmptySubscribe     = errors.New("mqtt: subscribe packet without topic filter")
+	errMQTTBadSubscribeQoS    = errors.New("mqtt: invalid requested QoS in subscribe packet")
+	errMQTTClientIDRequired   = errors.New("mqtt: client identifier required for persistent sessions")
+	errMQTTBadConnectFlags    = errors.New("mqtt: invalid connect flags")
+	errMQTTNotSupportedFilter = errors.New("mqtt: topic filter not supported")
+)
+
+// Per-connection MQTT state.
+type mqtt struct {
+	// Bytes of an incomplete packet, kept for the next read.
+	pending []byte
+	// Time of the last read, used for the keep alive.
+	last int64
+	// Keep alive interval, including the grace period.
+	kad time.Duration
+
+	connected bool
+	asm       *mqttAccountSessionManager
+	sess      *mqttSession
+	// The will, cleared on DISCONNECT.
+	will *mqttPublish
+}
+
+// MQTT related state of the server.
+type srvMQTT struct {
+	listener     net.Listener
+	authOverride bool
+	users        map[string]*User
+	sessmgr      mqttSessionManager
+}
+
+// Session managers keyed by account name.
+type mqttSessionManager struct {
+	mu       sync.Mutex
+	sessions map[string]*mqttAccountSessionManager
+}
+
+// Holds the streams and the sessions of the MQTT clients of an account.
+type mqttAccountSessionManager struct {
+	mu       sync.Mutex
+	s        *Server
+	msgs     *Stream
+	rmsgs    *Stream
+	sessions *Stream
+	sess     map[string]*mqttSession     // keyed by client ID
+	retained map[string]*mqttRetainedMsg // keyed by subject
+
+	// Filter subjects of the QoS 1 consumers, used to decide whether QoS 1
+	// messages need to be stored. The subscriptions are keyed by consumer.
+	qos1     *Sublist
+	qos1subs map[string]*subscription
+
+	// Internal client used for the delivery subscriptions of the QoS 1
+	// consumers and to publish wills.
+	ic  *client
+	wmu sync.Mutex
+}
+
+type mqttSession struct {
+	// Protected by the account session manager lock.
+	c *client
+
+	// Immutable.
+	id    string
+	hash  string
+	clean bool
+
+	mu sync.Mutex
+	// Topic filters and their QoS.
+	subs map[string]byte
+	// Consumers and delivery subscriptions of QoS 1 subscriptions,
+	// keyed by subscription ID.
+	cons  map[string]*Consumer
+	dsubs map[string]*subscription
+	// Ack subject of QoS 1 messages waiting for a PUBACK, keyed by
+	// packet identifier, and the identifier of messages sent by
+	// consumers to reuse on redelivery.
+	pending  map[uint16]string
+	cpending map[string]uint16
+	ppi      uint16
+	// Sequence of the session record in the sessions stream.
+	seq uint64
+}
+
+// Session record stored in the sessions stream.
+type mqttPersistedSession struct {
+	ID   string          `json:"id"`
+	Subs map[string]byte `json:"subs,omitempty"`
+}
+
+// Retained message record stored in the retained messages stream.
+type mqttRetainedMsg struct {
+	Topic   string `json:"topic"`
+	Subject string `json:"subject"`
+	QoS     byte   `json:"qos"`
+	Msg     []byte `json:"msg,omitempty"`
+
+	seq uint64
+}
+
+type mqttPublish struct {
+	topic   []byte
+	subject string
+	msg     []byte
+	qos     byte
+	retain  bool
+	pi      uint16
+}
+
+type mqttConnectProto struct {
+	clientID  string
+	flags     byte
+	keepAlive uint16
+	will      *mqttPublish
+	username  string
+	password  []byte
+}
+
+// Validate the MQTT related options.
+func validateMQTTOptions(o *Options) error {
+	mo := &o.MQTT
+	// If no port is defined, we don't care about other options
+	if mo.Port == 0 {
+		return nil
+	}
+	// Sessions and QoS 1 messages are stored in JetStream.
+	if !o.JetStream {
+		return errors.New("mqtt requires JetStream to be enabled")
+	}
+	if mo.AckWait < 0 {
+		return errors.New("mqtt ack wait can not be negative")
+	}
+	// If there is a NoAuthUser, we need to have Users defined and
+	// the user to be present.
+	if mo.NoAuthUser != _EMPTY_ {
+		if mo.Users == nil {
+			return fmt.Errorf("mqtt no_auth_user %q configured, but users are not", mo.NoAuthUser)
+		}
+		for _, u := range mo.Users {
+			if u.Username == mo.NoAuthUser {
+				return nil
+			}
+		}
+		return fmt.Errorf("mqtt no_auth_user %q not found in users configuration", mo.NoAuthUser)
+	}
+	return nil
+}
+
+// Given the MQTT options, we check if any auth configuration
+// has been provided. If so, possibly create users and
+// store them in s.mqtt.users.
+// Also update a boolean that indicates if auth is required for
+// MQTT clients.
+// Server lock is held on entry.
+func (s *Server) mqttConfigAuth(opts *MQTTOpts) {
+	mqtt := &s.mqtt
+	if len(opts.Users) > 0 {
+		_, mqtt.users = s.buildNkeysAndUsersFromOptions(nil, opts.Users)
+		mqtt.authOverride = true
+	} else if opts.Username != "" || opts.Token != "" {
+		mqtt.authOverride = true
+	} else {
+		mqtt.users = nil
+		mqtt.authOverride = false
+	}
+}
+
+func (s *Server) startMQTT() {
+	sopts := s.getOpts()
+	o := &sopts.MQTT
+
+	port := o.Port
+	if port == -1 {
+		port = 0
+	}
+	hp := net.JoinHostPort(o.Host, strconv.Itoa(port))
+
+	s.mu.Lock()
+	if s.shutdown {
+		s.mu.Unlock()
+		return
+	}
+	hl, err := natsListen("tcp", hp)
+	if err != nil {
+		s.mu.Unlock()
+		s.Fatalf("Unable to listen for MQTT connections: %v", err)
+		return
+	}
+	if port == 0 {
+		s.opts.MQTT.Port = hl.Addr().(*net.TCPAddr).Port
+	}
+	proto := "mqtt"
+	if o.TLSConfig != nil {
+		proto = "mqtts"
+	}
+	s.Noticef("Listening for MQTT clients on %s://%s:%d", proto, o.Host, s.opts.MQTT.Port)
+	s.mqtt.listener = hl
+	go s.acceptConnections(hl, "MQTT", func(conn net.Conn) { s.createMQTTClient(conn) },
+		func(_ error) bool {
+			if s.isLameDuckMode() {
+				// Signal that we are not accepting new clients
+				s.ldmCh <- true
+				// Now wait for the Shutdown...
+				<-s.quitCh
+				return true
+			}
+			return false
+		})
+	s.mu.Unlock()
+}
+
+// Creates a client for the MQTT connection. This is similar to
+// createClient(), except that nothing is sent until the client
+// sends its CONNECT packet.
+func (s *Server) createMQTTClient(conn net.Conn) *client {
+	// Snapshot server options.
+	opts := s.getOpts()
+
+	maxPay := int32(opts.MaxPayload)
+	maxSubs := int32(opts.MaxSubs)
+	// For system, maxSubs of 0 means unlimited, so re-adjust here.
+	if maxSubs == 0 {
+		maxSubs = -1
+	}
+	now := time.Now()
+
+	c := &client{srv: s, nc: conn, opts: clientOpts{Echo: true}, mpay: maxPay, msubs: maxSubs, start: now, last: now, mqtt: &mqtt{}}
+	// MQTT clients get their own messages and messages with headers,
+	// which are needed to tell QoS 1 messages apart.
+	c.echo = true
+	c.headers = true
+
+	c.registerWithAccount(s.globalAccount())
+
+	s.mu.Lock()
+	s.totalClients++
+	s.mu.Unlock()
+
+	c.mu.Lock()
+	c.initClient()
+	c.Debugf("Client connection created")
+	c.mu.Unlock()
+
+	// Register with the server.
+	s.mu.Lock()
+	// See createClient() for details.
+	if !s.running || s.ldm {
+		if s.shutdown {
+			conn.Close()
+		}
+		s.mu.Unlock()
+		return c
+	}
+
+	// If there is a max connections specified, check that adding
+	// this new client would not push us over the max
+	if opts.MaxConn > 0 && len(s.clients) >= opts.MaxConn {
+		s.mu.Unlock()
+		c.maxConnExceeded()
+		return nil
+	}
+	s.clients[c.cid] = c
+	s.mu.Unlock()
+
+	// Re-Grab lock
+	c.mu.Lock()
+
+	isClosed := c.isClosed()
+	tlsRequired := opts.MQTT.TLSConfig != nil
+
+	// Check for TLS
+	if !isClosed && tlsRequired {
+		c.Debugf("Starting TLS client connection handshake")
+		c.nc = tls.Server(c.nc, opts.MQTT.TLSConfig)
+		conn := c.nc.(*tls.Conn)
+
+		// Setup the timeout
+		ttl := secondsToDuration(opts.MQTT.TLSTimeout)
+		time.AfterFunc(ttl, func() { tlsTimeout(c, conn) })
+		conn.SetReadDeadline(time.Now().Add(ttl))
+
+		// Force handshake
+		c.mu.Unlock()
+		if err := conn.Handshake(); err != nil {
+			c.Errorf("TLS handshake error: %v", err)
+			c.closeConnection(TLSHandshakeError)
+			return nil
+		}
+		// Reset the read deadline
+		conn.SetReadDeadline(time.Time{})
+
+		// Re-Grab lock
+		c.mu.Lock()
+
+		// Indicate that handshake is complete (used in monitoring)
+		c.flags.set(handshakeComplete)
+
+		// The connection may have been closed
+		isClosed = c.isClosed()
+	}
+
+	// If connection is marked as closed, bail out.
+	if isClosed {
+		c.mu.Unlock()
+		c.closeConnection(WriteError)
+		return nil
+	}
+
+	// The CONNECT packet has to be received within the auth timeout,
+	// regardless of authentication being required.
+	timeout := opts.AuthTimeout
+	if opts.MQTT.AuthTimeout != 0 {
+		timeout = opts.MQTT.AuthTimeout
+	}
+	c.setAuthTimer(secondsToDuration(timeout))
+
+	// Spin up the read loop.
+	s.startGoRoutine(func() { c.readLoop(nil) })
+
+	// Spin up the write loop.
+	s.startGoRoutine(func() { c.writeLoop() })
+
+	if tlsRequired {
+		c.Debugf("TLS handshake complete")
+		cs := c.nc.(*tls.Conn).ConnectionState()
+		c.Debugf("TLS version %s, cipher suite %s", tlsVersion(cs.Version), tlsCipher(cs.CipherSuite))
+	}
+
+	c.mu.Unlock()
+
+	return c
+}
+
+// Returns the session manager of the given account, creating the
+// streams used for MQTT if needed.
+func (s *Server) mqttGetAccountSessionManager(acc *Account) (*mqttAccountSessionManager, error) {
+	sm := &s.mqtt.sessmgr
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if asm := sm.sessions[acc.Name]; asm != nil {
+		return asm, nil
+	}
+	if !acc.JetStreamEnabled() {
+		return nil, fmt.Errorf("jetstream not enabled for account %q", acc.Name)
+	}
+	asm, err := s.mqttCreateAccountSessionManager(acc)
+	if err != nil {
+		return nil, err
+	}
+	if sm.sessions == nil {
+		sm.sessions = make(map[string]*mqttAccountSessionManager)
+	}
+	sm.sessions[acc.Name] = asm
+	return asm, nil
+}
+
+func (s *Server) mqttCreateAccountSessionManager(acc *Account) (*mqttAccountSessionManager, error) {
+	asm := &mqttAccountSessionManager{
+		s:        s,
+		sess:     make(map[string]*mqttSession),
+		retained: make(map[string]*mqttRetainedMsg),
+		qos1:     NewSublistWithCache(),
+		qos1subs: make(map[string]*subscription),
+	}
+	var err error
+	if asm.msgs, err = mqttLookupOrAddStream(acc, &StreamConfig{
+		Name:      mqttStreamName,
+		Subjects:  []string{mqttStreamSubjectPrefix + ">"},
+		Retention: InterestPolicy,
+		Storage:   FileStorage,
+	}); err != nil {
+		return nil, err
+	}
+	if asm.rmsgs, err = mqttLookupOrAddStream(acc, &StreamConfig{
+		Name:     mqttRetainedMsgsStreamName,
+		Subjects: []string{mqttRetainedMsgsStreamSubject + ">"},
+		Storage:  FileStorage,
+	}); err != nil {
+		return nil, err
+	}
+	if asm.sessions, err = mqttLookupOrAddStream(acc, &StreamConfig{
+		Name:     mqttSessStreamName,
+		Subjects: []string{mqttSessStreamSubjectPrefix + ">"},
+		Storage:  FileStorage,
+	}); err != nil {
+		return nil, err
+	}
+
+	// Load the retained messages, keeping the most recent one per subject.
+	mqttLoadRecords(asm.rmsgs, func(seq uint64, data []byte) {
+		rm := &mqttRetainedMsg{}
+		if err := json.Unmarshal(data, rm); err != nil {
+			s.Warnf("Unable to load MQTT retained message %v: %v", seq, err)
+			return
+		}
+		if old := asm.retained[rm.Subject]; old != nil {
+			asm.rmsgs.RemoveMsg(old.seq)
+		}
+		rm.seq = seq
+		asm.retained[rm.Subject] = rm
+	})
+	// Load the persisted sessions.
+	mqttLoadRecords(asm.sessions, func(seq uint64, data []byte) {
+		ps := &mqttPersistedSession{}
+		if err := json.Unmarshal(data, ps); err != nil {
+			s.Warnf("Unable to load MQTT session %v: %v", seq, err)
+			return
+		}
+		if old := asm.sess[ps.ID]; old != nil {
+			asm.sessions.RemoveMsg(old.seq)
+		}
+		sess := newMQTTSession(ps.ID, false)
+		for filter, qos := range ps.Subs {
+			sess.subs[filter] = qos
+		}
+		sess.seq = seq
+		asm.sess[ps.ID] = sess
+	})
+	// Remove consumers left behind by sessions that are gone, for instance
+	// clean sessions of clients that were connected when the server stopped.
+	known := make(map[string]struct{})
+	for _, sess := range asm.sess {
+		for _, name := range sess.consumerNames() {
+			known[name] = struct{}{}
+		}
+	}
+	for _, o := range asm.msgs.Consumers() {
+		name := o.Name()
+		if !strings.HasPrefix(name, mqttConsumerNamePrefix) {
+			continue
+		}
+		if _, ok := known[name]; !ok {
+			o.Delete()
+		} else {
+			asm.addQoS1Interest(name, o.Config().FilterSubject)
+		}
+	}
+
+	asm.ic = s.createInternalJetStreamClient()
+	asm.ic.registerWithAccount(acc)
+	return asm, nil
+}
+
+func mqttLookupOrAddStream(acc *Account, cfg *StreamConfig) (*Stream, error) {
+	if mset, err := acc.LookupStream(cfg.Name); err == nil {
+		return mset, nil
+	}
+	return acc.AddStream(cfg)
+}
+
+// Invokes the callback for each message of the stream.
+func mqttLoadRecords(mset *Stream, cb func(seq uint64, data []byte)) {
+	state := mset.State()
+	for seq := state.FirstSeq; seq > 0 && seq <= state.LastSeq; seq++ {
+		sm, err := mset.GetMsg(seq)
+		if err != nil {
+			continue
+		}
+		cb(seq, sm.Data)
+	}
+}
+
+// Stores a record in the stream, bypassing the consumers.
+func mqttStoreMsg(mset *Stream, subject string, msg []byte) (uint64, error) {
+	mset.mu.RLock()
+	store := mset.store
+	mset.mu.RUnlock()
+	if store == nil {
+		return 0, ErrStoreClosed
+	}
+	seq, _, err := store.StoreMsg(subject, nil, msg)
+	return seq, err
+}
+
+// Returns a hash of the given string that can be used in subjects and names.
+func mqttHash(val string) string {
+	h := sha256.Sum256([]byte(val))
+	return hex.EncodeToString(h[:16])
+}
+
+func newMQTTSession(id string, clean bool) *mqttSession {
+	return &mqttSession{
+		id:       id,
+		hash:     mqttHash(id),
+		clean:    clean,
+		subs:     make(map[string]byte),
+		cons:     make(map[string]*Consumer),
+		dsubs:    make(map[string]*subscription),
+		pending:  make(map[uint16]string),
+		cpending: make(map[string]uint16),
+	}
+}
+
+// Returns the names of the consumers of the QoS 1 subscriptions.
+func (sess *mqttSession) consumerNames() []string {
+	var names []string
+	for filter, qos := range sess.subs {
+		if qos == 0 {
+			continue
+		}
+		subjects, err := mqttFilterToNATSSubjects(filter)
+		if err != nil {
+			continue
+		}
+		for i := range subjects {
+			names = append(names, mqttConsumerName(sess.id, mqttSubID(filter, subjects, i)))
+		}
+	}
+	return names
+}
+
+func mqttConsumerName(clientID, sid string) string {
+	return mqttConsumerNamePrefix + mqttHash(clientID+" "+sid)
+}
+
+// Returns the subscription ID for the subject at the given index of
+// the subjects of a topic filter. A filter ending with '#' also has a
+// subscription on the parent level, which uses the filter as its ID
+// since it would otherwise clash with the filter of that parent level.
+func mqttSubID(filter string, subjects []string, i int) string {
+	if i == 0 {
+		return subjects[0]
+	}
+	return filter
+}
+
+// Key that identifies a message sent by a consumer, regardless of the
+// number of deliveries, from its ack subject.
+// The ack subject is $JS.ACK.<stream>.<consumer>.<dcount>.<sseq>.<dseq>.<ts>
+func mqttPendingKey(ack string) string {
+	tokens := strings.Split(ack, tsep)
+	if len(tokens) < 6 {
+		return _EMPTY_
+	}
+	return tokens[3] + tsep + tokens[5]
+}
+
+// Records the ack subject of a message about to be sent to the client
+// and returns the packet identifier to use and if it is a redelivery.
+// A packet identifier of 0 means that none is available.
+func (sess *mqttSession) trackPending(ack string) (uint16, bool) {
+	key := mqttPendingKey(ack)
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if key != _EMPTY_ {
+		if pi, ok := sess.cpending[key]; ok {
+			sess.pending[pi] = ack
+			return pi, true
+		}
+	}
+	for i := 0; i < 0xffff; i++ {
+		sess.ppi++
+		if sess.ppi == 0 {
+			sess.ppi = 1
+		}
+		if _, used := sess.pending[sess.ppi]; used {
+			continue
+		}
+		sess.pending[sess.ppi] = ack
+		if key != _EMPTY_ {
+			sess.cpending[key] = sess.ppi
+		}
+		return sess.ppi, false
+	}
+	return 0, false
+}
+
+// Removes the message with the given packet identifier from the pending
+// ones and returns its ack subject.
+func (sess *mqttSession) untrackPending(pi uint16) string {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	ack, ok := sess.pending[pi]
+	if !ok {
+		return _EMPTY_
+	}
+	delete(sess.pending, pi)
+	if key := mqttPendingKey(ack); key != _EMPTY_ {
+		delete(sess.cpending, key)
+	}
+	return ack
+}
+
+// Stores the session record if the session is persistent.
+func (asm *mqttAccountSessionManager) persistSession(sess *mqttSession) {
+	if sess.clean {
+		return
+	}
+	sess.mu.Lock()
+	ps := &mqttPersistedSession{ID: sess.id, Subs: make(map[string]byte, len(sess.subs))}
+	for filter, qos := range sess.subs {
+		ps.Subs[filter] = qos
+	}
+	b, _ := json.Marshal(ps)
+	old := sess.seq
+	seq, err := mqttStoreMsg(asm.sessions, mqttSessStreamSubjectPrefix+sess.hash, b)
+	if err == nil {
+		sess.seq = seq
+	}
+	sess.mu.Unlock()
+	if err != nil {
+		asm.s.Errorf("Unable to store MQTT session %q: %v", sess.id, err)
+		return
+	}
+	if old > 0 {
+		asm.sessions.RemoveMsg(old)
+	}
+}
+
+// Removes the delivery subscriptions of the QoS 1 consumers of the session
+// so that messages are no longer sent to the client that owned it.
+func (asm *mqttAccountSessionManager) removeDeliverySubs(sess *mqttSession) {
+	sess.mu.Lock()
+	dsubs := sess.dsubs
+	sess.dsubs = make(map[string]*subscription)
+	sess.cons = make(map[string]*Consumer)
+	sess.pending = make(map[uint16]string)
+	sess.cpending = make(map[string]uint16)
+	sess.mu.Unlock()
+	for _, sub := range dsubs {
+		asm.ic.processUnsub(sub.sid)
+	}
+}
+
+// Deletes the consumers and the record of the session.
+func (asm *mqttAccountSessionManager) deleteSessionState(sess *mqttSession) {
+	asm.removeDeliverySubs(sess)
+	sess.mu.Lock()
+	names := sess.consumerNames()
+	seq := sess.seq
+	sess.seq = 0
+	sess.mu.Unlock()
+	for _, name := range names {
+		asm.removeQoS1Interest(name)
+		if o := asm.msgs.LookupConsumer(name); o != nil {
+			o.Delete()
+		}
+	}
+	if seq > 0 {
+		asm.sessions.RemoveMsg(seq)
+	}
+}
+
+// Creates, or binds to, the durable consumer of a QoS 1 subscription
+// and subscribes to its delivery subject.
+func (asm *mqttAccountSessionManager) addConsumer(c *client, sess *mqttSession, sid, subject string) error {
+	sess.mu.Lock()
+	_, ok := sess.cons[sid]
+	sess.mu.Unlock()
+	if ok {
+		return nil
+	}
+	ackWait := asm.s.getOpts().MQTT.AckWait
+	if ackWait == 0 {
+		ackWait = mqttDefaultAckWait
+	}
+	name := mqttConsumerName(sess.id, sid)
+	dsubj := mqttDeliverSubjectPrefix + name
+	cfg := &ConsumerConfig{
+		Durable:        name,
+		DeliverSubject: dsubj,
+		DeliverPolicy:  DeliverNew,
+		AckPolicy:      AckExplicit,
+		AckWait:        ackWait,
+		FilterSubject:  mqttStreamSubjectPrefix + subject,
+	}
+	o, err := asm.msgs.AddConsumer(cfg)
+	if err != nil {
+		// The durable may exist with a different configuration, for
+		// instance after the ack wait has changed, so replace it.
+		if eo := asm.msgs.LookupConsumer(name); eo != nil {
+			eo.Delete()
+			o, err = asm.msgs.AddConsumer(cfg)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	asm.addQoS1Interest(name, cfg.FilterSubject)
+	dsub, err := asm.ic.processSub([]byte(dsubj), nil, []byte(dsubj), c.mqttDeliverMsgCbQoS1(sess), false)
+	if err != nil {
+		return err
+	}
+	sess.mu.Lock()
+	sess.cons[sid] = o
+	sess.dsubs[sid] = dsub
+	sess.mu.Unlock()
+	return nil
+}
+
+// Deletes the consumer of the QoS 1 subscription, if any.
+func (asm *mqttAccountSessionManager) removeConsumer(sess *mqttSession, sid string) {
+	sess.mu.Lock()
+	o := sess.cons[sid]
+	dsub := sess.dsubs[sid]
+	delete(sess.cons, sid)
+	delete(sess.dsubs, sid)
+	sess.mu.Unlock()
+	asm.removeQoS1Interest(mqttConsumerName(sess.id, sid))
+	if dsub != nil {
+		asm.ic.processUnsub(dsub.sid)
+	}
+	if o != nil {
+		o.Delete()
+	}
+}
+
+// Records the filter subject of the QoS 1 consumer.
+func (asm *mqttAccountSessionManager) addQoS1Interest(name, filter string) {
+	asm.mu.Lock()
+	defer asm.mu.Unlock()
+	if _, ok := asm.qos1subs[name]; ok {
+		return
+	}
+	sub := &subscription{subject: []byte(filter), sid: []byte(name)}
+	if err := asm.qos1.Insert(sub); err != nil {
+		asm.s.Warnf("Unable to track MQTT QoS 1 filter %q: %v", filter, err)
+		return
+	}
+	asm.qos1subs[name] = sub
+}
+
+// Removes the filter subject of the QoS 1 consumer, if any.
+func (asm *mqttAccountSessionManager) removeQoS1Interest(name string) {
+	asm.mu.Lock()
+	defer asm.mu.Unlock()
+	if sub, ok := asm.qos1subs[name]; ok {
+		delete(asm.qos1subs, name)
+		asm.qos1.Remove(sub)
+	}
+}
+
+// Returns true if a QoS 1 subscription matches the subject.
+func (asm *mqttAccountSessionManager) hasQoS1Interest(subject string) bool {
+	return len(asm.qos1.Match(subject).psubs) > 0
+}
+
+// Stores the message as the retained message of its subject, or removes
+// the retained message if the payload is empty.
+func (asm *mqttAccountSessionManager) handleRetainedMsg(pp *mqttPublish) {
+	asm.mu.Lock()
+	old := asm.retained[pp.subject]
+	if len(pp.msg) == 0 {
+		delete(asm.retained, pp.subject)
+	} else {
+		rm := &mqttRetainedMsg{Topic: string(pp.topic), Subject: pp.subject, QoS: pp.qos, Msg: pp.msg}
+		b, _ := json.Marshal(rm)
+		seq, err := mqttStoreMsg(asm.rmsgs, mqttRetainedMsgsStreamSubject+pp.subject, b)
+		if err != nil {
+			asm.mu.Unlock()
+			asm.s.Errorf("Unable to store MQTT retained message on %q: %v", pp.subject, err)
+			return
+		}
+		rm.seq = seq
+		asm.retained[pp.subject] = rm
+	}
+	asm.mu.Unlock()
+	if old != nil {
+		asm.rmsgs.RemoveMsg(old.seq)
+	}
+}
+
+// Returns the retained messages matching any of the subjects.
+func (asm *mqttAccountSessionManager) retainedMsgs(subjects []string) []*mqttRetainedMsg {
+	asm.mu.Lock()
+	defer asm.mu.Unlock()
+	var rms []*mqttRetainedMsg
+	for subj, rm := range asm.retained {
+		for _, filter := range subjects {
+			if matchLiteral(subj, filter) {
+				rms = append(rms, rm)
+				break
+			}
+		}
+	}
+	return rms
+}
+
+// Publishes the message on behalf of the client, which is either the MQTT
+// client or the internal client for wills. QoS 1 messages are also stored
+// when a QoS 1 subscription matches them.
+func (asm *mqttAccountSessionManager) processPublish(c *client, pp *mqttPublish) {
+	// Do not store or retain what the client is not allowed to publish.
+	if c.kind == CLIENT && !c.pubAllowed(pp.subject) {
+		c.pubPermissionViolation([]byte(pp.subject))
+		return
+	}
+
+	var msg []byte
+	c.pa.subject = []byte(pp.subject)
+	c.pa.reply = nil
+	c.pa.deliver = nil
+	if pp.qos > 0 {
+		hdr := genHeader(nil, mqttNatsHeader, "1")
+		c.pa.hdr = len(hdr)
+		c.pa.hdb = []byte(strconv.Itoa(c.pa.hdr))
+		msg = make([]byte, 0, len(hdr)+len(pp.msg)+LEN_CR_LF)
+		msg = append(msg, hdr...)
+	} else {
+		c.pa.hdr = -1
+		c.pa.hdb = nil
+		msg = make([]byte, 0, len(pp.msg)+LEN_CR_LF)
+	}
+	msg = append(msg, pp.msg...)
+	c.pa.size = len(msg)
+	c.pa.szb = []byte(strconv.Itoa(c.pa.size))
+	msg = append(msg, _CRLF_...)
+
+	c.processInboundClientMsg(msg)
+
+	if pp.qos > 0 {
+		subject := mqttStreamSubjectPrefix + pp.subject
+		if asm.hasQoS1Interest(subject) {
+			asm.msgs.processInboundJetStreamMsg(nil, c, subject, _EMPTY_, msg[:len(msg)-LEN_CR_LF])
+		}
+	}
+	c.pa.subject, c.pa.szb, c.pa.hdb = nil, nil, nil
+
+	if pp.retain {
+		asm.handleRetainedMsg(pp)
+	}
+}
+
+// Publishes the will of a client.
+func (asm *mqttAccountSessionManager) publishWill(will *mqttPublish) {
+	asm.wmu.Lock()
+	defer asm.wmu.Unlock()
+	asm.processPublish(asm.ic, will)
+	asm.ic.flushClients(0)
+}
+
+// Converts a topic name, which can not have wildcards, to a NATS subject.
+func mqttTopicToNATSPubSubject(topic []byte) (string, error) {
+	if len(topic) == 0 {
+		return _EMPTY_, errMQTTTopicIsEmpty
+	}
+	levels := strings.Split(string(topic), "/")
+	for _, l := range levels {
+		if err := mqttCheckLevel(l); err != nil {
+			return _EMPTY_, err
+		}
+		if strings.ContainsAny(l, "+#") {
+			return _EMPTY_, fmt.Errorf("mqtt: wildcards not allowed in topic name %q", topic)
+		}
+	}
+	return strings.Join(levels, tsep), nil
+}
+
+// Converts a topic filter to the NATS subjects to subscribe to.
+// The '+' wildcard becomes '*' and '#' becomes '>'. Since '#' also
+// matches the parent level, "a/#" is converted to "a.>" and "a".
+func mqttFilterToNATSSubjects(filter string) ([]string, error) {
+	if filter == _EMPTY_ {
+		return nil, errMQTTTopicIsEmpty
+	}
+	levels := strings.Split(filter, "/")
+	tokens := make([]string, len(levels))
+	last := len(levels) - 1
+	for i, l := range levels {
+		switch l {
+		case "+":
+			tokens[i] = string(pwc)
+		case "#":
+			if i != last {
+				return nil, errMQTTNotSupportedFilter
+			}
+			tokens[i] = string(fwc)
+		default:
+			if err := mqttCheckLevel(l); err != nil {
+				return nil, err
+			}
+			if strings.ContainsAny(l, "+#") {
+				return nil, errMQTTNotSupportedFilter
+			}
+			tokens[i] = l
+		}
+	}
+	subjects := []string{strings.Join(tokens, tsep)}
+	if last > 0 && levels[last] == "#" {
+		subjects = append(subjects, strings.Join(tokens[:last], tsep))
+	}
+	return subjects, nil
+}
+
+// Rejects topic levels that can not be represented in a NATS subject.
+func mqttCheckLevel(l string) error {
+	if l == _EMPTY_ || l == string(pwc) || l == string(fwc) || strings.ContainsAny(l, ". \t\r\n") {
+		return fmt.Errorf("mqtt: topic level %q not supported", l)
+	}
+	return nil
+}
+
+// Converts a NATS subject to a MQTT topic name.
+func natsSubjectToMQTTTopic(subject string) []byte {
+	topic := []byte(subject)
+	for i, b := range topic {
+		if b == btsep {
+			topic[i] = '/'
+		}
+	}
+	return topic
+}
+
+//////////////////////////////////////////////////////////////////////////////
+//
+// Parsing
+//
+//////////////////////////////////////////////////////////////////////////////
+
+type mqttReader struct {
+	buf []byte
+	pos int
+}
+
+func (r *mqttReader) hasMore() bool {
+	return r.pos != len(r.buf)
+}
+
+func (r *mqttReader) readByte(field string) (byte, error) {
+	if r.pos == len(r.buf) {
+		return 0, fmt.Errorf("mqtt: error reading %s: %v", field, errors.New("not enough data"))
+	}
+	b := r.buf[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *mqttReader) readUint16(field string) (uint16, error) {
+	if len(r.buf)-r.pos < 2 {
+		return 0, fmt.Errorf("mqtt: error reading %s: %v", field, errors.New("not enough data"))
+	}
+	v := binary.BigEndian.Uint16(r.buf[r.pos:])
+	r.pos += 2
+	return v, nil
+}
+
+func (r *mqttReader) readBytes(field string) ([]byte, error) {
+	l, err := r.readUint16(field)
+	if err != nil {
+		return nil, err
+	}
+	if len(r.buf)-r.pos < int(l) {
+		return nil, fmt.Errorf("mqtt: error reading %s: %v", field, errors.New("not enough data"))
+	}
+	b := r.buf[r.pos : r.pos+int(l)]
+	r.pos += int(l)
+	return b, nil
+}
+
+func (r *mqttReader) readString(field string) (string, error) {
+	b, err := r.readBytes(field)
+	if err != nil {
+		return _EMPTY_, err
+	}
+	// Spec [MQTT-1.5.3-1]
+	if !utf8.Valid(b) {
+		return _EMPTY_, fmt.Errorf("mqtt: invalid utf8 for %s", field)
+	}
+	return string(b), nil
+}
+
+// Decodes the remaining length of a packet. Returns the number of bytes
+// it was encoded with, which is 0 if more bytes are needed.
+func mqttDecodeRemainingLength(b []byte) (int, int, error) {
+	rl := 0
+	for i := 0; i < 4; i++ {
+		if i == len(b) {
+			return 0, 0, nil
+		}
+		rl |= int(b[i]&0x7f) << (7 * uint(i))
+		if b[i]&0x80 == 0 {
+			return rl, i + 1, nil
+		}
+	}
+	return 0, 0, errMQTTMalformedVarInt
+}
+
+func mqttEncodeRemainingLength(b []byte, rl int) []byte {
+	for {
+		d := byte(rl % 128)
+		rl /= 128
+		if rl > 0 {
+			d |= 0x80
+		}
+		b = append(b, d)
+		if rl == 0 {
+			return b
+		}
+	}
+}
+
+func mqttAppendBytes(b, v []byte) []byte {
+	b = append(b, byte(len(v)>>8), byte(len(v)))
+	return append(b, v...)
+}
+
+// Parses the MQTT packets in the buffer. An incomplete packet is kept
+// until the rest of it is read.
+// Runs from the readLoop only.
+func (c *client) mqttParse(buf []byte) error {
+	mq := c.mqtt
+	atomic.StoreInt64(&mq.last, time.Now().UnixNano())
+	if len(mq.pending) > 0 {
+		buf = append(mq.pending, buf...)
+		mq.pending = nil
+	}
+	mpay := int(atomic.LoadInt32(&c.mpay))
+	for len(buf) > 1 {
+		rl, n, err := mqttDecodeRemainingLength(buf[1:])
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			break
+		}
+		if mpay > 0 && rl > mpay+mqttMaxPubOverhead {
+			c.maxPayloadViolation(rl, int32(mpay))
+			return ErrMaxPayload
+		}
+		if len(buf) < 1+n+rl {
+			break
+		}
+		pt, flags := buf[0]&mqttPacketMask, buf[0]&mqttPacketFlagMask
+		pl := buf[1+n : 1+n+rl]
+		buf = buf[1+n+rl:]
+
+		if !mq.connected && pt != mqttPacketConnect {
+			return errMQTTNotConnected
+		}
+		switch pt {
+		case mqttPacketConnect:
+			if mq.connected {
+				return errMQTTSecondConnect
+			}
+			err = c.mqttProcessConnect(pl)
+		case mqttPacketPub:
+			err = c.mqttProcessPub(flags, pl)
+		case mqttPacketPubAck:
+			err = c.mqttProcessPubAck(pl)
+		case mqttPacketSub:
+			err = c.mqttProcessSubs(flags, pl)
+		case mqttPacketUnsub:
+			err = c.mqttProcessUnsubs(flags, pl)
+		case mqttPacketPing:
+			c.mu.Lock()
+			c.mqttEnqueue([]byte{mqttPacketPingResp, 0})
+			c.mu.Unlock()
+		case mqttPacketDisconnect:
+			// Spec [MQTT-3.14.4-3]: the will is discarded.
+			c.mu.Lock()
+			mq.will = nil
+			c.mu.Unlock()
+			c.closeConnection(ClientClosed)
+			return nil
+		default:
+			err = errMQTTUnsupportedPacket
+		}
+		if err != nil {
+			return err
+		}
+		c.mu.Lock()
+		closed := c.isClosed()
+		c.mu.Unlock()
+		if closed {
+			return nil
+		}
+	}
+	if len(buf) > 0 {
+		mq.pending = append([]byte(nil), buf...)
+	}
+	return nil
+}
+
+func mqttParseConnect(pl []byte) (*mqttConnectProto, byte, error) {
+	r := &mqttReader{buf: pl}
+	name, err := r.readString("protocol name")
+	if err != nil {
+		return nil, 0, err
+	}
+	if name != mqttProtoName {
+		return nil, 0, fmt.Errorf("mqtt: invalid protocol name %q", name)
+	}
+	level, err := r.readByte("protocol level")
+	if err != nil {
+		return nil, 0, err
+	}
+	// Spec [MQTT-3.1.2-2]
+	if level != mqttProtoLevel {
+		return nil, mqttConnAckRCUnacceptableProtocolVersion, fmt.Errorf("mqtt: unsupported protocol level %v", level)
+	}
+	cp := &mqttConnectProto{}
+	if cp.flags, err = r.readByte("flags"); err != nil {
+		return nil, 0, err
+	}
+	// Spec [MQTT-3.1.2-3]
+	if cp.flags&mqttConnFlagReserved != 0 {
+		return nil, 0, errMQTTBadConnectFlags
+	}
+	willQoS := (cp.flags & mqttConnFlagWillQoS) >> 3
+	willRetain := cp.flags&mqttConnFlagWillRetain != 0
+	if cp.flags&mqttConnFlagWillFlag == 0 {
+		// Spec [MQTT-3.1.2-13] and [MQTT-3.1.2-15]
+		if willQoS != 0 || willRetain {
+			return nil, 0, errMQTTBadConnectFlags
+		}
+	} else if willQoS > 1 {
+		return nil, 0, errMQTTQoS2NotSupported
+	}
+	// Spec [MQTT-3.1.2-22]
+	if cp.flags&mqttConnFlagUsernameFlag == 0 && cp.flags&mqttConnFlagPasswordFlag != 0 {
+		return nil, 0, errMQTTBadConnectFlags
+	}
+	if cp.keepAlive, err = r.readUint16("keep alive"); err != nil {
+		return nil, 0, err
+	}
+	if cp.clientID, err = r.readString("client ID"); err != nil {
+		return nil, 0, err
+	}
+	if cp.flags&mqttConnFlagWillFlag != 0 {
+		topic, err := r.readBytes("will topic")
+		if err != nil {
+			return nil, 0, err
+		}
+		subject, err := mqttTopicToNATSPubSubject(topic)
+		if err != nil {
+			return nil, 0, err
+		}
+		msg, err := r.readBytes("will message")
+		if err != nil {
+			return nil, 0, err
+		}
+		cp.will = &mqttPublish{
+			topic:   append([]byte(nil), topic...),
+			subject: subject,
+			msg:     append([]byte(nil), msg...),
+			qos:     willQoS,
+			retain:  willRetain,
+		}
+	}
+	if cp.flags&mqttConnFlagUsernameFlag != 0 {
+		if cp.username, err = r.readString("user name"); err != nil {
+			return nil, 0, err
+		}
+	}
+	if cp.flags&mqttConnFlagPasswordFlag != 0 {
+		pwd, err := r.readBytes("password")
+		if err != nil {
+			return nil, 0, err
+		}
+		cp.password = append([]byte(nil), pwd...)
+	}
+	return cp, 0, nil
+}
+
+// Processes the CONNECT packet: authenticates the client and sets up
+// its session.
+// The password is used as a password, a token or a user JWT. Since
+// there is no nonce that MQTT clients could sign before sending the
+// CONNECT packet, nkey users are not supported and user JWTs need to
+// be bearer tokens.
+func (c *client) mqttProcessConnect(pl []byte) error {
+	s := c.srv
+	cp, rc, err := mqttParseConnect(pl)
+	if err != nil {
+		if rc != 0 {
+			c.mqttSendConnAck(rc, false)
+		}
+		return err
+	}
+
+	c.mu.Lock()
+	// If we can't stop the timer because the callback is in progress,
+	// the connection is about to be closed.
+	if !c.clearAuthTimer() {
+		c.mu.Unlock()
+		return nil
+	}
+	c.flags.set(connectReceived)
+	c.opts.Username = cp.username
+	c.opts.Password = string(cp.password)
+	c.opts.Token = c.opts.Password
+	c.opts.JWT = c.opts.Password
+	c.mu.Unlock()
+
+	if !s.checkAuthentication(c) {
+		c.mqttSendConnAck(mqttConnAckRCNotAuthorized, false)
+		c.authViolation()
+		return ErrAuthentication
+	}
+	c.mu.Lock()
+	acc := c.acc
+	c.mu.Unlock()
+	if acc == nil {
+		acc = s.globalAccount()
+		c.registerWithAccount(acc)
+	}
+	if cp.will != nil && !c.pubAllowed(cp.will.subject) {
+		c.mqttSendConnAck(mqttConnAckRCNotAuthorized, false)
+		c.pubPermissionViolation([]byte(cp.will.subject))
+		c.closeConnection(AuthenticationViolation)
+		return nil
+	}
+
+	clean := cp.flags&mqttConnFlagCleanSession != 0
+	cid := cp.clientID
+	if cid == _EMPTY_ {
+		// Spec [MQTT-3.1.3-7] and [MQTT-3.1.3-8]
+		if !clean {
+			c.mqttSendConnAck(mqttConnAckRCIdentifierRejected, false)
+			return errMQTTClientIDRequired
+		}
+		cid = nuid.Next()
+	}
+
+	asm, err := s.mqttGetAccountSessionManager(acc)
+	if err != nil {
+		c.mqttSendConnAck(mqttConnAckRCServerUnavailable, false)
+		return fmt.Errorf("mqtt: unable to setup session: %v", err)
+	}
+
+	// Take over the session of a client connected with the same identifier.
+	asm.mu.Lock()
+	sess := asm.sess[cid]
+	var old *client
+	if sess != nil {
+		old = sess.c
+		sess.c = nil
+	}
+	var wipe *mqttSession
+	present := sess != nil && !clean
+	if !present {
+		wipe = sess
+		sess = newMQTTSession(cid, clean)
+		asm.sess[cid] = sess
+	}
+	sess.c = c
+	asm.mu.Unlock()
+
+	if old != nil {
+		asm.removeDeliverySubs(sess)
+		old.Debugf("Client %q connected elsewhere, closing this connection", cid)
+		old.closeConnection(DuplicateClientID)
+	}
+	if wipe != nil {
+		asm.deleteSessionState(wipe)
+	}
+
+	c.mu.Lock()
+	c.opts.Name = cid
+	mq := c.mqtt
+	mq.asm, mq.sess, mq.will = asm, sess, cp.will
+	mq.connected = true
+	// Spec [MQTT-3.1.2-24]: the server closes the connection after one
+	// and a half times the keep alive without receiving anything.
+	if cp.keepAlive > 0 {
+		mq.kad = time.Duration(cp.keepAlive) * time.Second * 3 / 2
+		c.ping.tmr = time.AfterFunc(mq.kad, c.mqttKeepAliveCheck)
+	}
+	c.mu.Unlock()
+
+	c.mqttSendConnAck(mqttConnAckRCConnectionAccepted, present)
+
+	// Restore the subscriptions of a persistent session.
+	if present {
+		sess.mu.Lock()
+		subs := make(map[string]byte, len(sess.subs))
+		for filter, qos := range sess.subs {
+			subs[filter] = qos
+		}
+		sess.mu.Unlock()
+		for filter, qos := range subs {
+			if c.mqttAddSubscription(filter, qos) == mqttSubAckFailure {
+				c.Warnf("Unable to restore subscription on %q", filter)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *client) mqttSendConnAck(rc byte, sessionPresent bool) {
+	var sp byte
+	if sessionPresent {
+		sp = 1
+	}
+	c.mu.Lock()
+	c.mqttEnqueue([]byte{mqttPacketConnectAck, 2, sp, rc})
+	c.mu.Unlock()
+}
+
+// Closes the connection if nothing was read during the keep alive
+// interval, otherwise reschedules itself.
+func (c *client) mqttKeepAliveCheck() {
+	c.mu.Lock()
+	if c.isClosed() {
+		c.mu.Unlock()
+		return
+	}
+	mq := c.mqtt
+	elapsed := time.Since(time.Unix(0, atomic.LoadInt64(&mq.last)))
+	if elapsed >= mq.kad {
+		c.mu.Unlock()
+		c.Debugf("Stale Client Connection - Closing")
+		c.closeConnection(StaleConnection)
+		return
+	}
+	c.ping.tmr = time.AfterFunc(mq.kad-elapsed, c.mqttKeepAliveCheck)
+	c.mu.Unlock()
+}
+
+func (c *client) mqttProcessPub(flags byte, pl []byte) error {
+	qos := (flags & mqttPubFlagQoS) >> 1
+	if qos > 1 {
+		return errMQTTQoS2NotSupported
+	}
+	r := &mqttReader{buf: pl}
+	topic, err := r.readBytes("topic")
+	if err != nil {
+		return err
+	}
+	subject, err := mqttTopicToNATSPubSubject(topic)
+	if err != nil {
+		return err
+	}
+	var pi uint16
+	if qos > 0 {
+		if pi, err = r.readUint16("packet identifier"); err != nil {
+			return err
+		}
+		if pi == 0 {
+			return errMQTTPacketIDIsZero
+		}
+	}
+	msg := pl[r.pos:]
+	if mpay := atomic.LoadInt32(&c.mpay); mpay > 0 && len(msg) > int(mpay) {
+		c.maxPayloadViolation(len(msg), mpay)
+		return ErrMaxPayload
+	}
+	pp := &mqttPublish{
+		topic:   topic,
+		subject: subject,
+		msg:     msg,
+		qos:     qos,
+		retain:  flags&mqttPubFlagRetain != 0,
+		pi:      pi,
+	}
+	if pp.retain {
+		// The retained message outlives the read buffer.
+		pp.topic = append([]byte(nil), topic...)
+		pp.msg = append([]byte(nil), msg...)
+	}
+	c.mqtt.asm.processPublish(c, pp)
+
+	if qos > 0 {
+		c.mu.Lock()
+		c.mqttEnqueue([]byte{mqttPacketPubAck, 2, byte(pi >> 8), byte(pi)})
+		c.mu.Unlock()
+	}
+	return nil
+}
+
+func (c *client) mqttProcessPubAck(pl []byte) error {
+	r := &mqttReader{buf: pl}
+	pi, err := r.readUint16("packet identifier")
+	if err != nil {
+		return err
+	}
+	if pi == 0 {
+		return errMQTTPacketIDIsZero
+	}
+	mq := c.mqtt
+	ack := mq.sess.untrackPending(pi)
+	if ack == _EMPTY_ {
+		return nil
+	}
+	// The consumer name is the 4th token of the ack subject.
+	tokens := strings.Split(ack, tsep)
+	if len(tokens) < 4 {
+		return nil
+	}
+	if o := mq.asm.msgs.LookupConsumer(tokens[3]); o != nil {
+		o.processAck(nil, nil, ack, _EMPTY_, nil)
+	}
+	return nil
+}
+
+func (c *client) mqttProcessSubs(flags byte, pl []byte) error {
+	// Spec [MQTT-3.8.1-1]
+	if flags != mqttSubscribeFlags {
+		return fmt.Errorf("mqtt: invalid subscribe flags %v", flags)
+	}
+	r := &mqttReader{buf: pl}
+	pi, err := r.readUint16("packet identifier")
+	if err != nil {
+		return err
+	}
+	if pi == 0 {
+		return errMQTTPacketIDIsZero
+	}
+	var filters []string
+	var qoss []byte
+	for r.hasMore() {
+		filter, err := r.readString("topic filter")
+		if err != nil {
+			return err
+		}
+		qos, err := r.readByte("QoS")
+		if err != nil {
+			return err
+		}
+		// Spec [MQTT-3.8.3-4]
+		if qos > 2 {
+			return errMQTTBadSubscribeQoS
+		}
+		filters = append(filters, filter)
+		qoss = append(qoss, qos)
+	}
+	// Spec [MQTT-3.8.3-3]
+	if len(filters) == 0 {
+		return errMQTTEmptySubscribe
+	}
+
+	mq := c.mqtt
+	codes := make([]byte, len(filters))
+	for i, filter := range filters {
+		// QoS 2 is downgraded to QoS 1.
+		qos := qoss[i]
+		if qos > 1 {
+			qos = 1
+		}
+		codes[i] = c.mqttAddSubscription(filter, qos)
+	}
+	mq.asm.persistSession(mq.sess)
+
+	ack := make([]byte, 0, 7+len(codes))
+	ack = append(ack, mqttPacketSubAck)
+	ack = mqttEncodeRemainingLength(ack, 2+len(codes))
+	ack = append(ack, byte(pi>>8), byte(pi))
+	ack = append(ack, codes...)
+	c.mu.Lock()
+	c.mqttEnqueue(ack)
+	c.mu.Unlock()
+
+	// Spec [MQTT-3.3.1-6]: send the retained messages matching the
+	// new subscriptions.
+	for i, filter := range filters {
+		if codes[i] == mqttSubAckFailure {
+			continue
+		}
+		subjects, _ := mqttFilterToNATSSubjects(filter)
+		for _, rm := range mq.asm.retainedMsgs(subjects) {
+			qos := rm.QoS
+			if qos > codes[i] {
+				qos = codes[i]
+			}
+			var pi uint16
+			if qos > 0 {
+				// There is no consumer to ack for a retained message.
+				if pi, _ = mq.sess.trackPending(_EMPTY_); pi == 0 {
+					continue
+				}
+			}
+			c.mqttEnqueuePublish([]byte(rm.Topic), rm.Msg, qos, pi, false, true)
+		}
+	}
+	return nil
+}
+
+// Subscribes to the NATS subjects of the topic filter and, for QoS 1,
+// binds to the consumers on the messages stream. Returns the granted
+// QoS, or mqttSubAckFailure.
+func (c *client) mqttAddSubscription(filter string, qos byte) byte {
+	subjects, err := mqttFilterToNATSSubjects(filter)
+	if err != nil {
+		c.Errorf("Invalid topic filter %q: %v", filter, err)
+		return mqttSubAckFailure
+	}
+	mq := c.mqtt
+	sess := mq.sess
+	// Messages are not sent to wildcard subscriptions on topics starting
+	// with '$'. Spec [MQTT-4.7.2-1]
+	skipDollar := filter[0] == '+' || filter[0] == '#'
+	for i, subj := range subjects {
+		sid := mqttSubID(filter, subjects, i)
+		// A subscription on the same filter is replaced.
+		c.processUnsub([]byte(sid))
+		_, err = c.processSub([]byte(subj), nil, []byte(sid), c.mqttDeliverMsgCbQoS0(qos, skipDollar), false)
+		if err == nil {
+			if qos > 0 {
+				err = mq.asm.addConsumer(c, sess, sid, subj)
+			} else {
+				mq.asm.removeConsumer(sess, sid)
+			}
+		}
+		if err != nil {
+			c.Errorf("Unable to subscribe to %q: %v", filter, err)
+			c.mqttRemoveSubscription(filter)
+			return mqttSubAckFailure
+		}
+	}
+	sess.mu.Lock()
+	sess.subs[filter] = qos
+	sess.mu.Unlock()
+	return qos
+}
+
+// Removes the subscriptions and the consumers of the topic filter.
+func (c *client) mqttRemoveSubscription(filter string) {
+	mq := c.mqtt
+	subjects, err := mqttFilterToNATSSubjects(filter)
+	if err == nil {
+		for i := range subjects {
+			sid := mqttSubID(filter, subjects, i)
+			c.processUnsub([]byte(sid))
+			mq.asm.removeConsumer(mq.sess, sid)
+		}
+	}
+	mq.sess.mu.Lock()
+	delete(mq.sess.subs, filter)
+	mq.sess.mu.Unlock()
+}
+
+func (c *client) mqttProcessUnsubs(flags byte, pl []byte) error {
+	// Spec [MQTT-3.10.1-1]
+	if flags != mqttUnsubscribeFlags {
+		return fmt.Errorf("mqtt: invalid unsubscribe flags %v", flags)
+	}
+	r := &mqttReader{buf: pl}
+	pi, err := r.readUint16("packet identifier")
+	if err != nil {
+		return err
+	}
+	if pi == 0 {
+		return errMQTTPacketIDIsZero
+	}
+	var filters []string
+	for r.hasMore() {
+		filter, err := r.readString("topic filter")
+		if err != nil {
+			return err
+		}
+		filters = append(filters, filter)
+	}
+	// Spec [MQTT-3.10.3-2]
+	if len(filters) == 0 {
+		return errMQTTEmptyUnsubscribe
+	}
+	mq := c.mqtt
+	for _, filter := range filters {
+		c.mqttRemoveSubscription(filter)
+	}
+	mq.asm.persistSession(mq.sess)
+
+	c.mu.Lock()
+	c.mqttEnqueue([]byte{mqttPacketUnsubAck, 2, byte(pi >> 8), byte(pi)})
+	c.mu.Unlock()
+	return nil
+}
+
+// Returns the header and the payload of a message delivered to a callback.
+func mqttSplitMsg(pc *client, msg []byte) ([]byte, []byte) {
+	if pc.pa.hdr > 0 && pc.pa.hdr <= len(msg) {
+		return msg[:pc.pa.hdr], msg[pc.pa.hdr:]
+	}
+	return nil, msg
+}
+
+// Returns the callback of the subscriptions on the NATS subjects of a
+// topic filter. Messages are sent with QoS 0, except that QoS 1 messages
+// published by MQTT clients are skipped for QoS 1 subscriptions, which
+// get them from their consumer.
+func (c *client) mqttDeliverMsgCbQoS0(qos byte, skipDollar bool) msgHandler {
+	return func(_ *subscription, pc *client, subject, _ string, msg []byte) {
+		if skipDollar && strings.HasPrefix(subject, "$") {
+			return
+		}
+		hdr, payload := mqttSplitMsg(pc, msg)
+		if qos > 0 && len(hdr) > 0 && getHdrVal(mqttNatsHeader, hdr) != nil {
+			return
+		}
+		c.mqttEnqueuePublish(natsSubjectToMQTTTopic(subject), payload, 0, 0, false, false)
+	}
+}
+
+// Returns the callback of the delivery subscription of a QoS 1 consumer.
+func (c *client) mqttDeliverMsgCbQoS1(sess *mqttSession) msgHandler {
+	return func(_ *subscription, pc *client, _, reply string, msg []byte) {
+		// The stream's client has the original subject of the message.
+		subject := string(pc.pa.deliver)
+		if !strings.HasPrefix(subject, mqttStreamSubjectPrefix) {
+			return
+		}
+		_, payload := mqttSplitMsg(pc, msg)
+		pi, dup := sess.trackPending(reply)
+		if pi == 0 {
+			c.Warnf("No packet identifier available, message on %q not sent", subject)
+			return
+		}
+		topic := natsSubjectToMQTTTopic(subject[len(mqttStreamSubjectPrefix):])
+		c.mqttEnqueuePublish(topic, payload, 1, pi, dup, false)
+	}
+}
+
+func (c *client) mqttEnqueuePublish(topic, payload []byte, qos byte, pi uint16, dup, retain bool) {
+	flags := qos << 1
+	if dup {
+		flags |= mqttPubFlagDup
+	}
+	if retain {
+		flags |= mqttPubFlagRetain
+	}
+	rl := 2 + len(topic) + len(payload)
+	if qos > 0 {
+		rl += 2
+	}
+	pkt := make([]byte, 0, 5+rl)
+	pkt = append(pkt, mqttPacketPub|flags)
+	pkt = mqttEncodeRemainingLength(pkt, rl)
+	pkt = mqttAppendBytes(pkt, topic)
+	if qos > 0 {
+		pkt = append(pkt, byte(pi>>8), byte(pi))
+	}
+	pkt = append(pkt, payload...)
+	c.mu.Lock()
+	c.mqttEnqueue(pkt)
+	c.mu.Unlock()
+}
+
+// Queues the packet and signals the writeLoop.
+// Lock is held on entry.
+func (c *client) mqttEnqueue(pkt []byte) {
+	if c.isClosed() {
+		return
+	}
+	c.queueOutbound(pkt)
+	c.flushSignal()
+}
+
+// Publishes the will, if any, and releases the session of the client,
+// deleting it if it was not persistent.
+func (c *client) mqttHandleClosedClient(reason ClosedState) {
+	c.mu.Lock()
+	mq := c.mqtt
+	asm, sess, will := mq.asm, mq.sess, mq.will
+	mq.will = nil
+	c.mu.Unlock()
+	if asm == nil {
+		return
+	}
+	if will != nil {
+		c.Debugf("Publishing will on %q, connection closed: %s", will.subject, reason)
+		asm.publishWill(will)
+	}
+
+	asm.mu.Lock()
+	owner := sess.c == c
+	if owner {
+		sess.c = nil
+		if sess.clean {
+			delete(asm.sess, sess.id)
+		}
+	}
+	asm.mu.Unlock()
+	// The session may have been taken over by another connection.
+	if !owner {
+		return
+	}
+	if sess.clean {
+		asm.deleteSessionState(sess)
+	} else {
+		asm.removeDeliverySubs(sess)
+	}
+}
@@ -0,0 +1,761 @@
+// Copyright 2020 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+)
+
+type testMQTTConnectOpts struct {
+	clientID  string
+	clean     bool
+	user      string
+	pass      string
+	keepAlive uint16
+	willTopic string
+	willMsg   string
+}
+
+func testMQTTDefaultOptions(t *testing.T) (*Options, string) {
+	t.Helper()
+	storeDir, err := ioutil.TempDir("", JetStreamStoreDir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	o := DefaultOptions()
+	// JetStream requires a standalone server.
+	o.Cluster.Port = 0
+	o.JetStream = true
+	o.StoreDir = storeDir
+	o.MQTT.Host = "127.0.0.1"
+	o.MQTT.Port = -1
+	return o, storeDir
+}
+
+func testMQTTRunServer(t *testing.T, o *Options) *Server {
+	t.Helper()
+	s, err := NewServer(o)
+	if err != nil {
+		t.Fatalf("Error creating server: %v", err)
+	}
+	s.ConfigureLogger()
+	go s.Start()
+	if !s.ReadyForConnections(10 * time.Second) {
+		s.Shutdown()
+		t.Fatalf("Unable to start server")
+	}
+	return s
+}
+
+func testMQTTPacket(ptype byte, body []byte) []byte {
+	pkt := []byte{ptype}
+	pkt = mqttEncodeRemainingLength(pkt, len(body))
+	return append(pkt, body...)
+}
+
+func testMQTTConnectPacket(co *testMQTTConnectOpts) []byte {
+	var flags byte
+	if co.clean {
+		flags |= mqttConnFlagCleanSession
+	}
+	if co.willTopic != _EMPTY_ {
+		flags |= mqttConnFlagWillFlag
+	}
+	if co.user != _EMPTY_ {
+		flags |= mqttConnFlagUsernameFlag
+	}
+	if co.pass != _EMPTY_ {
+		flags |= mqttConnFlagPasswordFlag
+	}
+	body := mqttAppendBytes(nil, []byte(mqttProtoName))
+	body = append(body, mqttProtoLevel, flags, byte(co.keepAlive>>8), byte(co.keepAlive))
+	body = mqttAppendBytes(body, []byte(co.clientID))
+	if co.willTopic != _EMPTY_ {
+		body = mqttAppendBytes(body, []byte(co.willTopic))
+		body = mqttAppendBytes(body, []byte(co.willMsg))
+	}
+	if co.user != _EMPTY_ {
+		body = mqttAppendBytes(body, []byte(co.user))
+	}
+	if co.pass != _EMPTY_ {
+		body = mqttAppendBytes(body, []byte(co.pass))
+	}
+	return testMQTTPacket(mqttPacketConnect, body)
+}
+
+// Connects and returns the connection, the reader and the CONNACK
+// return code and session present flag.
+func testMQTTConnectRC(t testing.TB, host string, port int, co *testMQTTConnectOpts) (net.Conn, *bufio.Reader, byte, bool) {
+	t.Helper()
+	c, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		t.Fatalf("Error creating mqtt connection: %v", err)
+	}
+	if _, err := c.Write(testMQTTConnectPacket(co)); err != nil {
+		t.Fatalf("Error sending connect: %v", err)
+	}
+	r := bufio.NewReader(c)
+	ptype, pl := testMQTTRead(t, c, r)
+	if ptype != mqttPacketConnectAck || len(pl) != 2 {
+		t.Fatalf("Expected CONNACK, got %x %v", ptype, pl)
+	}
+	return c, r, pl[1], pl[0] == 1
+}
+
+func testMQTTConnect(t testing.TB, host string, port int, co *testMQTTConnectOpts) (net.Conn, *bufio.Reader, bool) {
+	t.Helper()
+	c, r, rc, sp := testMQTTConnectRC(t, host, port, co)
+	if rc != mqttConnAckRCConnectionAccepted {
+		c.Close()
+		t.Fatalf("Expected connection to be accepted, got return code %v", rc)
+	}
+	return c, r, sp
+}
+
+// Reads a packet and returns its first byte and its payload.
+func testMQTTRead(t testing.TB, c net.Conn, r *bufio.Reader) (byte, []byte) {
+	t.Helper()
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	defer c.SetReadDeadline(time.Time{})
+	b, err := r.ReadByte()
+	if err != nil {
+		t.Fatalf("Error reading packet: %v", err)
+	}
+	rl, mul := 0, uint(0)
+	for {
+		d, err := r.ReadByte()
+		if err != nil {
+			t.Fatalf("Error reading packet: %v", err)
+		}
+		rl |= int(d&0x7f) << mul
+		mul += 7
+		if d&0x80 == 0 {
+			break
+		}
+	}
+	pl := make([]byte, rl)
+	if _, err := io.ReadFull(r, pl); err != nil {
+		t.Fatalf("Error reading packet: %v", err)
+	}
+	return b, pl
+}
+
+func testMQTTExpectNothing(t testing.TB, c net.Conn, r *bufio.Reader) {
+	t.Helper()
+	c.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	defer c.SetReadDeadline(time.Time{})
+	if b, err := r.ReadByte(); err == nil {
+		t.Fatalf("Expected nothing, got packet %x", b)
+	}
+}
+
+func testMQTTSub(t testing.TB, c net.Conn, r *bufio.Reader, pi uint16, filters []string, qos byte, expected []byte) {
+	t.Helper()
+	body := []byte{byte(pi >> 8), byte(pi)}
+	for _, f := range filters {
+		body = mqttAppendBytes(body, []byte(f))
+		body = append(body, qos)
+	}
+	if _, err := c.Write(testMQTTPacket(mqttPacketSub|mqttSubscribeFlags, body)); err != nil {
+		t.Fatalf("Error sending subscribe: %v", err)
+	}
+	ptype, pl := testMQTTRead(t, c, r)
+	if ptype != mqttPacketSubAck {
+		t.Fatalf("Expected SUBACK, got %x", ptype)
+	}
+	expectedPl := append([]byte{byte(pi >> 8), byte(pi)}, expected...)
+	if !reflect.DeepEqual(pl, expectedPl) {
+		t.Fatalf("Expected SUBACK %v, got %v", expectedPl, pl)
+	}
+}
+
+func testMQTTPub(t testing.TB, c net.Conn, r *bufio.Reader, qos byte, topic, payload string, retain bool, pi uint16) {
+	t.Helper()
+	flags := qos << 1
+	if retain {
+		flags |= mqttPubFlagRetain
+	}
+	body := mqttAppendBytes(nil, []byte(topic))
+	if qos > 0 {
+		body = append(body, byte(pi>>8), byte(pi))
+	}
+	body = append(body, payload...)
+	if _, err := c.Write(testMQTTPacket(mqttPacketPub|flags, body)); err != nil {
+		t.Fatalf("Error sending publish: %v", err)
+	}
+	if qos > 0 {
+		ptype, pl := testMQTTRead(t, c, r)
+		if ptype != mqttPacketPubAck || !reflect.DeepEqual(pl, []byte{byte(pi >> 8), byte(pi)}) {
+			t.Fatalf("Expected PUBACK for %v, got %x %v", pi, ptype, pl)
+		}
+	}
+}
+
+// Reads a PUBLISH packet, checks its topic and payload and returns its
+// flags and packet identifier.
+func testMQTTExpectPub(t testing.TB, c net.Conn, r *bufio.Reader, topic, payload string) (byte, uint16) {
+	t.Helper()
+	ptype, pl := testMQTTRead(t, c, r)
+	if ptype&mqttPacketMask != mqttPacketPub {
+		t.Fatalf("Expected PUBLISH, got %x", ptype)
+	}
+	flags := ptype & mqttPacketFlagMask
+	rd := &mqttReader{buf: pl}
+	tp, err := rd.readBytes("topic")
+	if err != nil {
+		t.Fatalf("Error reading topic: %v", err)
+	}
+	var pi uint16
+	if flags&mqttPubFlagQoS != 0 {
+		if pi, err = rd.readUint16("packet identifier"); err != nil {
+			t.Fatalf("Error reading packet identifier: %v", err)
+		}
+	}
+	if string(tp) != topic || string(pl[rd.pos:]) != payload {
+		t.Fatalf("Expected %q on %q, got %q on %q", payload, topic, pl[rd.pos:], tp)
+	}
+	return flags, pi
+}
+
+func testMQTTPubAck(t testing.TB, c net.Conn, pi uint16) {
+	t.Helper()
+	if _, err := c.Write([]byte{mqttPacketPubAck, 2, byte(pi >> 8), byte(pi)}); err != nil {
+		t.Fatalf("Error sending puback: %v", err)
+	}
+}
+
+func TestMQTTParseOptions(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		content  string
+		checkOpt func(*MQTTOpts) error
+		err      string
+	}{
+		// Negative tests
+		{"bad type", "mqtt: []", nil, "to be a map"},
+		{"bad listen", "mqtt: { listen: [] }", nil, "port or host:port"},
+		{"bad port", `mqtt: { port: "abc" }`, nil, "not int64"},
+		{"bad host", `mqtt: { host: 123 }`, nil, "not string"},
+		{"bad tls", `mqtt: { tls: 123 }`, nil, "not map[string]interface {}"},
+		{"bad ack wait", `mqtt: { ack_wait: "abc" }`, nil, "invalid duration"},
+		{"unknown field", `mqtt: { this_does_not_exist: 123 }`, nil, "unknown"},
+		{"nkeys", `mqtt: { authorization { users: [{nkey: "UDKTV7HZVYJFJN64LLMYQBUR6MTNNYCDC3LAZH4VHURW3GZLL3FULBXV"}] } }`, nil, "does not support nkeys"},
+		// Positive tests
+		{"listen host and port", `mqtt { listen: "localhost:1234" }`, func(mo *MQTTOpts) error {
+			if mo.Host != "localhost" || mo.Port != 1234 {
+				return fmt.Errorf("expected localhost:1234, got %v:%v", mo.Host, mo.Port)
+			}
+			return nil
+		}, ""},
+		{"port", `mqtt { port: 1234 }`, func(mo *MQTTOpts) error {
+			if mo.Port != 1234 {
+				return fmt.Errorf("expected 1234, got %v", mo.Port)
+			}
+			return nil
+		}, ""},
+		{"ack wait in seconds", `mqtt { ack_wait: 5 }`, func(mo *MQTTOpts) error {
+			if mo.AckWait != 5*time.Second {
+				return fmt.Errorf("expected ack wait to be 5s, got %v", mo.AckWait)
+			}
+			return nil
+		}, ""},
+		{"ack wait duration", `mqtt { ack_wait: "500ms" }`, func(mo *MQTTOpts) error {
+			if mo.AckWait != 500*time.Millisecond {
+				return fmt.Errorf("expected ack wait to be 500ms, got %v", mo.AckWait)
+			}
+			return nil
+		}, ""},
+		{"authorization", `mqtt { authorization { username: "user", password: "pwd", timeout: 2.0 } }`, func(mo *MQTTOpts) error {
+			if mo.Username != "user" || mo.Password != "pwd" || mo.AuthTimeout != 2.0 {
+				return fmt.Errorf("unexpected auth options: %q %q %v", mo.Username, mo.Password, mo.AuthTimeout)
+			}
+			return nil
+		}, ""},
+		{"tls config",
+			`
+			mqtt {
+				tls {
+					cert_file: "./configs/certs/server.pem"
+					key_file: "./configs/certs/key.pem"
+				}
+			}
+			`, func(mo *MQTTOpts) error {
+				if mo.TLSConfig == nil {
+					return fmt.Errorf("TLSConfig should have been set")
+				}
+				return nil
+			}, ""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			conf := createConfFile(t, []byte(test.content))
+			defer os.Remove(conf)
+			o, err := ProcessConfigFile(conf)
+			if test.err != _EMPTY_ {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("For content: %q, expected error about %q, got %v", test.content, test.err, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("Unexpected error for content %q: %v", test.content, err)
+			}
+			if err := test.checkOpt(&o.MQTT); err != nil {
+				t.Fatalf("Incorrect option for content %q: %v", test.content, err.Error())
+			}
+		})
+	}
+}
+
+func TestMQTTValidateOptions(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		getOpts func() *Options
+		err     string
+	}{
+		{"mqtt disabled", func() *Options { return DefaultOptions() }, ""},
+		{"no jetstream", func() *Options { o := DefaultOptions(); o.MQTT.Port = -1; return o }, "requires JetStream"},
+		{"negative ack wait", func() *Options {
+			o := DefaultOptions()
+			o.JetStream = true
+			o.MQTT.Port = -1
+			o.MQTT.AckWait = -time.Second
+			return o
+		}, "can not be negative"},
+		{"no auth user without users", func() *Options {
+			o := DefaultOptions()
+			o.JetStream = true
+			o.MQTT.Port = -1
+			o.MQTT.NoAuthUser = "user"
+			return o
+		}, "users are not"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateMQTTOptions(test.getOpts())
+			if test.err == "" && err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			} else if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Fatalf("Expected error to contain %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestMQTTTopicAndFilterConversion(t *testing.T) {
+	for _, test := range []struct {
+		filter   string
+		subjects []string
+		err      bool
+	}{
+		{"foo", []string{"foo"}, false},
+		{"foo/bar/baz", []string{"foo.bar.baz"}, false},
+		{"foo/+/baz", []string{"foo.*.baz"}, false},
+		{"foo/#", []string{"foo.>", "foo"}, false},
+		{"#", []string{">"}, false},
+		{"+", []string{"*"}, false},
+		{"foo/#/bar", nil, true},
+		{"foo/b#", nil, true},
+		{"foo/b+", nil, true},
+		{"foo//bar", nil, true},
+		{"foo.bar", nil, true},
+		{"foo/*", nil, true},
+		{"foo/>", nil, true},
+		{"foo bar", nil, true},
+		{"", nil, true},
+	} {
+		t.Run(test.filter, func(t *testing.T) {
+			subjects, err := mqttFilterToNATSSubjects(test.filter)
+			if test.err {
+				if err == nil {
+					t.Fatalf("Expected error for %q, got subjects %q", test.filter, subjects)
+				}
+				return
+			}
+			if err != nil || !reflect.DeepEqual(subjects, test.subjects) {
+				t.Fatalf("Expected %q, got %q (err=%v)", test.subjects, subjects, err)
+			}
+		})
+	}
+	if _, err := mqttTopicToNATSPubSubject([]byte("foo/+")); err == nil {
+		t.Fatal("Expected error for wildcard in topic name")
+	}
+	if subj, err := mqttTopicToNATSPubSubject([]byte("foo/bar")); err != nil || subj != "foo.bar" {
+		t.Fatalf("Unexpected subject %q (err=%v)", subj, err)
+	}
+	if topic := natsSubjectToMQTTTopic("foo.bar.baz"); string(topic) != "foo/bar/baz" {
+		t.Fatalf("Unexpected topic %q", topic)
+	}
+}
+
+func TestMQTTConnectAuth(t *testing.T) {
+	o, storeDir := testMQTTDefaultOptions(t)
+	defer os.RemoveAll(storeDir)
+	o.Users = []*User{{Username: "user", Password: "pwd"}}
+	s := testMQTTRunServer(t, o)
+	defer s.Shutdown()
+
+	host, port := o.MQTT.Host, o.MQTT.Port
+	for _, test := range []struct {
+		name string
+		user string
+		pass string
+		rc   byte
+	}{
+		{"no credentials", "", "", mqttConnAckRCNotAuthorized},
+		{"bad password", "user", "bad", mqttConnAckRCNotAuthorized},
+		{"good credentials", "user", "pwd", mqttConnAckRCConnectionAccepted},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c, _, rc, _ := testMQTTConnectRC(t, host, port, &testMQTTConnectOpts{clientID: "auth", clean: true, user: test.user, pass: test.pass})
+			defer c.Close()
+			if rc != test.rc {
+				t.Fatalf("Expected return code %v, got %v", test.rc, rc)
+			}
+		})
+	}
+
+	// A persistent session requires a client identifier.
+	c, _, rc, _ := testMQTTConnectRC(t, host, port, &testMQTTConnectOpts{user: "user", pass: "pwd"})
+	defer c.Close()
+	if rc != mqttConnAckRCIdentifierRejected {
+		t.Fatalf("Expected return code %v, got %v", mqttConnAckRCIdentifierRejected, rc)
+	}
+}
+
+func TestMQTTConnectNkeyNotSupported(t *testing.T) {
+	nkp, _ := nkeys.CreateUser()
+	pub, _ := nkp.PublicKey()
+	o, storeDir := testMQTTDefaultOptions(t)
+	defer os.RemoveAll(storeDir)
+	o.Nkeys = []*NkeyUser{{Nkey: pub}}
+	s := testMQTTRunServer(t, o)
+	defer s.Shutdown()
+
+	// There is no nonce, so a signature of anything the client
+	// chooses, such as its identifier, could be replayed.
+	sig, _ := nkp.Sign([]byte("nkey"))
+	c, _, rc, _ := testMQTTConnectRC(t, o.MQTT.Host, o.MQTT.Port, &testMQTTConnectOpts{clientID: "nkey", clean: true,
+		user: pub, pass: base64.RawURLEncoding.EncodeToString(sig)})
+	defer c.Close()
+	if rc != mqttConnAckRCNotAuthorized {
+		t.Fatalf("Expected return code %v, got %v", mqttConnAckRCNotAuthorized, rc)
+	}
+}
+
+func TestMQTTConnectJWT(t *testing.T) {
+	okp, _ := nkeys.FromSeed(oSeed)
+	opub, _ := okp.PublicKey()
+	akp, _ := nkeys.CreateAccount()
+	apub, _ := akp.PublicKey()
+	ajwt, err := jwt.NewAccountClaims(apub).Encode(okp)
+	if err != nil {
+		t.Fatalf("Error generating account JWT: %v", err)
+	}
+	// JetStream requires a system account.
+	skp, _ := nkeys.CreateAccount()
+	spub, _ := skp.PublicKey()
+	sjwt, err := jwt.NewAccountClaims(spub).Encode(okp)
+	if err != nil {
+		t.Fatalf("Error generating account JWT: %v", err)
+	}
+
+	o, storeDir := testMQTTDefaultOptions(t)
+	defer os.RemoveAll(storeDir)
+	o.TrustedKeys = []string{opub}
+	o.SystemAccount = spub
+	o.AccountResolver = &MemAccResolver{}
+	o.AccountResolver.Store(apub, ajwt)
+	o.AccountResolver.Store(spub, sjwt)
+	s := testMQTTRunServer(t, o)
+	defer s.Shutdown()
+
+	acc, err := s.LookupAccount(apub)
+	if err != nil {
+		t.Fatalf("Error looking up account: %v", err)
+	}
+	if err := acc.EnableJetStream(&JetStreamAccountLimits{MaxMemory: -1, MaxStore: -1, MaxStreams: -1, MaxConsumers: -1}); err != nil {
+		t.Fatalf("Error enabling JetStream: %v", err)
+	}
+
+	userJWT := func(bearer bool) string {
+		t.Helper()
+		nkp, _ := nkeys.CreateUser()
+		pub, _ := nkp.PublicKey()
+		nuc := jwt.NewUserClaims(pub)
+		nuc.BearerToken = bearer
+		ujwt, err := nuc.Encode(akp)
+		if err != nil {
+			t.Fatalf("Error generating user JWT: %v", err)
+		}
+		return ujwt
+	}
+	for _, test := range []struct {
+		name   string
+		bearer bool
+		rc     byte
+	}{
+		{"bearer token", true, mqttConnAckRCConnectionAccepted},
+		{"signature required", false, mqttConnAckRCNotAuthorized},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c, _, rc, _ := testMQTTConnectRC(t, o.MQTT.Host, o.MQTT.Port, &testMQTTConnectOpts{clientID: "jwt", clean: true,
+				user: "jwt", pass: userJWT(test.bearer)})
+			defer c.Close()
+			if rc != test.rc {
+				t.Fatalf("Expected return code %v, got %v", test.rc, rc)
+			}
+		})
+	}
+}
+
+func TestMQTTPubSubWithNATS(t *testing.T) {
+	o, storeDir := testMQTTDefaultOptions(t)
+	defer os.RemoveAll(storeDir)
+	s := testMQTTRunServer(t, o)
+	defer s.Shutdown()
+
+	mc, mr, _ := testMQTTConnect(t, o.MQTT.Host, o.MQTT.Port, &testMQTTConnectOpts{clientID: "sub", clean: true})
+	defer mc.Close()
+	testMQTTSub(t, mc, mr, 1, []string{"foo/+", "bar/#"}, 0, []byte{0, 0})
+
+	nc := natsConnect(t, s.ClientURL())
+	defer nc.Close()
+	natsPub(t, nc, "foo.bar", []byte("msg1"))
+	natsPub(t, nc, "bar", []byte("msg2"))
+	natsPub(t, nc, "bar.baz.bat", []byte("msg3"))
+	natsFlush(t, nc)
+	testMQTTExpectPub(t, mc, mr, "foo/bar", "msg1")
+	testMQTTExpectPub(t, mc, mr, "bar", "msg2")
+	testMQTTExpectPub(t, mc, mr, "bar/baz/bat", "msg3")
+
+	// Messages published by MQTT clients reach NATS subscribers and the
+	// MQTT subscriptions, whatever the QoS.
+	sub := natsSubSync(t, nc, "foo.>")
+	natsFlush(t, nc)
+	pc, pr, _ := testMQTTConnect(t, o.MQTT.Host, o.MQTT.Port, &testMQTTConnectOpts{clientID: "pub", clean: true})
+	defer pc.Close()
+	testMQTTPub(t, pc, pr, 0, "foo/baz", "msg4", false, 0)
+	testMQTTPub(t, pc, pr, 1, "foo/bat", "msg5", false, 1)
+	if msg := natsNexMsg(t, sub, time.Second); string(msg.Data) != "msg4" {
+		t.Fatalf("Unexpected message: %q", msg.Data)
+	}
+	if msg := natsNexMsg(t, sub, time.Second); string(msg.Data) != "msg5" {
+		t.Fatalf("Unexpected message: %q", msg.Data)
+	}
+	testMQTTExpectPub(t, mc, mr, "foo/baz", "msg4")
+	testMQTTExpectPub(t, mc, mr, "foo/bat", "msg5")
+	testMQTTExpectNothing(t, mc, mr)
+}
+
+func TestMQTTQoS1PersistentSession(t *testing.T) {
+	o, storeDir := testMQTTDefaultOptions(t)
+	defer os.RemoveAll(storeDir)
+	o.MQTT.AckWait = 250 * time.Millisecond
+	s := testMQTTRunServer(t, o)
+	defer s.Shutdown()
+
+	host, port := o.MQTT.Host, o.MQTT.Port
+	sc, sr, sp := testMQTTConnect(t, host, port, &testMQTTConnectOpts{clientID: "sub"})
+	if sp {
+		t.Fatal("Session should not be present")
+	}
+	testMQTTSub(t, sc, sr, 1, []string{"foo/#"}, 1, []byte{1})
+	sc.Close()
+
+	// Messages published with QoS 1 while the subscriber is away are
+	// delivered when it comes back.
+	pc, pr, _ := testMQTTConnect(t, host, port, &testMQTTConnectOpts{clientID: "pub", clean: true})
+	defer pc.Close()
+	testMQTTPub(t, pc, pr, 1, "foo/bar", "msg1", false, 1)
+
+	// Wait for the session to be released.
+	checkFor(t, 2*time.Second, 15*time.Millisecond, func() error {
+		if n := s.NumClients(); n != 1 {
+			return fmt.Errorf("expected 1 client, got %v", n)
+		}
+		return nil
+	})
+	sc, sr, sp = testMQTTConnect(t, host, port, &testMQTTConnectOpts{clientID: "sub"})
+	defer sc.Close()
+	if !sp {
+		t.Fatal("Session should be present")
+	}
+	flags, pi := testMQTTExpectPub(t, sc, sr, "foo/bar", "msg1")
+	if flags&mqttPubFlagQoS>>1 != 1 || flags&mqttPubFlagDup != 0 {
+		t.Fatalf("Unexpected flags: %x", flags)
+	}
+	// Not acknowledged, so it is sent again with the same identifier.
+	flags, rpi := testMQTTExpectPub(t, sc, sr, "foo/bar", "msg1")
+	if flags&mqttPubFlagDup == 0 || rpi != pi {
+		t.Fatalf("Expected redelivery of %v, got flags %x and %v", pi, flags, rpi)
+	}
+	testMQTTPubAck(t, sc, pi)
+	testMQTTExpectNothing(t, sc, sr)
+	time.Sleep(300 * time.Millisecond)
+	testMQTTExpectNothing(t, sc, sr)
+
+	// A clean session drops the previous one.
+	sc.Close()
+	checkFor(t, 2*time.Second, 15*time.Millisecond, func() error {
+		if n := s.NumClients(); n != 1 {
+			return fmt.Errorf("expected 1 client, got %v", n)
+		}
+		return nil
+	})
+	sc, _, sp = testMQTTConnect(t, host, port, &testMQTTConnectOpts{clientID: "sub", clean: true})
+	defer sc.Close()
+	if sp {
+		t.Fatal("Session should not be present")
+	}
+}
+
+func TestMQTTRetainedMessages(t *testing.T) {
+	o, storeDir := testMQTTDefaultOptions(t)
+	defer os.RemoveAll(storeDir)
+	s := testMQTTRunServer(t, o)
+	defer s.Shutdown()
+
+	host, port := o.MQTT.Host, o.MQTT.Port
+	pc, pr, _ := testMQTTConnect(t, host, port, &testMQTTConnectOpts{clientID: "pub", clean: true})
+	defer pc.Close()
+	testMQTTPub(t, pc, pr, 0, "foo/bar", "old", true, 0)
+	testMQTTPub(t, pc, pr, 1, "foo/bar", "new", true, 1)
+	testMQTTPub(t, pc, pr, 0, "foo/baz", "removed", true, 0)
+	testMQTTPub(t, pc, pr, 0, "foo/baz", "", true, 0)
+
+	sc, sr, _ := testMQTTConnect(t, host, port, &testMQTTConnectOpts{clientID: "sub", clean: true})
+	defer sc.Close()
+	testMQTTSub(t, sc, sr, 1, []string{"foo/+"}, 0, []byte{0})
+	flags, _ := testMQTTExpectPub(t, sc, sr, "foo/bar", "new")
+	if flags&mqttPubFlagRetain == 0 {
+		t.Fatalf("Expected retain flag, got %x", flags)
+	}
+	testMQTTExpectNothing(t, sc, sr)
+
+	// Retained messages survive a restart.
+	s.Shutdown()
+	s = testMQTTRunServer(t, o)
+	defer s.Shutdown()
+	sc, sr, _ = testMQTTConnect(t, host, s.getOpts().MQTT.Port, &testMQTTConnectOpts{clientID: "sub", clean: true})
+	defer sc.Close()
+	testMQTTSub(t, sc, sr, 1, []string{"foo/#"}, 0, []byte{0})
+	testMQTTExpectPub(t, sc, sr, "foo/bar", "new")
+	testMQTTExpectNothing(t, sc, sr)
+}
+
+func TestMQTTWillAndTakeover(t *testing.T) {
+	o, storeDir := testMQTTDefaultOptions(t)
+	defer os.RemoveAll(storeDir)
+	s := testMQTTRunServer(t, o)
+	defer s.Shutdown()
+
+	nc := natsConnect(t, s.ClientURL())
+	defer nc.Close()
+	sub := natsSubSync(t, nc, "will.>")
+	natsFlush(t, nc)
+
+	host, port := o.MQTT.Host, o.MQTT.Port
+	co := &testMQTTConnectOpts{clientID: "dev", clean: true, willTopic: "will/dev", willMsg: "gone"}
+	c1, _, _ := testMQTTConnect(t, host, port, co)
+	defer c1.Close()
+
+	// A client with the same identifier closes the first connection,
+	// which publishes its will.
+	c2, _, _ := testMQTTConnect(t, host, port, co)
+	if msg := natsNexMsg(t, sub, time.Second); string(msg.Data) != "gone" {
+		t.Fatalf("Unexpected will: %q", msg.Data)
+	}
+	checkClosedConns(t, s, 1, 2*time.Second)
+	conns := s.closedClients()
+	if conns[0].Reason != DuplicateClientID.String() {
+		t.Fatalf("Unexpected close reason: %v", conns[0].Reason)
+	}
+
+	// The will is not published on a clean disconnect.
+	if _, err := c2.Write([]byte{mqttPacketDisconnect, 0}); err != nil {
+		t.Fatalf("Error sending disconnect: %v", err)
+	}
+	c2.Close()
+	checkClosedConns(t, s, 2, 2*time.Second)
+	if msg, err := sub.NextMsg(100 * time.Millisecond); err == nil {
+		t.Fatalf("Unexpected will: %q", msg.Data)
+	}
+}
+
+func TestMQTTQoS1InterestAfterRestart(t *testing.T) {
+	o, storeDir := testMQTTDefaultOptions(t)
+	defer os.RemoveAll(storeDir)
+	s := testMQTTRunServer(t, o)
+	defer s.Shutdown()
+
+	host, port := o.MQTT.Host, o.MQTT.Port
+	sc, sr, _ := testMQTTConnect(t, host, port, &testMQTTConnectOpts{clientID: "sub"})
+	testMQTTSub(t, sc, sr, 1, []string{"foo/#"}, 1, []byte{1})
+	sc.Close()
+
+	checkInterest := func(s *Server, subject string, expected bool) {
+		t.Helper()
+		asm, err := s.mqttGetAccountSessionManager(s.GlobalAccount())
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if ok := asm.hasQoS1Interest(mqttStreamSubjectPrefix + subject); ok != expected {
+			t.Fatalf("Expected interest on %q to be %v, got %v", subject, expected, ok)
+		}
+	}
+	checkInterest(s, "foo.bar", true)
+	checkInterest(s, "bar", false)
+
+	// The filters of the persisted session are known after a restart, so
+	// messages are stored before the subscriber comes back.
+	s.Shutdown()
+	s = testMQTTRunServer(t, o)
+	defer s.Shutdown()
+	checkInterest(s, "foo.bar", true)
+
+	pc, pr, _ := testMQTTConnect(t, host, port, &testMQTTConnectOpts{clientID: "pub", clean: true})
+	defer pc.Close()
+	testMQTTPub(t, pc, pr, 1, "foo/bar", "msg1", false, 1)
+
+	sc, sr, sp := testMQTTConnect(t, host, port, &testMQTTConnectOpts{clientID: "sub"})
+	if !sp {
+		t.Fatal("Session should be present")
+	}
+	_, pi := testMQTTExpectPub(t, sc, sr, "foo/bar", "msg1")
+	testMQTTPubAck(t, sc, pi)
+	sc.Close()
+	checkFor(t, 2*time.Second, 15*time.Millisecond, func() error {
+		if n := s.NumClients(); n != 1 {
+			return fmt.Errorf("expected 1 client, got %v", n)
+		}
+		return nil
+	})
+
+	// A clean session drops the interest of the previous one.
+	sc, _, _ = testMQTTConnect(t, host, port, &testMQTTConnectOpts{clientID: "sub", clean: true})
+	defer sc.Close()
+	checkInterest(s, "foo.bar", false)
+}
@@ -0,0 +1,636 @@
+// Copyright 2020 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// OCSPMode defines the policy for stapling OCSP responses
+// to the certificates of the server.
+type OCSPMode uint8
+
+const (
+	// OCSPModeAuto staples responses for the certificates
+	// that have the OCSP must-staple extension.
+	OCSPModeAuto OCSPMode = iota
+	// OCSPModeAlways staples responses for all certificates.
+	OCSPModeAlways
+	// OCSPModeNever disables OCSP stapling.
+	OCSPModeNever
+)
+
+const (
+	// How long to wait for an OCSP responder or CRL distribution point.
+	ocspHTTPTimeout = 5 * time.Second
+
+	// Refresh interval of responses that do not have a next update.
+	ocspDefaultRefresh = time.Hour
+
+	// Minimum interval between attempts to refresh a response.
+	ocspMinRefresh = 30 * time.Second
+
+	// Maximum size of an OCSP response or CRL we are willing to read.
+	ocspMaxResponseSize = 10 * 1024 * 1024
+
+	// Extension of the files responses are persisted to.
+	ocspCacheFileExt = ".ocsp"
+)
+
+// TLS feature extension (RFC 7633), status_request is OCSP must-staple.
+var (
+	oidTLSFeature       = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 24}
+	tlsFeatureStatusReq = 5
+)
+
+// OCSPConfig configures OCSP stapling for the certificates
+// of the client, route, gateway, leafnode and websocket listeners.
+type OCSPConfig struct {
+	// Mode decides which certificates get a stapled response.
+	Mode OCSPMode
+	// OverrideURLs are used instead of the responders
+	// listed in the certificates.
+	OverrideURLs []string
+	// CacheDir is where responses are persisted so that
+	// they can be used on restart, if not empty.
+	CacheDir string
+}
+
+// String returns the name of the mode as used in the configuration.
+func (m OCSPMode) String() string {
+	switch m {
+	case OCSPModeAuto:
+		return "auto"
+	case OCSPModeAlways:
+		return "always"
+	case OCSPModeNever:
+		return "never"
+	}
+	return fmt.Sprintf("unknown (%d)", uint8(m))
+}
+
+// Returns the OCSP mode for its configuration name.
+func ocspModeFromString(s string) (OCSPMode, error) {
+	switch strings.ToLower(s) {
+	case "auto":
+		return OCSPModeAuto, nil
+	case "always":
+		return OCSPModeAlways, nil
+	case "never":
+		return OCSPModeNever, nil
+	}
+	return OCSPModeAuto, fmt.Errorf("invalid ocsp mode %q", s)
+}
+
+// Returns the name of a certificate status.
+func ocspStatusString(status int) string {
+	switch status {
+	case ocsp.Good:
+		return "good"
+	case ocsp.Revoked:
+		return "revoked"
+	}
+	return "unknown"
+}
+
+// Checks if the certificate requires a stapled OCSP response.
+func hasMustStaple(cert *x509.Certificate) bool {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidTLSFeature) {
+			continue
+		}
+		var features []int
+		if _, err := asn1.Unmarshal(ext.Value, &features); err != nil {
+			return false
+		}
+		for _, f := range features {
+			if f == tlsFeatureStatusReq {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Sends the OCSP request to the responder and returns the raw response.
+func ocspRequest(hc *http.Client, url string, req []byte) ([]byte, error) {
+	resp, err := hc.Post(url, "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bad OCSP responder status: %s", resp.Status)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, ocspMaxResponseSize))
+}
+
+// ocspMonitor keeps a fresh OCSP response stapled to the
+// certificate of one of the TLS listeners of the server.
+type ocspMonitor struct {
+	mu     sync.Mutex
+	srv    *Server
+	oc     *OCSPConfig
+	tc     *tlsCert
+	leaf   *x509.Certificate
+	issuer *x509.Certificate
+	urls   []string
+	path   string
+	hc     *http.Client
+	raw    []byte
+	resp   *ocsp.Response
+	stopCh chan struct{}
+}
+
+// Creates the monitor for the certificate, returns nil
+// if the certificate does not need a stapled response.
+func (s *Server) newOCSPMonitor(oc *OCSPConfig, tc *tlsCert) (*ocspMonitor, error) {
+	m := &ocspMonitor{
+		srv:    s,
+		oc:     oc,
+		tc:     tc,
+		hc:     &http.Client{Timeout: ocspHTTPTimeout},
+		stopCh: make(chan struct{}),
+	}
+	if staple, err := m.setCertificate(tc.certificate()); err != nil || !staple {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Sets the certificate to get responses for, returns
+// whether it needs a stapled response.
+func (m *ocspMonitor) setCertificate(cert *tls.Certificate) (bool, error) {
+	var leaf, issuer *x509.Certificate
+	var urls []string
+	var path string
+	err := func() error {
+		kind := m.tc.kind
+		leaf = cert.Leaf
+		if leaf == nil {
+			var err error
+			if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+				return fmt.Errorf("error parsing %s certificate: %v", kind, err)
+			}
+		}
+		if m.oc.Mode == OCSPModeAuto && !hasMustStaple(leaf) {
+			leaf = nil
+			return nil
+		}
+		urls = m.oc.OverrideURLs
+		if len(urls) == 0 {
+			urls = leaf.OCSPServer
+		}
+		if len(urls) == 0 {
+			return fmt.Errorf("%s certificate has no OCSP responder", kind)
+		}
+		if len(cert.Certificate) < 2 {
+			return fmt.Errorf("%s certificate chain does not include the issuer required for OCSP", kind)
+		}
+		var err error
+		if issuer, err = x509.ParseCertificate(cert.Certificate[1]); err != nil {
+			return fmt.Errorf("error parsing %s certificate issuer: %v", kind, err)
+		}
+		if m.oc.CacheDir != _EMPTY_ {
+			sum := sha256.Sum256(leaf.Raw)
+			path = filepath.Join(m.oc.CacheDir, hex.EncodeToString(sum[:])+ocspCacheFileExt)
+		}
+		return nil
+	}()
+	if err != nil {
+		leaf = nil
+	}
+
+	m.mu.Lock()
+	m.leaf, m.issuer, m.urls, m.path = leaf, issuer, urls, path
+	m.raw, m.resp = nil, nil
+	m.mu.Unlock()
+	return leaf != nil, err
+}
+
+// Gets the initial response, from a previous monitor for the same
+// certificate, the cache directory or the responder, in that order.
+func (m *ocspMonitor) init(prev []*ocspMonitor) error {
+	var raw []byte
+	var resp *ocsp.Response
+	for _, pm := range prev {
+		pm.mu.Lock()
+		if pm.leaf != nil && bytes.Equal(pm.leaf.Raw, m.leaf.Raw) && pm.resp != nil && ocspResponseCurrent(pm.resp) {
+			raw, resp = pm.raw, pm.resp
+		}
+		pm.mu.Unlock()
+		if resp != nil {
+			break
+		}
+	}
+	if resp == nil {
+		raw, resp = m.loadCached()
+	}
+	if resp == nil {
+		var err error
+		if raw, resp, err = m.fetch(); err != nil {
+			return fmt.Errorf("unable to get OCSP response for %s certificate: %v", m.tc.kind, err)
+		}
+		m.save(raw)
+	}
+	if resp.Status != ocsp.Good {
+		return fmt.Errorf("OCSP status of %s certificate is %s", m.tc.kind, ocspStatusString(resp.Status))
+	}
+	m.setStaple(raw, resp)
+	return nil
+}
+
+// Checks that the response has not expired.
+func ocspResponseCurrent(resp *ocsp.Response) bool {
+	return resp.NextUpdate.IsZero() || time.Now().Before(resp.NextUpdate)
+}
+
+// Fetches a response from the first responder that returns a valid one.
+func (m *ocspMonitor) fetch() ([]byte, *ocsp.Response, error) {
+	m.mu.Lock()
+	leaf, issuer, urls := m.leaf, m.issuer, m.urls
+	m.mu.Unlock()
+
+	req, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	var lastErr error
+	for _, u := range urls {
+		raw, err := ocspRequest(m.hc, u, req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp, err := ocsp.ParseResponseForCert(raw, leaf, issuer)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return raw, resp, nil
+	}
+	return nil, nil, lastErr
+}
+
+// Loads the persisted response, if any and still current.
+func (m *ocspMonitor) loadCached() ([]byte, *ocsp.Response) {
+	m.mu.Lock()
+	leaf, issuer, path := m.leaf, m.issuer, m.path
+	m.mu.Unlock()
+
+	if path == _EMPTY_ {
+		return nil, nil
+	}
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil
+	}
+	resp, err := ocsp.ParseResponseForCert(raw, leaf, issuer)
+	if err != nil || !ocspResponseCurrent(resp) {
+		return nil, nil
+	}
+	return raw, resp
+}
+
+// Persists the response so that it can be used on restart.
+func (m *ocspMonitor) save(raw []byte) {
+	m.mu.Lock()
+	path := m.path
+	m.mu.Unlock()
+
+	if path == _EMPTY_ {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		m.srv.Warnf("Unable to create OCSP cache directory: %v", err)
+		return
+	}
+	if err := ioutil.WriteFile(path, raw, 0644); err != nil {
+		m.srv.Warnf("Unable to persist OCSP response for %s certificate: %v", m.tc.kind, err)
+	}
+}
+
+// Staples the response to the certificate, a nil response removes the staple.
+func (m *ocspMonitor) setStaple(raw []byte, resp *ocsp.Response) {
+	m.mu.Lock()
+	m.raw, m.resp = raw, resp
+	leaf := m.leaf
+	m.mu.Unlock()
+	m.tc.setStaple(leaf.Raw, raw)
+}
+
+// Returns when the response should be refreshed, which is
+// half way to its next update.
+func (m *ocspMonitor) nextRefresh() time.Duration {
+	m.mu.Lock()
+	resp := m.resp
+	m.mu.Unlock()
+	if resp == nil || resp.NextUpdate.IsZero() {
+		return ocspDefaultRefresh
+	}
+	d := time.Until(resp.NextUpdate) / 2
+	if d < ocspMinRefresh {
+		d = ocspMinRefresh
+	}
+	return d
+}
+
+// Refreshes the response until the monitor is stopped or the server
+// shuts down, and gets one for the new certificate when it is rotated.
+func (m *ocspMonitor) run() {
+	s := m.srv
+	defer s.grWG.Done()
+
+	kind := m.tc.kind
+	for {
+		t := time.NewTimer(m.nextRefresh())
+		select {
+		case <-t.C:
+		case <-m.tc.rotated:
+			t.Stop()
+			staple, err := m.setCertificate(m.tc.certificate())
+			if err != nil {
+				s.Errorf("Unable to staple OCSP response to %s certificate: %v", kind, err)
+			}
+			if !staple {
+				continue
+			}
+			if raw, resp := m.loadCached(); resp != nil {
+				m.setStaple(raw, resp)
+				continue
+			}
+		case <-m.stopCh:
+			t.Stop()
+			return
+		case <-s.quitCh:
+			t.Stop()
+			return
+		}
+		m.mu.Lock()
+		leaf := m.leaf
+		m.mu.Unlock()
+		if leaf == nil {
+			continue
+		}
+		m.refresh()
+	}
+}
+
+// Gets a new response and staples it if the certificate is still good.
+// A revoked certificate has its staple removed, on any other status the
+// previous good response is kept for as long as it is current.
+func (m *ocspMonitor) refresh() {
+	s, kind := m.srv, m.tc.kind
+	raw, resp, err := m.fetch()
+	if err != nil {
+		s.Warnf("Unable to refresh OCSP response for %s certificate: %v", kind, err)
+		return
+	}
+	switch resp.Status {
+	case ocsp.Good:
+		m.save(raw)
+		m.setStaple(raw, resp)
+	case ocsp.Revoked:
+		s.Errorf("OCSP status of %s certificate is %s, removing the stapled response", kind, ocspStatusString(resp.Status))
+		m.setStaple(nil, nil)
+	default:
+		s.Errorf("OCSP status of %s certificate is %s, keeping the previous response", kind, ocspStatusString(resp.Status))
+		m.mu.Lock()
+		prev := m.resp
+		m.mu.Unlock()
+		if prev != nil && !ocspResponseCurrent(prev) {
+			m.setStaple(nil, nil)
+		}
+	}
+}
+
+func (m *ocspMonitor) stop() {
+	close(m.stopCh)
+}
+
+// Creates the monitors for the certificates of the TLS listeners
+// that need stapled responses, getting their initial responses.
+func (s *Server) configureOCSP(oc *OCSPConfig, certs []*tlsCert, prev []*ocspMonitor) ([]*ocspMonitor, error) {
+	if oc == nil || oc.Mode == OCSPModeNever {
+		return nil, nil
+	}
+	var monitors []*ocspMonitor
+	for _, c := range certs {
+		if c.remote {
+			continue
+		}
+		m, err := s.newOCSPMonitor(oc, c)
+		if err != nil {
+			return nil, err
+		}
+		if m == nil {
+			continue
+		}
+		if err := m.init(prev); err != nil {
+			return nil, err
+		}
+		monitors = append(monitors, m)
+	}
+	return monitors, nil
+}
+
+// Starts refreshing the stapled responses.
+func (s *Server) startOCSPMonitoring() {
+	s.mu.Lock()
+	monitors := s.ocsps
+	s.mu.Unlock()
+	for _, m := range monitors {
+		s.startGoRoutine(m.run)
+	}
+}
+
+// revocationChecker verifies that the certificates presented by peers
+// have not been revoked, with OCSP when the certificate has a responder
+// and its CRLs otherwise.
+type revocationChecker struct {
+	mu   sync.Mutex
+	hc   *http.Client
+	ocsp map[string]*revocationStatus
+	crls map[string]*pkix.CertificateList
+}
+
+type revocationStatus struct {
+	revoked bool
+	expires time.Time
+}
+
+func newRevocationChecker() *revocationChecker {
+	return &revocationChecker{
+		hc:   &http.Client{Timeout: ocspHTTPTimeout},
+		ocsp: make(map[string]*revocationStatus),
+		crls: make(map[string]*pkix.CertificateList),
+	}
+}
+
+// Installed as VerifyPeerCertificate, one of the verified
+// chains needs to be free of revoked certificates.
+func (rc *revocationChecker) verifyPeerCertificate(_ [][]byte, chains [][]*x509.Certificate) error {
+	var err error
+	for _, chain := range chains {
+		if err = rc.checkChain(chain); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func (rc *revocationChecker) checkChain(chain []*x509.Certificate) error {
+	// The last certificate is the trusted root.
+	for i := 0; i+1 < len(chain); i++ {
+		if err := rc.check(chain[i], chain[i+1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (rc *revocationChecker) check(cert, issuer *x509.Certificate) error {
+	var revoked bool
+	var err error
+	switch {
+	case len(cert.OCSPServer) > 0:
+		revoked, err = rc.checkOCSP(cert, issuer)
+	case len(cert.CRLDistributionPoints) > 0:
+		revoked, err = rc.checkCRL(cert, issuer)
+	default:
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to check revocation status of certificate %q: %v", cert.Subject, err)
+	}
+	if revoked {
+		return fmt.Errorf("certificate %q has been revoked", cert.Subject)
+	}
+	return nil
+}
+
+func (rc *revocationChecker) checkOCSP(cert, issuer *x509.Certificate) (bool, error) {
+	sum := sha256.Sum256(issuer.Raw)
+	key := hex.EncodeToString(sum[:]) + ":" + cert.SerialNumber.String()
+	rc.mu.Lock()
+	st := rc.ocsp[key]
+	rc.mu.Unlock()
+	if st != nil && time.Now().Before(st.expires) {
+		return st.revoked, nil
+	}
+
+	req, err := ocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		return false, err
+	}
+	var lastErr error
+	for _, u := range cert.OCSPServer {
+		raw, err := ocspRequest(rc.hc, u, req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp, err := ocsp.ParseResponseForCert(raw, cert, issuer)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if resp.Status != ocsp.Good && resp.Status != ocsp.Revoked {
+			lastErr = fmt.Errorf("OCSP status is %s", ocspStatusString(resp.Status))
+			continue
+		}
+		st := &revocationStatus{revoked: resp.Status == ocsp.Revoked, expires: resp.NextUpdate}
+		if st.expires.IsZero() {
+			st.expires = time.Now().Add(ocspDefaultRefresh)
+		}
+		rc.mu.Lock()
+		rc.ocsp[key] = st
+		rc.mu.Unlock()
+		return st.revoked, nil
+	}
+	return false, lastErr
+}
+
+func (rc *revocationChecker) checkCRL(cert, issuer *x509.Certificate) (bool, error) {
+	var lastErr error
+	for _, u := range cert.CRLDistributionPoints {
+		crl, err := rc.getCRL(u, issuer)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		for _, rev := range crl.TBSCertList.RevokedCertificates {
+			if rev.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return false, lastErr
+}
+
+// Returns the CRL from the distribution point, cached until its next update.
+func (rc *revocationChecker) getCRL(url string, issuer *x509.Certificate) (*pkix.CertificateList, error) {
+	rc.mu.Lock()
+	crl := rc.crls[url]
+	rc.mu.Unlock()
+	if crl != nil && !crl.HasExpired(time.Now()) {
+		return crl, nil
+	}
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, fmt.Errorf("unsupported CRL distribution point %q", url)
+	}
+	resp, err := rc.hc.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bad CRL distribution point status: %s", resp.Status)
+	}
+	raw, err := ioutil.ReadAll(io.LimitReader(resp.Body, ocspMaxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	if crl, err = x509.ParseCRL(raw); err != nil {
+		return nil, err
+	}
+	if err := issuer.CheckCRLSignature(crl); err != nil {
+		return nil, err
+	}
+	if crl.HasExpired(time.Now()) {
+		return nil, fmt.Errorf("CRL from %q has expired", url)
+	}
+	rc.mu.Lock()
+	rc.crls[url] = crl
+	rc.mu.Unlock()
+	return crl, nil
+}
@@ -0,0 +1,480 @@
+// Copyright 2020 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+type ocspTestCA struct {
+	sync.Mutex
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	revoked map[int64]bool
+	unknown map[int64]bool
+	hits    int
+}
+
+func newOCSPTestCA(t *testing.T) *ocspTestCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "OCSP Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Error creating CA certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &ocspTestCA{cert: cert, key: key, revoked: make(map[int64]bool), unknown: make(map[int64]bool)}
+}
+
+func (ca *ocspTestCA) revoke(serial int64) {
+	ca.Lock()
+	ca.revoked[serial] = true
+	ca.Unlock()
+}
+
+func (ca *ocspTestCA) setUnknown(serial int64, unknown bool) {
+	ca.Lock()
+	ca.unknown[serial] = unknown
+	ca.Unlock()
+}
+
+func (ca *ocspTestCA) numRequests() int {
+	ca.Lock()
+	defer ca.Unlock()
+	return ca.hits
+}
+
+// Runs an OCSP responder and a CRL distribution point for the CA.
+func (ca *ocspTestCA) run(t *testing.T) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		if r.URL.Path == "/crl" {
+			var revoked []pkix.RevokedCertificate
+			ca.Lock()
+			for serial := range ca.revoked {
+				revoked = append(revoked, pkix.RevokedCertificate{SerialNumber: big.NewInt(serial), RevocationTime: now})
+			}
+			ca.Unlock()
+			crl, err := ca.cert.CreateCRL(rand.Reader, ca.key, revoked, now, now.Add(time.Hour))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Write(crl)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		req, err := ocsp.ParseRequest(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		status := ocsp.Good
+		ca.Lock()
+		ca.hits++
+		if ca.revoked[req.SerialNumber.Int64()] {
+			status = ocsp.Revoked
+		} else if ca.unknown[req.SerialNumber.Int64()] {
+			status = ocsp.Unknown
+		}
+		ca.Unlock()
+		tmpl := ocsp.Response{
+			Status:       status,
+			SerialNumber: req.SerialNumber,
+			ThisUpdate:   now.Add(-time.Minute),
+			NextUpdate:   now.Add(time.Hour),
+			RevokedAt:    now.Add(-time.Minute),
+		}
+		resp, err := ocsp.CreateResponse(ca.cert, ca.cert, tmpl, ca.key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/ocsp-response")
+		w.Write(resp)
+	}))
+}
+
+// Writes the certificate of the CA in the directory and returns the file name.
+func (ca *ocspTestCA) writeCert(t *testing.T, dir string) string {
+	t.Helper()
+	caFile := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0644); err != nil {
+		t.Fatalf("Error writing CA certificate: %v", err)
+	}
+	return caFile
+}
+
+// Issues a certificate, with the OCSP responder or CRL distribution point
+// of the given URL, and returns it parsed and as cert and key files.
+func (ca *ocspTestCA) issue(t *testing.T, dir string, serial int64, ocspURL, crlURL string, mustStaple bool) (*x509.Certificate, string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: fmt.Sprintf("cert-%d", serial)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if ocspURL != _EMPTY_ {
+		tmpl.OCSPServer = []string{ocspURL}
+	}
+	if crlURL != _EMPTY_ {
+		tmpl.CRLDistributionPoints = []string{crlURL}
+	}
+	if mustStaple {
+		v, _ := asn1.Marshal([]int{tlsFeatureStatusReq})
+		tmpl.ExtraExtensions = []pkix.Extension{{Id: oidTLSFeature, Value: v}}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("Error creating certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	kb, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Error marshaling key: %v", err)
+	}
+	chain := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})...)
+	certFile := filepath.Join(dir, fmt.Sprintf("cert-%d.pem", serial))
+	keyFile := filepath.Join(dir, fmt.Sprintf("key-%d.pem", serial))
+	if err := ioutil.WriteFile(certFile, chain, 0644); err != nil {
+		t.Fatalf("Error writing certificate: %v", err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kb}), 0600); err != nil {
+		t.Fatalf("Error writing key: %v", err)
+	}
+	return cert, certFile, keyFile
+}
+
+func ocspTestOptions(t *testing.T, certFile, keyFile string, oc *OCSPConfig) *Options {
+	t.Helper()
+	opts := DefaultOptions()
+	opts.Port = -1
+	tc := &TLSConfigOpts{CertFile: certFile, KeyFile: keyFile}
+	config, err := GenTLSConfig(tc)
+	if err != nil {
+		t.Fatalf("Error generating tls config: %v", err)
+	}
+	opts.TLSConfig = config
+	opts.tlsConfigOpts = tc
+	opts.TLSTimeout = 2
+	opts.OCSPConfig = oc
+	return opts
+}
+
+// Returns the state of a TLS connection to the server.
+func tlsConnState(t *testing.T, s *Server, ca *ocspTestCA) tls.ConnectionState {
+	t.Helper()
+	opts := s.getOpts()
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", opts.Host, opts.Port), 2*time.Second)
+	if err != nil {
+		t.Fatalf("Unexpected error on dial: %v", err)
+	}
+	defer conn.Close()
+	br := bufio.NewReaderSize(conn, 100)
+	if _, err := br.ReadString('\n'); err != nil {
+		t.Fatalf("Unexpected error reading INFO: %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	tlsConn := tls.Client(conn, &tls.Config{RootCAs: pool, ServerName: "localhost"})
+	defer tlsConn.Close()
+	if err := tlsConn.Handshake(); err != nil {
+		t.Fatalf("Unexpected error during handshake: %v", err)
+	}
+	return tlsConn.ConnectionState()
+}
+
+func TestOCSPStapling(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ocsp")
+	if err != nil {
+		t.Fatalf("Error creating dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newOCSPTestCA(t)
+	ts := ca.run(t)
+	cert, certFile, keyFile := ca.issue(t, dir, 2, ts.URL, _EMPTY_, false)
+
+	cacheDir := filepath.Join(dir, "cache")
+	opts := ocspTestOptions(t, certFile, keyFile, &OCSPConfig{Mode: OCSPModeAlways, CacheDir: cacheDir})
+	s := RunServer(opts)
+	staple := tlsConnState(t, s, ca).OCSPResponse
+	s.Shutdown()
+
+	resp, err := ocsp.ParseResponseForCert(staple, cert, ca.cert)
+	if err != nil {
+		t.Fatalf("Error parsing stapled response: %v", err)
+	}
+	if resp.Status != ocsp.Good {
+		t.Fatalf("Expected good status, got %v", ocspStatusString(resp.Status))
+	}
+	files, _ := filepath.Glob(filepath.Join(cacheDir, "*"+ocspCacheFileExt))
+	if len(files) != 1 {
+		t.Fatalf("Expected the response to be persisted, got %v", files)
+	}
+
+	// On restart the persisted response is used, even if the responder is down.
+	ts.Close()
+	hits := ca.numRequests()
+	opts = ocspTestOptions(t, certFile, keyFile, &OCSPConfig{Mode: OCSPModeAlways, CacheDir: cacheDir})
+	s = RunServer(opts)
+	if staple := tlsConnState(t, s, ca).OCSPResponse; len(staple) == 0 {
+		t.Fatal("Expected a stapled response")
+	}
+	s.Shutdown()
+	if n := ca.numRequests(); n != hits {
+		t.Fatalf("Expected no request to the responder, got %v", n-hits)
+	}
+
+	// A revoked certificate prevents the server from starting.
+	ts = ca.run(t)
+	defer ts.Close()
+	ca.revoke(2)
+	opts = ocspTestOptions(t, certFile, keyFile, &OCSPConfig{Mode: OCSPModeAlways, OverrideURLs: []string{ts.URL}})
+	if _, err := NewServer(opts); err == nil || !strings.Contains(err.Error(), "revoked") {
+		t.Fatalf("Expected error about revoked certificate, got %v", err)
+	}
+}
+
+func TestOCSPRefresh(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ocsp")
+	if err != nil {
+		t.Fatalf("Error creating dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newOCSPTestCA(t)
+	ts := ca.run(t)
+	defer ts.Close()
+	cert, certFile, keyFile := ca.issue(t, dir, 2, ts.URL, _EMPTY_, false)
+
+	opts := ocspTestOptions(t, certFile, keyFile, &OCSPConfig{Mode: OCSPModeAlways})
+	s := RunServer(opts)
+	defer s.Shutdown()
+
+	s.mu.Lock()
+	m := s.ocsps[0]
+	s.mu.Unlock()
+
+	stapledStatus := func() int {
+		t.Helper()
+		staple := tlsConnState(t, s, ca).OCSPResponse
+		if len(staple) == 0 {
+			return -1
+		}
+		resp, err := ocsp.ParseResponseForCert(staple, cert, ca.cert)
+		if err != nil {
+			t.Fatalf("Error parsing stapled response: %v", err)
+		}
+		return resp.Status
+	}
+
+	// An unknown status keeps the previous good response.
+	ca.setUnknown(2, true)
+	m.refresh()
+	if status := stapledStatus(); status != ocsp.Good {
+		t.Fatalf("Expected the good response to be kept, got %v", status)
+	}
+
+	// A revoked certificate has its staple removed.
+	ca.setUnknown(2, false)
+	ca.revoke(2)
+	m.refresh()
+	if status := stapledStatus(); status != -1 {
+		t.Fatalf("Expected no stapled response, got %v", status)
+	}
+}
+
+func TestOCSPModes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ocsp")
+	if err != nil {
+		t.Fatalf("Error creating dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newOCSPTestCA(t)
+	ts := ca.run(t)
+	defer ts.Close()
+	_, certFile, keyFile := ca.issue(t, dir, 2, ts.URL, _EMPTY_, false)
+	_, msCertFile, msKeyFile := ca.issue(t, dir, 3, ts.URL, _EMPTY_, true)
+	_, noURLCertFile, noURLKeyFile := ca.issue(t, dir, 4, _EMPTY_, _EMPTY_, false)
+
+	for _, test := range []struct {
+		name     string
+		certFile string
+		keyFile  string
+		mode     OCSPMode
+		stapled  bool
+		err      string
+	}{
+		{"auto", certFile, keyFile, OCSPModeAuto, false, ""},
+		{"auto must staple", msCertFile, msKeyFile, OCSPModeAuto, true, ""},
+		{"always", certFile, keyFile, OCSPModeAlways, true, ""},
+		{"never", msCertFile, msKeyFile, OCSPModeNever, false, ""},
+		{"no responder", noURLCertFile, noURLKeyFile, OCSPModeAlways, false, "no OCSP responder"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			opts := ocspTestOptions(t, test.certFile, test.keyFile, &OCSPConfig{Mode: test.mode})
+			if test.err != _EMPTY_ {
+				if _, err := NewServer(opts); err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("Expected error containing %q, got %v", test.err, err)
+				}
+				return
+			}
+			s := RunServer(opts)
+			defer s.Shutdown()
+			if staple := tlsConnState(t, s, ca).OCSPResponse; (len(staple) > 0) != test.stapled {
+				t.Fatalf("Expected stapled to be %v", test.stapled)
+			}
+		})
+	}
+}
+
+func TestOCSPPeerRevocation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ocsp")
+	if err != nil {
+		t.Fatalf("Error creating dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newOCSPTestCA(t)
+	ts := ca.run(t)
+	defer ts.Close()
+	crlURL := ts.URL + "/crl"
+	good, _, _ := ca.issue(t, dir, 10, ts.URL, _EMPTY_, false)
+	revoked, _, _ := ca.issue(t, dir, 11, ts.URL, _EMPTY_, false)
+	crlGood, _, _ := ca.issue(t, dir, 12, _EMPTY_, crlURL, false)
+	crlRevoked, _, _ := ca.issue(t, dir, 13, _EMPTY_, crlURL, false)
+	ca.revoke(11)
+	ca.revoke(13)
+
+	config, err := GenTLSConfig(&TLSConfigOpts{Verify: true, VerifyRevocation: true})
+	if err != nil {
+		t.Fatalf("Error generating tls config: %v", err)
+	}
+	if config.VerifyPeerCertificate == nil {
+		t.Fatal("Expected peer certificates to be checked for revocation")
+	}
+	for _, test := range []struct {
+		name    string
+		cert    *x509.Certificate
+		revoked bool
+	}{
+		{"ocsp good", good, false},
+		{"ocsp revoked", revoked, true},
+		{"crl good", crlGood, false},
+		{"crl revoked", crlRevoked, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := config.VerifyPeerCertificate(nil, [][]*x509.Certificate{{test.cert, ca.cert}})
+			if test.revoked {
+				if err == nil || !strings.Contains(err.Error(), "revoked") {
+					t.Fatalf("Expected error about revoked certificate, got %v", err)
+				}
+			} else if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+		})
+	}
+
+	// Responder is down, the status of checked certificates is cached.
+	ts.Close()
+	if err := config.VerifyPeerCertificate(nil, [][]*x509.Certificate{{good, ca.cert}}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	unchecked, _, _ := ca.issue(t, dir, 14, ts.URL, _EMPTY_, false)
+	if err := config.VerifyPeerCertificate(nil, [][]*x509.Certificate{{unchecked, ca.cert}}); err == nil {
+		t.Fatal("Expected error when revocation status can't be checked")
+	}
+}
+
+func TestOCSPConfig(t *testing.T) {
+	conf := createConfFile(t, []byte(`
+		ocsp {
+			mode: always
+			url: "http://127.0.0.1:8888"
+			cache_dir: "/tmp/ocsp"
+		}
+	`))
+	defer os.Remove(conf)
+	opts, err := ProcessConfigFile(conf)
+	if err != nil {
+		t.Fatalf("Error processing config: %v", err)
+	}
+	expected := &OCSPConfig{Mode: OCSPModeAlways, OverrideURLs: []string{"http://127.0.0.1:8888"}, CacheDir: "/tmp/ocsp"}
+	if oc := opts.OCSPConfig; oc == nil || oc.Mode != expected.Mode || oc.CacheDir != expected.CacheDir ||
+		len(oc.OverrideURLs) != 1 || oc.OverrideURLs[0] != expected.OverrideURLs[0] {
+		t.Fatalf("Expected %+v, got %+v", expected, opts.OCSPConfig)
+	}
+
+	conf = createConfFile(t, []byte(`ocsp: false`))
+	defer os.Remove(conf)
+	if opts, err = ProcessConfigFile(conf); err != nil {
+		t.Fatalf("Error processing config: %v", err)
+	}
+	if oc := opts.OCSPConfig; oc == nil || oc.Mode != OCSPModeNever {
+		t.Fatalf("Expected ocsp to be disabled, got %+v", oc)
+	}
+
+	conf = createConfFile(t, []byte(`ocsp { mode: sometimes }`))
+	defer os.Remove(conf)
+	if _, err := ProcessConfigFile(conf); err == nil || !strings.Contains(err.Error(), "invalid ocsp mode") {
+		t.Fatalf("Expected error about the mode, got %v", err)
+	}
+}
@@ -15,8 +15,10 @@ package server
 
 import (
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
@@ -71,6 +73,11 @@ type ClusterOpts struct {
 	Advertise      string            `json:"-"`
 	NoAdvertise    bool              `json:"-"`
 	ConnectRetries int               `json:"-"`
+	TLSPinnedCerts PinnedCertSet     `json:"-"`
+	Compression    string            `json:"-"`
+
+	// Not exported, the options the TLSConfig was generated from.
+	tlsConfigOpts *TLSConfigOpts
 }
 
 // GatewayOpts are options for gateways.
@@ -90,6 +97,11 @@ type GatewayOpts struct {
 	ConnectRetries int                  `json:"connect_retries,omitempty"`
 	Gateways       []*RemoteGatewayOpts `json:"gateways,omitempty"`
 	RejectUnknown  bool                 `json:"reject_unknown,omitempty"`
+	TLSPinnedCerts PinnedCertSet        `json:"-"`
+	Compression    string               `json:"-"`
+
+	// Not exported, the options the TLSConfig was generated from.
+	tlsConfigOpts *TLSConfigOpts
 
 	// Not exported, for tests.
 	resolver         netResolver
@@ -100,10 +112,14 @@ type GatewayOpts struct {
 // NOTE: This structure is no longer used for monitoring endpoints
 // and json tags are deprecated and may be removed in the future.
 type RemoteGatewayOpts struct {
-	Name       string      `json:"name"`
-	TLSConfig  *tls.Config `json:"-"`
-	TLSTimeout float64     `json:"tls_timeout,omitempty"`
-	URLs       []*url.URL  `json:"urls,omitempty"`
+	Name           string        `json:"name"`
+	TLSConfig      *tls.Config   `json:"-"`
+	TLSTimeout     float64       `json:"tls_timeout,omitempty"`
+	TLSPinnedCerts PinnedCertSet `json:"-"`
+	URLs           []*url.URL    `json:"urls,omitempty"`
+
+	// Not exported, the options the TLSConfig was generated from.
+	tlsConfigOpts *TLSConfigOpts
 }
 
 // LeafNodeOpts are options for a given server to accept leaf node connections and/or connect to a remote cluster.
@@ -121,10 +137,15 @@ type LeafNodeOpts struct {
 	Advertise         string        `json:"-"`
 	NoAdvertise       bool          `json:"-"`
 	ReconnectInterval time.Duration `json:"-"`
+	TLSPinnedCerts    PinnedCertSet `json:"-"`
+	Compression       string        `json:"-"`
 
 	// For solicited connections to other clusters/superclusters.
 	Remotes []*RemoteLeafOpts `json:"remotes,omitempty"`
 
+	// Not exported, the options the TLSConfig was generated from.
+	tlsConfigOpts *TLSConfigOpts
+
 	// Not exported, for tests.
 	resolver    netResolver
 	dialTimeout time.Duration
@@ -133,15 +154,25 @@ type LeafNodeOpts struct {
 
 // RemoteLeafOpts are options for connecting to a remote server as a leaf node.
 type RemoteLeafOpts struct {
-	LocalAccount string      `json:"local_account,omitempty"`
-	URLs         []*url.URL  `json:"urls,omitempty"`
-	Credentials  string      `json:"-"`
-	TLS          bool        `json:"-"`
-	TLSConfig    *tls.Config `json:"-"`
-	TLSTimeout   float64     `json:"tls_timeout,omitempty"`
-	Hub          bool        `json:"hub,omitempty"`
-	DenyImports  []string    `json:"-"`
-	DenyExports  []string    `json:"-"`
+	LocalAccount   string        `json:"local_account,omitempty"`
+	URLs           []*url.URL    `json:"urls,omitempty"`
+	Credentials    string        `json:"-"`
+	TLS            bool          `json:"-"`
+	TLSConfig      *tls.Config   `json:"-"`
+	TLSTimeout     float64       `json:"tls_timeout,omitempty"`
+	TLSPinnedCerts PinnedCertSet `json:"-"`
+	Hub            bool          `json:"hub,omitempty"`
+	DenyImports    []string      `json:"-"`
+	DenyExports    []string      `json:"-"`
+	Compression    string        `json:"-"`
+
+	// Options for URLs with the "ws" or "wss" scheme.
+	Websocket struct {
+		Compression bool `json:"-"`
+	}
+
+	// Not exported, the options the TLSConfig was generated from.
+	tlsConfigOpts *TLSConfigOpts
 }
 
 // Options block for nats-server.
@@ -174,6 +205,7 @@ type Options struct {
 	Username              string        `json:"-"`
 	Password              string        `json:"-"`
 	Authorization         string        `json:"-"`
+	AuthCallout           *AuthCallout  `json:"-"`
 	PingInterval          time.Duration `json:"ping_interval"`
 	MaxPingsOut           int           `json:"ping_max"`
 	HTTPHost              string        `json:"http_host"`
@@ -192,6 +224,7 @@ type Options struct {
 	JetStreamMaxStore     int64         `json:"-"`
 	StoreDir              string        `json:"-"`
 	Websocket             WebsocketOpts `json:"-"`
+	MQTT                  MQTTOpts      `json:"-"`
 	ProfPort              int           `json:"-"`
 	PidFile               string        `json:"-"`
 	PortsFileDir          string        `json:"-"`
@@ -218,6 +251,16 @@ type Options struct {
 	// MaxTracedMsgLen is the maximum printable length for traced messages.
 	MaxTracedMsgLen int `json:"-"`
 
+	// OCSPConfig configures OCSP stapling of the server certificates.
+	OCSPConfig *OCSPConfig `json:"-"`
+
+	// Listeners are additional client listeners, each with its own
+	// TLS, authorization and connection limit.
+	Listeners []*ListenerOpts `json:"-"`
+
+	// Not exported, the options the TLSConfig was generated from.
+	tlsConfigOpts *TLSConfigOpts
+
 	// Operating a trusted NATS server
 	TrustedKeys              []string              `json:"-"`
 	TrustedOperators         []*jwt.OperatorClaims `json:"-"`
@@ -231,6 +274,12 @@ type Options struct {
 	// CheckConfig configuration file syntax test was successful and exit.
 	CheckConfig bool `json:"-"`
 
+	// JetStreamCheck will verify the JetStream storage directory and exit.
+	JetStreamCheck bool `json:"-"`
+
+	// JetStreamRepair will verify and repair the JetStream storage directory and exit.
+	JetStreamRepair bool `json:"-"`
+
 	// ConnectErrorReports specifies the number of failed attempts
 	// at which point server should report the failure of an initial
 	// connection to a route, gateway or leaf node.
@@ -315,6 +364,97 @@ type WebsocketOpts struct {
 	// and write the response back to the client. This include the
 	// time needed for the TLS Handshake.
 	HandshakeTimeout time.Duration
+
+	// Not exported, the options the TLSConfig was generated from.
+	tlsConfigOpts *TLSConfigOpts
+}
+
+// MQTTOpts are options for MQTT
+type MQTTOpts struct {
+	// The server will accept MQTT client connections on this hostname/IP.
+	Host string
+	// The server will accept MQTT client connections on this port.
+	Port int
+
+	// If no user name is provided when a client connects, will default to this
+	// user and associated account. This user has to exist either in the
+	// Users defined here or in the global options.
+	NoAuthUser string
+
+	// Authentication section. If anything is configured in this section,
+	// it will override the authorization configuration for regular clients.
+	// Nkeys are not supported since MQTT clients can't sign a nonce, and
+	// user JWTs have to be bearer tokens for the same reason.
+	Username string
+	Password string
+	Token    string
+	Users    []*User
+
+	// Timeout for the authentication process. For MQTT clients, this is
+	// the time allowed to send the CONNECT packet.
+	AuthTimeout float64
+
+	// TLS configuration.
+	TLSConfig *tls.Config
+	// If true, map certificate values for authentication purposes.
+	TLSMap bool
+	// Timeout for the TLS handshake.
+	TLSTimeout float64
+
+	// Time after which a QoS 1 message that was sent to a subscription
+	// and not acknowledged by the client is sent again.
+	AckWait time.Duration
+
+	// Not exported, the options the TLSConfig was generated from.
+	tlsConfigOpts *TLSConfigOpts
+}
+
+// ListenerOpts are options for an additional client listener. Each
+// listener has its own TLS, authorization and connection limit, so
+// that, for instance, the server can accept clients with TLS on a
+// public interface and without TLS on a unix socket.
+type ListenerOpts struct {
+	// Name of the listener, used in logs and monitoring. Defaults to
+	// the listen address.
+	Name string
+	// The server will accept client connections on this hostname/IP.
+	Host string
+	// The server will accept client connections on this port.
+	Port int
+	// If set, the server will accept client connections on this unix
+	// socket instead of Host and Port.
+	Socket string
+
+	// Maximum number of client connections accepted by this listener.
+	// The server's MaxConn still applies to the total number of clients.
+	MaxConn int
+
+	// If no user is provided when a client connects, will default to this
+	// user and associated account. This user has to exist in the Users
+	// defined here.
+	NoAuthUser string
+
+	// Authentication section. If anything is configured in this section,
+	// it will override the authorization configuration for regular clients.
+	Username string
+	Password string
+	Token    string
+	Users    []*User
+	Nkeys    []*NkeyUser
+
+	// Timeout for the authentication process.
+	AuthTimeout float64
+
+	// TLS configuration. If set, TLS is required for all clients of
+	// this listener.
+	TLSConfig *tls.Config
+	// If true, map certificate values for authentication purposes.
+	TLSMap bool
+	// Timeout for the TLS handshake.
+	TLSTimeout float64
+
+	// Not exported, the options the TLSConfig was generated from.
+	tlsConfigOpts *TLSConfigOpts
 }
 
 type netResolver interface {
@@ -389,6 +529,7 @@ type authorization struct {
 	users              []*User
 	timeout            float64
 	defaultPermissions *Permissions
+	callout            *AuthCallout
 }
 
 // TLSConfigOpts holds the parsed tls config information,
@@ -403,6 +544,18 @@ type TLSConfigOpts struct {
 	Timeout          float64
 	Ciphers          []uint16
 	CurvePreferences []tls.CurveID
+	VerifyRevocation bool
+	PinnedCerts      PinnedCertSet
+}
+
+// PinnedCertSet is the set of the hex encoded SHA-256 fingerprints of
+// the subject public key info of the certificates a peer may present.
+type PinnedCertSet map[string]struct{}
+
+// Returns the fingerprint of the certificate as found in a PinnedCertSet.
+func pinnedCertFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return hex.EncodeToString(sum[:])
 }
 
 var tlsUsage = `
@@ -416,6 +569,8 @@ e.g.
         ca_file:        "./certs/ca.pem"
         verify:         true
         verify_and_map: true
+        verify_revocation: true
+        pinned_certs:   ["<sha256 of the peer certificate public key>"]
 
         cipher_suites: [
             "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
@@ -632,6 +787,7 @@ func (o *Options) processConfigFileLine(k string, v interface{}, errors *[]error
 			return
 		}
 		o.AuthTimeout = auth.timeout
+		o.AuthCallout = auth.callout
 		// Check for multiple users defined
 		if auth.users != nil {
 			if auth.user != "" {
@@ -752,8 +908,14 @@ func (o *Options) processConfigFileLine(k string, v interface{}, errors *[]error
 			*errors = append(*errors, err)
 			return
 		}
+		if tc.PinnedCerts != nil {
+			err := &configErr{tk, "pinned_certs is only supported for routes, gateways and leafnodes"}
+			*errors = append(*errors, err)
+			return
+		}
 		o.TLSTimeout = tc.Timeout
 		o.TLSMap = tc.Map
+		o.tlsConfigOpts = tc
 
 	case "allow_non_tls":
 		o.AllowNonTLS = v.(bool)
@@ -996,6 +1158,21 @@ func (o *Options) processConfigFileLine(k string, v interface{}, errors *[]error
 			*errors = append(*errors, err)
 			return
 		}
+	case "mqtt":
+		if err := parseMQTT(tk, o, errors, warnings); err != nil {
+			*errors = append(*errors, err)
+			return
+		}
+	case "listeners":
+		if err := parseListeners(tk, o, errors, warnings); err != nil {
+			*errors = append(*errors, err)
+			return
+		}
+	case "ocsp":
+		if err := parseOCSP(tk, o, errors); err != nil {
+			*errors = append(*errors, err)
+			return
+		}
 	default:
 		if au := atomic.LoadInt32(&allowUnknownTopLevelField); au == 0 && !tk.IsUsedVariable() {
 			err := &unknownConfigFieldErr{
@@ -1148,6 +1325,8 @@ func parseCluster(v interface{}, opts *Options, errors *[]error, warnings *[]err
 			opts.Cluster.TLSConfig = config
 			opts.Cluster.TLSTimeout = tlsopts.Timeout
 			opts.Cluster.TLSMap = tlsopts.Map
+			opts.Cluster.TLSPinnedCerts = tlsopts.PinnedCerts
+			opts.Cluster.tlsConfigOpts = tlsopts
 		case "cluster_advertise", "advertise":
 			opts.Cluster.Advertise = mv.(string)
 		case "no_advertise":
@@ -1155,6 +1334,13 @@ func parseCluster(v interface{}, opts *Options, errors *[]error, warnings *[]err
 			trackExplicitVal(opts, &opts.inConfig, "Cluster.NoAdvertise", opts.Cluster.NoAdvertise)
 		case "connect_retries":
 			opts.Cluster.ConnectRetries = int(mv.(int64))
+		case "compression":
+			mode, err := parseCompression(mv)
+			if err != nil {
+				*errors = append(*errors, &configErr{tk, err.Error()})
+				continue
+			}
+			opts.Cluster.Compression = mode
 		case "permissions":
 			perms, err := parseUserPermissions(mv, errors, warnings)
 			if err != nil {
@@ -1263,6 +1449,8 @@ func parseGateway(v interface{}, o *Options, errors *[]error, warnings *[]error)
 			o.Gateway.TLSConfig = config
 			o.Gateway.TLSTimeout = tlsopts.Timeout
 			o.Gateway.TLSMap = tlsopts.Map
+			o.Gateway.TLSPinnedCerts = tlsopts.PinnedCerts
+			o.Gateway.tlsConfigOpts = tlsopts
 		case "advertise":
 			o.Gateway.Advertise = mv.(string)
 		case "connect_retries":
@@ -1275,6 +1463,13 @@ func parseGateway(v interface{}, o *Options, errors *[]error, warnings *[]error)
 			o.Gateway.Gateways = gateways
 		case "reject_unknown":
 			o.Gateway.RejectUnknown = mv.(bool)
+		case "compression":
+			mode, err := parseCompression(mv)
+			if err != nil {
+				*errors = append(*errors, &configErr{tk, err.Error()})
+				continue
+			}
+			o.Gateway.Compression = mode
 		default:
 			if !tk.IsUsedVariable() {
 				err := &unknownConfigFieldErr{
@@ -1479,11 +1674,20 @@ func parseLeafNodes(v interface{}, opts *Options, errors *[]error, warnings *[]e
 				continue
 			}
 			opts.LeafNode.TLSTimeout = tc.Timeout
+			opts.LeafNode.TLSPinnedCerts = tc.PinnedCerts
+			opts.LeafNode.tlsConfigOpts = tc
 		case "leafnode_advertise", "advertise":
 			opts.LeafNode.Advertise = mv.(string)
 		case "no_advertise":
 			opts.LeafNode.NoAdvertise = mv.(bool)
 			trackExplicitVal(opts, &opts.inConfig, "LeafNode.NoAdvertise", opts.LeafNode.NoAdvertise)
+		case "compression":
+			mode, err := parseCompression(mv)
+			if err != nil {
+				*errors = append(*errors, &configErr{tk, err.Error()})
+				continue
+			}
+			opts.LeafNode.Compression = mode
 		default:
 			if !tk.IsUsedVariable() {
 				err := &unknownConfigFieldErr{
@@ -1681,8 +1885,19 @@ func parseRemoteLeafNodes(v interface{}, errors *[]error, warnings *[]error) ([]
 				} else {
 					remote.TLSTimeout = float64(DEFAULT_LEAF_TLS_TIMEOUT)
 				}
+				remote.TLSPinnedCerts = tc.PinnedCerts
+				remote.tlsConfigOpts = tc
 			case "hub":
 				remote.Hub = v.(bool)
+			case "ws_compression":
+				remote.Websocket.Compression = v.(bool)
+			case "compression":
+				mode, err := parseCompression(v)
+				if err != nil {
+					*errors = append(*errors, &configErr{tk, err.Error()})
+					continue
+				}
+				remote.Compression = mode
 			case "deny_imports", "deny_import":
 				subjects, err := parseSubjects(tk, errors, warnings)
 				if err != nil {
@@ -1768,6 +1983,8 @@ func parseGateways(v interface{}, errors *[]error, warnings *[]error) ([]*Remote
 				}
 				gateway.TLSConfig = tls
 				gateway.TLSTimeout = tlsopts.Timeout
+				gateway.TLSPinnedCerts = tlsopts.PinnedCerts
+				gateway.tlsConfigOpts = tlsopts
 			case "url":
 				url, err := parseURL(v.(string), "gateway")
 				if err != nil {
@@ -1944,6 +2161,12 @@ func parseAccounts(v interface{}, opts *Options, errors *[]error, warnings *[]er
 						*errors = append(*errors, err)
 						continue
 					}
+				case "mappings", "maps":
+					err := parseAccountMappings(tk, acc, errors, warnings)
+					if err != nil {
+						*errors = append(*errors, err)
+						continue
+					}
 				case "users":
 					var err error
 					usersTk = tk
@@ -2141,6 +2364,100 @@ func parseAccountExports(v interface{}, acc *Account, errors, warnings *[]error)
 	return streams, services, nil
 }
 
+// Parse the subject mappings of an account. A mapping is either a single
+// destination subject or an array of weighted destinations.
+func parseAccountMappings(v interface{}, acc *Account, errors, warnings *[]error) error {
+	var lt token
+	defer convertPanicToErrorList(&lt, errors)
+
+	tk, v := unwrapValue(v, &lt)
+	am, ok := v.(map[string]interface{})
+	if !ok {
+		return &configErr{tk, fmt.Sprintf("Mappings should be a map, got %T", v)}
+	}
+	for src, mv := range am {
+		tk, mv := unwrapValue(mv, &lt)
+		switch vv := mv.(type) {
+		case string:
+			if err := acc.AddMapping(src, vv); err != nil {
+				*errors = append(*errors, &configErr{tk, fmt.Sprintf("Error adding mapping for %q: %v", src, err)})
+			}
+		case []interface{}:
+			var dests []*MapDest
+			for _, dv := range vv {
+				dest, err := parseMapDest(dv, errors)
+				if err != nil {
+					*errors = append(*errors, err)
+					continue
+				}
+				dests = append(dests, dest)
+			}
+			if err := acc.AddWeightedMappings(src, dests...); err != nil {
+				*errors = append(*errors, &configErr{tk, fmt.Sprintf("Error adding mapping for %q: %v", src, err)})
+			}
+		default:
+			*errors = append(*errors, &configErr{tk, fmt.Sprintf("Unknown type %T for mapping destination of %q", mv, src)})
+		}
+	}
+	return nil
+}
+
+// Parse a weighted mapping destination, the weight can be given as a
+// number or a percentage and defaults to 100.
+func parseMapDest(v interface{}, errors *[]error) (*MapDest, error) {
+	var lt token
+	defer convertPanicToErrorList(&lt, errors)
+
+	tk, v := unwrapValue(v, &lt)
+	dm, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, &configErr{tk, fmt.Sprintf("Mapping destination should be a map, got %T", v)}
+	}
+	dest := &MapDest{Weight: 100}
+	for k, v := range dm {
+		tk, mv := unwrapValue(v, &lt)
+		switch strings.ToLower(k) {
+		case "destination", "dest", "subject":
+			s, ok := mv.(string)
+			if !ok {
+				return nil, &configErr{tk, fmt.Sprintf("Mapping destination subject should be a string, got %T", mv)}
+			}
+			dest.Subject = s
+		case "cluster":
+			s, ok := mv.(string)
+			if !ok {
+				return nil, &configErr{tk, fmt.Sprintf("Mapping destination cluster should be a string, got %T", mv)}
+			}
+			dest.Cluster = s
+		case "weight":
+			var w int64
+			switch vv := mv.(type) {
+			case int64:
+				w = vv
+			case string:
+				var err error
+				if w, err = strconv.ParseInt(strings.TrimSuffix(strings.TrimSpace(vv), "%"), 10, 8); err != nil {
+					return nil, &configErr{tk, fmt.Sprintf("Invalid mapping weight %q", vv)}
+				}
+			default:
+				return nil, &configErr{tk, fmt.Sprintf("Unknown type %T for mapping weight", mv)}
+			}
+			if w < 0 || w > 100 {
+				return nil, &configErr{tk, fmt.Sprintf("Mapping weight %d should be between 0 and 100", w)}
+			}
+			dest.Weight = uint8(w)
+		default:
+			if !tk.IsUsedVariable() {
+				return nil, &unknownConfigFieldErr{field: k, configErr: configErr{token: tk}}
+			}
+		}
+	}
+	if dest.Subject == _EMPTY_ {
+		return nil, &configErr{tk, "Mapping destination requires a subject"}
+	}
+	return dest, nil
+}
+
 // Parse the account imports
 func parseAccountImports(v interface{}, acc *Account, errors, warnings *[]error) ([]*importStream, []*importService, error) {
 	var lt token
@@ -2645,6 +2962,13 @@ func parseAuthorization(v interface{}, opts *Options, errors *[]error, warnings
 				continue
 			}
 			auth.defaultPermissions = permissions
+		case "auth_callout", "callout":
+			callout, err := parseAuthCallout(tk, errors, warnings)
+			if err != nil {
+				*errors = append(*errors, err)
+				continue
+			}
+			auth.callout = callout
 		default:
 			if !tk.IsUsedVariable() {
 				err := &unknownConfigFieldErr{
@@ -2663,6 +2987,53 @@ func parseAuthorization(v interface{}, opts *Options, errors *[]error, warnings
 	return auth, nil
 }
 
+// Helper function to parse the auth callout config.
+func parseAuthCallout(mv interface{}, errors, warnings *[]error) (*AuthCallout, error) {
+	var (
+		tk token
+		lt token
+		ac = &AuthCallout{}
+	)
+	defer convertPanicToErrorList(&lt, errors)
+
+	tk, mv = unwrapValue(mv, &lt)
+	am, ok := mv.(map[string]interface{})
+	if !ok {
+		return nil, &configErr{tk, fmt.Sprintf("Expected auth callout to be a map, got %T", mv)}
+	}
+	for mk, mv := range am {
+		tk, mv = unwrapValue(mv, &lt)
+		switch strings.ToLower(mk) {
+		case "issuer":
+			ac.Issuer = mv.(string)
+		case "account":
+			ac.Account = mv.(string)
+		case "auth_users":
+			users, ok := mv.([]interface{})
+			if !ok {
+				return nil, &configErr{tk, fmt.Sprintf("Expected auth users to be an array, got %T", mv)}
+			}
+			for _, u := range users {
+				_, u = unwrapValue(u, &lt)
+				ac.AuthUsers = append(ac.AuthUsers, u.(string))
+			}
+		case "timeout":
+			ac.Timeout = parseDuration("timeout", tk, mv, errors, warnings)
+		default:
+			if !tk.IsUsedVariable() {
+				err := &unknownConfigFieldErr{
+					field: mk,
+					configErr: configErr{
+						token: tk,
+					},
+				}
+				*errors = append(*errors, err)
+			}
+		}
+	}
+	return ac, nil
+}
+
 // Helper function to parse multiple users array with optional permissions.
 func parseUsers(mv interface{}, opts *Options, errors *[]error, warnings *[]error) ([]*NkeyUser, []*User, error) {
 	var (
@@ -3063,6 +3434,29 @@ func parseTLS(v interface{}) (t *TLSConfigOpts, retErr error) {
 			}
 			tc.Verify = verify
 			tc.Map = verify
+		case "verify_revocation":
+			verify, ok := mv.(bool)
+			if !ok {
+				return nil, &configErr{tk, "error parsing tls config, expected 'verify_revocation' to be a boolean"}
+			}
+			tc.VerifyRevocation = verify
+		case "pinned_certs":
+			ra, ok := mv.([]interface{})
+			if !ok || len(ra) == 0 {
+				return nil, &configErr{tk, "error parsing tls config, 'pinned_certs' must be a non empty list"}
+			}
+			tc.PinnedCerts = make(PinnedCertSet, len(ra))
+			for _, r := range ra {
+				tk, r := unwrapValue(r, &lt)
+				fp, ok := r.(string)
+				if ok {
+					fp = strings.ToLower(fp)
+				}
+				if b, err := hex.DecodeString(fp); !ok || err != nil || len(b) != sha256.Size {
+					return nil, &configErr{tk, fmt.Sprintf("error parsing tls config, invalid pinned certificate %v, expected a hex encoded SHA-256 fingerprint", r)}
+				}
+				tc.PinnedCerts[fp] = struct{}{}
+			}
 		case "cipher_suites":
 			ra := mv.([]interface{})
 			if len(ra) == 0 {
@@ -3159,7 +3553,13 @@ func parseWebsocket(v interface{}, o *Options, errors *[]error, warnings *[]erro
 				*errors = append(*errors, err)
 				continue
 			}
+			if tc.PinnedCerts != nil {
+				err := &configErr{tk, "pinned_certs is only supported for routes, gateways and leafnodes"}
+				*errors = append(*errors, err)
+				continue
+			}
 			o.Websocket.TLSMap = tc.Map
+			o.Websocket.tlsConfigOpts = tc
 		case "same_origin":
 			o.Websocket.SameOrigin = mv.(bool)
 		case "allowed_origins", "allowed_origin", "allow_origins", "allow_origin", "origins", "origin":
@@ -3257,6 +3657,294 @@ func parseWebsocket(v interface{}, o *Options, errors *[]error, warnings *[]erro
 	return nil
 }
 
+func parseMQTT(v interface{}, o *Options, errors *[]error, warnings *[]error) error {
+	var lt token
+	defer convertPanicToErrorList(&lt, errors)
+
+	tk, v := unwrapValue(v, &lt)
+	mm, ok := v.(map[string]interface{})
+	if !ok {
+		return &configErr{tk, fmt.Sprintf("Expected mqtt to be a map, got %T", v)}
+	}
+	for mk, mv := range mm {
+		// Again, unwrap token value if line check is required.
+		tk, mv = unwrapValue(mv, &lt)
+		switch strings.ToLower(mk) {
+		case "listen":
+			hp, err := parseListen(mv)
+			if err != nil {
+				err := &configErr{tk, err.Error()}
+				*errors = append(*errors, err)
+				continue
+			}
+			o.MQTT.Host = hp.host
+			o.MQTT.Port = hp.port
+		case "port":
+			o.MQTT.Port = int(mv.(int64))
+		case "host", "net":
+			o.MQTT.Host = mv.(string)
+		case "tls":
+			tc, err := parseTLS(tk)
+			if err != nil {
+				*errors = append(*errors, err)
+				continue
+			}
+			if o.MQTT.TLSConfig, err = GenTLSConfig(tc); err != nil {
+				err := &configErr{tk, err.Error()}
+				*errors = append(*errors, err)
+				continue
+			}
+			if tc.PinnedCerts != nil {
+				err := &configErr{tk, "pinned_certs is only supported for routes, gateways and leafnodes"}
+				*errors = append(*errors, err)
+				continue
+			}
+			o.MQTT.TLSTimeout = tc.Timeout
+			o.MQTT.TLSMap = tc.Map
+			o.MQTT.tlsConfigOpts = tc
+		case "authorization", "authentication":
+			auth, err := parseAuthorization(tk, o, errors, warnings)
+			if err != nil {
+				*errors = append(*errors, err)
+				continue
+			}
+			o.MQTT.Username = auth.user
+			o.MQTT.Password = auth.pass
+			o.MQTT.Token = auth.token
+			if (auth.user != "" || auth.pass != "") && auth.token != "" {
+				err := &configErr{tk, "Cannot have a user/pass and token"}
+				*errors = append(*errors, err)
+				continue
+			}
+			o.MQTT.AuthTimeout = auth.timeout
+			// Check for multiple users defined
+			if auth.users != nil {
+				if auth.user != "" {
+					err := &configErr{tk, "Can not have a single user/pass and a users array"}
+					*errors = append(*errors, err)
+					continue
+				}
+				if auth.token != "" {
+					err := &configErr{tk, "Can not have a token and a users array"}
+					*errors = append(*errors, err)
+					continue
+				}
+				// Users may have been added from Accounts parsing, so do an append here
+				o.MQTT.Users = append(o.MQTT.Users, auth.users...)
+			}
+			// MQTT clients can't sign a nonce.
+			if auth.nkeys != nil {
+				err := &configErr{tk, "MQTT authorization does not support nkeys"}
+				*errors = append(*errors, err)
+				continue
+			}
+		case "no_auth_user":
+			o.MQTT.NoAuthUser = mv.(string)
+		case "ack_wait", "ackwait":
+			switch mv := mv.(type) {
+			case int64:
+				o.MQTT.AckWait = time.Duration(mv) * time.Second
+			case string:
+				dur, err := time.ParseDuration(mv)
+				if err != nil {
+					err := &configErr{tk, err.Error()}
+					*errors = append(*errors, err)
+					continue
+				}
+				o.MQTT.AckWait = dur
+			default:
+				err := &configErr{tk, fmt.Sprintf("error parsing ack wait: unsupported type %T", mv)}
+				*errors = append(*errors, err)
+			}
+		default:
+			if !tk.IsUsedVariable() {
+				err := &unknownConfigFieldErr{
+					field: mk,
+					configErr: configErr{
+						token: tk,
+					},
+				}
+				*errors = append(*errors, err)
+				continue
+			}
+		}
+	}
+	return nil
+}
+
+func parseListeners(v interface{}, o *Options, errors *[]error, warnings *[]error) error {
+	var lt token
+	defer convertPanicToErrorList(&lt, errors)
+
+	tk, v := unwrapValue(v, &lt)
+	la, ok := v.([]interface{})
+	if !ok {
+		return &configErr{tk, fmt.Sprintf("Expected listeners to be an array, got %T", v)}
+	}
+	for _, l := range la {
+		tk, l = unwrapValue(l, &lt)
+		lm, ok := l.(map[string]interface{})
+		if !ok {
+			*errors = append(*errors, &configErr{tk, fmt.Sprintf("Expected listener entry to be a map/struct, got %v", l)})
+			continue
+		}
+		lo := &ListenerOpts{}
+		for mk, mv := range lm {
+			tk, mv = unwrapValue(mv, &lt)
+			switch strings.ToLower(mk) {
+			case "name":
+				lo.Name = mv.(string)
+			case "listen":
+				hp, err := parseListen(mv)
+				if err != nil {
+					err := &configErr{tk, err.Error()}
+					*errors = append(*errors, err)
+					continue
+				}
+				lo.Host = hp.host
+				lo.Port = hp.port
+			case "port":
+				lo.Port = int(mv.(int64))
+			case "host", "net":
+				lo.Host = mv.(string)
+			case "socket", "unix_socket":
+				lo.Socket = mv.(string)
+			case "max_connections", "max_conn":
+				lo.MaxConn = int(mv.(int64))
+			case "tls":
+				tc, err := parseTLS(tk)
+				if err != nil {
+					*errors = append(*errors, err)
+					continue
+				}
+				if lo.TLSConfig, err = GenTLSConfig(tc); err != nil {
+					err := &configErr{tk, err.Error()}
+					*errors = append(*errors, err)
+					continue
+				}
+				if tc.PinnedCerts != nil {
+					err := &configErr{tk, "pinned_certs is only supported for routes, gateways and leafnodes"}
+					*errors = append(*errors, err)
+					continue
+				}
+				lo.TLSTimeout = tc.Timeout
+				lo.TLSMap = tc.Map
+				lo.tlsConfigOpts = tc
+			case "authorization", "authentication":
+				auth, err := parseAuthorization(tk, o, errors, warnings)
+				if err != nil {
+					*errors = append(*errors, err)
+					continue
+				}
+				lo.Username = auth.user
+				lo.Password = auth.pass
+				lo.Token = auth.token
+				if (auth.user != "" || auth.pass != "") && auth.token != "" {
+					err := &configErr{tk, "Cannot have a user/pass and token"}
+					*errors = append(*errors, err)
+					continue
+				}
+				lo.AuthTimeout = auth.timeout
+				// Check for multiple users defined
+				if auth.users != nil {
+					if auth.user != "" {
+						err := &configErr{tk, "Can not have a single user/pass and a users array"}
+						*errors = append(*errors, err)
+						continue
+					}
+					if auth.token != "" {
+						err := &configErr{tk, "Can not have a token and a users array"}
+						*errors = append(*errors, err)
+						continue
+					}
+					lo.Users = auth.users
+				}
+				// Check for nkeys
+				if auth.nkeys != nil {
+					lo.Nkeys = auth.nkeys
+				}
+			case "no_auth_user":
+				lo.NoAuthUser = mv.(string)
+			default:
+				if !tk.IsUsedVariable() {
+					err := &unknownConfigFieldErr{
+						field: mk,
+						configErr: configErr{
+							token: tk,
+						},
+					}
+					*errors = append(*errors, err)
+					continue
+				}
+			}
+		}
+		o.Listeners = append(o.Listeners, lo)
+	}
+	return nil
+}
+
+// parseOCSP parses the OCSP stapling configuration, which is either
+// a boolean or a map with the mode, responder URLs and cache directory.
+func parseOCSP(v interface{}, o *Options, errors *[]error) error {
+	var lt token
+	defer convertPanicToErrorList(&lt, errors)
+
+	tk, v := unwrapValue(v, &lt)
+	oc := &OCSPConfig{}
+	switch v := v.(type) {
+	case bool:
+		if v {
+			oc.Mode = OCSPModeAlways
+		} else {
+			oc.Mode = OCSPModeNever
+		}
+		o.OCSPConfig = oc
+		return nil
+	case map[string]interface{}:
+		for mk, mv := range v {
+			tk, mv = unwrapValue(mv, &lt)
+			switch strings.ToLower(mk) {
+			case "mode":
+				mode, err := ocspModeFromString(mv.(string))
+				if err != nil {
+					*errors = append(*errors, &configErr{tk, err.Error()})
+					continue
+				}
+				oc.Mode = mode
+			case "url", "urls":
+				switch mv := mv.(type) {
+				case string:
+					oc.OverrideURLs = []string{mv}
+				case []interface{}:
+					for _, u := range mv {
+						tk, u = unwrapValue(u, &lt)
+						oc.OverrideURLs = append(oc.OverrideURLs, u.(string))
+					}
+				default:
+					*errors = append(*errors, &configErr{tk, fmt.Sprintf("error parsing ocsp url: unsupported type %T", mv)})
+				}
+			case "cache_dir", "dir":
+				oc.CacheDir = mv.(string)
+			default:
+				if !tk.IsUsedVariable() {
+					err := &unknownConfigFieldErr{
+						field: mk,
+						configErr: configErr{
+							token: tk,
+						},
+					}
+					*errors = append(*errors, err)
+					continue
+				}
+			}
+		}
+	default:
+		return &configErr{tk, fmt.Sprintf("Expected ocsp to be a boolean or a map, got %T", v)}
+	}
+	o.OCSPConfig = oc
+	return nil
+}
+
 // GenTLSConfig loads TLS related configuration parameters.
 func GenTLSConfig(tc *TLSConfigOpts) (*tls.Config, error) {
 	// Create the tls.Config from our options before including the certs.
@@ -3292,6 +3980,10 @@ func GenTLSConfig(tc *TLSConfigOpts) (*tls.Config, error) {
 	if tc.Verify {
 		config.ClientAuth = tls.RequireAndVerifyClientCert
 	}
+	// Check that verified peer certificates have not been revoked.
+	if tc.VerifyRevocation {
+		config.VerifyPeerCertificate = newRevocationChecker().verifyPeerCertificate
+	}
 	// Add in CAs if applicable.
 	if tc.CaFile != "" {
 		rootPEM, err := ioutil.ReadFile(tc.CaFile)
@@ -3604,6 +4296,29 @@ func setBaselineOptions(opts *Options) {
 			opts.Websocket.Host = DEFAULT_HOST
 		}
 	}
+	if opts.MQTT.Port != 0 {
+		if opts.MQTT.Host == "" {
+			opts.MQTT.Host = DEFAULT_HOST
+		}
+		if opts.MQTT.TLSTimeout == 0 {
+			opts.MQTT.TLSTimeout = float64(TLS_TIMEOUT) / float64(time.Second)
+		}
+	}
+	for _, l := range opts.Listeners {
+		if l.Socket == _EMPTY_ && l.Host == _EMPTY_ {
+			l.Host = DEFAULT_HOST
+		}
+		if l.Name == _EMPTY_ {
+			if l.Socket != _EMPTY_ {
+				l.Name = l.Socket
+			} else {
+				l.Name = net.JoinHostPort(l.Host, strconv.Itoa(l.Port))
+			}
+		}
+		if l.TLSTimeout == 0 {
+			l.TLSTimeout = float64(TLS_TIMEOUT) / float64(time.Second)
+		}
+	}
 	// JetStream
 	if opts.JetStreamMaxMemory == 0 {
 		opts.JetStreamMaxMemory = -1
@@ -3691,6 +4406,8 @@ func ConfigureOptions(fs *flag.FlagSet, args []string, printVersion, printHelp,
 	fs.BoolVar(&opts.JetStream, "jetstream", false, "Enable JetStream.")
 	fs.StringVar(&opts.StoreDir, "sd", "", "Storage directory.")
 	fs.StringVar(&opts.StoreDir, "store_dir", "", "Storage directory.")
+	fs.BoolVar(&opts.JetStreamCheck, "js_check", false, "Verify the JetStream storage directory and exit.")
+	fs.BoolVar(&opts.JetStreamRepair, "js_repair", false, "Verify and repair the JetStream storage directory and exit.")
 	fs.BoolVar(&opts.Sctp, "sctp", false, "sctp transport protocol")
 
 	// The flags definition above set "default" values to some of the options.
@@ -3804,6 +4521,11 @@ func ConfigureOptions(fs *flag.FlagSet, args []string, printVersion, printHelp,
 		return nil, fmt.Errorf("must specify [-c, --config] option to check configuration file syntax")
 	}
 
+	// Repairing the JetStream storage directory implies checking it.
+	if opts.JetStreamRepair {
+		opts.JetStreamCheck = true
+	}
+
 	// Special handling of some flags
 	var (
 		flagErr     error
@@ -3902,6 +4624,7 @@ func overrideTLS(opts *Options) error {
 
 	var err error
 	opts.TLSConfig, err = GenTLSConfig(&tc)
+	opts.tlsConfigOpts = &tc
 	return err
 }
 
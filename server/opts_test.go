@@ -146,6 +146,7 @@ func TestTLSConfigFile(t *testing.T) {
 		t.Fatal("Expected opts.TLSConfig to be non-nil")
 	}
 	opts.TLSConfig = nil
+	opts.tlsConfigOpts = nil
 	checkOptionsEqual(t, golden, opts)
 
 	// Now check TLSConfig a bit more closely
@@ -1927,6 +1928,7 @@ func TestParsingGateways(t *testing.T) {
 		t.Fatalf("Expected TLSConfig, got none")
 	}
 	opts.Gateway.TLSConfig = nil
+	opts.Gateway.tlsConfigOpts = nil
 	if !reflect.DeepEqual(&opts.Gateway, expected) {
 		t.Fatalf("Expected %v, got %v", expected, opts.Gateway)
 	}
@@ -2189,6 +2191,7 @@ func TestParsingLeafNodesListener(t *testing.T) {
 		t.Fatalf("Expected TLSConfig, got none")
 	}
 	opts.LeafNode.TLSConfig = nil
+	opts.LeafNode.tlsConfigOpts = nil
 	if !reflect.DeepEqual(&opts.LeafNode, expected) {
 		t.Fatalf("Expected %v, got %v", expected, opts.LeafNode)
 	}
@@ -2790,3 +2793,59 @@ func TestClusterNameAndGatewayNameConflict(t *testing.T) {
 		t.Fatalf("Expected ErrClusterNameConfigConflict got %v", err)
 	}
 }
+
+func TestParsingPinnedCerts(t *testing.T) {
+	fp := strings.Repeat("ab", 32)
+	tmpl := `
+		%s {
+			%s
+			tls {
+				cert_file: "./configs/certs/server.pem"
+				key_file: "./configs/certs/key.pem"
+				pinned_certs: [%s]
+			}
+		}
+	`
+	for _, test := range []struct {
+		name        string
+		block       string
+		extra       string
+		pinned      string
+		expectedErr string
+	}{
+		{"cluster", "cluster", `listen: "127.0.0.1:-1"`, fmt.Sprintf("%q", strings.ToUpper(fp)), _EMPTY_},
+		{"gateway", "gateway", `name: "A"`, fmt.Sprintf("%q", fp), _EMPTY_},
+		{"leafnode", "leafnodes", `listen: "127.0.0.1:-1"`, fmt.Sprintf("%q", fp), _EMPTY_},
+		{"websocket", "websocket", `listen: "127.0.0.1:-1"`, fmt.Sprintf("%q", fp), "only supported"},
+		{"empty", "cluster", _EMPTY_, _EMPTY_, "non empty list"},
+		{"not hex", "cluster", _EMPTY_, fmt.Sprintf("%q", strings.Repeat("zz", 32)), "invalid pinned certificate"},
+		{"bad length", "cluster", _EMPTY_, `"abcd"`, "invalid pinned certificate"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			conf := createConfFile(t, []byte(fmt.Sprintf(tmpl, test.block, test.extra, test.pinned)))
+			defer os.Remove(conf)
+			opts, err := ProcessConfigFile(conf)
+			if test.expectedErr != _EMPTY_ {
+				if err == nil || !strings.Contains(err.Error(), test.expectedErr) {
+					t.Fatalf("Expected error about %q, got %v", test.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Error processing config: %v", err)
+			}
+			var pinned PinnedCertSet
+			switch test.block {
+			case "cluster":
+				pinned = opts.Cluster.TLSPinnedCerts
+			case "gateway":
+				pinned = opts.Gateway.TLSPinnedCerts
+			case "leafnodes":
+				pinned = opts.LeafNode.TLSPinnedCerts
+			}
+			if _, ok := pinned[fp]; !ok || len(pinned) != 1 {
+				t.Fatalf("Expected pinned certificate %q, got %v", fp, pinned)
+			}
+		})
+	}
+}
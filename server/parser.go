@@ -985,6 +985,12 @@ func (c *client) parse(buf []byte) error {
 					arg = buf[c.as : i-c.drop]
 				}
 				if err := c.processInfo(arg); err != nil {
+					// What follows is compressed and will be decompressed
+					// by the readLoop before being parsed.
+					if err == errCompressionStart {
+						c.drop, c.as, c.state = 0, i+1, OP_START
+						c.in.cpend = buf[i+1:]
+					}
 					return err
 				}
 				c.drop, c.as, c.state = 0, i+1, OP_START
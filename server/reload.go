@@ -200,6 +200,87 @@ func (t *tlsOption) Apply(server *Server) {
 	server.Noticef("Reloaded: tls = %s", message)
 }
 
+// ocspOption implements the option interface for the `ocsp` setting.
+type ocspOption struct {
+	noopOption
+	newValue *OCSPConfig
+}
+
+// Apply is a no-op because OCSP stapling is setup for the new TLS
+// configurations before the options are applied.
+func (o *ocspOption) Apply(server *Server) {
+	server.Noticef("Reloaded: ocsp")
+}
+
+// pinnedCertOption implements the option interface for the tls `pinned_certs`
+// setting of the cluster, gateway and leafnode blocks.
+type pinnedCertOption struct {
+	noopOption
+	kind     int
+	newValue PinnedCertSet
+	gateways []*RemoteGatewayOpts
+}
+
+// Apply the pinned certificates change by closing the existing connections
+// whose peer certificate is no longer pinned.
+func (p *pinnedCertOption) Apply(s *Server) {
+	var conns []*client
+	s.mu.Lock()
+	switch p.kind {
+	case ROUTER:
+		for _, c := range s.routes {
+			conns = append(conns, c)
+		}
+	case GATEWAY:
+		gw := s.gateway
+		gw.RLock()
+		// Remote gateways without their own tls block use the pinned
+		// certificates of the gateway block.
+		for _, cfg := range gw.remotes {
+			cfg.Lock()
+			if cfg.implicit {
+				cfg.TLSPinnedCerts = p.newValue
+			}
+			for _, rgo := range p.gateways {
+				if rgo.Name == cfg.Name && rgo.TLSConfig == nil {
+					cfg.TLSPinnedCerts = p.newValue
+				}
+			}
+			cfg.Unlock()
+		}
+		for _, c := range gw.out {
+			conns = append(conns, c)
+		}
+		for _, c := range gw.in {
+			conns = append(conns, c)
+		}
+		gw.RUnlock()
+	case LEAF:
+		for _, c := range s.leafs {
+			// Solicited leafnodes use the pinned certificates of their remote.
+			if !c.isSolicitedLeafNode() {
+				conns = append(conns, c)
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	for _, c := range conns {
+		pinned := p.newValue
+		c.mu.Lock()
+		if c.kind == GATEWAY && c.gw.outbound {
+			c.gw.cfg.RLock()
+			pinned = c.gw.cfg.TLSPinnedCerts
+			c.gw.cfg.RUnlock()
+		}
+		c.mu.Unlock()
+		if !c.matchesPinnedCert(pinned) {
+			c.closeConnection(TLSHandshakeError)
+		}
+	}
+	s.Noticef("Reloaded: pinned_certs")
+}
+
 // tlsTimeoutOption implements the option interface for the tls `timeout`
 // setting.
 type tlsTimeoutOption struct {
@@ -289,6 +370,16 @@ func (u *nkeysOption) Apply(server *Server) {
 	server.Noticef("Reloaded: authorization nkey users")
 }
 
+// authCalloutOption implements the option interface for the authorization
+// `auth_callout` setting.
+type authCalloutOption struct {
+	authOption
+}
+
+func (a *authCalloutOption) Apply(server *Server) {
+	server.Noticef("Reloaded: authorization callout")
+}
+
 // clusterOption implements the option interface for the `cluster` setting.
 type clusterOption struct {
 	authOption
@@ -633,6 +724,11 @@ func (s *Server) Reload() error {
 	gatewayOrgPort := curOpts.Gateway.Port
 	leafnodesOrgPort := curOpts.LeafNode.Port
 	websocketOrgPort := curOpts.Websocket.Port
+	mqttOrgPort := curOpts.MQTT.Port
+	listenersOrgPorts := make([]int, len(curOpts.Listeners))
+	for i, l := range curOpts.Listeners {
+		listenersOrgPorts[i] = l.Port
+	}
 
 	s.mu.Unlock()
 
@@ -665,6 +761,14 @@ func (s *Server) Reload() error {
 	if newOpts.Websocket.Port == -1 {
 		newOpts.Websocket.Port = websocketOrgPort
 	}
+	if newOpts.MQTT.Port == -1 {
+		newOpts.MQTT.Port = mqttOrgPort
+	}
+	for i, l := range newOpts.Listeners {
+		if l.Port == -1 && i < len(listenersOrgPorts) {
+			l.Port = listenersOrgPorts[i]
+		}
+	}
 
 	if err := s.reloadOptions(curOpts, newOpts); err != nil {
 		return err
@@ -721,6 +825,12 @@ func (s *Server) reloadOptions(curOpts, newOpts *Options) error {
 		}
 	}
 
+	// The certificates of the new TLS configurations are setup,
+	// with their OCSP staples, before they are used.
+	if err := s.reloadTLSCerts(newOpts); err != nil {
+		return err
+	}
+
 	// Create a context that is used to pass special info that we may need
 	// while applying the new options.
 	ctx := reloadContext{oldClusterPerms: curOpts.Cluster.Permissions}
@@ -769,8 +879,12 @@ func imposeOrder(value interface{}) error {
 		sort.Slice(value.AllowedOrigins, func(i, j int) bool {
 			return value.AllowedOrigins[i] < value.AllowedOrigins[j]
 		})
+	case *AuthCallout:
+		if value != nil {
+			sort.Strings(value.AuthUsers)
+		}
 	case string, bool, int, int32, int64, time.Duration, float64, nil,
-		LeafNodeOpts, ClusterOpts, *tls.Config, *URLAccResolver, *MemAccResolver, *DirAccResolver, *CacheDirAccResolver, Authentication:
+		LeafNodeOpts, ClusterOpts, MQTTOpts, []*ListenerOpts, *tls.Config, *OCSPConfig, *URLAccResolver, *MemAccResolver, *DirAccResolver, *CacheDirAccResolver, Authentication:
 		// explicitly skipped types
 	default:
 		// this will fail during unit tests
@@ -826,6 +940,8 @@ func (s *Server) diffOptions(newOpts *Options) ([]option, error) {
 			diffOpts = append(diffOpts, &remoteSyslogOption{newValue: newValue.(string)})
 		case "tlsconfig":
 			diffOpts = append(diffOpts, &tlsOption{newValue: newValue.(*tls.Config)})
+		case "ocspconfig":
+			diffOpts = append(diffOpts, &ocspOption{newValue: newValue.(*OCSPConfig)})
 		case "tlstimeout":
 			diffOpts = append(diffOpts, &tlsTimeoutOption{newValue: newValue.(float64)})
 		case "username":
@@ -838,6 +954,8 @@ func (s *Server) diffOptions(newOpts *Options) ([]option, error) {
 			diffOpts = append(diffOpts, &authTimeoutOption{newValue: newValue.(float64)})
 		case "users":
 			diffOpts = append(diffOpts, &usersOption{})
+		case "authcallout":
+			diffOpts = append(diffOpts, &authCalloutOption{})
 		case "nkeys":
 			diffOpts = append(diffOpts, &nkeysOption{})
 		case "cluster":
@@ -848,6 +966,9 @@ func (s *Server) diffOptions(newOpts *Options) ([]option, error) {
 			}
 			permsChanged := !reflect.DeepEqual(newClusterOpts.Permissions, oldClusterOpts.Permissions)
 			diffOpts = append(diffOpts, &clusterOption{newValue: newClusterOpts, permsChanged: permsChanged})
+			if !reflect.DeepEqual(newClusterOpts.TLSPinnedCerts, oldClusterOpts.TLSPinnedCerts) {
+				diffOpts = append(diffOpts, &pinnedCertOption{kind: ROUTER, newValue: newClusterOpts.TLSPinnedCerts})
+			}
 		case "routes":
 			add, remove := diffRoutes(oldValue.([]*url.URL), newValue.([]*url.URL))
 			diffOpts = append(diffOpts, &routesOption{add: add, remove: remove})
@@ -897,18 +1018,33 @@ func (s *Server) diffOptions(newOpts *Options) ([]option, error) {
 			tmpNew := newValue.(GatewayOpts)
 			tmpOld.TLSConfig = nil
 			tmpNew.TLSConfig = nil
+			tmpOld.tlsConfigOpts = nil
+			tmpNew.tlsConfigOpts = nil
+			tmpOld.TLSPinnedCerts = nil
+			tmpNew.TLSPinnedCerts = nil
+			tmpOld.Gateways = remoteGatewaysWithoutTLSConfig(tmpOld.Gateways)
+			tmpNew.Gateways = remoteGatewaysWithoutTLSConfig(tmpNew.Gateways)
 			// If there is really a change prevents reload.
 			if !reflect.DeepEqual(tmpOld, tmpNew) {
 				// See TODO(ik) note below about printing old/new values.
 				return nil, fmt.Errorf("config reload not supported for %s: old=%v, new=%v",
 					field.Name, oldValue, newValue)
 			}
+			newGatewayOpts := newValue.(GatewayOpts)
+			if !reflect.DeepEqual(newGatewayOpts.TLSPinnedCerts, oldValue.(GatewayOpts).TLSPinnedCerts) {
+				diffOpts = append(diffOpts, &pinnedCertOption{kind: GATEWAY,
+					newValue: newGatewayOpts.TLSPinnedCerts, gateways: newGatewayOpts.Gateways})
+			}
 		case "leafnode":
 			// Similar to gateways
 			tmpOld := oldValue.(LeafNodeOpts)
 			tmpNew := newValue.(LeafNodeOpts)
 			tmpOld.TLSConfig = nil
 			tmpNew.TLSConfig = nil
+			tmpOld.tlsConfigOpts = nil
+			tmpNew.tlsConfigOpts = nil
+			tmpOld.TLSPinnedCerts = nil
+			tmpNew.TLSPinnedCerts = nil
 
 			// Special check for leafnode remotes changes which are not supported right now.
 			leafRemotesChanged := func(a, b LeafNodeOpts) bool {
@@ -926,7 +1062,7 @@ func (s *Server) diffOptions(newOpts *Options) ([]option, error) {
 							newRemote.LocalAccount = globalAccountName
 						}
 
-						if reflect.DeepEqual(oldRemote, newRemote) {
+						if reflect.DeepEqual(remoteLeafWithoutTLSConfig(oldRemote), remoteLeafWithoutTLSConfig(newRemote)) {
 							found = true
 							break
 						}
@@ -953,6 +1089,9 @@ func (s *Server) diffOptions(newOpts *Options) ([]option, error) {
 				return nil, fmt.Errorf("config reload not supported for %s: old=%v, new=%v",
 					field.Name, oldValue, newValue)
 			}
+			if newPinned := newValue.(LeafNodeOpts).TLSPinnedCerts; !reflect.DeepEqual(newPinned, oldValue.(LeafNodeOpts).TLSPinnedCerts) {
+				diffOpts = append(diffOpts, &pinnedCertOption{kind: LEAF, newValue: newPinned})
+			}
 		case "storedir":
 			return nil, fmt.Errorf("config reload not supported for jetstream storage directory")
 		case "jetstream":
@@ -971,12 +1110,40 @@ func (s *Server) diffOptions(newOpts *Options) ([]option, error) {
 			tmpNew := newValue.(WebsocketOpts)
 			tmpOld.TLSConfig = nil
 			tmpNew.TLSConfig = nil
+			tmpOld.tlsConfigOpts = nil
+			tmpNew.tlsConfigOpts = nil
 			// If there is really a change prevents reload.
 			if !reflect.DeepEqual(tmpOld, tmpNew) {
 				// See TODO(ik) note below about printing old/new values.
 				return nil, fmt.Errorf("config reload not supported for %s: old=%v, new=%v",
 					field.Name, oldValue, newValue)
 			}
+		case "mqtt":
+			tmpOld := oldValue.(MQTTOpts)
+			tmpNew := newValue.(MQTTOpts)
+			tmpOld.TLSConfig = nil
+			tmpNew.TLSConfig = nil
+			tmpOld.tlsConfigOpts = nil
+			tmpNew.tlsConfigOpts = nil
+			// If there is really a change prevents reload.
+			if !reflect.DeepEqual(tmpOld, tmpNew) {
+				return nil, fmt.Errorf("config reload not supported for %s: old=%v, new=%v",
+					field.Name, oldValue, newValue)
+			}
+		case "listeners":
+			// Similar to websocket, for each listener.
+			oldListeners := oldValue.([]*ListenerOpts)
+			newListeners := newValue.([]*ListenerOpts)
+			changed := len(oldListeners) != len(newListeners)
+			for i := 0; !changed && i < len(oldListeners); i++ {
+				tmpOld, tmpNew := *oldListeners[i], *newListeners[i]
+				tmpOld.TLSConfig, tmpNew.TLSConfig = nil, nil
+				tmpOld.tlsConfigOpts, tmpNew.tlsConfigOpts = nil, nil
+				changed = !reflect.DeepEqual(tmpOld, tmpNew)
+			}
+			if changed {
+				return nil, fmt.Errorf("config reload not supported for %s", field.Name)
+			}
 		case "connecterrorreports":
 			diffOpts = append(diffOpts, &connectErrorReports{newValue: newValue.(int)})
 		case "reconnecterrorreports":
@@ -1012,6 +1179,32 @@ func (s *Server) diffOptions(newOpts *Options) ([]option, error) {
 	return diffOpts, nil
 }
 
+// Returns copies of the remote gateways without their TLS configuration when
+// it was generated from options, which are compared instead since the
+// configuration may have its certificate installed.
+func remoteGatewaysWithoutTLSConfig(gateways []*RemoteGatewayOpts) []*RemoteGatewayOpts {
+	res := make([]*RemoteGatewayOpts, len(gateways))
+	for i, g := range gateways {
+		tmp := *g
+		if tmp.tlsConfigOpts != nil {
+			tmp.TLSConfig = nil
+		}
+		res[i] = &tmp
+	}
+	return res
+}
+
+// Same than remoteGatewaysWithoutTLSConfig for a leafnode remote, TLS
+// is ignored as well since it is set when the remote requires TLS.
+func remoteLeafWithoutTLSConfig(r *RemoteLeafOpts) *RemoteLeafOpts {
+	tmp := *r
+	tmp.TLS = false
+	if tmp.tlsConfigOpts != nil {
+		tmp.TLSConfig = nil
+	}
+	return &tmp
+}
+
 func (s *Server) applyOptions(ctx *reloadContext, opts []option) {
 	var (
 		reloadLogging      = false
@@ -1445,6 +1638,10 @@ func validateClusterOpts(old, new ClusterOpts) error {
 		return fmt.Errorf("config reload not supported for cluster port: old=%d, new=%d",
 			old.Port, new.Port)
 	}
+	if old.Compression != new.Compression {
+		return fmt.Errorf("config reload not supported for cluster compression: old=%s, new=%s",
+			old.Compression, new.Compression)
+	}
 	// Validate Cluster.Advertise syntax
 	if new.Advertise != "" {
 		if _, _, err := parseHostPort(new.Advertise, 0); err != nil {
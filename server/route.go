@@ -491,6 +491,7 @@ func (c *client) processRouteInfo(info *Info) {
 
 	supportsHeaders := c.srv.supportsHeaders()
 	clusterName := c.srv.ClusterName()
+	compression := c.srv.getOpts().Cluster.Compression
 
 	c.mu.Lock()
 	// Connection can be closed at any time (by auth timeout, etc).
@@ -621,6 +622,10 @@ func (c *client) processRouteInfo(info *Info) {
 		c.route.url = url
 	}
 
+	// Our INFO has already been sent, so we can start compressing
+	// if the remote wants compression too.
+	c.maybeStartCompression(compression, needsCompression(info.Compression))
+
 	// Check to see if we have this remote already registered.
 	// This can happen when both servers have routes to each other.
 	c.mu.Unlock()
@@ -1345,6 +1350,12 @@ func (s *Server) createRoute(conn net.Conn, rURL *url.URL) *client {
 		// Reset the read deadline
 		conn.SetReadDeadline(time.Time{})
 
+		if !c.matchesPinnedCert(opts.Cluster.TLSPinnedCerts) {
+			c.sendErr("Secure Connection - Certificate Not Pinned")
+			c.closeConnection(TLSHandshakeError)
+			return nil
+		}
+
 		// Re-Grab lock
 		c.mu.Lock()
 
@@ -1719,6 +1730,10 @@ func (s *Server) startRouteAcceptLoop() {
 		Dynamic:      s.isClusterNameDynamic(),
 		LNOC:         true,
 	}
+	// Advertise the compression mode if compression is enabled.
+	if needsCompression(opts.Cluster.Compression) {
+		info.Compression = opts.Cluster.Compression
+	}
 	// Set this if only if advertise is not disabled
 	if !opts.Cluster.NoAdvertise {
 		info.ClientConnectURLs = s.clientConnectURLs
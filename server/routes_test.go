@@ -15,9 +15,12 @@ package server
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/url"
+	"os"
 	"strconv"
 	"strings"
 	"sync"
@@ -1281,3 +1284,67 @@ func TestRouteClusterNameConflictBetweenStaticAndDynamic(t *testing.T) {
 
 	checkClusterFormed(t, s1, s2)
 }
+
+func TestRoutePinnedCerts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pinned")
+	if err != nil {
+		t.Fatalf("Error creating dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newOCSPTestCA(t)
+	caFile := ca.writeCert(t, dir)
+	certA, certFileA, keyFileA := ca.issue(t, dir, 2, _EMPTY_, _EMPTY_, false)
+	certB, certFileB, keyFileB := ca.issue(t, dir, 3, _EMPTY_, _EMPTY_, false)
+	other, _, _ := ca.issue(t, dir, 4, _EMPTY_, _EMPTY_, false)
+
+	tmpl := `
+		listen: "127.0.0.1:%d"
+		cluster {
+			name: "abc"
+			listen: "127.0.0.1:%d"
+			tls {
+				cert_file: %q
+				key_file: %q
+				ca_file: %q
+				verify: true
+				pinned_certs: [%q, %q]
+			}
+			%s
+		}
+	`
+	confA := createConfFile(t, []byte(fmt.Sprintf(tmpl, -1, -1, certFileA, keyFileA, caFile,
+		pinnedCertFingerprint(certA), pinnedCertFingerprint(certB), _EMPTY_)))
+	defer os.Remove(confA)
+	sA, optsA := RunServerWithConfig(confA)
+	defer sA.Shutdown()
+
+	confB := createConfFile(t, []byte(fmt.Sprintf(tmpl, -1, -1, certFileB, keyFileB, caFile,
+		pinnedCertFingerprint(certA), pinnedCertFingerprint(certB),
+		fmt.Sprintf("routes: [\"nats://127.0.0.1:%d\"]", optsA.Cluster.Port))))
+	defer os.Remove(confB)
+	sB, _ := RunServerWithConfig(confB)
+	defer sB.Shutdown()
+
+	checkClusterFormed(t, sA, sB)
+
+	// Once B is no longer pinned, the route is closed and B can't reconnect.
+	reloadA := func(pinned *x509.Certificate) {
+		t.Helper()
+		changeCurrentConfigContentWithNewContent(t, confA, []byte(fmt.Sprintf(tmpl, optsA.Port, optsA.Cluster.Port,
+			certFileA, keyFileA, caFile, pinnedCertFingerprint(certA), pinnedCertFingerprint(pinned), _EMPTY_)))
+		if err := sA.Reload(); err != nil {
+			t.Fatalf("Error on reload: %v", err)
+		}
+	}
+	reloadA(other)
+	checkNumRoutes(t, sA, 0)
+	checkNumRoutes(t, sB, 0)
+	time.Sleep(100 * time.Millisecond)
+	if n := sA.NumRoutes(); n != 0 {
+		t.Fatalf("Expected no route, got %v", n)
+	}
+
+	reloadA(certB)
+	checkClusterFormed(t, sA, sB)
+}
@@ -81,6 +81,8 @@ type Info struct {
 	ClientConnectURLs []string `json:"connect_urls,omitempty"`    // Contains URLs a client can connect to.
 	WSConnectURLs     []string `json:"ws_connect_urls,omitempty"` // Contains URLs a ws client can connect to.
 	LameDuckMode      bool     `json:"ldm,omitempty"`
+	Compression       string   `json:"compression,omitempty"`       // Compression mode of the route, gateway or leafnode sending the INFO.
+	CompressionStart  bool     `json:"compression_start,omitempty"` // What follows this INFO is compressed.
 
 	// Route Specific
 	Import *SubjectPermission `json:"import,omitempty"`
@@ -107,6 +109,7 @@ type Server struct {
 	kp               nkeys.KeyPair
 	prand            *rand.Rand
 	info             Info
+	cnameV           atomic.Value // Cluster name, readable without the lock.
 	configFile       string
 	optsMu           sync.RWMutex
 	opts             *Options
@@ -114,6 +117,7 @@ type Server struct {
 	shutdown         bool
 	reloading        bool
 	listener         net.Listener
+	listeners        []*clientListener
 	gacc             *Account
 	sys              *internal
 	js               *jetStream
@@ -219,6 +223,15 @@ type Server struct {
 
 	// Websocket structure
 	websocket srvWebsocket
+
+	// MQTT structure
+	mqtt srvMQTT
+
+	// Certificates of the TLS listeners that can be swapped while in use.
+	tlsCerts []*tlsCert
+
+	// Keep the OCSP responses stapled to the certificates fresh.
+	ocsps []*ocspMonitor
 }
 
 // Make sure all are 64bits for atomic use
@@ -319,6 +332,13 @@ func NewServer(opts *Options) (*Server, error) {
 	// Ensure that non-exported options (used in tests) are properly set.
 	s.setLeafNodeNonExportedOptions()
 
+	// Setup the certificates of the TLS listeners and get the OCSP
+	// responses to staple to them. This is done before the gateway
+	// is created since it clones the TLS configurations of the remotes.
+	if err := s.enableTLSCerts(); err != nil {
+		return nil, err
+	}
+
 	// Call this even if there is no gateway defined. It will
 	// initialize the structure so we don't have to check for
 	// it to be nil or not in various places in the code.
@@ -330,6 +350,7 @@ func NewServer(opts *Options) (*Server, error) {
 	if opts.Cluster.Port != 0 && opts.Cluster.Name == "" {
 		s.info.Cluster = nuid.Next()
 	}
+	s.cnameV.Store(s.info.Cluster)
 
 	// This is normally done in the AcceptLoop, once the
 	// listener has been created (possibly with random port),
@@ -432,6 +453,9 @@ func NewServer(opts *Options) (*Server, error) {
 		}
 	}
 
+	// Additional client listeners, needed to setup Authorization.
+	s.listeners = newClientListeners(opts)
+
 	// Used to setup Authorization.
 	s.configureAuthorization()
 
@@ -449,6 +473,13 @@ func (s *Server) ClusterName() string {
 	return cn
 }
 
+// cachedClusterName returns the cluster name without taking the server lock.
+// This is for hot paths, e.g. selecting the destinations of a mapping.
+func (s *Server) cachedClusterName() string {
+	cn, _ := s.cnameV.Load().(string)
+	return cn
+}
+
 // setClusterName will update the cluster name for this server.
 func (s *Server) setClusterName(name string) {
 	s.mu.Lock()
@@ -458,6 +489,7 @@ func (s *Server) setClusterName(name string) {
 		resetCh = s.sys.resetCh
 	}
 	s.info.Cluster = name
+	s.cnameV.Store(name)
 	s.routeInfo.Cluster = name
 	// Regenerate the info byte array
 	s.generateRouteInfoJSON()
@@ -537,8 +569,20 @@ func validateOptions(o *Options) error {
 	if err := validateClusterName(o); err != nil {
 		return err
 	}
-	// Finally check websocket options.
-	return validateWebsocketOptions(o)
+	// Check websocket options.
+	if err := validateWebsocketOptions(o); err != nil {
+		return err
+	}
+	// Check compression options.
+	if err := validateCompressionOptions(o); err != nil {
+		return err
+	}
+	// Check additional client listeners options.
+	if err := validateListenersOptions(o); err != nil {
+		return err
+	}
+	// Finally check MQTT options.
+	return validateMQTTOptions(o)
 }
 
 func (s *Server) getOpts() *Options {
@@ -1336,6 +1380,11 @@ func (s *Server) Start() {
 	s.grRunning = true
 	s.grMu.Unlock()
 
+	// Watch the certificate files for rotation and refresh
+	// the OCSP responses stapled to the certificates.
+	s.startGoRoutine(s.watchTLSCerts)
+	s.startOCSPMonitoring()
+
 	// Snapshot server options.
 	opts := s.getOpts()
 
@@ -1503,6 +1552,12 @@ func (s *Server) Start() {
 		s.startWebsocketServer()
 	}
 
+	// Start MQTT listener if needed. This needs to be after JetStream
+	// since MQTT sessions are stored in streams.
+	if opts.MQTT.Port != 0 {
+		s.startMQTT()
+	}
+
 	// Start up routing as well if needed.
 	if opts.Cluster.Port != 0 {
 		s.startGoRoutine(func() {
@@ -1590,6 +1645,9 @@ func (s *Server) Shutdown() {
 		s.listener = nil
 	}
 
+	// Kick the additional client listeners accept loops
+	doneExpected += s.closeClientListeners()
+
 	// Kick websocket server
 	if s.websocket.server != nil {
 		doneExpected++
@@ -1598,6 +1656,13 @@ func (s *Server) Shutdown() {
 		s.websocket.listener = nil
 	}
 
+	// Kick MQTT accept loop
+	if s.mqtt.listener != nil {
+		doneExpected++
+		s.mqtt.listener.Close()
+		s.mqtt.listener = nil
+	}
+
 	// Kick leafnodes AcceptLoop()
 	if s.leafNodeListener != nil {
 		doneExpected++
@@ -1738,9 +1803,9 @@ func (s *Server) AcceptLoop(clr chan struct{}) {
 	if opts.Port == 0 {
 		// Write resolved port back to options.
 		if isSctp {
-			opts.Cluster.Port = l.Addr().(*net.UDPAddr).Port
+			opts.Port = l.Addr().(*net.UDPAddr).Port
 		} else {
-			opts.Cluster.Port = l.Addr().(*net.TCPAddr).Port
+			opts.Port = l.Addr().(*net.TCPAddr).Port
 		}
 	}
 
@@ -1770,6 +1835,11 @@ func (s *Server) AcceptLoop(clr chan struct{}) {
 			}
 			return false
 		})
+	// Start the additional client listeners, if any.
+	if !s.startClientListeners(opts) {
+		s.mu.Unlock()
+		return
+	}
 	s.mu.Unlock()
 
 	// Let the caller know that we are ready
@@ -1915,14 +1985,19 @@ func (s *Server) StartMonitoring() error {
 
 // HTTP endpoints
 const (
-	RootPath     = "/"
-	VarzPath     = "/varz"
-	ConnzPath    = "/connz"
-	RoutezPath   = "/routez"
-	GatewayzPath = "/gatewayz"
-	LeafzPath    = "/leafz"
-	SubszPath    = "/subsz"
-	StackszPath  = "/stacksz"
+	RootPath      = "/"
+	VarzPath      = "/varz"
+	ConnzPath     = "/connz"
+	RoutezPath    = "/routez"
+	GatewayzPath  = "/gatewayz"
+	LeafzPath     = "/leafz"
+	SubszPath     = "/subsz"
+	StackszPath   = "/stacksz"
+	ConsumerzPath = "/consumerz"
+	JszPath       = "/jsz"
+	HealthzPath   = "/healthz"
+	AccountzPath  = "/accountz"
+	MetricsPath   = "/metrics"
 )
 
 func (s *Server) basePath(p string) string {
@@ -2000,6 +2075,16 @@ func (s *Server) startMonitoring(secure bool) error {
 	mux.HandleFunc(s.basePath("/subscriptionsz"), s.HandleSubsz)
 	// Stacksz
 	mux.HandleFunc(s.basePath(StackszPath), s.HandleStacksz)
+	// Consumerz
+	mux.HandleFunc(s.basePath(ConsumerzPath), s.HandleConsumerz)
+	// Jsz
+	mux.HandleFunc(s.basePath(JszPath), s.HandleJsz)
+	// Healthz
+	mux.HandleFunc(s.basePath(HealthzPath), s.HandleHealthz)
+	// Accountz
+	mux.HandleFunc(s.basePath(AccountzPath), s.HandleAccountz)
+	// Metrics
+	mux.HandleFunc(s.basePath(MetricsPath), s.HandleMetrics)
 
 	// Do not set a WriteTimeout because it could cause cURL/browser
 	// to return empty response or unable to display page if the
@@ -2077,10 +2162,24 @@ func (c *tlsMixConn) Read(b []byte) (int, error) {
 }
 
 func (s *Server) createClient(conn net.Conn, ws *websocket) *client {
+	return s.createClientFromListener(conn, ws, nil)
+}
+
+// Creates a client accepted by the given additional client listener,
+// which is nil for clients of the main or websocket listeners.
+func (s *Server) createClientFromListener(conn net.Conn, ws *websocket, cl *clientListener) *client {
 	// Snapshot server options.
 
 	opts := s.getOpts()
 
+	// TLS settings, possibly overridden by the listener.
+	tlsConfig, tlsTimeoutSecs, allowNonTLS := opts.TLSConfig, opts.TLSTimeout, opts.AllowNonTLS
+	var lo *ListenerOpts
+	if cl != nil {
+		lo = cl.opts(opts)
+		tlsConfig, tlsTimeoutSecs, allowNonTLS = lo.TLSConfig, lo.TLSTimeout, false
+	}
+
 	maxPay := int32(opts.MaxPayload)
 	maxSubs := int32(opts.MaxSubs)
 	// For system, maxSubs of 0 means unlimited, so re-adjust here.
@@ -2089,7 +2188,7 @@ func (s *Server) createClient(conn net.Conn, ws *websocket) *client {
 	}
 	now := time.Now()
 
-	c := &client{srv: s, nc: conn, opts: defaultOpts, mpay: maxPay, msubs: maxSubs, start: now, last: now, ws: ws}
+	c := &client{srv: s, nc: conn, opts: defaultOpts, mpay: maxPay, msubs: maxSubs, start: now, last: now, ws: ws, listener: cl}
 
 	c.registerWithAccount(s.globalAccount())
 
@@ -2102,6 +2201,16 @@ func (s *Server) createClient(conn net.Conn, ws *websocket) *client {
 	if ws != nil && !info.AuthRequired {
 		info.AuthRequired = s.websocket.authOverride
 	}
+	// Same for clients of an additional listener, which also has
+	// its own TLS configuration.
+	if cl != nil {
+		if !info.AuthRequired {
+			info.AuthRequired = cl.authOverride
+		}
+		info.TLSRequired = tlsConfig != nil
+		info.TLSVerify = tlsConfig != nil && tlsConfig.ClientAuth == tls.RequireAndVerifyClientCert
+		info.TLSAvailable = false
+	}
 	if s.nonceRequired() {
 		// Nonce handling
 		var raw [nonceLen]byte
@@ -2158,7 +2267,16 @@ func (s *Server) createClient(conn net.Conn, ws *websocket) *client {
 		c.maxConnExceeded()
 		return nil
 	}
+	// Same for the listener's own limit.
+	if cl != nil && lo.MaxConn > 0 && cl.numConns >= lo.MaxConn {
+		s.mu.Unlock()
+		c.maxConnExceeded()
+		return nil
+	}
 	s.clients[c.cid] = c
+	if cl != nil {
+		cl.numConns++
+	}
 	s.mu.Unlock()
 
 	// Re-Grab lock
@@ -2171,9 +2289,9 @@ func (s *Server) createClient(conn net.Conn, ws *websocket) *client {
 	var pre []byte
 	// If we have both TLS and non-TLS allowed we need to see which
 	// one the client wants.
-	if !isClosed && opts.TLSConfig != nil && opts.AllowNonTLS {
+	if !isClosed && tlsConfig != nil && allowNonTLS {
 		pre = make([]byte, 4)
-		c.nc.SetReadDeadline(time.Now().Add(secondsToDuration(opts.TLSTimeout)))
+		c.nc.SetReadDeadline(time.Now().Add(secondsToDuration(tlsTimeoutSecs)))
 		n, _ := io.ReadFull(c.nc, pre[:])
 		c.nc.SetReadDeadline(time.Time{})
 		pre = pre[:n]
@@ -2194,11 +2312,11 @@ func (s *Server) createClient(conn net.Conn, ws *websocket) *client {
 			pre = nil
 		}
 
-		c.nc = tls.Server(c.nc, opts.TLSConfig)
+		c.nc = tls.Server(c.nc, tlsConfig)
 		conn := c.nc.(*tls.Conn)
 
 		// Setup the timeout
-		ttl := secondsToDuration(opts.TLSTimeout)
+		ttl := secondsToDuration(tlsTimeoutSecs)
 		time.AfterFunc(ttl, func() { tlsTimeout(c, conn) })
 		conn.SetReadDeadline(time.Now().Add(ttl))
 
@@ -2243,6 +2361,8 @@ func (s *Server) createClient(conn net.Conn, ws *websocket) *client {
 		// if user has explicitly set or not.
 		if ws != nil && opts.Websocket.AuthTimeout != 0 {
 			timeout = opts.Websocket.AuthTimeout
+		} else if lo != nil && lo.AuthTimeout != 0 {
+			timeout = lo.AuthTimeout
 		}
 		c.setAuthTimer(secondsToDuration(timeout))
 	}
@@ -2421,6 +2541,10 @@ func (s *Server) removeClient(c *client) {
 		c.mu.Unlock()
 
 		s.mu.Lock()
+		// c.listener is immutable.
+		if _, ok := s.clients[cid]; ok && c.listener != nil {
+			c.listener.numConns--
+		}
 		delete(s.clients, cid)
 		if updateProtoInfoCount {
 			s.cproto--
@@ -2586,14 +2710,7 @@ func (s *Server) ReadyForConnections(dur time.Duration) bool {
 
 	end := time.Now().Add(dur)
 	for time.Now().Before(end) {
-		s.mu.Lock()
-		ok := s.listener != nil &&
-			(opts.Cluster.Port == 0 || s.routeListener != nil) &&
-			(opts.Gateway.Name == "" || s.gatewayListener != nil) &&
-			(opts.LeafNode.Port == 0 || s.leafNodeListener != nil) &&
-			(opts.Websocket.Port == 0 || s.websocket.listener != nil)
-		s.mu.Unlock()
-		if ok {
+		if s.listenersReady(opts) == nil {
 			return true
 		}
 		time.Sleep(25 * time.Millisecond)
@@ -2601,6 +2718,32 @@ func (s *Server) ReadyForConnections(dur time.Duration) bool {
 	return false
 }
 
+// Returns an error naming the first configured listener that is not yet accepting.
+func (s *Server) listenersReady(opts *Options) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.listener == nil:
+		return fmt.Errorf("client listener not ready")
+	case opts.Cluster.Port != 0 && s.routeListener == nil:
+		return fmt.Errorf("route listener not ready")
+	case opts.Gateway.Name != "" && s.gatewayListener == nil:
+		return fmt.Errorf("gateway listener not ready")
+	case opts.LeafNode.Port != 0 && s.leafNodeListener == nil:
+		return fmt.Errorf("leafnode listener not ready")
+	case opts.Websocket.Port != 0 && s.websocket.listener == nil:
+		return fmt.Errorf("websocket listener not ready")
+	case opts.MQTT.Port != 0 && s.mqtt.listener == nil:
+		return fmt.Errorf("mqtt listener not ready")
+	}
+	for _, cl := range s.listeners {
+		if cl.listener == nil {
+			return fmt.Errorf("client listener %q not ready", cl.name)
+		}
+	}
+	return nil
+}
+
 // Quick utility to function to tell if the server supports headers.
 func (s *Server) supportsHeaders() bool {
 	if s == nil {
@@ -2792,6 +2935,9 @@ type Ports struct {
 	Cluster    []string `json:"cluster,omitempty"`
 	Profile    []string `json:"profile,omitempty"`
 	WebSocket  []string `json:"websocket,omitempty"`
+	MQTT       []string `json:"mqtt,omitempty"`
+	// URLs of the additional client listeners, keyed by listener name.
+	Listeners map[string][]string `json:"listeners,omitempty"`
 }
 
 // PortsInfo attempts to resolve all the ports. If after maxWait the ports are not
@@ -2809,6 +2955,8 @@ func (s *Server) PortsInfo(maxWait time.Duration) *Ports {
 		profileListener := s.profiler
 		wsListener := s.websocket.listener
 		wss := s.websocket.tls
+		mqttListener := s.mqtt.listener
+		listenersURLs := s.clientListenersURLs(opts)
 		s.mu.Unlock()
 
 		ports := Ports{}
@@ -2849,6 +2997,16 @@ func (s *Server) PortsInfo(maxWait time.Duration) *Ports {
 			ports.WebSocket = formatURL(protocol, wsListener)
 		}
 
+		if mqttListener != nil {
+			protocol := "mqtt"
+			if opts.MQTT.TLSConfig != nil {
+				protocol = "mqtts"
+			}
+			ports.MQTT = formatURL(protocol, mqttListener)
+		}
+
+		ports.Listeners = listenersURLs
+
 		return &ports
 	}
 
@@ -2955,6 +3113,12 @@ func (s *Server) serviceListeners() []net.Listener {
 	if opts.Websocket.Port != 0 {
 		listeners = append(listeners, s.websocket.listener)
 	}
+	if opts.MQTT.Port != 0 {
+		listeners = append(listeners, s.mqtt.listener)
+	}
+	for _, cl := range s.listeners {
+		listeners = append(listeners, cl.listener)
+	}
 	return listeners
 }
 
@@ -2985,6 +3149,12 @@ func (s *Server) lameDuckMode() {
 		s.websocket.server = nil
 		s.websocket.listener = nil
 	}
+	if s.mqtt.listener != nil {
+		expected++
+		s.mqtt.listener.Close()
+		s.mqtt.listener = nil
+	}
+	expected += s.closeClientListeners()
 	s.ldmCh = make(chan bool, expected)
 	opts := s.getOpts()
 	gp := opts.LameDuckGracePeriod
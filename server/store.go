@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -30,6 +31,8 @@ const (
 	MemoryStorage StorageType = iota
 	// FileStorage specifies on disk, designated by the JetStream config StoreDir.
 	FileStorage
+	// TieredStorage specifies on disk like FileStorage with the most recent messages also held in memory.
+	TieredStorage
 )
 
 var (
@@ -138,6 +141,100 @@ type ConsumerState struct {
 	Redelivered map[uint64]uint64 `json:"redelivered"`
 }
 
+// StreamStoreFactory creates a StreamStore for a stream. The FileStoreConfig holds
+// the directory reserved for the stream along with any file store specific options.
+// The created time is when the stream was originally created.
+type StreamStoreFactory func(fcfg FileStoreConfig, cfg StreamConfig, created time.Time) (StreamStore, error)
+
+// A registered stream store implementation.
+type storageBackend struct {
+	name       string
+	accounting StorageType
+	factory    StreamStoreFactory
+}
+
+var (
+	backendsMu sync.RWMutex
+	// Built-in stores are always present.
+	backends = map[StorageType]*storageBackend{
+		MemoryStorage: {memoryStorageString, MemoryStorage, func(_ FileStoreConfig, cfg StreamConfig, _ time.Time) (StreamStore, error) {
+			return newMemStore(&cfg)
+		}},
+		FileStorage: {fileStorageString, FileStorage, func(fcfg FileStoreConfig, cfg StreamConfig, created time.Time) (StreamStore, error) {
+			return newFileStoreWithCreated(fcfg, cfg, created)
+		}},
+		TieredStorage: {tieredStorageString, FileStorage, func(fcfg FileStoreConfig, cfg StreamConfig, created time.Time) (StreamStore, error) {
+			return newTieredStore(fcfg, cfg, created)
+		}},
+	}
+	nextStorageType = TieredStorage + 1
+)
+
+// RegisterStorageType registers a named stream store implementation and returns the
+// StorageType that selects it in a StreamConfig. The name is what is used for the
+// storage type in JSON, e.g. "storage": "lsm". Usage counts against either the memory
+// or file storage limits as determined by accounting. Backends accounted as FileStorage
+// are expected to persist their state in the directory they are given, and their streams
+// will be recovered on restart as long as the backend is registered before the server starts.
+func RegisterStorageType(name string, accounting StorageType, factory StreamStoreFactory) (StorageType, error) {
+	if name == _EMPTY_ || strings.ContainsAny(name, " \t\"") {
+		return 0, fmt.Errorf("invalid storage type name %q", name)
+	}
+	if accounting != MemoryStorage && accounting != FileStorage {
+		return 0, fmt.Errorf("storage type accounting must be memory or file")
+	}
+	if factory == nil {
+		return 0, fmt.Errorf("storage type factory required")
+	}
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	for _, b := range backends {
+		if b.name == name {
+			return 0, fmt.Errorf("storage type %q already registered", name)
+		}
+	}
+	st := nextStorageType
+	nextStorageType++
+	backends[st] = &storageBackend{name, accounting, factory}
+	return st, nil
+}
+
+// NewStreamStore creates a new StreamStore for the config using the backend registered
+// for its storage type.
+func NewStreamStore(fcfg FileStoreConfig, cfg StreamConfig) (StreamStore, error) {
+	return newStreamStore(fcfg, cfg, time.Now().UTC())
+}
+
+func newStreamStore(fcfg FileStoreConfig, cfg StreamConfig, created time.Time) (StreamStore, error) {
+	b := lookupStorageBackend(cfg.Storage)
+	if b == nil {
+		return nil, fmt.Errorf("unknown storage type %d", cfg.Storage)
+	}
+	return b.factory(fcfg, cfg, created)
+}
+
+func lookupStorageBackend(st StorageType) *storageBackend {
+	backendsMu.RLock()
+	b := backends[st]
+	backendsMu.RUnlock()
+	return b
+}
+
+// Returns whether usage for this storage type counts against memory or file storage limits.
+func (st StorageType) accounting() StorageType {
+	if b := lookupStorageBackend(st); b != nil {
+		return b.accounting
+	}
+	return st
+}
+
+// Returns true for registered backends, other than the file store itself,
+// that persist their state. For these the stream maintains the meta data
+// needed to recover them on restart.
+func (st StorageType) needsStreamMeta() bool {
+	return st != FileStorage && st != TieredStorage && st.accounting() == FileStorage
+}
+
 // TemplateStore stores templates.
 type TemplateStore interface {
 	Store(*StreamTemplate) error
@@ -231,40 +328,33 @@ func (dp *DiscardPolicy) UnmarshalJSON(data []byte) error {
 const (
 	memoryStorageString = "memory"
 	fileStorageString   = "file"
+	tieredStorageString = "tiered"
 )
 
 func (st StorageType) String() string {
-	switch st {
-	case MemoryStorage:
-		return strings.Title(memoryStorageString)
-	case FileStorage:
-		return strings.Title(fileStorageString)
-	default:
-		return "Unknown Storage Type"
+	if b := lookupStorageBackend(st); b != nil {
+		return strings.Title(b.name)
 	}
+	return "Unknown Storage Type"
 }
 
 func (st StorageType) MarshalJSON() ([]byte, error) {
-	switch st {
-	case MemoryStorage:
-		return json.Marshal(memoryStorageString)
-	case FileStorage:
-		return json.Marshal(fileStorageString)
-	default:
-		return nil, fmt.Errorf("can not marshal %v", st)
+	if b := lookupStorageBackend(st); b != nil {
+		return json.Marshal(b.name)
 	}
+	return nil, fmt.Errorf("can not marshal %v", st)
 }
 
 func (st *StorageType) UnmarshalJSON(data []byte) error {
-	switch string(data) {
-	case jsonString(memoryStorageString):
-		*st = MemoryStorage
-	case jsonString(fileStorageString):
-		*st = FileStorage
-	default:
-		return fmt.Errorf("can not unmarshal %q", data)
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+	for t, b := range backends {
+		if string(data) == jsonString(b.name) {
+			*st = t
+			return nil
+		}
 	}
-	return nil
+	return fmt.Errorf("can not unmarshal %q", data)
 }
 
 const (
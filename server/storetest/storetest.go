@@ -0,0 +1,355 @@
+// Copyright 2020 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package storetest provides the conformance tests that every JetStream
+// stream store backend registered with server.RegisterStorageType must pass.
+//
+// A backend would typically be checked from its own tests with:
+//
+//	func TestLSMStore(t *testing.T) {
+//		storetest.TestStreamStore(t, lsmStorageType)
+//	}
+package storetest
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/yanzongzhen/nats-server/server"
+)
+
+// TestStreamStore runs the conformance tests against the backend registered for the storage type.
+func TestStreamStore(t *testing.T, st server.StorageType) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, st server.StorageType)
+	}{
+		{"StoreAndLoad", testStoreAndLoad},
+		{"Headers", testHeaders},
+		{"RemoveAndErase", testRemoveAndErase},
+		{"Purge", testPurge},
+		{"MaxMsgs", testMaxMsgs},
+		{"MaxBytes", testMaxBytes},
+		{"DiscardNew", testDiscardNew},
+		{"MaxAge", testMaxAge},
+		{"SeqFromTime", testSeqFromTime},
+		{"StorageBytesUpdate", testStorageBytesUpdate},
+		{"UpdateConfig", testUpdateConfig},
+		{"ConsumerStore", testConsumerStore},
+		{"Stop", testStop},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) { test.test(t, st) })
+	}
+}
+
+// Creates a new store for the storage type along with a function that removes it.
+func newStore(t *testing.T, cfg server.StreamConfig) (server.StreamStore, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "storetest-")
+	if err != nil {
+		t.Fatalf("Unexpected error creating directory: %v", err)
+	}
+	if cfg.Name == "" {
+		cfg.Name = "TEST"
+	}
+	store, err := server.NewStreamStore(server.FileStoreConfig{StoreDir: dir}, cfg)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("Unexpected error creating store: %v", err)
+	}
+	return store, func() {
+		store.Stop()
+		os.RemoveAll(dir)
+	}
+}
+
+func storeMsgs(t *testing.T, store server.StreamStore, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if _, _, err := store.StoreMsg("foo", nil, []byte("Hello World")); err != nil {
+			t.Fatalf("Unexpected error storing msg: %v", err)
+		}
+	}
+}
+
+// Helper to wait for a condition.
+func checkFor(t *testing.T, totalWait, sleepDur time.Duration, f func() error) {
+	t.Helper()
+	timeout := time.Now().Add(totalWait)
+	var err error
+	for time.Now().Before(timeout) {
+		if err = f(); err == nil {
+			return
+		}
+		time.Sleep(sleepDur)
+	}
+	t.Fatal(err.Error())
+}
+
+func testStoreAndLoad(t *testing.T, st server.StorageType) {
+	store, cleanup := newStore(t, server.StreamConfig{Storage: st})
+	defer cleanup()
+
+	subj, msg := "foo", []byte("Hello World")
+	var lts int64
+	for i := 1; i <= 5; i++ {
+		seq, ts, err := store.StoreMsg(subj, nil, msg)
+		if err != nil {
+			t.Fatalf("Unexpected error storing msg: %v", err)
+		}
+		if seq != uint64(i) {
+			t.Fatalf("Expected sequence to be %d, got %d", i, seq)
+		}
+		if ts < lts {
+			t.Fatalf("Expected timestamps to not go backwards")
+		}
+		lts = ts
+	}
+	state := store.State()
+	if state.Msgs != 5 || state.FirstSeq != 1 || state.LastSeq != 5 || state.Bytes == 0 {
+		t.Fatalf("Unexpected state: %+v", state)
+	}
+	if state.LastTime.UnixNano() != lts {
+		t.Fatalf("Expected last time to match last timestamp")
+	}
+	nsubj, _, nmsg, ts, err := store.LoadMsg(2)
+	if err != nil {
+		t.Fatalf("Unexpected error looking up msg: %v", err)
+	}
+	if nsubj != subj || !bytes.Equal(nmsg, msg) || ts == 0 {
+		t.Fatalf("Loaded msg does not match: %q %q", nsubj, nmsg)
+	}
+	if _, _, _, _, err := store.LoadMsg(6); err != server.ErrStoreEOF {
+		t.Fatalf("Expected %v, got %v", server.ErrStoreEOF, err)
+	}
+}
+
+func testHeaders(t *testing.T, st server.StorageType) {
+	store, cleanup := newStore(t, server.StreamConfig{Storage: st})
+	defer cleanup()
+
+	hdr, msg := []byte("name:derek"), []byte("Hello World")
+	if _, _, err := store.StoreMsg("foo", hdr, msg); err != nil {
+		t.Fatalf("Unexpected error storing msg: %v", err)
+	}
+	_, nhdr, nmsg, _, err := store.LoadMsg(1)
+	if err != nil {
+		t.Fatalf("Unexpected error looking up msg: %v", err)
+	}
+	if !bytes.Equal(nhdr, hdr) || !bytes.Equal(nmsg, msg) {
+		t.Fatalf("Loaded msg does not match: %q %q", nhdr, nmsg)
+	}
+}
+
+func testRemoveAndErase(t *testing.T, st server.StorageType) {
+	store, cleanup := newStore(t, server.StreamConfig{Storage: st})
+	defer cleanup()
+	storeMsgs(t, store, 5)
+
+	if removed, err := store.RemoveMsg(1); !removed || err != nil {
+		t.Fatalf("Expected remove to succeed, got %v", err)
+	}
+	if state := store.State(); state.Msgs != 4 || state.FirstSeq != 2 {
+		t.Fatalf("Unexpected state after removing first msg: %+v", state)
+	}
+	if removed, _ := store.RemoveMsg(3); !removed {
+		t.Fatalf("Expected remove to succeed")
+	}
+	if removed, _ := store.RemoveMsg(3); removed {
+		t.Fatalf("Expected remove of a removed msg to return false")
+	}
+	if _, _, _, _, err := store.LoadMsg(3); err == nil {
+		t.Fatalf("Expected an error loading a removed msg")
+	}
+	if removed, _ := store.EraseMsg(4); !removed {
+		t.Fatalf("Expected erase to succeed")
+	}
+	if _, _, _, _, err := store.LoadMsg(4); err == nil {
+		t.Fatalf("Expected an error loading an erased msg")
+	}
+	if state := store.State(); state.Msgs != 2 || state.FirstSeq != 2 || state.LastSeq != 5 {
+		t.Fatalf("Unexpected state: %+v", state)
+	}
+}
+
+func testPurge(t *testing.T, st server.StorageType) {
+	store, cleanup := newStore(t, server.StreamConfig{Storage: st})
+	defer cleanup()
+	storeMsgs(t, store, 10)
+
+	if purged := store.Purge(); purged != 10 {
+		t.Fatalf("Expected 10 msgs purged, got %d", purged)
+	}
+	state := store.State()
+	if state.Msgs != 0 || state.Bytes != 0 || state.FirstSeq != state.LastSeq+1 {
+		t.Fatalf("Unexpected state after purge: %+v", state)
+	}
+	if seq, _, err := store.StoreMsg("foo", nil, []byte("ok")); err != nil || seq != 11 {
+		t.Fatalf("Expected next sequence to be 11, got %d, %v", seq, err)
+	}
+}
+
+func testMaxMsgs(t *testing.T, st server.StorageType) {
+	store, cleanup := newStore(t, server.StreamConfig{Storage: st, MaxMsgs: 10})
+	defer cleanup()
+	storeMsgs(t, store, 20)
+
+	if state := store.State(); state.Msgs != 10 || state.FirstSeq != 11 || state.LastSeq != 20 {
+		t.Fatalf("Unexpected state: %+v", state)
+	}
+}
+
+func testMaxBytes(t *testing.T, st server.StorageType) {
+	maxBytes := int64(1024)
+	store, cleanup := newStore(t, server.StreamConfig{Storage: st, MaxBytes: maxBytes})
+	defer cleanup()
+	storeMsgs(t, store, 100)
+
+	state := store.State()
+	if state.Bytes > uint64(maxBytes) {
+		t.Fatalf("Expected bytes to be at most %d, got %d", maxBytes, state.Bytes)
+	}
+	if state.Msgs == 0 || state.Msgs >= 100 || state.LastSeq != 100 {
+		t.Fatalf("Unexpected state: %+v", state)
+	}
+}
+
+func testDiscardNew(t *testing.T, st server.StorageType) {
+	store, cleanup := newStore(t, server.StreamConfig{Storage: st, MaxMsgs: 5, Discard: server.DiscardNew})
+	defer cleanup()
+	storeMsgs(t, store, 5)
+
+	if _, _, err := store.StoreMsg("foo", nil, []byte("Hello World")); err != server.ErrMaxMsgs {
+		t.Fatalf("Expected %v, got %v", server.ErrMaxMsgs, err)
+	}
+	if state := store.State(); state.Msgs != 5 || state.LastSeq != 5 {
+		t.Fatalf("Unexpected state: %+v", state)
+	}
+}
+
+func testMaxAge(t *testing.T, st server.StorageType) {
+	store, cleanup := newStore(t, server.StreamConfig{Storage: st, MaxAge: 100 * time.Millisecond})
+	defer cleanup()
+	storeMsgs(t, store, 10)
+
+	checkFor(t, 2*time.Second, 50*time.Millisecond, func() error {
+		if state := store.State(); state.Msgs != 0 {
+			return fmt.Errorf("Expected all msgs to expire, got %d", state.Msgs)
+		}
+		return nil
+	})
+}
+
+func testSeqFromTime(t *testing.T, st server.StorageType) {
+	store, cleanup := newStore(t, server.StreamConfig{Storage: st})
+	defer cleanup()
+	storeMsgs(t, store, 5)
+	time.Sleep(10 * time.Millisecond)
+	start := time.Now()
+	storeMsgs(t, store, 5)
+
+	if seq := store.GetSeqFromTime(start); seq != 6 {
+		t.Fatalf("Expected sequence 6, got %d", seq)
+	}
+	if seq := store.GetSeqFromTime(time.Now().Add(time.Hour)); seq != 11 {
+		t.Fatalf("Expected sequence 11 for a time in the future, got %d", seq)
+	}
+}
+
+func testStorageBytesUpdate(t *testing.T, st server.StorageType) {
+	store, cleanup := newStore(t, server.StreamConfig{Storage: st})
+	defer cleanup()
+
+	var total int64
+	store.StorageBytesUpdate(func(delta int64) { total += delta })
+	storeMsgs(t, store, 10)
+	store.RemoveMsg(5)
+
+	if state := store.State(); total != int64(state.Bytes) {
+		t.Fatalf("Expected updates to total %d, got %d", state.Bytes, total)
+	}
+	store.Purge()
+	if total != 0 {
+		t.Fatalf("Expected updates to total 0 after purge, got %d", total)
+	}
+}
+
+func testUpdateConfig(t *testing.T, st server.StorageType) {
+	cfg := server.StreamConfig{Name: "TEST", Storage: st}
+	store, cleanup := newStore(t, cfg)
+	defer cleanup()
+	storeMsgs(t, store, 20)
+
+	cfg.MaxMsgs = 5
+	if err := store.UpdateConfig(&cfg); err != nil {
+		t.Fatalf("Unexpected error updating config: %v", err)
+	}
+	if state := store.State(); state.Msgs != 5 || state.FirstSeq != 16 {
+		t.Fatalf("Expected limits to be enforced on update, got %+v", state)
+	}
+}
+
+func testConsumerStore(t *testing.T, st server.StorageType) {
+	store, cleanup := newStore(t, server.StreamConfig{Storage: st})
+	defer cleanup()
+	storeMsgs(t, store, 10)
+
+	cs, err := store.ConsumerStore("dlc", &server.ConsumerConfig{Durable: "dlc", AckPolicy: server.AckExplicit})
+	if err != nil {
+		t.Fatalf("Unexpected error creating consumer store: %v", err)
+	}
+	if n := store.State().Consumers; n != 1 {
+		t.Fatalf("Expected 1 consumer, got %d", n)
+	}
+	now := time.Now().Round(time.Second).UnixNano()
+	state := &server.ConsumerState{
+		Delivered:   server.SequencePair{ConsumerSeq: 5, StreamSeq: 5},
+		AckFloor:    server.SequencePair{ConsumerSeq: 2, StreamSeq: 2},
+		Pending:     map[uint64]int64{4: now, 5: now},
+		Redelivered: map[uint64]uint64{4: 2},
+	}
+	if err := cs.Update(state); err != nil {
+		t.Fatalf("Unexpected error updating consumer state: %v", err)
+	}
+	// Stores that do not persist consumer state may return nil.
+	if nstate, err := cs.State(); err != nil {
+		t.Fatalf("Unexpected error reading consumer state: %v", err)
+	} else if nstate != nil && !reflect.DeepEqual(state, nstate) {
+		t.Fatalf("Consumer state does not match: %+v vs %+v", state, nstate)
+	}
+	if err := cs.Stop(); err != nil {
+		t.Fatalf("Unexpected error stopping consumer store: %v", err)
+	}
+	if n := store.State().Consumers; n != 0 {
+		t.Fatalf("Expected no consumers after stop, got %d", n)
+	}
+}
+
+func testStop(t *testing.T, st server.StorageType) {
+	store, cleanup := newStore(t, server.StreamConfig{Storage: st})
+	defer cleanup()
+	storeMsgs(t, store, 5)
+
+	if err := store.Stop(); err != nil {
+		t.Fatalf("Unexpected error stopping store: %v", err)
+	}
+	if _, _, err := store.StoreMsg("foo", nil, []byte("Hello World")); err != server.ErrStoreClosed {
+		t.Fatalf("Expected %v, got %v", server.ErrStoreClosed, err)
+	}
+}
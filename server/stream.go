@@ -17,6 +17,7 @@ import (
 	"archive/tar"
 	"bytes"
 	"compress/gzip"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -30,6 +31,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/minio/highwayhash"
 	"github.com/nats-io/nuid"
 )
 
@@ -50,6 +52,8 @@ type StreamConfig struct {
 	NoAck        bool            `json:"no_ack,omitempty"`
 	Template     string          `json:"template_owner,omitempty"`
 	Duplicates   time.Duration   `json:"duplicate_window,omitempty"`
+	MaxHeadMsgs  int64           `json:"max_head_msgs,omitempty"`
+	MaxHeadBytes int64           `json:"max_head_bytes,omitempty"`
 }
 
 // PubAck is the detail you get back from a publish to a stream that was successful.
@@ -344,6 +348,9 @@ func checkStreamCfg(config *StreamConfig) (StreamConfig, error) {
 	cfg := *config
 
 	// TODO(dlc) - check config for conflicts, e.g replicas > 1 in single server mode.
+	if lookupStorageBackend(cfg.Storage) == nil {
+		return StreamConfig{}, fmt.Errorf("stream storage type is unknown")
+	}
 	if cfg.Replicas == 0 {
 		cfg.Replicas = 1
 	}
@@ -366,6 +373,15 @@ func checkStreamCfg(config *StreamConfig) (StreamConfig, error) {
 	if cfg.MaxConsumers == 0 {
 		cfg.MaxConsumers = -1
 	}
+	// The memory head only applies to tiered storage.
+	if cfg.MaxHeadMsgs != 0 || cfg.MaxHeadBytes != 0 {
+		if cfg.Storage != TieredStorage {
+			return StreamConfig{}, fmt.Errorf("memory head limits require tiered storage")
+		}
+		if cfg.MaxHeadMsgs < 0 || cfg.MaxHeadBytes < 0 {
+			return StreamConfig{}, fmt.Errorf("memory head limits can not be negative")
+		}
+	}
 	if cfg.Duplicates == 0 {
 		if cfg.MaxAge != 0 && cfg.MaxAge < StreamDefaultDuplicatesWindow {
 			cfg.Duplicates = cfg.MaxAge
@@ -465,7 +481,13 @@ func (mset *Stream) Update(config *StreamConfig) error {
 		return fmt.Errorf("stream configuration maximum consumers exceeds account limit")
 	}
 	if cfg.MaxBytes > 0 && cfg.MaxBytes > o_cfg.MaxBytes {
-		if err := jsa.checkBytesLimits(cfg.MaxBytes*int64(cfg.Replicas), cfg.Storage); err != nil {
+		if err := jsa.checkBytesLimits(cfg.MaxBytes*int64(cfg.Replicas), cfg.Storage.accounting()); err != nil {
+			jsa.mu.Unlock()
+			return err
+		}
+	}
+	if cfg.MaxHeadBytes > 0 && cfg.MaxHeadBytes > o_cfg.MaxHeadBytes {
+		if err := jsa.checkBytesLimits(cfg.MaxHeadBytes*int64(cfg.Replicas), MemoryStorage); err != nil {
 			jsa.mu.Unlock()
 			return err
 		}
@@ -506,6 +528,11 @@ func (mset *Stream) Update(config *StreamConfig) error {
 	// Now update config and store's version of our config.
 	mset.config = cfg
 	mset.store.UpdateConfig(&cfg)
+	if cfg.Storage.needsStreamMeta() {
+		if err := mset.writeStreamMeta(); err != nil {
+			return err
+		}
+	}
 
 	mset.sendUpdateAdvisoryLocked()
 
@@ -636,25 +663,54 @@ func (mset *Stream) setupStore(fsCfg *FileStoreConfig) error {
 
 	mset.created = time.Now().UTC()
 
-	switch mset.config.Storage {
-	case MemoryStorage:
-		ms, err := newMemStore(&mset.config)
-		if err != nil {
-			return err
-		}
-		mset.store = ms
-	case FileStorage:
-		fs, err := newFileStoreWithCreated(*fsCfg, mset.config, mset.created)
-		if err != nil {
-			return err
+	store, err := newStreamStore(*fsCfg, mset.config, mset.created)
+	if err != nil {
+		return err
+	}
+	mset.store = store
+
+	// Write our meta data iff does not exist for other persistent stores.
+	if mset.config.Storage.needsStreamMeta() {
+		meta := path.Join(fsCfg.StoreDir, JetStreamMetaFile)
+		if _, err := os.Stat(meta); err != nil && os.IsNotExist(err) {
+			if err := mset.writeStreamMeta(); err != nil {
+				return err
+			}
 		}
-		mset.store = fs
 	}
-	jsa, st := mset.jsa, mset.config.Storage
+	jsa, st := mset.jsa, mset.config.Storage.accounting()
 	mset.store.StorageBytesUpdate(func(delta int64) { jsa.updateUsage(st, delta) })
+	// The memory head of tiered storage is charged against the memory of the account.
+	if ts, ok := mset.store.(*tieredStore); ok {
+		jsa.mu.RLock()
+		maxMem := jsa.limits.MaxMemory
+		jsa.mu.RUnlock()
+		ts.capHead(maxMem)
+		ts.HeadBytesUpdate(func(delta int64) { jsa.updateUsage(MemoryStorage, delta) })
+	}
 	return nil
 }
 
+// Returns the directory reserved for our store.
+func (mset *Stream) storeDir() string {
+	return path.Join(mset.jsa.storeDir, streamsDir, mset.config.Name)
+}
+
+// Write out the meta data for stores other than the file store so they can be recovered.
+// Lock should be held.
+func (mset *Stream) writeStreamMeta() error {
+	sdir := mset.storeDir()
+	if err := os.MkdirAll(sdir, 0755); err != nil {
+		return fmt.Errorf("could not create storage directory - %v", err)
+	}
+	key := sha256.Sum256([]byte(mset.config.Name))
+	hh, err := highwayhash.New64(key[:])
+	if err != nil {
+		return fmt.Errorf("could not create hash: %v", err)
+	}
+	return writeStreamMetaFile(sdir, &FileStreamInfo{Created: mset.created, StreamConfig: mset.config}, hh)
+}
+
 // NumMsgIds returns the number of message ids being tracked for duplicate suppression.
 func (mset *Stream) NumMsgIds() int {
 	mset.mu.RLock()
@@ -739,6 +795,26 @@ func getHdrVal(key string, hdr []byte) []byte {
 	return value
 }
 
+// Header line that starts a header block.
+const hdrLine = "NATS/1.0\r\n"
+
+// Will return a copy of hdr with the header denoted by key and value added,
+// creating the header block if hdr is empty.
+func genHeader(hdr []byte, key, value string) []byte {
+	var bb bytes.Buffer
+	if len(hdr) > LEN_CR_LF {
+		bb.Write(hdr[:len(hdr)-LEN_CR_LF])
+	} else {
+		bb.WriteString(hdrLine)
+	}
+	bb.WriteString(key)
+	bb.WriteString(": ")
+	bb.WriteString(value)
+	bb.WriteString(_CRLF_)
+	bb.WriteString(_CRLF_)
+	return bb.Bytes()
+}
+
 // Fast lookup of msgId.
 func getMsgId(hdr []byte) string {
 	return string(getHdrVal(JSPubId, hdr))
@@ -756,7 +832,7 @@ func (mset *Stream) processInboundJetStreamMsg(_ *subscription, pc *client, subj
 	doAck := !mset.config.NoAck
 	pubAck := mset.pubAck
 	jsa := mset.jsa
-	stype := mset.config.Storage
+	stype := mset.config.Storage.accounting()
 	name := mset.config.Name
 	maxMsgSize := int(mset.config.MaxMsgSize)
 	numConsumers := len(mset.consumers)
@@ -1016,6 +1092,10 @@ func (mset *Stream) stop(delete bool) error {
 		if err := mset.store.Delete(); err != nil {
 			return err
 		}
+		// Cleanup what we maintained for other persistent stores.
+		if mset.config.Storage.needsStreamMeta() {
+			os.RemoveAll(mset.storeDir())
+		}
 	} else if err := mset.store.Stop(); err != nil {
 		return err
 	}
@@ -1295,6 +1375,9 @@ func (a *Account) RestoreStream(stream string, r io.Reader) (*Stream, error) {
 			mset.Delete()
 			return nil, fmt.Errorf("error restoring consumer [%q]: %v", ofi.Name(), err)
 		}
+		if cfg.Paused {
+			obs.restorePause(cfg.PauseUntil)
+		}
 	}
 	return mset, nil
 }
@@ -0,0 +1,290 @@
+// Copyright 2020 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Default size of the memory head for tiered storage when no limits are configured.
+const defaultTieredHeadMaxBytes = 32 * 1024 * 1024
+
+// tieredStore keeps the most recent messages of a stream in memory while
+// all messages are written through to a fileStore. Reads for messages in
+// the head never touch the file store, older messages are served from
+// the file store blocks. The file store is the source of truth so on
+// restart the stream is recovered from disk with an empty head.
+// The head is memory and is reported as such through HeadBytesUpdate.
+type tieredStore struct {
+	mu     sync.RWMutex
+	fs     *fileStore
+	head   map[uint64]*storedMsg
+	hfirst uint64
+	hbytes uint64
+	hmax   int64
+	hmaxb  int64
+	hcap   int64
+	hcb    func(int64)
+}
+
+func newTieredStore(fcfg FileStoreConfig, cfg StreamConfig, created time.Time) (*tieredStore, error) {
+	if cfg.Storage != TieredStorage {
+		return nil, fmt.Errorf("tieredStore requires tiered storage type in config")
+	}
+	fs, err := newFileStoreWithCreated(fcfg, cfg, created)
+	if err != nil {
+		return nil, err
+	}
+	ts := &tieredStore{fs: fs, head: make(map[uint64]*storedMsg)}
+	ts.setHeadLimits(&cfg)
+	return ts, nil
+}
+
+// Lock should be held.
+func (ts *tieredStore) setHeadLimits(cfg *StreamConfig) {
+	ts.hmax, ts.hmaxb = cfg.MaxHeadMsgs, cfg.MaxHeadBytes
+}
+
+// Returns the effective bytes limit for the head.
+// Lock should be held.
+func (ts *tieredStore) headMaxBytes() int64 {
+	maxb := ts.hmaxb
+	if ts.hmax <= 0 && maxb <= 0 {
+		maxb = defaultTieredHeadMaxBytes
+	}
+	if ts.hcap > 0 && (maxb <= 0 || maxb > ts.hcap) {
+		maxb = ts.hcap
+	}
+	return maxb
+}
+
+// capHead bounds the bytes held in the head regardless of the configured limits.
+// This is used to keep the head within the memory limits of the account.
+func (ts *tieredStore) capHead(maxBytes int64) {
+	ts.mu.Lock()
+	start := ts.hbytes
+	ts.hcap = maxBytes
+	ts.enforceHeadLimits()
+	cb, delta := ts.hcb, int64(ts.hbytes)-int64(start)
+	ts.mu.Unlock()
+
+	if cb != nil && delta != 0 {
+		cb(delta)
+	}
+}
+
+func (ts *tieredStore) UpdateConfig(cfg *StreamConfig) error {
+	if cfg.Storage != TieredStorage {
+		return fmt.Errorf("tieredStore requires tiered storage type in config")
+	}
+	if err := ts.fs.UpdateConfig(cfg); err != nil {
+		return err
+	}
+	ts.mu.Lock()
+	start := ts.hbytes
+	ts.setHeadLimits(cfg)
+	ts.enforceHeadLimits()
+	cb, delta := ts.hcb, int64(ts.hbytes)-int64(start)
+	ts.mu.Unlock()
+
+	if cb != nil && delta != 0 {
+		cb(delta)
+	}
+	return nil
+}
+
+// StoreMsg writes the message through to the file store and keeps it in the head.
+func (ts *tieredStore) StoreMsg(subj string, hdr, msg []byte) (uint64, int64, error) {
+	ts.mu.Lock()
+	seq, tstamp, err := ts.fs.StoreMsg(subj, hdr, msg)
+	if err != nil {
+		ts.mu.Unlock()
+		return seq, tstamp, err
+	}
+	start := ts.hbytes
+	if len(msg) > 0 {
+		msg = append(msg[:0:0], msg...)
+	}
+	if len(hdr) > 0 {
+		hdr = append(hdr[:0:0], hdr...)
+	}
+	if len(ts.head) == 0 {
+		ts.hfirst = seq
+	}
+	ts.head[seq] = &storedMsg{subj, hdr, msg, seq, tstamp}
+	ts.hbytes += memStoreMsgSize(subj, hdr, msg)
+	ts.enforceHeadLimits()
+	cb, delta := ts.hcb, int64(ts.hbytes)-int64(start)
+	ts.mu.Unlock()
+
+	if cb != nil && delta != 0 {
+		cb(delta)
+	}
+	return seq, tstamp, nil
+}
+
+// Age out the oldest messages in the head until we are within our limits.
+// Lock should be held.
+func (ts *tieredStore) enforceHeadLimits() {
+	maxb := ts.headMaxBytes()
+	for len(ts.head) > 0 {
+		if (ts.hmax <= 0 || int64(len(ts.head)) <= ts.hmax) && (maxb <= 0 || int64(ts.hbytes) <= maxb) {
+			return
+		}
+		ts.removeFromHead(ts.hfirst)
+	}
+}
+
+// Drop everything in the head below seq.
+// Lock should be held.
+func (ts *tieredStore) trimHead(seq uint64) {
+	for len(ts.head) > 0 && ts.hfirst < seq {
+		ts.removeFromHead(ts.hfirst)
+	}
+}
+
+// Lock should be held.
+func (ts *tieredStore) removeFromHead(seq uint64) {
+	sm, ok := ts.head[seq]
+	if !ok {
+		return
+	}
+	delete(ts.head, seq)
+	ts.hbytes -= memStoreMsgSize(sm.subj, sm.hdr, sm.msg)
+	if seq != ts.hfirst {
+		return
+	}
+	if len(ts.head) == 0 {
+		ts.hfirst = 0
+		return
+	}
+	for ts.hfirst++; ts.head[ts.hfirst] == nil; ts.hfirst++ {
+	}
+}
+
+// LoadMsg serves the message from the head when present, otherwise from the file store.
+func (ts *tieredStore) LoadMsg(seq uint64) (string, []byte, []byte, int64, error) {
+	ts.mu.RLock()
+	sm := ts.head[seq]
+	// The file store may have removed it due to limits or age.
+	if sm != nil && seq < ts.fs.firstSeq() {
+		sm = nil
+	}
+	ts.mu.RUnlock()
+
+	if sm != nil {
+		return sm.subj, sm.hdr, sm.msg, sm.ts, nil
+	}
+	return ts.fs.LoadMsg(seq)
+}
+
+func (ts *tieredStore) RemoveMsg(seq uint64) (bool, error) {
+	return ts.removeMsg(seq, false)
+}
+
+func (ts *tieredStore) EraseMsg(seq uint64) (bool, error) {
+	return ts.removeMsg(seq, true)
+}
+
+func (ts *tieredStore) removeMsg(seq uint64, secure bool) (bool, error) {
+	ts.mu.Lock()
+	var removed bool
+	var err error
+	if secure {
+		removed, err = ts.fs.EraseMsg(seq)
+	} else {
+		removed, err = ts.fs.RemoveMsg(seq)
+	}
+	start := ts.hbytes
+	if err == nil {
+		ts.removeFromHead(seq)
+		ts.trimHead(ts.fs.firstSeq())
+	}
+	cb, delta := ts.hcb, int64(ts.hbytes)-int64(start)
+	ts.mu.Unlock()
+
+	if cb != nil && delta != 0 {
+		cb(delta)
+	}
+	return removed, err
+}
+
+func (ts *tieredStore) Purge() uint64 {
+	ts.mu.Lock()
+	purged := ts.fs.Purge()
+	ts.mu.Unlock()
+	ts.dropHead()
+	return purged
+}
+
+func (ts *tieredStore) GetSeqFromTime(t time.Time) uint64 {
+	return ts.fs.GetSeqFromTime(t)
+}
+
+// State returns the state of the stream as held by the file store.
+func (ts *tieredStore) State() StreamState {
+	return ts.fs.State()
+}
+
+// HeadState returns the number of messages and bytes currently held in the memory head.
+func (ts *tieredStore) HeadState() (msgs, bytes uint64) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return uint64(len(ts.head)), ts.hbytes
+}
+
+// StorageBytesUpdate registers for updates to the bytes used on disk.
+func (ts *tieredStore) StorageBytesUpdate(cb func(int64)) {
+	ts.fs.StorageBytesUpdate(cb)
+}
+
+// HeadBytesUpdate registers for updates to the bytes held in the memory head.
+func (ts *tieredStore) HeadBytesUpdate(cb func(int64)) {
+	ts.mu.Lock()
+	ts.hcb = cb
+	ts.mu.Unlock()
+}
+
+func (ts *tieredStore) Delete() error {
+	ts.dropHead()
+	return ts.fs.Delete()
+}
+
+func (ts *tieredStore) Stop() error {
+	ts.dropHead()
+	return ts.fs.Stop()
+}
+
+func (ts *tieredStore) dropHead() {
+	ts.mu.Lock()
+	cb, bytes := ts.hcb, int64(ts.hbytes)
+	ts.head = make(map[uint64]*storedMsg)
+	ts.hfirst, ts.hbytes = 0, 0
+	ts.mu.Unlock()
+
+	if cb != nil && bytes > 0 {
+		cb(-bytes)
+	}
+}
+
+// ConsumerStore consumers are always stored with the file store.
+func (ts *tieredStore) ConsumerStore(name string, cfg *ConsumerConfig) (ConsumerStore, error) {
+	return ts.fs.ConsumerStore(name, cfg)
+}
+
+func (ts *tieredStore) Snapshot(deadline time.Duration, includeConsumers, checkMsgs bool) (*SnapshotResult, error) {
+	return ts.fs.Snapshot(deadline, includeConsumers, checkMsgs)
+}
@@ -0,0 +1,172 @@
+// Copyright 2020 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestTieredStoreHeadAndTail(t *testing.T) {
+	storeDir, _ := ioutil.TempDir("", JetStreamStoreDir)
+	os.MkdirAll(storeDir, 0755)
+	defer os.RemoveAll(storeDir)
+
+	cfg := StreamConfig{Name: "zzz", Storage: TieredStorage, MaxHeadMsgs: 10}
+	ts, err := newTieredStore(FileStoreConfig{StoreDir: storeDir}, cfg, time.Now())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer ts.Stop()
+
+	toStore := 100
+	for i := 1; i <= toStore; i++ {
+		if _, _, err := ts.StoreMsg("foo", nil, []byte(fmt.Sprintf("MSG-%d", i))); err != nil {
+			t.Fatalf("Error storing msg: %v", err)
+		}
+	}
+	if state := ts.State(); state.Msgs != uint64(toStore) {
+		t.Fatalf("Expected %d msgs, got %d", toStore, state.Msgs)
+	}
+	if msgs, _ := ts.HeadState(); msgs != 10 {
+		t.Fatalf("Expected 10 msgs in the head, got %d", msgs)
+	}
+	if ts.head[91] == nil || ts.head[90] != nil {
+		t.Fatalf("Expected the head to hold the most recent messages")
+	}
+	// All messages should be served regardless of where they live.
+	for _, seq := range []uint64{1, 50, 90, 91, 100} {
+		_, _, msg, _, err := ts.LoadMsg(seq)
+		if err != nil {
+			t.Fatalf("Unexpected error looking up msg %d: %v", seq, err)
+		}
+		if expected := []byte(fmt.Sprintf("MSG-%d", seq)); !bytes.Equal(msg, expected) {
+			t.Fatalf("Msgs don't match, original %q vs %q", expected, msg)
+		}
+	}
+
+	// Removing from the head should be reflected in both tiers.
+	if removed, _ := ts.RemoveMsg(95); !removed {
+		t.Fatalf("Expected the message to be removed")
+	}
+	if _, _, _, _, err := ts.LoadMsg(95); err == nil {
+		t.Fatalf("Expected an error for a removed msg")
+	}
+	if msgs, _ := ts.HeadState(); msgs != 9 {
+		t.Fatalf("Expected 9 msgs in the head, got %d", msgs)
+	}
+
+	// Limits enforced by the file store should drop messages from the head as well.
+	cfg.MaxMsgs = 5
+	if err := ts.UpdateConfig(&cfg); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, _, _, _, err := ts.LoadMsg(91); err == nil {
+		t.Fatalf("Expected an error for a msg removed by limits")
+	}
+	if _, _, _, _, err := ts.LoadMsg(96); err != nil {
+		t.Fatalf("Unexpected error looking up msg: %v", err)
+	}
+
+	ts.Purge()
+	if msgs, bytes := ts.HeadState(); msgs != 0 || bytes != 0 {
+		t.Fatalf("Expected the head to be empty after purge, got %d msgs and %d bytes", msgs, bytes)
+	}
+}
+
+func TestTieredStoreHeadBytesLimit(t *testing.T) {
+	storeDir, _ := ioutil.TempDir("", JetStreamStoreDir)
+	os.MkdirAll(storeDir, 0755)
+	defer os.RemoveAll(storeDir)
+
+	subj, msg := "foo", make([]byte, 100)
+	msz := memStoreMsgSize(subj, nil, msg)
+
+	cfg := StreamConfig{Name: "zzz", Storage: TieredStorage, MaxHeadBytes: int64(msz * 5)}
+	ts, err := newTieredStore(FileStoreConfig{StoreDir: storeDir}, cfg, time.Now())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer ts.Stop()
+
+	var used int64
+	ts.HeadBytesUpdate(func(delta int64) { used += delta })
+
+	for i := 0; i < 20; i++ {
+		ts.StoreMsg(subj, nil, msg)
+	}
+	if msgs, bytes := ts.HeadState(); msgs != 5 || bytes != msz*5 {
+		t.Fatalf("Expected 5 msgs and %d bytes in the head, got %d and %d", msz*5, msgs, bytes)
+	}
+	if used != int64(msz*5) {
+		t.Fatalf("Expected %d bytes to be reported, got %d", msz*5, used)
+	}
+
+	// A cap below the configured limit should shrink the head.
+	ts.capHead(int64(msz * 2))
+	if msgs, bytes := ts.HeadState(); msgs != 2 || bytes != msz*2 {
+		t.Fatalf("Expected 2 msgs and %d bytes in the head, got %d and %d", msz*2, msgs, bytes)
+	}
+	if used != int64(msz*2) {
+		t.Fatalf("Expected %d bytes to be reported, got %d", msz*2, used)
+	}
+
+	ts.RemoveMsg(20)
+	if used != int64(msz) {
+		t.Fatalf("Expected %d bytes to be reported, got %d", msz, used)
+	}
+	ts.Purge()
+	if used != 0 {
+		t.Fatalf("Expected no bytes to be reported, got %d", used)
+	}
+}
+
+func TestTieredStoreRecovery(t *testing.T) {
+	storeDir, _ := ioutil.TempDir("", JetStreamStoreDir)
+	os.MkdirAll(storeDir, 0755)
+	defer os.RemoveAll(storeDir)
+
+	cfg := StreamConfig{Name: "zzz", Storage: TieredStorage, MaxHeadMsgs: 2}
+	ts, err := newTieredStore(FileStoreConfig{StoreDir: storeDir}, cfg, time.Now())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		ts.StoreMsg("foo", nil, []byte("Hello World"))
+	}
+	state := ts.State()
+	ts.Stop()
+
+	ts, err = newTieredStore(FileStoreConfig{StoreDir: storeDir}, cfg, time.Now())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer ts.Stop()
+
+	if nstate := ts.State(); nstate != state {
+		t.Fatalf("Expected state of %+v, got %+v", state, nstate)
+	}
+	if _, _, _, _, err := ts.LoadMsg(10); err != nil {
+		t.Fatalf("Unexpected error looking up msg: %v", err)
+	}
+	// New messages should land in the head again.
+	ts.StoreMsg("foo", nil, []byte("Hello World"))
+	if msgs, _ := ts.HeadState(); msgs != 1 {
+		t.Fatalf("Expected 1 msg in the head, got %d", msgs)
+	}
+}
@@ -0,0 +1,352 @@
+// Copyright 2020 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Interval at which the certificate and key files of
+// the TLS listeners are checked for changes.
+var tlsCertCheckInterval = 30 * time.Second
+
+// tlsCert serves the certificate of one of the TLS listeners of the server
+// through GetConfigForClient and GetClientCertificate, so that it can be
+// swapped while the configuration is in use: when its files are rotated or
+// to staple an OCSP response.
+type tlsCert struct {
+	mu       sync.RWMutex
+	kind     string
+	certFile string
+	keyFile  string
+	modTime  time.Time
+	failed   time.Time
+	lastErr  string
+	cert     *tls.Certificate
+	config   *tls.Config // The clone of the configuration the certificate is installed in.
+	served   *tls.Config // The configuration with the current certificate for incoming connections.
+	rotated  chan struct{}
+	remote   bool // Only used to solicit connections, so nothing is stapled.
+}
+
+// A TLS configuration of the server, the options it was generated from
+// and how to replace it in the server options.
+type tlsListenerConfig struct {
+	kind   string
+	config *tls.Config
+	opts   *TLSConfigOpts
+	remote bool
+	set    func(*tls.Config)
+}
+
+func tlsListenerConfigs(o *Options) []tlsListenerConfig {
+	configs := []tlsListenerConfig{
+		{"client", o.TLSConfig, o.tlsConfigOpts, false, func(c *tls.Config) { o.TLSConfig = c }},
+		{"route", o.Cluster.TLSConfig, o.Cluster.tlsConfigOpts, false, func(c *tls.Config) { o.Cluster.TLSConfig = c }},
+		{"gateway", o.Gateway.TLSConfig, o.Gateway.tlsConfigOpts, false, func(c *tls.Config) { o.Gateway.TLSConfig = c }},
+		{"leafnode", o.LeafNode.TLSConfig, o.LeafNode.tlsConfigOpts, false, func(c *tls.Config) { o.LeafNode.TLSConfig = c }},
+		{"websocket", o.Websocket.TLSConfig, o.Websocket.tlsConfigOpts, false, func(c *tls.Config) { o.Websocket.TLSConfig = c }},
+		{"mqtt", o.MQTT.TLSConfig, o.MQTT.tlsConfigOpts, false, func(c *tls.Config) { o.MQTT.TLSConfig = c }},
+	}
+	for _, l := range o.Listeners {
+		l := l
+		configs = append(configs, tlsListenerConfig{"client listener " + strconv.Quote(l.Name), l.TLSConfig, l.tlsConfigOpts, false,
+			func(c *tls.Config) { l.TLSConfig = c }})
+	}
+	// The remotes can't be changed by a configuration reload.
+	for _, r := range o.Gateway.Gateways {
+		r := r
+		configs = append(configs, tlsListenerConfig{"remote gateway " + strconv.Quote(r.Name), r.TLSConfig, r.tlsConfigOpts, true,
+			func(c *tls.Config) { r.TLSConfig = c }})
+	}
+	for i, r := range o.LeafNode.Remotes {
+		r := r
+		configs = append(configs, tlsListenerConfig{"remote leafnode " + strconv.Itoa(i), r.TLSConfig, r.tlsConfigOpts, true,
+			func(c *tls.Config) { r.TLSConfig = c }})
+	}
+	return configs
+}
+
+// Takes over the certificate of the configuration. It is served for
+// both incoming and, for routes, gateways and leafnodes, solicited
+// connections.
+func newTLSCert(l tlsListenerConfig) *tlsCert {
+	cert := l.config.Certificates[0]
+	c := &tlsCert{kind: l.kind, cert: &cert, rotated: make(chan struct{}, 1), remote: l.remote}
+	if tc := l.opts; tc != nil && tc.CertFile != _EMPTY_ && tc.KeyFile != _EMPTY_ {
+		c.certFile, c.keyFile = tc.CertFile, tc.KeyFile
+		c.modTime, _ = tlsFilesModTime(c.certFile, c.keyFile)
+	}
+	c.install(l)
+	return c
+}
+
+// Installs the certificate in a clone of the configuration that replaces
+// it in the options, the configuration itself is left untouched so that it
+// can be used by other servers. The other certificates, e.g. for SNI, are
+// served as well.
+func (c *tlsCert) install(l tlsListenerConfig) {
+	config := l.config.Clone()
+	config.GetConfigForClient = c.getConfigForClient
+	config.GetClientCertificate = c.getClientCertificate
+	c.mu.Lock()
+	c.config = config
+	c.setCert(c.cert)
+	c.mu.Unlock()
+	l.set(config)
+}
+
+// Sets the certificate along with the configuration serving it.
+// Lock should be held.
+func (c *tlsCert) setCert(cert *tls.Certificate) {
+	c.cert = cert
+	served := c.config.Clone()
+	served.GetConfigForClient = nil
+	served.Certificates = append([]tls.Certificate{*cert}, c.config.Certificates[1:]...)
+	c.served = served
+}
+
+// Returns the latest modification time of the files.
+func tlsFilesModTime(files ...string) (time.Time, error) {
+	var modTime time.Time
+	for _, f := range files {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if fi.ModTime().After(modTime) {
+			modTime = fi.ModTime()
+		}
+	}
+	return modTime, nil
+}
+
+func (c *tlsCert) certificate() *tls.Certificate {
+	c.mu.RLock()
+	cert := c.cert
+	c.mu.RUnlock()
+	return cert
+}
+
+func (c *tlsCert) getConfigForClient(_ *tls.ClientHelloInfo) (*tls.Config, error) {
+	c.mu.RLock()
+	config := c.served
+	c.mu.RUnlock()
+	return config, nil
+}
+
+func (c *tlsCert) getClientCertificate(_ *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return c.certificate(), nil
+}
+
+// Staples the OCSP response if the certificate is still the one it is for.
+func (c *tlsCert) setStaple(leaf, staple []byte) {
+	c.mu.Lock()
+	if bytes.Equal(c.cert.Certificate[0], leaf) {
+		cert := *c.cert
+		cert.OCSPStaple = staple
+		c.setCert(&cert)
+	}
+	c.mu.Unlock()
+}
+
+// Loads the certificate again if its files changed and returns whether it
+// was swapped. An error is returned only once until the files change.
+func (c *tlsCert) reload() (bool, error) {
+	modTime, err := tlsFilesModTime(c.certFile, c.keyFile)
+	c.mu.RLock()
+	unchanged := err == nil && (modTime.Equal(c.modTime) || modTime.Equal(c.failed))
+	c.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+	var cert tls.Certificate
+	if err == nil {
+		if cert, err = tls.LoadX509KeyPair(c.certFile, c.keyFile); err == nil {
+			cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		c.failed = modTime
+		if err.Error() == c.lastErr {
+			return false, nil
+		}
+		c.lastErr = err.Error()
+		return false, err
+	}
+	c.modTime, c.failed, c.lastErr = modTime, time.Time{}, _EMPTY_
+	if bytes.Equal(cert.Certificate[0], c.cert.Certificate[0]) {
+		return false, nil
+	}
+	c.setCert(&cert)
+	select {
+	case c.rotated <- struct{}{}:
+	default:
+	}
+	return true, nil
+}
+
+// Swaps the certificate for the one loaded again by a configuration reload
+// if it is different.
+func (c *tlsCert) swap(cert tls.Certificate) {
+	modTime, _ := tlsFilesModTime(c.certFile, c.keyFile)
+	c.mu.Lock()
+	if !bytes.Equal(cert.Certificate[0], c.cert.Certificate[0]) {
+		c.setCert(&cert)
+	}
+	c.modTime, c.failed, c.lastErr = modTime, time.Time{}, _EMPTY_
+	c.mu.Unlock()
+}
+
+// Sets up the certificates of the TLS listeners that can be swapped while
+// in use, which are the ones loaded from files or that get OCSP responses
+// stapled. A certificate already taken over is kept when the configuration
+// it is installed in is reused, or when it is loaded from the same files, so
+// that the configurations cloned for solicited connections, such as the ones
+// of the gateway and leafnode remotes, keep getting the rotated certificate
+// after a configuration reload.
+func configureTLSCerts(o *Options, prev []*tlsCert) []*tlsCert {
+	stapling := o.OCSPConfig != nil && o.OCSPConfig.Mode != OCSPModeNever
+	var certs []*tlsCert
+	for _, l := range tlsListenerConfigs(o) {
+		if l.config == nil {
+			continue
+		}
+		if c := installedTLSCert(prev, l.config); c != nil {
+			certs = append(certs, c)
+			continue
+		}
+		if len(l.config.Certificates) == 0 {
+			continue
+		}
+		if l.opts == nil && (!stapling || l.remote) {
+			continue
+		}
+		if c := findTLSCert(prev, l); c != nil {
+			c.swap(l.config.Certificates[0])
+			c.install(l)
+			certs = append(certs, c)
+			continue
+		}
+		certs = append(certs, newTLSCert(l))
+	}
+	return certs
+}
+
+// Returns the certificate of the previous configuration
+// that is installed in the configuration, if any.
+func installedTLSCert(prev []*tlsCert, config *tls.Config) *tlsCert {
+	for _, c := range prev {
+		c.mu.RLock()
+		installed := c.config == config
+		c.mu.RUnlock()
+		if installed {
+			return c
+		}
+	}
+	return nil
+}
+
+// Returns the certificate of the previous configuration
+// that was loaded from the same files, if any.
+func findTLSCert(prev []*tlsCert, l tlsListenerConfig) *tlsCert {
+	if l.opts == nil || l.opts.CertFile == _EMPTY_ || l.opts.KeyFile == _EMPTY_ {
+		return nil
+	}
+	for _, c := range prev {
+		if c.kind == l.kind && c.certFile == l.opts.CertFile && c.keyFile == l.opts.KeyFile {
+			return c
+		}
+	}
+	return nil
+}
+
+// Sets up the certificates of the TLS listeners and their OCSP
+// staples when the server is created.
+// Lock should be held.
+func (s *Server) enableTLSCerts() error {
+	opts := s.getOpts()
+	certs := configureTLSCerts(opts, nil)
+	monitors, err := s.configureOCSP(opts.OCSPConfig, certs, nil)
+	if err != nil {
+		return err
+	}
+	s.tlsCerts, s.ocsps = certs, monitors
+	return nil
+}
+
+// Sets up the certificates of the TLS configurations of the new options,
+// this has to be done before they are in use.
+func (s *Server) reloadTLSCerts(o *Options) error {
+	s.mu.Lock()
+	prevCerts, prevOCSPs := s.tlsCerts, s.ocsps
+	s.mu.Unlock()
+	certs := configureTLSCerts(o, prevCerts)
+	monitors, err := s.configureOCSP(o.OCSPConfig, certs, prevOCSPs)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.tlsCerts, s.ocsps = certs, monitors
+	s.mu.Unlock()
+	for _, m := range prevOCSPs {
+		m.stop()
+	}
+	for _, m := range monitors {
+		s.startGoRoutine(m.run)
+	}
+	return nil
+}
+
+// Checks the files of the certificates of the TLS listeners
+// and swaps the ones that were rotated.
+func (s *Server) watchTLSCerts() {
+	defer s.grWG.Done()
+
+	t := time.NewTicker(tlsCertCheckInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-s.quitCh:
+			return
+		}
+		s.mu.Lock()
+		certs := s.tlsCerts
+		s.mu.Unlock()
+		for _, c := range certs {
+			if c.certFile == _EMPTY_ {
+				continue
+			}
+			rotated, err := c.reload()
+			if err != nil {
+				s.Errorf("Error rotating %s certificate: %v", c.kind, err)
+				s.sendTLSCertRotationEvent(c, err)
+			} else if rotated {
+				leaf := c.certificate().Leaf
+				s.Noticef("Rotated %s certificate %q, expires %v", c.kind, leaf.Subject, leaf.NotAfter)
+				s.sendTLSCertRotationEvent(c, nil)
+			}
+		}
+	}
+}
@@ -0,0 +1,357 @@
+// Copyright 2020 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// Replaces the content of the files, making sure their modification time changes.
+func rotateTLSFiles(t *testing.T, certFile, keyFile, newCertFile, newKeyFile string) {
+	t.Helper()
+	mt := time.Now().Add(time.Minute)
+	for _, f := range [][2]string{{newCertFile, certFile}, {newKeyFile, keyFile}} {
+		b, err := ioutil.ReadFile(f[0])
+		if err != nil {
+			t.Fatalf("Error reading file: %v", err)
+		}
+		if err := ioutil.WriteFile(f[1], b, 0600); err != nil {
+			t.Fatalf("Error writing file: %v", err)
+		}
+		if err := os.Chtimes(f[1], mt, mt); err != nil {
+			t.Fatalf("Error setting file times: %v", err)
+		}
+	}
+}
+
+func TestTLSCertRotation(t *testing.T) {
+	orgInterval := tlsCertCheckInterval
+	tlsCertCheckInterval = 50 * time.Millisecond
+	defer func() { tlsCertCheckInterval = orgInterval }()
+
+	dir, err := ioutil.TempDir("", "tlscert")
+	if err != nil {
+		t.Fatalf("Error creating dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newOCSPTestCA(t)
+	_, certFile, keyFile := ca.issue(t, dir, 2, _EMPTY_, _EMPTY_, false)
+	_, newCertFile, newKeyFile := ca.issue(t, dir, 3, _EMPTY_, _EMPTY_, false)
+
+	opts := ocspTestOptions(t, certFile, keyFile, nil)
+	opts.NoSystemAccount = false
+	s := RunServer(opts)
+	defer s.Shutdown()
+
+	events := make(chan *TLSCertRotationEventMsg, 10)
+	if _, err := s.SystemAccount().subscribeInternal(fmt.Sprintf(tlsCertRotationEventSubj, "*"), func(_ *subscription, _ *client, _, _ string, msg []byte) {
+		var em TLSCertRotationEventMsg
+		if err := json.Unmarshal(msg, &em); err != nil {
+			t.Errorf("Error unmarshaling event: %v", err)
+			return
+		}
+		events <- &em
+	}); err != nil {
+		t.Fatalf("Error subscribing: %v", err)
+	}
+	waitForEvent := func() *TLSCertRotationEventMsg {
+		t.Helper()
+		select {
+		case em := <-events:
+			return em
+		case <-time.After(2 * time.Second):
+			t.Fatal("Did not get the rotation event")
+		}
+		return nil
+	}
+	checkSerial := func(expected string) {
+		t.Helper()
+		cs := tlsConnState(t, s, ca)
+		if serial := cs.PeerCertificates[0].SerialNumber.String(); serial != expected {
+			t.Fatalf("Expected certificate with serial %s, got %s", expected, serial)
+		}
+	}
+	checkSerial("2")
+
+	rotateTLSFiles(t, certFile, keyFile, newCertFile, newKeyFile)
+	em := waitForEvent()
+	if em.Type != TLSCertRotationEventMsgType || em.Kind != "client" || em.CertFile != certFile ||
+		em.Serial != "3" || em.Error != _EMPTY_ {
+		t.Fatalf("Unexpected event: %+v", em)
+	}
+	checkSerial("3")
+
+	// A bad certificate is reported and the current one kept.
+	if err := ioutil.WriteFile(certFile, []byte("bad"), 0600); err != nil {
+		t.Fatalf("Error writing file: %v", err)
+	}
+	mt := time.Now().Add(time.Hour)
+	os.Chtimes(certFile, mt, mt)
+	em = waitForEvent()
+	if em.Kind != "client" || em.Error == _EMPTY_ {
+		t.Fatalf("Expected event with error, got %+v", em)
+	}
+	checkSerial("3")
+	// The error is reported once.
+	select {
+	case em := <-events:
+		t.Fatalf("Unexpected event: %+v", em)
+	case <-time.After(250 * time.Millisecond):
+	}
+}
+
+func TestTLSCertConfigNotModified(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlscert")
+	if err != nil {
+		t.Fatalf("Error creating dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newOCSPTestCA(t)
+	_, certFile, keyFile := ca.issue(t, dir, 2, _EMPTY_, _EMPTY_, false)
+	_, otherCertFile, otherKeyFile := ca.issue(t, dir, 3, _EMPTY_, _EMPTY_, false)
+
+	opts := ocspTestOptions(t, certFile, keyFile, nil)
+	config := opts.TLSConfig
+	other, err := tls.LoadX509KeyPair(otherCertFile, otherKeyFile)
+	if err != nil {
+		t.Fatalf("Error loading certificate: %v", err)
+	}
+	config.Certificates = append(config.Certificates, other)
+
+	// Two servers built from the same TLS configuration.
+	s1 := RunServer(opts)
+	defer s1.Shutdown()
+	opts2 := ocspTestOptions(t, certFile, keyFile, nil)
+	opts2.TLSConfig, opts2.tlsConfigOpts = config, opts.tlsConfigOpts
+	s2 := RunServer(opts2)
+	defer s2.Shutdown()
+
+	if len(config.Certificates) != 2 || config.GetConfigForClient != nil || config.GetClientCertificate != nil {
+		t.Fatalf("Expected the configuration to be left untouched, got %+v", config)
+	}
+	s1.mu.Lock()
+	c1 := s1.tlsCerts[0]
+	s1.mu.Unlock()
+	s2.mu.Lock()
+	c2 := s2.tlsCerts[0]
+	s2.mu.Unlock()
+	if c1 == c2 {
+		t.Fatal("Expected the servers to have their own certificates")
+	}
+	for _, s := range []*Server{s1, s2} {
+		if served := s.getOpts().TLSConfig; served == config || len(served.Certificates) != 2 {
+			t.Fatalf("Expected the server to use a clone with all certificates, got %+v", served)
+		}
+		if serial := tlsConnState(t, s, ca).PeerCertificates[0].SerialNumber.String(); serial != "2" {
+			t.Fatalf("Expected certificate with serial 2, got %s", serial)
+		}
+	}
+}
+
+func TestTLSCertRotationWithOCSP(t *testing.T) {
+	orgInterval := tlsCertCheckInterval
+	tlsCertCheckInterval = 50 * time.Millisecond
+	defer func() { tlsCertCheckInterval = orgInterval }()
+
+	dir, err := ioutil.TempDir("", "tlscert")
+	if err != nil {
+		t.Fatalf("Error creating dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newOCSPTestCA(t)
+	ts := ca.run(t)
+	defer ts.Close()
+	_, certFile, keyFile := ca.issue(t, dir, 2, ts.URL, _EMPTY_, false)
+	newCert, newCertFile, newKeyFile := ca.issue(t, dir, 3, ts.URL, _EMPTY_, false)
+
+	opts := ocspTestOptions(t, certFile, keyFile, &OCSPConfig{Mode: OCSPModeAlways})
+	s := RunServer(opts)
+	defer s.Shutdown()
+
+	rotateTLSFiles(t, certFile, keyFile, newCertFile, newKeyFile)
+	// The new certificate gets its own response stapled.
+	checkFor(t, 2*time.Second, 50*time.Millisecond, func() error {
+		cs := tlsConnState(t, s, ca)
+		if serial := cs.PeerCertificates[0].SerialNumber.String(); serial != "3" {
+			return fmt.Errorf("certificate not rotated yet")
+		}
+		if len(cs.OCSPResponse) == 0 {
+			return fmt.Errorf("no response stapled")
+		}
+		resp, err := ocsp.ParseResponseForCert(cs.OCSPResponse, newCert, ca.cert)
+		if err != nil {
+			return err
+		}
+		if resp.Status != ocsp.Good {
+			return fmt.Errorf("unexpected status %v", ocspStatusString(resp.Status))
+		}
+		return nil
+	})
+}
+
+func TestTLSCertRotationConfigReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlscert")
+	if err != nil {
+		t.Fatalf("Error creating dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newOCSPTestCA(t)
+	_, certFile, keyFile := ca.issue(t, dir, 2, _EMPTY_, _EMPTY_, false)
+	_, newCertFile, newKeyFile := ca.issue(t, dir, 3, _EMPTY_, _EMPTY_, false)
+
+	content := `
+		listen: "127.0.0.1:-1"
+		tls {
+			cert_file: %q
+			key_file: %q
+		}
+	`
+	conf := createConfFile(t, []byte(fmt.Sprintf(content, certFile, keyFile)))
+	defer os.Remove(conf)
+	s, _ := RunServerWithConfig(conf)
+	defer s.Shutdown()
+
+	if cs := tlsConnState(t, s, ca); cs.PeerCertificates[0].SerialNumber.String() != "2" {
+		t.Fatal("Expected certificate with serial 2")
+	}
+	changeCurrentConfigContentWithNewContent(t, conf, []byte(fmt.Sprintf(content, newCertFile, newKeyFile)))
+	if err := s.Reload(); err != nil {
+		t.Fatalf("Error on reload: %v", err)
+	}
+	if cs := tlsConnState(t, s, ca); cs.PeerCertificates[0].SerialNumber.String() != "3" {
+		t.Fatal("Expected certificate with serial 3 after reload")
+	}
+	s.mu.Lock()
+	n := len(s.tlsCerts)
+	certFile = s.tlsCerts[0].certFile
+	s.mu.Unlock()
+	if n != 1 || !strings.HasSuffix(certFile, "cert-3.pem") {
+		t.Fatalf("Expected the new certificate files to be watched, got %v", certFile)
+	}
+}
+
+func TestTLSCertRotationLeafNodeRemote(t *testing.T) {
+	orgInterval := tlsCertCheckInterval
+	tlsCertCheckInterval = 50 * time.Millisecond
+	defer func() { tlsCertCheckInterval = orgInterval }()
+
+	dir, err := ioutil.TempDir("", "tlscert")
+	if err != nil {
+		t.Fatalf("Error creating dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newOCSPTestCA(t)
+	caFile := ca.writeCert(t, dir)
+	_, hubCertFile, hubKeyFile := ca.issue(t, dir, 1, _EMPTY_, _EMPTY_, false)
+	_, certFile, keyFile := ca.issue(t, dir, 2, _EMPTY_, _EMPTY_, false)
+	_, newCertFile, newKeyFile := ca.issue(t, dir, 3, _EMPTY_, _EMPTY_, false)
+
+	hubConf := createConfFile(t, []byte(fmt.Sprintf(`
+		listen: "127.0.0.1:-1"
+		leafnodes {
+			listen: "127.0.0.1:-1"
+			tls {
+				cert_file: %q
+				key_file: %q
+				ca_file: %q
+				verify: true
+			}
+		}
+	`, hubCertFile, hubKeyFile, caFile)))
+	defer os.Remove(hubConf)
+	hub, hubOpts := RunServerWithConfig(hubConf)
+	defer hub.Shutdown()
+
+	content := `
+		listen: "127.0.0.1:-1"
+		%s
+		leafnodes {
+			remotes [{
+				url: "tls://127.0.0.1:%d"
+				tls {
+					cert_file: %q
+					key_file: %q
+					ca_file: %q
+				}
+			}]
+		}
+	`
+	conf := createConfFile(t, []byte(fmt.Sprintf(content, _EMPTY_, hubOpts.LeafNode.Port, certFile, keyFile, caFile)))
+	defer os.Remove(conf)
+	s, _ := RunServerWithConfig(conf)
+	defer s.Shutdown()
+
+	checkLeafNodeConnected(t, hub)
+	leaf := func() *client {
+		hub.mu.Lock()
+		defer hub.mu.Unlock()
+		for _, c := range hub.leafs {
+			return c
+		}
+		return nil
+	}
+	checkSerial := func(expected string) error {
+		c := leaf()
+		if c == nil {
+			return fmt.Errorf("leafnode not connected")
+		}
+		c.mu.Lock()
+		cs := c.nc.(*tls.Conn).ConnectionState()
+		c.mu.Unlock()
+		if serial := cs.PeerCertificates[0].SerialNumber.String(); serial != expected {
+			return fmt.Errorf("expected certificate with serial %s, got %s", expected, serial)
+		}
+		return nil
+	}
+	if err := checkSerial("2"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// The certificate is still watched after a configuration reload.
+	changeCurrentConfigContentWithNewContent(t, conf, []byte(fmt.Sprintf(content, "debug: true", hubOpts.LeafNode.Port, certFile, keyFile, caFile)))
+	if err := s.Reload(); err != nil {
+		t.Fatalf("Error on reload: %v", err)
+	}
+	s.mu.Lock()
+	n := len(s.tlsCerts)
+	kind := s.tlsCerts[0].kind
+	s.mu.Unlock()
+	if n != 1 || kind != "remote leafnode 0" {
+		t.Fatalf("Expected the remote certificate to be watched, got %v certificates", n)
+	}
+
+	rotateTLSFiles(t, certFile, keyFile, newCertFile, newKeyFile)
+	// Solicited connections use the rotated certificate.
+	checkFor(t, 5*time.Second, 100*time.Millisecond, func() error {
+		if c := leaf(); c != nil {
+			c.closeConnection(ClientClosed)
+		}
+		checkLeafNodeConnected(t, hub)
+		return checkSerial("3")
+	})
+}
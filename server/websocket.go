@@ -16,8 +16,10 @@ package server
 import (
 	"bytes"
 	"compress/flate"
+	"crypto/rand"
 	"crypto/sha1"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/binary"
 	"errors"
@@ -70,6 +72,9 @@ const (
 	wsCloseStatusInternalSrvError   = 1011
 	wsCloseStatusTLSHandshake       = 1015
 
+	// Path on the websocket port where leafnode connections are accepted.
+	wsLeafNodePath = "/leafnode"
+
 	wsFirstFrame        = true
 	wsContFrame         = false
 	wsFinalFrame        = true
@@ -91,6 +96,9 @@ type websocket struct {
 	browser    bool
 	compressor *flate.Writer
 	cookieJwt  string
+	// Set for the client side of the connection, that is, a solicited
+	// leafnode. It masks the frames it sends and reads unmasked frames.
+	maskwrite bool
 }
 
 type srvWebsocket struct {
@@ -115,6 +123,7 @@ type allowedOrigin struct {
 type wsUpgradeResult struct {
 	conn net.Conn
 	ws   *websocket
+	kind int
 }
 
 type wsReadInfo struct {
@@ -125,6 +134,8 @@ type wsReadInfo struct {
 	mkpos byte
 	mkey  [4]byte
 	buf   []byte
+	// Frames sent by a server are not masked.
+	nomask bool
 }
 
 func (r *wsReadInfo) init() {
@@ -184,8 +195,11 @@ func (c *client) wsRead(r *wsReadInfo, ior io.Reader, buf []byte) ([][]byte, err
 			b1 := tmpBuf[0]
 
 			// Clients MUST set the mask bit. If not set, reject.
-			if b1&wsMaskBit == 0 {
+			// Servers MUST NOT, so reject it when reading as a client.
+			if masked := b1&wsMaskBit != 0; !masked && !r.nomask {
 				return bufs, c.wsHandleProtocolError("mask bit missing")
+			} else if masked && r.nomask {
+				return bufs, c.wsHandleProtocolError("mask bit set")
 			}
 
 			// Store size in case it is < 125
@@ -233,12 +247,14 @@ func (c *client) wsRead(r *wsReadInfo, ior io.Reader, buf []byte) ([][]byte, err
 			}
 
 			// Read masking key
-			tmpBuf, pos, err = wsGet(ior, buf, pos, 4)
-			if err != nil {
-				return bufs, err
+			if !r.nomask {
+				tmpBuf, pos, err = wsGet(ior, buf, pos, 4)
+				if err != nil {
+					return bufs, err
+				}
+				copy(r.mkey[:], tmpBuf)
+				r.mkpos = 0
 			}
-			copy(r.mkey[:], tmpBuf)
-			r.mkpos = 0
 
 			// Handle control messages in place...
 			if wsIsControlFrame(frameType) {
@@ -343,6 +359,9 @@ func (c *client) wsHandleControlFrame(r *wsReadInfo, frameType wsOpCode, nc io.R
 
 // Unmask the given slice.
 func (r *wsReadInfo) unmask(buf []byte) {
+	if r.nomask {
+		return
+	}
 	p := int(r.mkpos)
 	if len(buf) < 16 {
 		for i := 0; i < len(buf); i++ {
@@ -415,6 +434,22 @@ func wsFillFrameHeader(fh []byte, first, final, compressed bool, frameType wsOpC
 	return n
 }
 
+// Returns a copy of the frame header `fh` with the mask bit set and
+// a random masking key, after masking `payload` in place with that key.
+// The client side of a connection MUST mask the frames it sends.
+func wsMaskFrame(fh, payload []byte) []byte {
+	var key [4]byte
+	rand.Read(key[:])
+	mfh := make([]byte, len(fh)+len(key))
+	copy(mfh, fh)
+	mfh[1] |= wsMaskBit
+	copy(mfh[len(fh):], key[:])
+	for i := range payload {
+		payload[i] ^= key[i&3]
+	}
+	return mfh
+}
+
 // Invokes wsEnqueueControlMessageLocked under client lock.
 //
 // Client lock MUST NOT be held on entry
@@ -440,6 +475,9 @@ func (c *client) wsEnqueueControlMessageLocked(controlMsg wsOpCode, payload []by
 	if len(payload) > 0 {
 		copy(cm[2:], payload)
 	}
+	if c.ws.maskwrite {
+		cm = append(wsMaskFrame(cm[:2], cm[2:]), cm[2:]...)
+	}
 	c.out.pb += int64(len(cm))
 	if controlMsg == wsCloseMessage {
 		// We can't add the close message to the frames buffers
@@ -546,10 +584,25 @@ func (s *Server) wsUpgrade(w http.ResponseWriter, r *http.Request) (*wsUpgradeRe
 	if !wsHeaderContains(r.Header, "Sec-Websocket-Version", "13") {
 		return nil, wsReturnHTTPError(w, http.StatusBadRequest, "invalid version")
 	}
+	// Leafnodes connect on a dedicated path, which is available only
+	// if the server accepts leafnode connections.
+	kind := CLIENT
+	if r.URL != nil && r.URL.Path == wsLeafNodePath {
+		if opts.LeafNode.Port == 0 {
+			return nil, wsReturnHTTPError(w, http.StatusNotFound, "leafnode connections not accepted")
+		}
+		if err := wsCheckLeafNodeCert(r.TLS, &opts.LeafNode); err != nil {
+			return nil, wsReturnHTTPError(w, http.StatusForbidden, err.Error())
+		}
+		kind = LEAF
+	}
 	// Others are optional
 	// Point 7.
-	if err := s.websocket.checkOrigin(r); err != nil {
-		return nil, wsReturnHTTPError(w, http.StatusForbidden, fmt.Sprintf("origin not allowed: %v", err))
+	// Origin is only relevant for browsers, so not checked for leafnodes.
+	if kind == CLIENT {
+		if err := s.websocket.checkOrigin(r); err != nil {
+			return nil, wsReturnHTTPError(w, http.StatusForbidden, fmt.Sprintf("origin not allowed: %v", err))
+		}
 	}
 	// Point 8.
 	// We don't have protocols, so ignore.
@@ -603,7 +656,7 @@ func (s *Server) wsUpgrade(w http.ResponseWriter, r *http.Request) (*wsUpgradeRe
 			ws.cookieJwt = c.Value
 		}
 	}
-	return &wsUpgradeResult{conn: conn, ws: ws}, nil
+	return &wsUpgradeResult{conn: conn, ws: ws, kind: kind}, nil
 }
 
 // Returns true if the header named `name` contains a token with value `value`.
@@ -638,6 +691,43 @@ func wsClientSupportsCompression(header http.Header) bool {
 	return false
 }
 
+// The TLS handshake of leafnodes connecting over websocket is done by the
+// websocket listener, so the certificate they presented is checked here
+// against the verification and pinned certificates of the leafnode TLS block.
+func wsCheckLeafNodeCert(cs *tls.ConnectionState, lo *LeafNodeOpts) error {
+	verify := lo.TLSConfig != nil && lo.TLSConfig.ClientAuth == tls.RequireAndVerifyClientCert
+	if !verify && len(lo.TLSPinnedCerts) == 0 {
+		return nil
+	}
+	if cs == nil || len(cs.PeerCertificates) == 0 {
+		return fmt.Errorf("leafnode did not present a certificate")
+	}
+	cert := cs.PeerCertificates[0]
+	if verify {
+		vo := x509.VerifyOptions{
+			Roots:         lo.TLSConfig.ClientCAs,
+			Intermediates: x509.NewCertPool(),
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}
+		for _, ic := range cs.PeerCertificates[1:] {
+			vo.Intermediates.AddCert(ic)
+		}
+		chains, err := cert.Verify(vo)
+		if err == nil && lo.TLSConfig.VerifyPeerCertificate != nil {
+			err = lo.TLSConfig.VerifyPeerCertificate(nil, chains)
+		}
+		if err != nil {
+			return fmt.Errorf("leafnode certificate not verified: %v", err)
+		}
+	}
+	if len(lo.TLSPinnedCerts) > 0 {
+		if _, ok := lo.TLSPinnedCerts[pinnedCertFingerprint(cert)]; !ok {
+			return fmt.Errorf("leafnode certificate %q is not pinned", cert.Subject)
+		}
+	}
+	return nil
+}
+
 // Send an HTTP error with the given `status`` to the given http response writer `w`.
 // Return an error created based on the `reason` string.
 func wsReturnHTTPError(w http.ResponseWriter, status int, reason string) error {
@@ -877,7 +967,11 @@ func (s *Server) startWebsocketServer() {
 			s.Errorf(err.Error())
 			return
 		}
-		s.createClient(res.conn, res.ws)
+		if res.kind == LEAF {
+			s.createLeafNode(res.conn, nil, res.ws, nil)
+		} else {
+			s.createClient(res.conn, res.ws)
+		}
 	})
 	hs := &http.Server{
 		Addr:        hp,
@@ -954,7 +1048,10 @@ func (c *client) wsCollapsePtoNB() (net.Buffers, int64) {
 			usz += len(b)
 			cp.Write(b)
 		}
-		cp.Close()
+		// Flush ends the data with an empty stored block, whose trailing
+		// 0x00 0x00 0xff 0xff bytes are removed as per
+		// https://tools.ietf.org/html/rfc7692#section-7.2.1
+		cp.Flush()
 		b := buf.Bytes()
 		p := b[:len(b)-4]
 		if mfs > 0 && len(p) > mfs {
@@ -967,12 +1064,19 @@ func (c *client) wsCollapsePtoNB() (net.Buffers, int64) {
 				}
 				fh := make([]byte, wsMaxFrameHeaderSize)
 				n := wsFillFrameHeader(fh, first, final, wsCompressedFrame, wsBinaryMessage, lp)
-				bufs = append(bufs, fh[:n], p[:lp])
-				csz += n + lp
+				fh = fh[:n]
+				if c.ws.maskwrite {
+					fh = wsMaskFrame(fh, p[:lp])
+				}
+				bufs = append(bufs, fh, p[:lp])
+				csz += len(fh) + lp
 				p = p[lp:]
 			}
 		} else {
 			h := wsCreateFrameHeader(true, wsBinaryMessage, len(p))
+			if c.ws.maskwrite {
+				h = wsMaskFrame(h, p)
+			}
 			bufs = append(bufs, h, p)
 			csz = len(h) + len(p)
 		}
@@ -982,7 +1086,21 @@ func (c *client) wsCollapsePtoNB() (net.Buffers, int64) {
 		c.out.pb += int64(csz) - int64(usz)
 		c.ws.fs += int64(csz)
 	} else if len(nb) > 0 {
-		if mfs > 0 {
+		if c.ws.maskwrite {
+			// Masking is done in place, but pending buffers may reference
+			// data shared with other connections, so copy them first.
+			for _, b := range nb {
+				total += len(b)
+			}
+			p := make([]byte, 0, total)
+			for _, b := range nb {
+				p = append(p, b...)
+			}
+			wsfh := wsMaskFrame(wsCreateFrameHeader(false, wsBinaryMessage, total), p)
+			c.out.pb += int64(len(wsfh))
+			bufs = append(bufs, wsfh, p)
+			c.ws.fs += int64(len(wsfh) + total)
+		} else if mfs > 0 {
 			// We are limiting the frame size.
 			startFrame := func() int {
 				bufs = append(bufs, make([]byte, wsMaxFrameHeaderSize))
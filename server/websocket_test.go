@@ -2523,15 +2523,18 @@ func (wc *testWSWrappedConn) Write(p []byte) (int, error) {
 }
 
 func TestWSCompressionBasic(t *testing.T) {
-	payload := "This is the content of a message that will be compresseddddddddddddddddddddd."
+	// Small data (below 128 bytes) is not worth compressing for flate's
+	// BestSpeed level and would be sent in a stored block.
+	payload := strings.Repeat("This is the content of a message that will be compressed. ", 5)
 	msgProto := fmt.Sprintf("MSG foo 1 %d\r\n%s\r\n", len(payload), payload)
 
 	cbuf := &bytes.Buffer{}
 	compressor, _ := flate.NewWriter(cbuf, flate.BestSpeed)
 	compressor.Write([]byte(msgProto))
-	compressor.Close()
+	compressor.Flush()
 	compressed := cbuf.Bytes()
-	// The last 4 bytes are dropped
+	// The last 4 bytes (0x00 0x00 0xff 0xff) of the empty stored block
+	// that ends the flushed data are dropped.
 	compressed = compressed[:len(compressed)-4]
 
 	o := testWSOptions()
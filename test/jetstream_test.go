@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"math/rand"
+	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -5688,6 +5689,218 @@ func TestJetStreamSimpleFileStorageRecovery(t *testing.T) {
 	}
 }
 
+// Registered storage types live for the life of the process.
+var (
+	customStorageOnce sync.Once
+	customMemStorage  server.StorageType
+	customFileStorage server.StorageType
+)
+
+func registerCustomStorageTypes(t *testing.T) {
+	t.Helper()
+	customStorageOnce.Do(func() {
+		var err error
+		customMemStorage, err = server.RegisterStorageType("custom_memory", server.MemoryStorage,
+			func(fcfg server.FileStoreConfig, cfg server.StreamConfig, _ time.Time) (server.StreamStore, error) {
+				cfg.Storage = server.MemoryStorage
+				return server.NewStreamStore(fcfg, cfg)
+			})
+		if err != nil {
+			t.Fatalf("Unexpected error registering storage type: %v", err)
+		}
+		// Keep the wrapped file store in a sub directory to not collide with the stream's meta data.
+		customFileStorage, err = server.RegisterStorageType("custom_file", server.FileStorage,
+			func(fcfg server.FileStoreConfig, cfg server.StreamConfig, _ time.Time) (server.StreamStore, error) {
+				cfg.Storage = server.FileStorage
+				fcfg.StoreDir = filepath.Join(fcfg.StoreDir, "data")
+				return server.NewStreamStore(fcfg, cfg)
+			})
+		if err != nil {
+			t.Fatalf("Unexpected error registering storage type: %v", err)
+		}
+	})
+}
+
+func TestJetStreamCustomStorageTypes(t *testing.T) {
+	registerCustomStorageTypes(t)
+
+	if _, err := server.RegisterStorageType("custom_memory", server.MemoryStorage,
+		func(fcfg server.FileStoreConfig, cfg server.StreamConfig, _ time.Time) (server.StreamStore, error) {
+			return nil, nil
+		}); err == nil {
+		t.Fatalf("Expected an error registering a duplicate storage type")
+	}
+
+	b, _ := json.Marshal(customFileStorage)
+	if string(b) != `"custom_file"` {
+		t.Fatalf("Expected storage type to marshal by name, got %s", b)
+	}
+	var st server.StorageType
+	if err := json.Unmarshal([]byte(`"custom_memory"`), &st); err != nil || st != customMemStorage {
+		t.Fatalf("Expected storage type to unmarshal by name, got %v, %v", st, err)
+	}
+	if err := json.Unmarshal([]byte(`"bogus"`), &st); err == nil {
+		t.Fatalf("Expected an error for an unknown storage type")
+	}
+
+	s := RunBasicJetStreamServer()
+	defer s.Shutdown()
+
+	if config := s.JetStreamConfig(); config != nil {
+		defer os.RemoveAll(config.StoreDir)
+	}
+
+	acc := s.GlobalAccount()
+
+	if _, err := acc.AddStream(&server.StreamConfig{Name: "BAD", Storage: server.StorageType(1000)}); err == nil {
+		t.Fatalf("Expected an error for an unknown storage type")
+	}
+
+	mmset, err := acc.AddStream(&server.StreamConfig{Name: "MEM", Storage: customMemStorage})
+	if err != nil {
+		t.Fatalf("Unexpected error adding stream: %v", err)
+	}
+	defer mmset.Delete()
+
+	fmset, err := acc.AddStream(&server.StreamConfig{Name: "FILE", Storage: customFileStorage})
+	if err != nil {
+		t.Fatalf("Unexpected error adding stream: %v", err)
+	}
+
+	nc := clientConnectToServer(t, s)
+	defer nc.Close()
+
+	toSend := 10
+	for i := 0; i < toSend; i++ {
+		sendStreamMsg(t, nc, "MEM", "Hello World")
+		sendStreamMsg(t, nc, "FILE", "Hello World")
+	}
+	if state := mmset.State(); state.Msgs != uint64(toSend) {
+		t.Fatalf("Expected %d msgs, got %d", toSend, state.Msgs)
+	}
+	state := fmset.State()
+	if state.Msgs != uint64(toSend) {
+		t.Fatalf("Expected %d msgs, got %d", toSend, state.Msgs)
+	}
+
+	// Usage should be accounted for as memory and file storage respectively.
+	usage := acc.JetStreamUsage()
+	if usage.Memory != mmset.State().Bytes {
+		t.Fatalf("Expected memory usage of %d, got %d", mmset.State().Bytes, usage.Memory)
+	}
+	if usage.Store != state.Bytes {
+		t.Fatalf("Expected storage usage of %d, got %d", state.Bytes, usage.Store)
+	}
+
+	// Restart and make sure the file accounted stream is recovered.
+	u, _ := url.Parse(s.ClientURL())
+	port, _ := strconv.Atoi(u.Port())
+	sd := s.JetStreamConfig().StoreDir
+
+	s.Shutdown()
+
+	s = RunJetStreamServerOnPort(port, sd)
+	defer s.Shutdown()
+
+	acc = s.GlobalAccount()
+
+	fmset, err = acc.LookupStream("FILE")
+	if err != nil {
+		t.Fatalf("Expected to recover stream: %v", err)
+	}
+	if cfg := fmset.Config(); cfg.Storage != customFileStorage {
+		t.Fatalf("Expected storage type to be recovered, got %v", cfg.Storage)
+	}
+	if nstate := fmset.State(); nstate != state {
+		t.Fatalf("State does not match: %+v vs %+v", nstate, state)
+	}
+	if err := fmset.Delete(); err != nil {
+		t.Fatalf("Unexpected error deleting stream: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(sd, "$G", "streams", "FILE")); !os.IsNotExist(err) {
+		t.Fatalf("Expected stream directory to be removed")
+	}
+}
+
+func TestJetStreamTieredStorage(t *testing.T) {
+	s := RunBasicJetStreamServer()
+	defer s.Shutdown()
+
+	if config := s.JetStreamConfig(); config != nil {
+		defer os.RemoveAll(config.StoreDir)
+	}
+
+	acc := s.GlobalAccount()
+
+	if _, err := acc.AddStream(&server.StreamConfig{Name: "BAD", Storage: server.FileStorage, MaxHeadMsgs: 10}); err == nil {
+		t.Fatalf("Expected an error for head limits without tiered storage")
+	}
+	if _, err := acc.AddStream(&server.StreamConfig{Name: "BAD", Storage: server.TieredStorage, MaxHeadBytes: 1 << 62}); err == nil {
+		t.Fatalf("Expected an error for a head larger than the memory of the account")
+	}
+
+	mset, err := acc.AddStream(&server.StreamConfig{Name: "TIERED", Storage: server.TieredStorage, MaxHeadMsgs: 10})
+	if err != nil {
+		t.Fatalf("Unexpected error adding stream: %v", err)
+	}
+
+	nc := clientConnectToServer(t, s)
+	defer nc.Close()
+
+	toSend := 50
+	for i := 1; i <= toSend; i++ {
+		sendStreamMsg(t, nc, "TIERED", fmt.Sprintf("MSG-%d", i))
+	}
+	state := mset.State()
+	if state.Msgs != uint64(toSend) {
+		t.Fatalf("Expected %d msgs, got %d", toSend, state.Msgs)
+	}
+	// Usage counts against file storage, the head against memory.
+	if usage := acc.JetStreamUsage(); usage.Store != state.Bytes || usage.Memory == 0 || usage.Memory >= state.Bytes {
+		t.Fatalf("Expected storage usage of %d and memory usage for the head, got %+v", state.Bytes, usage)
+	}
+
+	o, err := mset.AddConsumer(&server.ConsumerConfig{Durable: "dlc", AckPolicy: server.AckExplicit})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer o.Delete()
+
+	// Read from the tail and into the head.
+	for i := 1; i <= toSend; i++ {
+		m, err := nc.Request(o.RequestNextMsgSubject(), nil, time.Second)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if expected := fmt.Sprintf("MSG-%d", i); string(m.Data) != expected {
+			t.Fatalf("Expected %q, got %q", expected, m.Data)
+		}
+		m.Respond(nil)
+	}
+
+	// Restart and make sure we are recovered as tiered storage.
+	u, _ := url.Parse(s.ClientURL())
+	port, _ := strconv.Atoi(u.Port())
+	sd := s.JetStreamConfig().StoreDir
+
+	nc.Close()
+	s.Shutdown()
+
+	s = RunJetStreamServerOnPort(port, sd)
+	defer s.Shutdown()
+
+	mset, err = s.GlobalAccount().LookupStream("TIERED")
+	if err != nil {
+		t.Fatalf("Expected to recover stream: %v", err)
+	}
+	if cfg := mset.Config(); cfg.Storage != server.TieredStorage || cfg.MaxHeadMsgs != 10 {
+		t.Fatalf("Expected tiered config to be recovered, got %+v", cfg)
+	}
+	if nstate := mset.State(); nstate.Msgs != state.Msgs || nstate.Bytes != state.Bytes {
+		t.Fatalf("State does not match: %+v vs %+v", nstate, state)
+	}
+}
+
 func TestJetStreamRequestAPI(t *testing.T) {
 	s := RunBasicJetStreamServer()
 	defer s.Shutdown()
@@ -6171,188 +6384,1313 @@ func TestJetStreamRequestAPI(t *testing.T) {
 	if err = json.Unmarshal(resp.Data, &tListResp); err != nil {
 		t.Fatalf("Unexpected error: %v", err)
 	}
-	if len(tListResp.Templates) != 1 {
-		t.Fatalf("Expected 1 template but got %d", len(tListResp.Templates))
-	}
-	if tListResp.Templates[0] != "kv" {
-		t.Fatalf("Expected to get %q, but got %q", "kv", tListResp.Templates[0])
+	if len(tListResp.Templates) != 1 {
+		t.Fatalf("Expected 1 template but got %d", len(tListResp.Templates))
+	}
+	if tListResp.Templates[0] != "kv" {
+		t.Fatalf("Expected to get %q, but got %q", "kv", tListResp.Templates[0])
+	}
+
+	// First create a stream from the template
+	sendStreamMsg(t, nc, "kv.22", "derek")
+	// Last do info
+	resp, err = nc.Request(fmt.Sprintf(server.JSApiTemplateInfoT, "kv"), nil, time.Second)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var ti server.StreamTemplateInfo
+	if err = json.Unmarshal(resp.Data, &ti); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(ti.Streams) != 1 {
+		t.Fatalf("Expected 1 stream, got %d", len(ti.Streams))
+	}
+	if ti.Streams[0] != server.CanonicalName("kv.22") {
+		t.Fatalf("Expected stream with name %q, but got %q", server.CanonicalName("kv.22"), ti.Streams[0])
+	}
+
+	// Test that we can send nil or an empty legal json for requests that take no args.
+	// We know this stream does not exist, this just checking request processing.
+	checkEmptyReqArg := func(arg string) {
+		t.Helper()
+		var req []byte
+		if len(arg) > 0 {
+			req = []byte(arg)
+		}
+		resp, err = nc.Request(fmt.Sprintf(server.JSApiStreamDeleteT, "foo_bar_baz"), req, time.Second)
+		var dResp server.JSApiStreamDeleteResponse
+		if err = json.Unmarshal(resp.Data, &dResp); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if dResp.Error == nil || dResp.Error.Code != 404 {
+			t.Fatalf("Got a bad response, expected a 404 response %+v", dResp.Error)
+		}
+	}
+
+	checkEmptyReqArg("")
+	checkEmptyReqArg("{}")
+	checkEmptyReqArg(" {} ")
+	checkEmptyReqArg(" { } ")
+}
+
+func TestJetStreamAPIStreamListPaging(t *testing.T) {
+	s := RunBasicJetStreamServer()
+	defer s.Shutdown()
+
+	// Forced cleanup of all persisted state.
+	if config := s.JetStreamConfig(); config != nil {
+		defer os.RemoveAll(config.StoreDir)
+	}
+
+	// Create 4X limit
+	streamsNum := 4 * server.JSApiNamesLimit
+	for i := 1; i <= streamsNum; i++ {
+		name := fmt.Sprintf("STREAM-%06d", i)
+		cfg := server.StreamConfig{Name: name}
+		_, err := s.GlobalAccount().AddStream(&cfg)
+		if err != nil {
+			t.Fatalf("Unexpected error adding stream: %v", err)
+		}
+	}
+
+	// Client for API requests.
+	nc := clientConnectToServer(t, s)
+	defer nc.Close()
+
+	reqList := func(offset int) []byte {
+		t.Helper()
+		var req []byte
+		if offset > 0 {
+			req, _ = json.Marshal(&server.JSApiStreamNamesRequest{ApiPagedRequest: server.ApiPagedRequest{Offset: offset}})
+		}
+		resp, err := nc.Request(server.JSApiStreams, req, time.Second)
+		if err != nil {
+			t.Fatalf("Unexpected error getting stream list: %v", err)
+		}
+		return resp.Data
+	}
+
+	checkResp := func(resp []byte, expectedLen, expectedOffset int) {
+		t.Helper()
+		var listResponse server.JSApiStreamNamesResponse
+		if err := json.Unmarshal(resp, &listResponse); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(listResponse.Streams) != expectedLen {
+			t.Fatalf("Expected only %d streams but got %d", expectedLen, len(listResponse.Streams))
+		}
+		if listResponse.Total != streamsNum {
+			t.Fatalf("Expected total to be %d but got %d", streamsNum, listResponse.Total)
+		}
+		if listResponse.Offset != expectedOffset {
+			t.Fatalf("Expected offset to be %d but got %d", expectedOffset, listResponse.Offset)
+		}
+		if expectedLen < 1 {
+			return
+		}
+		// Make sure we get the right stream.
+		sname := fmt.Sprintf("STREAM-%06d", expectedOffset+1)
+		if listResponse.Streams[0] != sname {
+			t.Fatalf("Expected stream %q to be first, got %q", sname, listResponse.Streams[0])
+		}
+	}
+
+	checkResp(reqList(0), server.JSApiNamesLimit, 0)
+	checkResp(reqList(server.JSApiNamesLimit), server.JSApiNamesLimit, server.JSApiNamesLimit)
+	checkResp(reqList(2*server.JSApiNamesLimit), server.JSApiNamesLimit, 2*server.JSApiNamesLimit)
+	checkResp(reqList(streamsNum), 0, streamsNum)
+	checkResp(reqList(streamsNum-22), 22, streamsNum-22)
+}
+
+func TestJetStreamAPIConsumerListPaging(t *testing.T) {
+	s := RunBasicJetStreamServer()
+	defer s.Shutdown()
+
+	// Forced cleanup of all persisted state.
+	if config := s.JetStreamConfig(); config != nil {
+		defer os.RemoveAll(config.StoreDir)
+	}
+
+	sname := "MYSTREAM"
+	mset, err := s.GlobalAccount().AddStream(&server.StreamConfig{Name: sname})
+	if err != nil {
+		t.Fatalf("Unexpected error adding stream: %v", err)
+	}
+
+	// Client for API requests.
+	nc := clientConnectToServer(t, s)
+	defer nc.Close()
+
+	sub, _ := nc.SubscribeSync("d.*")
+	defer sub.Unsubscribe()
+	nc.Flush()
+
+	consumersNum := server.JSApiNamesLimit
+	for i := 1; i <= consumersNum; i++ {
+		_, err := mset.AddConsumer(&server.ConsumerConfig{DeliverSubject: fmt.Sprintf("d.%d", i)})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	reqListSubject := fmt.Sprintf(server.JSApiConsumersT, sname)
+	reqList := func(offset int) []byte {
+		t.Helper()
+		var req []byte
+		if offset > 0 {
+			req, _ = json.Marshal(&server.JSApiConsumersRequest{ApiPagedRequest: server.ApiPagedRequest{Offset: offset}})
+		}
+		resp, err := nc.Request(reqListSubject, req, time.Second)
+		if err != nil {
+			t.Fatalf("Unexpected error getting stream list: %v", err)
+		}
+		return resp.Data
+	}
+
+	checkResp := func(resp []byte, expectedLen, expectedOffset int) {
+		t.Helper()
+		var listResponse server.JSApiConsumerNamesResponse
+		if err := json.Unmarshal(resp, &listResponse); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(listResponse.Consumers) != expectedLen {
+			t.Fatalf("Expected only %d streams but got %d", expectedLen, len(listResponse.Consumers))
+		}
+		if listResponse.Total != consumersNum {
+			t.Fatalf("Expected total to be %d but got %d", consumersNum, listResponse.Total)
+		}
+		if listResponse.Offset != expectedOffset {
+			t.Fatalf("Expected offset to be %d but got %d", expectedOffset, listResponse.Offset)
+		}
+	}
+
+	checkResp(reqList(0), server.JSApiNamesLimit, 0)
+	checkResp(reqList(consumersNum-22), 22, consumersNum-22)
+}
+
+func TestJetStreamConsumerPauseResume(t *testing.T) {
+	s := RunBasicJetStreamServer()
+	defer s.Shutdown()
+
+	if config := s.JetStreamConfig(); config != nil {
+		defer os.RemoveAll(config.StoreDir)
+	}
+
+	mset, err := s.GlobalAccount().AddStream(&server.StreamConfig{Name: "MY_STREAM", Storage: server.MemoryStorage})
+	if err != nil {
+		t.Fatalf("Unexpected error adding stream: %v", err)
+	}
+	defer mset.Delete()
+
+	nc := clientConnectToServer(t, s)
+	defer nc.Close()
+
+	sub, _ := nc.SubscribeSync(nats.NewInbox())
+	defer sub.Unsubscribe()
+	nc.Flush()
+
+	o, err := mset.AddConsumer(&server.ConsumerConfig{
+		Durable:        "dlc",
+		DeliverSubject: sub.Subject,
+		AckPolicy:      server.AckExplicit,
+		AckWait:        100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer o.Delete()
+
+	pause := func(req []byte) *server.JSApiConsumerPauseResponse {
+		t.Helper()
+		resp, err := nc.Request(fmt.Sprintf(server.JSApiConsumerPauseT, "MY_STREAM", "dlc"), req, time.Second)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		var presp server.JSApiConsumerPauseResponse
+		if err = json.Unmarshal(resp.Data, &presp); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return &presp
+	}
+	resume := func() *server.JSApiConsumerResumeResponse {
+		t.Helper()
+		resp, err := nc.Request(fmt.Sprintf(server.JSApiConsumerResumeT, "MY_STREAM", "dlc"), nil, time.Second)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		var rresp server.JSApiConsumerResumeResponse
+		if err = json.Unmarshal(resp.Data, &rresp); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return &rresp
+	}
+
+	// Deliver one message that we will not ack to check redelivery is held as well.
+	sendStreamMsg(t, nc, "MY_STREAM", "Hello World")
+	if _, err := sub.NextMsg(time.Second); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	presp := pause(nil)
+	if presp.Error != nil {
+		t.Fatalf("Unexpected error: %+v", presp.Error)
+	}
+	if !presp.ConsumerInfo.Paused || presp.ConsumerInfo.PauseUntil != nil {
+		t.Fatalf("Expected consumer to be paused until resumed, got %+v", presp.ConsumerInfo)
+	}
+
+	toSend := 10
+	for i := 0; i < toSend; i++ {
+		sendStreamMsg(t, nc, "MY_STREAM", "Hello World")
+	}
+	// Wait past the ack wait, nothing should be delivered or redelivered.
+	if m, err := sub.NextMsg(250 * time.Millisecond); err == nil {
+		t.Fatalf("Expected no messages while paused, got %q", m.Subject)
+	}
+	if !o.IsPaused() {
+		t.Fatalf("Expected consumer to be paused")
+	}
+
+	rresp := resume()
+	if rresp.Error != nil {
+		t.Fatalf("Unexpected error: %+v", rresp.Error)
+	}
+	if rresp.ConsumerInfo.Paused {
+		t.Fatalf("Expected consumer to not be paused")
+	}
+	// We should get the new messages and the redelivery.
+	for i := 0; i < toSend+1; i++ {
+		m, err := sub.NextMsg(time.Second)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		m.Respond(nil)
+	}
+
+	// Now pause with a deadline, which should resume on its own.
+	until := time.Now().Add(250 * time.Millisecond).UTC()
+	req, _ := json.Marshal(&server.JSApiConsumerPauseRequest{PauseUntil: &until})
+	presp = pause(req)
+	if presp.Error != nil {
+		t.Fatalf("Unexpected error: %+v", presp.Error)
+	}
+	if pu := presp.ConsumerInfo.PauseUntil; pu == nil || !pu.Equal(until) {
+		t.Fatalf("Expected pause until of %v, got %v", until, pu)
+	}
+	sendStreamMsg(t, nc, "MY_STREAM", "Hello World")
+	start := time.Now()
+	m, err := sub.NextMsg(2 * time.Second)
+	if err != nil {
+		t.Fatalf("Expected consumer to resume on its own: %v", err)
+	}
+	m.Respond(nil)
+	if time.Since(start) < 200*time.Millisecond {
+		t.Fatalf("Expected delivery to be held until the deadline")
+	}
+	if o.IsPaused() {
+		t.Fatalf("Expected consumer to not be paused")
+	}
+
+	// A deadline in the past is an error.
+	past := time.Now().Add(-time.Minute)
+	req, _ = json.Marshal(&server.JSApiConsumerPauseRequest{PauseUntil: &past})
+	if presp = pause(req); presp.Error == nil {
+		t.Fatalf("Expected an error for a pause time in the past")
+	}
+}
+
+func TestJetStreamConsumerUpdate(t *testing.T) {
+	s := RunBasicJetStreamServer()
+	defer s.Shutdown()
+
+	if config := s.JetStreamConfig(); config != nil {
+		defer os.RemoveAll(config.StoreDir)
+	}
+
+	mset, err := s.GlobalAccount().AddStream(&server.StreamConfig{
+		Name:     "MY_STREAM",
+		Subjects: []string{"foo.*"},
+		Storage:  server.FileStorage,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error adding stream: %v", err)
+	}
+	defer mset.Delete()
+
+	o, err := mset.AddConsumer(&server.ConsumerConfig{
+		Durable:       "dlc",
+		AckPolicy:     server.AckExplicit,
+		FilterSubject: "foo.bar",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	nc := clientConnectToServer(t, s)
+	defer nc.Close()
+
+	update := func(cfg server.ConsumerConfig) *server.JSApiConsumerUpdateResponse {
+		t.Helper()
+		req, _ := json.Marshal(&server.CreateConsumerRequest{Stream: "MY_STREAM", Config: cfg})
+		resp, err := nc.Request(fmt.Sprintf(server.JSApiConsumerUpdateT, "MY_STREAM", "dlc"), req, time.Second)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		var uresp server.JSApiConsumerUpdateResponse
+		if err = json.Unmarshal(resp.Data, &uresp); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return &uresp
+	}
+
+	cfg := o.Config()
+	cfg.AckWait = 250 * time.Millisecond
+	cfg.MaxDeliver = 2
+	cfg.SampleFrequency = "50%"
+	cfg.Description = "Orders for billing"
+
+	uresp := update(cfg)
+	if uresp.Error != nil {
+		t.Fatalf("Unexpected error: %+v", uresp.Error)
+	}
+	if !reflect.DeepEqual(uresp.ConsumerInfo.Config, cfg) {
+		t.Fatalf("Expected config to be updated to %+v, got %+v", cfg, uresp.ConsumerInfo.Config)
+	}
+
+	// Changes that alter what is delivered or the position are not allowed.
+	bad := cfg
+	bad.FilterSubject = "foo.baz"
+	if uresp = update(bad); uresp.Error == nil || !strings.Contains(uresp.Error.Description, "filter subject") {
+		t.Fatalf("Expected an error updating the filter subject, got %+v", uresp.Error)
+	}
+	bad = cfg
+	bad.DeliverPolicy = server.DeliverNew
+	if uresp = update(bad); uresp.Error == nil || !strings.Contains(uresp.Error.Description, "deliver policy") {
+		t.Fatalf("Expected an error updating the deliver policy, got %+v", uresp.Error)
+	}
+	bad = cfg
+	bad.RateLimit = 1024
+	if uresp = update(bad); uresp.Error == nil {
+		t.Fatalf("Expected an error setting a rate limit in pull mode")
+	}
+
+	// Make sure the new ack wait and max deliver are in effect.
+	sendStreamMsg(t, nc, "foo.bar", "Hello World")
+	for i := 0; i < 2; i++ {
+		if _, err := nc.Request(o.RequestNextMsgSubject(), nil, time.Second); err != nil {
+			t.Fatalf("Expected a redelivery after the updated ack wait: %v", err)
+		}
+	}
+	if _, err := nc.Request(o.RequestNextMsgSubject(), nil, 500*time.Millisecond); err == nil {
+		t.Fatalf("Expected no more deliveries past max deliver")
+	}
+
+	// Restart and make sure the update was persisted.
+	u, _ := url.Parse(s.ClientURL())
+	port, _ := strconv.Atoi(u.Port())
+	sd := s.JetStreamConfig().StoreDir
+
+	nc.Close()
+	s.Shutdown()
+
+	s = RunJetStreamServerOnPort(port, sd)
+	defer s.Shutdown()
+
+	mset, err = s.GlobalAccount().LookupStream("MY_STREAM")
+	if err != nil {
+		t.Fatalf("Expected to recover stream: %v", err)
+	}
+	if o = mset.LookupConsumer("dlc"); o == nil {
+		t.Fatalf("Expected to recover consumer")
+	}
+	if ncfg := o.Config(); !reflect.DeepEqual(ncfg, cfg) {
+		t.Fatalf("Expected config of %+v after restart, got %+v", cfg, ncfg)
+	}
+}
+
+func TestJetStreamConsumerUpdateWithDeliverSubjectChange(t *testing.T) {
+	s := RunBasicJetStreamServer()
+	defer s.Shutdown()
+
+	if config := s.JetStreamConfig(); config != nil {
+		defer os.RemoveAll(config.StoreDir)
+	}
+
+	mset, err := s.GlobalAccount().AddStream(&server.StreamConfig{Name: "MY_STREAM", Storage: server.MemoryStorage})
+	if err != nil {
+		t.Fatalf("Unexpected error adding stream: %v", err)
+	}
+	defer mset.Delete()
+
+	o, err := mset.AddConsumer(&server.ConsumerConfig{
+		Durable:        "dlc",
+		DeliverSubject: "d.0",
+		AckPolicy:      server.AckExplicit,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer o.Delete()
+
+	// Move the deliver subject around while updating the consumer. An update
+	// must never bring back a deliver subject that was replaced in between.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	last := "d.0"
+	go func() {
+		defer wg.Done()
+		for i := 1; i <= 200; i++ {
+			cfg := o.Config()
+			cfg.DeliverSubject = fmt.Sprintf("d.%d", i)
+			if _, err := mset.AddConsumer(&cfg); err == nil {
+				last = cfg.DeliverSubject
+			}
+		}
+	}()
+	for i := 0; i < 200; i++ {
+		cfg := o.Config()
+		cfg.MaxDeliver = i%10 + 1
+		o.Update(&cfg)
+	}
+	wg.Wait()
+
+	if cfg := o.Config(); cfg.DeliverSubject != last {
+		t.Fatalf("Expected deliver subject of %q, got %q", last, cfg.DeliverSubject)
+	}
+}
+
+func TestJetStreamConsumerUpdateKeepsCreated(t *testing.T) {
+	s := RunBasicJetStreamServer()
+	defer s.Shutdown()
+
+	if config := s.JetStreamConfig(); config != nil {
+		defer os.RemoveAll(config.StoreDir)
+	}
+
+	mset, err := s.GlobalAccount().AddStream(&server.StreamConfig{
+		Name:     "MY_STREAM",
+		Subjects: []string{"foo.*"},
+		Storage:  server.FileStorage,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error adding stream: %v", err)
+	}
+	if _, err := mset.AddConsumer(&server.ConsumerConfig{Durable: "dlc", AckPolicy: server.AckExplicit}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// The creation time recovered after a restart is the persisted one.
+	var created time.Time
+
+	u, _ := url.Parse(s.ClientURL())
+	port, _ := strconv.Atoi(u.Port())
+	sd := s.JetStreamConfig().StoreDir
+
+	restart := func() *server.Consumer {
+		t.Helper()
+		s.Shutdown()
+		s = RunJetStreamServerOnPort(port, sd)
+		mset, err := s.GlobalAccount().LookupStream("MY_STREAM")
+		if err != nil {
+			t.Fatalf("Expected to recover stream: %v", err)
+		}
+		o := mset.LookupConsumer("dlc")
+		if o == nil {
+			t.Fatalf("Expected to recover consumer")
+		}
+		if created.IsZero() {
+			created = o.Created()
+		} else if !o.Created().Equal(created) {
+			t.Fatalf("Expected created time %v, got %v", created, o.Created())
+		}
+		return o
+	}
+
+	// Restart, update the consumer and restart again: the creation
+	// time must not be lost when the meta data is rewritten.
+	o := restart()
+	nc := clientConnectToServer(t, s)
+	cfg := o.Config()
+	cfg.Description = "Updated after a restart"
+	req, _ := json.Marshal(&server.CreateConsumerRequest{Stream: "MY_STREAM", Config: cfg})
+	resp, err := nc.Request(fmt.Sprintf(server.JSApiConsumerUpdateT, "MY_STREAM", "dlc"), req, time.Second)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var uresp server.JSApiConsumerUpdateResponse
+	if err = json.Unmarshal(resp.Data, &uresp); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if uresp.Error != nil {
+		t.Fatalf("Unexpected error: %+v", uresp.Error)
+	}
+	nc.Close()
+
+	o = restart()
+	defer s.Shutdown()
+	if desc := o.Config().Description; desc != cfg.Description {
+		t.Fatalf("Expected description %q, got %q", cfg.Description, desc)
+	}
+}
+
+func TestJetStreamConsumerBackOff(t *testing.T) {
+	s := RunBasicJetStreamServer()
+	defer s.Shutdown()
+
+	if config := s.JetStreamConfig(); config != nil {
+		defer os.RemoveAll(config.StoreDir)
+	}
+
+	mset, err := s.GlobalAccount().AddStream(&server.StreamConfig{Name: "MY_STREAM", Storage: server.MemoryStorage})
+	if err != nil {
+		t.Fatalf("Unexpected error adding stream: %v", err)
+	}
+	defer mset.Delete()
+
+	for _, test := range []struct {
+		cfg server.ConsumerConfig
+		err string
+	}{
+		{server.ConsumerConfig{Durable: "A", DeliverSubject: "d", AckPolicy: server.AckNone, BackOff: []time.Duration{time.Second}}, "requires an ack policy"},
+		{server.ConsumerConfig{Durable: "B", AckPolicy: server.AckExplicit, BackOff: []time.Duration{0}}, "must be positive"},
+		{server.ConsumerConfig{Durable: "C", AckPolicy: server.AckExplicit, MaxDeliver: 2, BackOff: []time.Duration{time.Second, time.Second}}, "max deliver"},
+		{server.ConsumerConfig{Durable: "D", AckPolicy: server.AckExplicit, AckWait: time.Minute, BackOff: []time.Duration{time.Second}}, "conflicts"},
+	} {
+		if _, err := mset.AddConsumer(&test.cfg); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Fatalf("Expected error containing %q, got %v", test.err, err)
+		}
+	}
+
+	backoff := []time.Duration{100 * time.Millisecond, 300 * time.Millisecond}
+	o, err := mset.AddConsumer(&server.ConsumerConfig{
+		Durable:    "dlc",
+		AckPolicy:  server.AckExplicit,
+		MaxDeliver: 4,
+		BackOff:    backoff,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer o.Delete()
+
+	if cfg := o.Config(); cfg.AckWait != backoff[0] {
+		t.Fatalf("Expected ack wait to be the first backoff value, got %v", cfg.AckWait)
+	}
+
+	nc := clientConnectToServer(t, s)
+	defer nc.Close()
+
+	sub, _ := nc.SubscribeSync(nats.NewInbox())
+	defer sub.Unsubscribe()
+	nc.Flush()
+
+	sendStreamMsg(t, nc, "MY_STREAM", "Hello World")
+
+	// Ask for all deliveries up front and record when they arrive.
+	for i := 0; i < 4; i++ {
+		nc.PublishRequest(o.RequestNextMsgSubject(), sub.Subject, nil)
+	}
+	var times []time.Time
+	for i := 0; i < 4; i++ {
+		if _, err := sub.NextMsg(2 * time.Second); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		times = append(times, time.Now())
+	}
+	// First redelivery follows the first backoff value, the rest the last one.
+	expected := []time.Duration{backoff[0], backoff[1], backoff[1]}
+	for i, d := range expected {
+		if elapsed := times[i+1].Sub(times[i]); elapsed < d-10*time.Millisecond || elapsed > d+150*time.Millisecond {
+			t.Fatalf("Expected redelivery %d after about %v, got %v", i+1, d, elapsed)
+		}
+	}
+}
+
+func TestJetStreamConsumerNakWithDelay(t *testing.T) {
+	s := RunBasicJetStreamServer()
+	defer s.Shutdown()
+
+	if config := s.JetStreamConfig(); config != nil {
+		defer os.RemoveAll(config.StoreDir)
+	}
+
+	mset, err := s.GlobalAccount().AddStream(&server.StreamConfig{Name: "MY_STREAM", Storage: server.MemoryStorage})
+	if err != nil {
+		t.Fatalf("Unexpected error adding stream: %v", err)
+	}
+	defer mset.Delete()
+
+	nc := clientConnectToServer(t, s)
+	defer nc.Close()
+
+	sub, _ := nc.SubscribeSync(nats.NewInbox())
+	defer sub.Unsubscribe()
+	nc.Flush()
+
+	o, err := mset.AddConsumer(&server.ConsumerConfig{Durable: "dlc", DeliverSubject: sub.Subject, AckPolicy: server.AckExplicit})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer o.Delete()
+
+	sendStreamMsg(t, nc, "MY_STREAM", "Hello World")
+	m, err := sub.NextMsg(time.Second)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	nak, _ := json.Marshal(&server.ConsumerNakOptions{Delay: 250 * time.Millisecond})
+	start := time.Now()
+	m.Respond(append(append(server.AckNak, ' '), nak...))
+
+	if _, err := sub.NextMsg(150 * time.Millisecond); err == nil {
+		t.Fatalf("Expected redelivery to be delayed")
+	}
+	m, err = sub.NextMsg(time.Second)
+	if err != nil {
+		t.Fatalf("Expected a redelivery: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Fatalf("Expected redelivery after the delay, got %v", elapsed)
+	}
+	if _, _, dc, _ := o.ReplyInfo(m.Reply); dc != 2 {
+		t.Fatalf("Expected delivery count of 2, got %d", dc)
+	}
+
+	// Anything that is not a NAK or a NAK with options we can parse is ignored.
+	for _, bad := range []string{"-NAKED", "-NAK {\"delay\":"} {
+		m.Respond([]byte(bad))
+		if _, err := sub.NextMsg(100 * time.Millisecond); err == nil {
+			t.Fatalf("Expected no redelivery for %q", bad)
+		}
+	}
+
+	// A plain NAK still redelivers right away.
+	m.Respond(server.AckNak)
+	if _, err := sub.NextMsg(100 * time.Millisecond); err != nil {
+		t.Fatalf("Expected an immediate redelivery: %v", err)
+	}
+}
+
+func TestJetStreamConsumerDeadLetter(t *testing.T) {
+	s := RunBasicJetStreamServer()
+	defer s.Shutdown()
+
+	if config := s.JetStreamConfig(); config != nil {
+		defer os.RemoveAll(config.StoreDir)
+	}
+
+	mset, err := s.GlobalAccount().AddStream(&server.StreamConfig{Name: "MY_STREAM", Storage: server.MemoryStorage})
+	if err != nil {
+		t.Fatalf("Unexpected error adding stream: %v", err)
+	}
+	defer mset.Delete()
+
+	dlq, err := s.GlobalAccount().AddStream(&server.StreamConfig{Name: "DLQ", Subjects: []string{"dlq.>"}, Storage: server.MemoryStorage})
+	if err != nil {
+		t.Fatalf("Unexpected error adding stream: %v", err)
+	}
+	defer dlq.Delete()
+
+	nc := clientConnectToServer(t, s)
+	defer nc.Close()
+
+	sub, _ := nc.SubscribeSync(nats.NewInbox())
+	defer sub.Unsubscribe()
+	nc.Flush()
+
+	// Check validation of the dead letter subject.
+	for _, dls := range []string{"MY_STREAM", "dlq.*", sub.Subject} {
+		cfg := &server.ConsumerConfig{DeliverSubject: sub.Subject, AckPolicy: server.AckExplicit, DeadLetterSubject: dls}
+		if _, err := mset.AddConsumer(cfg); err == nil {
+			t.Fatalf("Expected an error for dead letter subject %q", dls)
+		}
+	}
+	if _, err := mset.AddConsumer(&server.ConsumerConfig{DeliverSubject: sub.Subject, AckPolicy: server.AckNone, DeadLetterSubject: "dlq.foo"}); err == nil {
+		t.Fatalf("Expected an error for dead letter subject with no ack policy")
+	}
+
+	o, err := mset.AddConsumer(&server.ConsumerConfig{
+		Durable:           "dlc",
+		DeliverSubject:    sub.Subject,
+		AckPolicy:         server.AckExplicit,
+		AckWait:           50 * time.Millisecond,
+		MaxDeliver:        2,
+		DeadLetterSubject: "dlq.dlc",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer o.Delete()
+
+	checkDeadLetter := func(stream, consumer string, seq uint64, sseq, deliveries, reason string) {
+		t.Helper()
+		checkFor(t, time.Second, 10*time.Millisecond, func() error {
+			if state := dlq.State(); state.Msgs < seq {
+				return fmt.Errorf("Expected %d dead letter messages, got %d", seq, state.Msgs)
+			}
+			return nil
+		})
+		sm, err := dlq.GetMsg(seq)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if sm.Subject != "dlq."+consumer {
+			t.Fatalf("Unexpected subject: %q", sm.Subject)
+		}
+		if string(sm.Data) != "Hello World" {
+			t.Fatalf("Unexpected data: %q", sm.Data)
+		}
+		hdr := string(sm.Header)
+		for _, h := range []string{
+			server.JSDeadLetterStream + ": " + stream,
+			server.JSDeadLetterConsumer + ": " + consumer,
+			server.JSDeadLetterSubject + ": " + stream,
+			server.JSDeadLetterSequence + ": " + sseq,
+			server.JSDeadLetterDeliveries + ": " + deliveries,
+			server.JSDeadLetterReason + ": " + reason,
+		} {
+			if !strings.Contains(hdr, h+"\r\n") {
+				t.Fatalf("Expected header %q in %q", h, hdr)
+			}
+		}
+	}
+
+	// Exceed max deliveries by never acking.
+	sendStreamMsg(t, nc, "MY_STREAM", "Hello World")
+	for i := 0; i < 2; i++ {
+		if _, err := sub.NextMsg(time.Second); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	checkDeadLetter("MY_STREAM", "dlc", 1, "1", "2", server.JSDeadLetterReasonMaxDeliveries)
+
+	// Terminate delivery explicitly.
+	sendStreamMsg(t, nc, "MY_STREAM", "Hello World")
+	m, err := sub.NextMsg(time.Second)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	m.Respond(server.AckTerm)
+	checkDeadLetter("MY_STREAM", "dlc", 2, "2", "1", server.JSDeadLetterReasonTerminated)
+
+	if state := dlq.State(); state.Msgs != 2 {
+		t.Fatalf("Expected 2 dead letter messages, got %d", state.Msgs)
+	}
+
+	// With work queue retention the terminated message is removed from the
+	// stream by the ack, it should still make it to the dead letter subject.
+	wq, err := s.GlobalAccount().AddStream(&server.StreamConfig{Name: "WQ", Retention: server.WorkQueuePolicy, Storage: server.MemoryStorage})
+	if err != nil {
+		t.Fatalf("Unexpected error adding stream: %v", err)
+	}
+	defer wq.Delete()
+
+	wo, err := wq.AddConsumer(&server.ConsumerConfig{
+		Durable:           "wq",
+		DeliverSubject:    sub.Subject,
+		AckPolicy:         server.AckExplicit,
+		DeadLetterSubject: "dlq.wq",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer wo.Delete()
+
+	sendStreamMsg(t, nc, "WQ", "Hello World")
+	if m, err = sub.NextMsg(time.Second); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	m.Respond(server.AckTerm)
+	checkDeadLetter("WQ", "wq", 3, "1", "1", server.JSDeadLetterReasonTerminated)
+
+	checkFor(t, time.Second, 10*time.Millisecond, func() error {
+		if state := wq.State(); state.Msgs != 0 {
+			return fmt.Errorf("Expected the message to be removed, got %d msgs", state.Msgs)
+		}
+		return nil
+	})
+}
+
+func TestJetStreamOrderedConsumer(t *testing.T) {
+	s := RunBasicJetStreamServer()
+	defer s.Shutdown()
+
+	if config := s.JetStreamConfig(); config != nil {
+		defer os.RemoveAll(config.StoreDir)
+	}
+
+	mset, err := s.GlobalAccount().AddStream(&server.StreamConfig{Name: "MY_STREAM", Storage: server.MemoryStorage})
+	if err != nil {
+		t.Fatalf("Unexpected error adding stream: %v", err)
+	}
+	defer mset.Delete()
+
+	nc := clientConnectToServer(t, s)
+	defer nc.Close()
+
+	toSend := 5
+	for i := 1; i <= toSend; i++ {
+		sendStreamMsg(t, nc, "MY_STREAM", fmt.Sprintf("msg-%d", i))
+	}
+
+	sub, _ := nc.SubscribeSync(nats.NewInbox())
+	defer sub.Unsubscribe()
+	nc.Flush()
+
+	// Check validation of ordered consumers.
+	for _, cfg := range []*server.ConsumerConfig{
+		{Ordered: true},
+		{Ordered: true, DeliverSubject: sub.Subject, Durable: "dlc"},
+		{Ordered: true, DeliverSubject: sub.Subject, AckPolicy: server.AckExplicit},
+		{Ordered: true, DeliverSubject: sub.Subject, MaxDeliver: 2},
+	} {
+		if _, err := mset.AddConsumer(cfg); err == nil {
+			t.Fatalf("Expected an error for config %+v", cfg)
+		}
+	}
+	// Only a single subscriber is allowed.
+	sub2, _ := nc.SubscribeSync(sub.Subject)
+	nc.Flush()
+	if _, err := mset.AddConsumer(&server.ConsumerConfig{Ordered: true, DeliverSubject: sub.Subject}); err == nil {
+		t.Fatalf("Expected an error with multiple subscribers")
+	}
+	sub2.Unsubscribe()
+	nc.Flush()
+
+	o, err := mset.AddConsumer(&server.ConsumerConfig{Ordered: true, DeliverSubject: sub.Subject})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer o.Delete()
+
+	// Can not switch out of ordered mode.
+	ncfg := o.Config()
+	ncfg.Ordered = false
+	if err := o.Update(&ncfg); err == nil {
+		t.Fatalf("Expected an error updating ordered mode")
+	}
+
+	checkNext := func(sseq, dseq uint64) {
+		t.Helper()
+		m, err := sub.NextMsg(time.Second)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if rsseq, rdseq, _, _ := o.ReplyInfo(m.Reply); rsseq != sseq || rdseq != dseq {
+			t.Fatalf("Expected stream and consumer sequence of %d and %d, got %d and %d", sseq, dseq, rsseq, rdseq)
+		}
+		if expected := fmt.Sprintf("msg-%d", sseq); string(m.Data) != expected {
+			t.Fatalf("Expected %q, got %q", expected, m.Data)
+		}
+	}
+	for i := uint64(1); i <= uint64(toSend); i++ {
+		checkNext(i, i)
+	}
+
+	reset := func(req []byte) *server.JSApiConsumerResetResponse {
+		t.Helper()
+		resp, err := nc.Request(fmt.Sprintf(server.JSApiConsumerResetT, "MY_STREAM", o.Name()), req, time.Second)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		var rresp server.JSApiConsumerResetResponse
+		if err := json.Unmarshal(resp.Data, &rresp); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return &rresp
+	}
+
+	// Pretend we detected a gap after stream sequence 2.
+	req, _ := json.Marshal(&server.JSApiConsumerResetRequest{Seq: 2})
+	rresp := reset(req)
+	if rresp.Error != nil {
+		t.Fatalf("Unexpected error: %+v", rresp.Error)
+	}
+	if rresp.Delivered.StreamSeq != 2 || rresp.Delivered.ConsumerSeq != 0 {
+		t.Fatalf("Unexpected delivered state after reset: %+v", rresp.Delivered)
+	}
+	for i := uint64(3); i <= uint64(toSend); i++ {
+		checkNext(i, i-2)
+	}
+
+	// Without a sequence we pick up after the last message delivered.
+	if rresp := reset(nil); rresp.Error != nil {
+		t.Fatalf("Unexpected error: %+v", rresp.Error)
+	}
+	if _, err := sub.NextMsg(100 * time.Millisecond); err == nil {
+		t.Fatalf("Did not expect a redelivery")
+	}
+	sendStreamMsg(t, nc, "MY_STREAM", "msg-6")
+	checkNext(6, 1)
+
+	// Reset is only for ordered consumers.
+	dsub, _ := nc.SubscribeSync(nats.NewInbox())
+	defer dsub.Unsubscribe()
+	nc.Flush()
+	do, err := mset.AddConsumer(&server.ConsumerConfig{DeliverSubject: dsub.Subject})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer do.Delete()
+	if err := do.Reset(1); err == nil {
+		t.Fatalf("Expected an error resetting a consumer that is not ordered")
+	}
+}
+
+func TestJetStreamConsumerStats(t *testing.T) {
+	opts := DefaultTestOptions
+	opts.Port = -1
+	opts.HTTPPort = -1
+	opts.JetStream = true
+	s := RunServer(&opts)
+	defer s.Shutdown()
+
+	if config := s.JetStreamConfig(); config != nil {
+		defer os.RemoveAll(config.StoreDir)
+	}
+
+	mset, err := s.GlobalAccount().AddStream(&server.StreamConfig{Name: "MY_STREAM", Storage: server.MemoryStorage})
+	if err != nil {
+		t.Fatalf("Unexpected error adding stream: %v", err)
+	}
+	defer mset.Delete()
+
+	nc := clientConnectToServer(t, s)
+	defer nc.Close()
+
+	sub, _ := nc.SubscribeSync(nats.NewInbox())
+	defer sub.Unsubscribe()
+	nc.Flush()
+
+	o, err := mset.AddConsumer(&server.ConsumerConfig{
+		Durable:        "dlc",
+		DeliverSubject: sub.Subject,
+		AckPolicy:      server.AckExplicit,
+		AckWait:        100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer o.Delete()
+
+	for i := 0; i < 4; i++ {
+		sendStreamMsg(t, nc, "MY_STREAM", "Hello World")
+	}
+	next := func() *nats.Msg {
+		t.Helper()
+		m, err := sub.NextMsg(time.Second)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return m
+	}
+	// Ack the first, nak the second, term the third and let the fourth expire.
+	next().Respond(nil)
+	next().Respond(server.AckNak)
+	next().Respond(server.AckTerm)
+	next()
+	// Now ack the redeliveries.
+	next().Respond(nil)
+	next().Respond(nil)
+	nc.Flush()
+
+	checkStats := func(stats *server.ConsumerStats) {
+		t.Helper()
+		if stats == nil {
+			t.Fatalf("Expected stats")
+		}
+		if stats.Delivered != 4 || stats.Redelivered != 2 || stats.Acked != 3 || stats.Naked != 1 ||
+			stats.Terminated != 1 || stats.Expired != 1 || stats.Exceeded != 0 {
+			t.Fatalf("Unexpected stats: %+v", stats)
+		}
+		lat := stats.AckLatency
+		if lat == nil || lat.Count != 3 {
+			t.Fatalf("Expected 3 ack latency samples, got %+v", lat)
+		}
+		if lat.Min > lat.Max || lat.Sum < lat.Max {
+			t.Fatalf("Unexpected ack latency: %+v", lat)
+		}
+		if n := len(lat.Buckets); n == 0 || lat.Buckets[n-1].Count != lat.Count {
+			t.Fatalf("Expected the last bucket to hold all samples: %+v", lat.Buckets)
+		}
+	}
+	checkFor(t, time.Second, 10*time.Millisecond, func() error {
+		if stats := o.Info().Stats; stats.Acked != 3 {
+			return fmt.Errorf("Expected 3 acks, got %d", stats.Acked)
+		}
+		return nil
+	})
+
+	resp, err := nc.Request(fmt.Sprintf(server.JSApiConsumerInfoT, "MY_STREAM", "dlc"), nil, time.Second)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var info server.JSApiConsumerInfoResponse
+	if err := json.Unmarshal(resp.Data, &info); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
 	}
+	checkStats(info.Stats)
 
-	// First create a stream from the template
-	sendStreamMsg(t, nc, "kv.22", "derek")
-	// Last do info
-	resp, err = nc.Request(fmt.Sprintf(server.JSApiTemplateInfoT, "kv"), nil, time.Second)
+	url := fmt.Sprintf("http://%s/consumerz?acc=%s&stream=MY_STREAM", s.MonitorAddr(), server.DEFAULT_GLOBAL_ACCOUNT)
+	hresp, err := http.Get(url)
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
 	}
-	var ti server.StreamTemplateInfo
-	if err = json.Unmarshal(resp.Data, &ti); err != nil {
+	defer hresp.Body.Close()
+	body, err := ioutil.ReadAll(hresp.Body)
+	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
 	}
-	if len(ti.Streams) != 1 {
-		t.Fatalf("Expected 1 stream, got %d", len(ti.Streams))
+	var cz server.Consumerz
+	if err := json.Unmarshal(body, &cz); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
 	}
-	if ti.Streams[0] != server.CanonicalName("kv.22") {
-		t.Fatalf("Expected stream with name %q, but got %q", server.CanonicalName("kv.22"), ti.Streams[0])
+	if cz.NumConsumers != 1 || cz.Consumers[0].Name != "dlc" || cz.Consumers[0].Account != server.DEFAULT_GLOBAL_ACCOUNT {
+		t.Fatalf("Unexpected consumerz response: %s", body)
 	}
+	checkStats(cz.Consumers[0].Stats)
 
-	// Test that we can send nil or an empty legal json for requests that take no args.
-	// We know this stream does not exist, this just checking request processing.
-	checkEmptyReqArg := func(arg string) {
-		t.Helper()
-		var req []byte
-		if len(arg) > 0 {
-			req = []byte(arg)
-		}
-		resp, err = nc.Request(fmt.Sprintf(server.JSApiStreamDeleteT, "foo_bar_baz"), req, time.Second)
-		var dResp server.JSApiStreamDeleteResponse
-		if err = json.Unmarshal(resp.Data, &dResp); err != nil {
-			t.Fatalf("Unexpected error: %v", err)
-		}
-		if dResp.Error == nil || dResp.Error.Code != 404 {
-			t.Fatalf("Got a bad response, expected a 404 response %+v", dResp.Error)
-		}
+	if cz, err := s.Consumerz(&server.ConsumerzOptions{Stream: "NOT_THERE"}); err != nil || cz.NumConsumers != 0 {
+		t.Fatalf("Expected no consumers, got %+v, %v", cz, err)
 	}
-
-	checkEmptyReqArg("")
-	checkEmptyReqArg("{}")
-	checkEmptyReqArg(" {} ")
-	checkEmptyReqArg(" { } ")
 }
 
-func TestJetStreamAPIStreamListPaging(t *testing.T) {
+func TestJetStreamConsumerInactiveThreshold(t *testing.T) {
 	s := RunBasicJetStreamServer()
 	defer s.Shutdown()
 
-	// Forced cleanup of all persisted state.
 	if config := s.JetStreamConfig(); config != nil {
 		defer os.RemoveAll(config.StoreDir)
 	}
 
-	// Create 4X limit
-	streamsNum := 4 * server.JSApiNamesLimit
-	for i := 1; i <= streamsNum; i++ {
-		name := fmt.Sprintf("STREAM-%06d", i)
-		cfg := server.StreamConfig{Name: name}
-		_, err := s.GlobalAccount().AddStream(&cfg)
-		if err != nil {
-			t.Fatalf("Unexpected error adding stream: %v", err)
-		}
+	mset, err := s.GlobalAccount().AddStream(&server.StreamConfig{Name: "MY_STREAM", Storage: server.MemoryStorage})
+	if err != nil {
+		t.Fatalf("Unexpected error adding stream: %v", err)
 	}
+	defer mset.Delete()
 
-	// Client for API requests.
 	nc := clientConnectToServer(t, s)
 	defer nc.Close()
 
-	reqList := func(offset int) []byte {
-		t.Helper()
-		var req []byte
-		if offset > 0 {
-			req, _ = json.Marshal(&server.JSApiStreamNamesRequest{ApiPagedRequest: server.ApiPagedRequest{Offset: offset}})
-		}
-		resp, err := nc.Request(server.JSApiStreams, req, time.Second)
-		if err != nil {
-			t.Fatalf("Unexpected error getting stream list: %v", err)
-		}
-		return resp.Data
+	if _, err := mset.AddConsumer(&server.ConsumerConfig{Durable: "bad", AckPolicy: server.AckExplicit, InactiveThreshold: -1}); err == nil {
+		t.Fatalf("Expected an error for a negative inactive threshold")
 	}
 
-	checkResp := func(resp []byte, expectedLen, expectedOffset int) {
-		t.Helper()
-		var listResponse server.JSApiStreamNamesResponse
-		if err := json.Unmarshal(resp, &listResponse); err != nil {
+	// Create a pull consumer through the API with an inactive threshold.
+	req, _ := json.Marshal(&server.CreateConsumerRequest{
+		Stream: "MY_STREAM",
+		Config: server.ConsumerConfig{Durable: "pull", AckPolicy: server.AckExplicit, InactiveThreshold: 250 * time.Millisecond},
+	})
+	resp, err := nc.Request(fmt.Sprintf(server.JSApiDurableCreateT, "MY_STREAM", "pull"), req, time.Second)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var ccResp server.JSApiConsumerCreateResponse
+	if err := json.Unmarshal(resp.Data, &ccResp); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if ccResp.Error != nil {
+		t.Fatalf("Unexpected error: %+v", ccResp.Error)
+	}
+	if ccResp.Config.InactiveThreshold != 250*time.Millisecond {
+		t.Fatalf("Expected inactive threshold to be set, got %v", ccResp.Config.InactiveThreshold)
+	}
+
+	// A pull consumer without a threshold never expires.
+	keep, err := mset.AddConsumer(&server.ConsumerConfig{Durable: "keep", AckPolicy: server.AckExplicit})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer keep.Delete()
+
+	// Requests for messages keep the pull consumer alive.
+	pull := mset.LookupConsumer("pull")
+	sendStreamMsg(t, nc, "MY_STREAM", "Hello World")
+	for i := 0; i < 5; i++ {
+		if _, err := nc.Request(pull.RequestNextMsgSubject(), nil, time.Second); err != nil {
 			t.Fatalf("Unexpected error: %v", err)
 		}
-		if len(listResponse.Streams) != expectedLen {
-			t.Fatalf("Expected only %d streams but got %d", expectedLen, len(listResponse.Streams))
-		}
-		if listResponse.Total != streamsNum {
-			t.Fatalf("Expected total to be %d but got %d", streamsNum, listResponse.Total)
-		}
-		if listResponse.Offset != expectedOffset {
-			t.Fatalf("Expected offset to be %d but got %d", expectedOffset, listResponse.Offset)
-		}
-		if expectedLen < 1 {
-			return
+		time.Sleep(100 * time.Millisecond)
+		if mset.LookupConsumer("pull") == nil {
+			t.Fatalf("Expected pull consumer to still exist")
 		}
-		// Make sure we get the right stream.
-		sname := fmt.Sprintf("STREAM-%06d", expectedOffset+1)
-		if listResponse.Streams[0] != sname {
-			t.Fatalf("Expected stream %q to be first, got %q", sname, listResponse.Streams[0])
+		sendStreamMsg(t, nc, "MY_STREAM", "Hello World")
+	}
+	checkFor(t, time.Second, 25*time.Millisecond, func() error {
+		if mset.LookupConsumer("pull") != nil {
+			return fmt.Errorf("Expected pull consumer to be deleted")
 		}
+		return nil
+	})
+	if mset.LookupConsumer("keep") == nil {
+		t.Fatalf("Expected pull consumer without an inactive threshold to remain")
 	}
 
-	checkResp(reqList(0), server.JSApiNamesLimit, 0)
-	checkResp(reqList(server.JSApiNamesLimit), server.JSApiNamesLimit, server.JSApiNamesLimit)
-	checkResp(reqList(2*server.JSApiNamesLimit), server.JSApiNamesLimit, 2*server.JSApiNamesLimit)
-	checkResp(reqList(streamsNum), 0, streamsNum)
-	checkResp(reqList(streamsNum-22), 22, streamsNum-22)
+	// Ephemeral push consumers use the threshold instead of the default.
+	sub, _ := nc.SubscribeSync(nats.NewInbox())
+	nc.Flush()
+	o, err := mset.AddConsumer(&server.ConsumerConfig{DeliverSubject: sub.Subject, InactiveThreshold: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	name := o.Name()
+	sub.Unsubscribe()
+	checkFor(t, time.Second, 25*time.Millisecond, func() error {
+		if mset.LookupConsumer(name) != nil {
+			return fmt.Errorf("Expected ephemeral consumer to be deleted")
+		}
+		return nil
+	})
+
+	// Durable push consumers are deleted once they have a threshold.
+	sub, _ = nc.SubscribeSync(nats.NewInbox())
+	nc.Flush()
+	o, err = mset.AddConsumer(&server.ConsumerConfig{Durable: "push", DeliverSubject: sub.Subject})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	sub.Unsubscribe()
+	nc.Flush()
+	time.Sleep(100 * time.Millisecond)
+	if mset.LookupConsumer("push") == nil {
+		t.Fatalf("Expected durable consumer to remain")
+	}
+	cfg := o.Config()
+	cfg.InactiveThreshold = 50 * time.Millisecond
+	if err := o.Update(&cfg); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	checkFor(t, time.Second, 25*time.Millisecond, func() error {
+		if mset.LookupConsumer("push") != nil {
+			return fmt.Errorf("Expected durable consumer to be deleted")
+		}
+		return nil
+	})
 }
 
-func TestJetStreamAPIConsumerListPaging(t *testing.T) {
-	s := RunBasicJetStreamServer()
+func TestJetStreamJsz(t *testing.T) {
+	opts := DefaultTestOptions
+	opts.Port = -1
+	opts.HTTPPort = -1
+	opts.JetStream = true
+	s := RunServer(&opts)
 	defer s.Shutdown()
 
-	// Forced cleanup of all persisted state.
 	if config := s.JetStreamConfig(); config != nil {
 		defer os.RemoveAll(config.StoreDir)
 	}
 
-	sname := "MYSTREAM"
-	mset, err := s.GlobalAccount().AddStream(&server.StreamConfig{Name: sname})
+	// Split resources between two accounts.
+	limits := &server.JetStreamAccountLimits{MaxMemory: 64 * 1024 * 1024, MaxStore: 64 * 1024 * 1024, MaxStreams: -1, MaxConsumers: -1}
+	if err := s.GlobalAccount().DisableJetStream(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := s.GlobalAccount().EnableJetStream(limits); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	acc, _ := s.LookupOrRegisterAccount("$FOO")
+	if err := acc.EnableJetStream(limits); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, name := range []string{"S1", "S2"} {
+		mset, err := s.GlobalAccount().AddStream(&server.StreamConfig{Name: name, Storage: server.MemoryStorage})
+		if err != nil {
+			t.Fatalf("Unexpected error adding stream: %v", err)
+		}
+		defer mset.Delete()
+	}
+	mset, err := acc.AddStream(&server.StreamConfig{Name: "S3", Storage: server.MemoryStorage})
 	if err != nil {
 		t.Fatalf("Unexpected error adding stream: %v", err)
 	}
+	defer mset.Delete()
+	if _, err := mset.AddConsumer(&server.ConsumerConfig{Durable: "dlc", AckPolicy: server.AckExplicit}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
 
-	// Client for API requests.
 	nc := clientConnectToServer(t, s)
 	defer nc.Close()
-
-	sub, _ := nc.SubscribeSync("d.*")
-	defer sub.Unsubscribe()
-	nc.Flush()
-
-	consumersNum := server.JSApiNamesLimit
-	for i := 1; i <= consumersNum; i++ {
-		_, err := mset.AddConsumer(&server.ConsumerConfig{DeliverSubject: fmt.Sprintf("d.%d", i)})
-		if err != nil {
-			t.Fatalf("Unexpected error: %v", err)
-		}
+	for i := 0; i < 5; i++ {
+		sendStreamMsg(t, nc, "S1", "Hello World")
 	}
 
-	reqListSubject := fmt.Sprintf(server.JSApiConsumersT, sname)
-	reqList := func(offset int) []byte {
+	jsz := func(query string) *server.JSInfo {
 		t.Helper()
-		var req []byte
-		if offset > 0 {
-			req, _ = json.Marshal(&server.JSApiConsumersRequest{ApiPagedRequest: server.ApiPagedRequest{Offset: offset}})
-		}
-		resp, err := nc.Request(reqListSubject, req, time.Second)
+		resp, err := http.Get(fmt.Sprintf("http://%s/jsz%s", s.MonitorAddr(), query))
 		if err != nil {
-			t.Fatalf("Unexpected error getting stream list: %v", err)
-		}
-		return resp.Data
-	}
-
-	checkResp := func(resp []byte, expectedLen, expectedOffset int) {
-		t.Helper()
-		var listResponse server.JSApiConsumerNamesResponse
-		if err := json.Unmarshal(resp, &listResponse); err != nil {
 			t.Fatalf("Unexpected error: %v", err)
 		}
-		if len(listResponse.Consumers) != expectedLen {
-			t.Fatalf("Expected only %d streams but got %d", expectedLen, len(listResponse.Consumers))
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected a 200 response, got %d", resp.StatusCode)
 		}
-		if listResponse.Total != consumersNum {
-			t.Fatalf("Expected total to be %d but got %d", consumersNum, listResponse.Total)
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
 		}
-		if listResponse.Offset != expectedOffset {
-			t.Fatalf("Expected offset to be %d but got %d", expectedOffset, listResponse.Offset)
+		var info server.JSInfo
+		if err := json.Unmarshal(body, &info); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
 		}
+		return &info
 	}
 
-	checkResp(reqList(0), server.JSApiNamesLimit, 0)
-	checkResp(reqList(consumersNum-22), 22, consumersNum-22)
+	info := jsz("")
+	if info.Disabled || info.Config == nil || info.Config.StoreDir != s.JetStreamConfig().StoreDir {
+		t.Fatalf("Unexpected config: %+v", info)
+	}
+	if info.Accounts != 2 || info.Streams != 3 || info.Consumers != 1 || info.Messages != 5 {
+		t.Fatalf("Unexpected totals: %+v", info)
+	}
+	if info.Memory == 0 || info.ReservedMemory == 0 {
+		t.Fatalf("Expected memory usage and reservations: %+v", info)
+	}
+	if len(info.AccountDetails) != 0 {
+		t.Fatalf("Did not expect account details by default")
+	}
+
+	info = jsz("?accounts=true")
+	if info.Total != 2 || len(info.AccountDetails) != 2 {
+		t.Fatalf("Expected 2 account details, got %+v", info)
+	}
+	if ad := info.AccountDetails[0]; ad.Name != "$FOO" || ad.JetStreamAccountStats.Streams != 1 {
+		t.Fatalf("Unexpected account detail: %+v", ad)
+	}
+	if info.AccountDetails[1].Memory == 0 || len(info.AccountDetails[1].Streams) != 0 {
+		t.Fatalf("Unexpected account detail: %+v", info.AccountDetails[1])
+	}
+
+	// Paging.
+	info = jsz("?accounts=true&offset=1&limit=1")
+	if info.Total != 2 || len(info.AccountDetails) != 1 || info.AccountDetails[0].Name != server.DEFAULT_GLOBAL_ACCOUNT {
+		t.Fatalf("Unexpected paged account details: %+v", info)
+	}
+
+	// Filter by account and include streams and consumers.
+	info = jsz("?acc=$FOO&consumers=true&config=true")
+	if len(info.AccountDetails) != 1 {
+		t.Fatalf("Expected 1 account detail, got %+v", info)
+	}
+	sd := info.AccountDetails[0].Streams
+	if len(sd) != 1 || sd[0].Name != "S3" || sd[0].Config == nil || len(sd[0].Consumers) != 1 {
+		t.Fatalf("Unexpected stream details: %+v", sd)
+	}
+	if ci := sd[0].Consumers[0]; ci.Name != "dlc" || ci.Config.Durable != "dlc" {
+		t.Fatalf("Unexpected consumer detail: %+v", ci)
+	}
+
+	// Streams without config.
+	info = jsz("?acc=" + server.DEFAULT_GLOBAL_ACCOUNT + "&streams=true")
+	sd = info.AccountDetails[0].Streams
+	if len(sd) != 2 || sd[0].Name != "S1" || sd[0].Config != nil || sd[0].State.Msgs != 5 || sd[0].Consumers != nil {
+		t.Fatalf("Unexpected stream details: %+v", sd)
+	}
+
+	// The options in system requests use the same names as the query parameters.
+	var jopts server.JSzOptions
+	if err := json.Unmarshal([]byte(`{"streams":true,"consumers":true}`), &jopts); err != nil || !jopts.Consumer {
+		t.Fatalf("Expected consumers to be set, got %+v", jopts)
+	}
 }
 
 func TestJetStreamUpdateStream(t *testing.T) {
@@ -7541,3 +8879,81 @@ func TestJetStreamPubSubPerf(t *testing.T) {
 	fmt.Printf("time is %v\n", tt)
 	fmt.Printf("%.0f msgs/sec\n", float64(toSend)/tt.Seconds())
 }
+
+func TestJetStreamConsumerPauseRestart(t *testing.T) {
+	s := RunBasicJetStreamServer()
+	defer s.Shutdown()
+
+	if config := s.JetStreamConfig(); config != nil {
+		defer os.RemoveAll(config.StoreDir)
+	}
+
+	mset, err := s.GlobalAccount().AddStream(&server.StreamConfig{Name: "MY_STREAM", Storage: server.FileStorage})
+	if err != nil {
+		t.Fatalf("Unexpected error adding stream: %v", err)
+	}
+	for _, name := range []string{"A", "B", "C"} {
+		if _, err := mset.AddConsumer(&server.ConsumerConfig{Durable: name, AckPolicy: server.AckExplicit}); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	lookup := func(name string) *server.Consumer {
+		t.Helper()
+		o := mset.LookupConsumer(name)
+		if o == nil {
+			t.Fatalf("Expected to find consumer %q", name)
+		}
+		return o
+	}
+
+	// A is paused until resumed, B until a deadline well in the future
+	// and C until a deadline that will pass while the server is down.
+	until := time.Now().Add(time.Hour).UTC()
+	if err := lookup("A").Pause(time.Time{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := lookup("B").Pause(until); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := lookup("C").Pause(time.Now().Add(100 * time.Millisecond)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	restart := func() {
+		t.Helper()
+		u, _ := url.Parse(s.ClientURL())
+		port, _ := strconv.Atoi(u.Port())
+		sd := s.JetStreamConfig().StoreDir
+		s.Shutdown()
+		time.Sleep(200 * time.Millisecond)
+		s = RunJetStreamServerOnPort(port, sd)
+		if mset, err = s.GlobalAccount().LookupStream("MY_STREAM"); err != nil {
+			t.Fatalf("Expected to recover stream: %v", err)
+		}
+	}
+	restart()
+
+	if info := lookup("A").Info(); !info.Paused || info.PauseUntil != nil {
+		t.Fatalf("Expected consumer to be paused until resumed, got %+v", info)
+	}
+	if info := lookup("B").Info(); !info.Paused || info.PauseUntil == nil || !info.PauseUntil.Equal(until) {
+		t.Fatalf("Expected consumer to be paused until %v, got %+v", until, info)
+	}
+	if lookup("C").IsPaused() {
+		t.Fatalf("Expected consumer to not be paused")
+	}
+
+	// Resuming should be persisted as well.
+	if err := lookup("A").Resume(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	restart()
+	defer s.Shutdown()
+
+	if lookup("A").IsPaused() || lookup("C").IsPaused() {
+		t.Fatalf("Expected consumers to not be paused")
+	}
+	if !lookup("B").IsPaused() {
+		t.Fatalf("Expected consumer to be paused")
+	}
+}